@@ -0,0 +1,227 @@
+package main
+
+import (
+	"dvpn/core"
+	"dvpn/middleware"
+	"dvpn/models"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gorm.io/gorm"
+)
+
+const usage = `Manages admins of the API and their keys.
+
+Usage:
+  admin create -name <name> -role <role> [-key-ttl <duration>]
+  admin set-role -name <name> -role <role>
+  admin disable -name <name>
+  admin enable -name <name>
+  admin issue-key -name <name> [-key-ttl <duration>]
+  admin revoke-key -prefix <key prefix>
+  admin list
+
+Roles: %s
+`
+
+func main() {
+	godotenv.Load()
+
+	if len(os.Args) < 2 {
+		exit(nil)
+	}
+
+	db, err := core.InitDB()
+	if err != nil {
+		exit(err)
+	}
+
+	err = db.AutoMigrate(&models.Admin{}, &models.AdminKey{})
+	if err != nil {
+		exit(err)
+	}
+
+	command, args := os.Args[1], os.Args[2:]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	name := flags.String("name", "", "name of the admin")
+	role := flags.String("role", "", "role of the admin")
+	keyTTL := flags.Duration("key-ttl", 0, "lifetime of the issued key, keys don't expire by default")
+	prefix := flags.String("prefix", "", "prefix of the key")
+	flags.Parse(args)
+
+	switch command {
+	case "create":
+		err = createAdmin(db, *name, models.AdminRole(strings.ToUpper(*role)), *keyTTL)
+	case "set-role":
+		err = updateAdmin(db, *name, "role", models.AdminRole(strings.ToUpper(*role)))
+	case "disable":
+		err = updateAdmin(db, *name, "is_disabled", true)
+	case "enable":
+		err = updateAdmin(db, *name, "is_disabled", false)
+	case "issue-key":
+		err = issueKey(db, *name, *keyTTL)
+	case "revoke-key":
+		err = revokeKey(db, *prefix)
+	case "list":
+		err = listAdmins(db)
+	default:
+		exit(nil)
+	}
+
+	if err != nil {
+		exit(err)
+	}
+}
+
+func createAdmin(db *gorm.DB, name string, role models.AdminRole, keyTTL time.Duration) error {
+	if name == "" {
+		return errors.New("-name is required")
+	}
+
+	if err := validateRole(role); err != nil {
+		return err
+	}
+
+	admin := models.Admin{Name: name, Role: role}
+	tx := db.Create(&admin)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	fmt.Printf("Created admin %s with role %s\n", admin.Name, admin.Role)
+
+	return issueKey(db, name, keyTTL)
+}
+
+func updateAdmin(db *gorm.DB, name string, column string, value interface{}) error {
+	if role, ok := value.(models.AdminRole); ok {
+		if err := validateRole(role); err != nil {
+			return err
+		}
+	}
+
+	tx := db.Model(&models.Admin{}).Where("name = ?", name).Update(column, value)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if tx.RowsAffected == 0 {
+		return fmt.Errorf("admin %q not found", name)
+	}
+
+	fmt.Printf("Updated %s of admin %s to %v\n", column, name, value)
+	return nil
+}
+
+func issueKey(db *gorm.DB, name string, keyTTL time.Duration) error {
+	var admin models.Admin
+	tx := db.First(&admin, "name = ?", name)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("admin %q not found", name)
+		}
+
+		return tx.Error
+	}
+
+	var expiresAt *time.Time
+	if keyTTL > 0 {
+		t := time.Now().Add(keyTTL)
+		expiresAt = &t
+	}
+
+	key, adminKey, err := middleware.IssueAdminKey(db, &admin, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Issued key %s for admin %s, pass it in `x-admin-key` header. It is shown only once:\n\n%s\n", adminKey.Prefix, admin.Name, key)
+	return nil
+}
+
+func revokeKey(db *gorm.DB, prefix string) error {
+	if prefix == "" {
+		return errors.New("-prefix is required")
+	}
+
+	tx := db.Model(&models.AdminKey{}).Where("prefix = ? AND revoked_at IS NULL", prefix).Update("revoked_at", time.Now())
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if tx.RowsAffected == 0 {
+		return fmt.Errorf("active key %q not found", prefix)
+	}
+
+	fmt.Printf("Revoked %d key(s) with prefix %s\n", tx.RowsAffected, prefix)
+	return nil
+}
+
+func listAdmins(db *gorm.DB) error {
+	var keys []models.AdminKey
+	tx := db.Order("admin_id, id").Find(&keys)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	var admins []models.Admin
+	tx = db.Order("id").Find(&admins)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ADMIN\tROLE\tDISABLED\tKEY\tEXPIRES\tREVOKED\tLAST USED")
+
+	for _, admin := range admins {
+		listed := false
+		for _, key := range keys {
+			if key.AdminID != admin.ID {
+				continue
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%s\t%s\t%s\n", admin.Name, admin.Role, admin.IsDisabled, key.Prefix, formatTime(key.ExpiresAt), formatTime(key.RevokedAt), formatTime(key.LastUsedAt))
+			listed = true
+		}
+
+		if !listed {
+			fmt.Fprintf(w, "%s\t%s\t%v\t-\t-\t-\t-\n", admin.Name, admin.Role, admin.IsDisabled)
+		}
+	}
+
+	return w.Flush()
+}
+
+func validateRole(role models.AdminRole) error {
+	for _, r := range models.AdminRoles {
+		if r == role {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown role %q, should be one of %v", role, models.AdminRoles)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func exit(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: "+err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, usage, models.AdminRoles)
+	os.Exit(2)
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"dvpn/controllers"
 	"dvpn/core"
+	"dvpn/internal/events"
+	"dvpn/internal/selection"
 	sentinelAPI "dvpn/internal/sentinel"
+	"dvpn/internal/vault"
 	"dvpn/jobs"
 	"dvpn/middleware"
 	"dvpn/models"
@@ -11,6 +15,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/go-co-op/gocron"
 	"github.com/joho/godotenv"
+	"go.uber.org/zap"
 	"os"
 	"strconv"
 	"time"
@@ -30,6 +35,14 @@ func main() {
 		&models.Server{},
 		&models.SentinelPlanSubscription{},
 		&models.SentinelNodeSubscription{},
+		&models.SentinelTransaction{},
+		&models.Admin{},
+		&models.AdminKey{},
+		&models.DeviceUsage{},
+		&models.QuotaPolicy{},
+		&models.SentinelSession{},
+		&models.Connection{},
+		&models.ConnectionAttempt{},
 	)
 	if err != nil {
 		panic(err)
@@ -40,6 +53,11 @@ func main() {
 		panic(err)
 	}
 
+	walletVault, err := vault.NewFromEnv()
+	if err != nil {
+		panic(err)
+	}
+
 	engine := gin.Default()
 	err = engine.SetTrustedProxies(nil)
 	if err != nil {
@@ -51,40 +69,135 @@ func main() {
 		panic(err)
 	}
 
+	// Wallets stored before encryption can't be opened until they are migrated, see example.env
+	var plaintextDevices int64
+	tx := db.Model(&models.Device{}).Where("wallet_key_version = ?", vault.KeyVersionPlaintext).Count(&plaintextDevices)
+	if tx.Error != nil {
+		panic(tx.Error)
+	}
+	if plaintextDevices > 0 && !walletVault.AllowPlaintext {
+		logger.Errorf("%d devices have wallet entropy which is not encrypted and can't connect, run `go run ./cmd/rotate-wallet-keys -migrate-plaintext`", plaintextDevices)
+	}
+
 	auth := &middleware.AuthMiddleware{
-		DB:     db,
-		Logger: logger.With("middleware", "auth"),
+		DB:              db,
+		Logger:          logger.With("middleware", "auth"),
+		TokenTTL:        envDuration("DEVICE_TOKEN_TTL", 30*24*time.Hour),
+		RefreshTokenTTL: envDuration("DEVICE_REFRESH_TOKEN_TTL", 365*24*time.Hour),
+		LegacyTokenTTL:  envDuration("DEVICE_LEGACY_TOKEN_TTL", 24*time.Hour),
+	}
+
+	var sentinel sentinelAPI.SentinelClient
+
+	var gasEstimator *sentinelAPI.GasEstimator
+	if os.Getenv("SENTINEL_CLIENT") == "fake" {
+		fakeNodes, _ := strconv.Atoi(os.Getenv("SENTINEL_FAKE_NODES"))
+		fakeSeed, _ := strconv.ParseInt(os.Getenv("SENTINEL_FAKE_SEED"), 10, 64)
+
+		logger.Warn("Using in-memory fake Sentinel chain")
+		sentinel = sentinelAPI.NewFakeSentinel(sentinelAPI.FakeSentinelConfig{
+			ProviderPlanID:        os.Getenv("SENTINEL_PROVIDER_PLAN_ID"),
+			ProviderWalletAddress: os.Getenv("SENTINEL_PROVIDER_WALLET_ADDRESS"),
+			DefaultDenom:          os.Getenv("SENTINEL_DEFAULT_DENOM"),
+			Nodes:                 fakeNodes,
+			Seed:                  fakeSeed,
+		})
+	} else {
+		gasBase, err := strconv.ParseInt(os.Getenv("SENTINEL_GAS_BASE"), 10, 64)
+		if err != nil {
+			panic(err)
+		}
+
+		gasEstimator = sentinelAPI.NewGasEstimator(
+			envFloat("SENTINEL_GAS_MULTIPLIER", 1.3),
+			int64(envInt("SENTINEL_GAS_CAP", 5000000)),
+			envDuration("SENTINEL_GAS_CACHE_TTL", 10*time.Minute),
+		)
+
+		transport := sentinelAPI.NewTransport(sentinelAPI.TransportConfig{
+			Timeout:          envDuration("SENTINEL_HTTP_TIMEOUT", 30*time.Second),
+			MaxRetries:       envInt("SENTINEL_HTTP_MAX_RETRIES", 2),
+			BackoffBase:      envDuration("SENTINEL_HTTP_BACKOFF_BASE", 250*time.Millisecond),
+			BackoffMax:       envDuration("SENTINEL_HTTP_BACKOFF_MAX", 5*time.Second),
+			BreakerThreshold: envInt("SENTINEL_BREAKER_THRESHOLD", 5),
+			BreakerCooldown:  envDuration("SENTINEL_BREAKER_COOLDOWN", 30*time.Second),
+		})
+
+		gateway := &sentinelAPI.Sentinel{
+			APIEndpoint:                      os.Getenv("SENTINEL_API_ENDPOINT"),
+			RPCEndpoint:                      os.Getenv("SENTINEL_RPC_ENDPOINT"),
+			ProviderPlanID:                   os.Getenv("SENTINEL_PROVIDER_PLAN_ID"),
+			ProviderWalletAddress:            os.Getenv("SENTINEL_PROVIDER_WALLET_ADDRESS"),
+			ProviderMnemonic:                 os.Getenv("SENTINEL_PROVIDER_WALLET_MNEMONIC"),
+			NodeSubscriberWalletAddress:      os.Getenv("SENTINEL_NODE_SUBSCRIBER_WALLET_ADDRESS"),
+			NodeSubscriberMnemonic:           os.Getenv("SENTINEL_NODE_SUBSCRIBER_WALLET_MNEMONIC"),
+			NodeLinkerWalletAddress:          os.Getenv("SENTINEL_NODE_LINKER_WALLET_ADDRESS"),
+			NodeLinkerMnemonic:               os.Getenv("SENTINEL_NODE_LINKER_WALLET_MNEMONIC"),
+			NodeRemoverWalletAddress:         os.Getenv("SENTINEL_NODE_REMOVER_WALLET_ADDRESS"),
+			NodeRemoverMnemonic:              os.Getenv("SENTINEL_NODE_REMOVER_WALLET_MNEMONIC"),
+			FeeGranterWalletAddress:          os.Getenv("SENTINEL_FEE_GRANTER_WALLET_ADDRESS"),
+			FeeGranterMnemonic:               os.Getenv("SENTINEL_FEE_GRANTER_WALLET_MNEMONIC"),
+			MainSubscriberWalletAddress:      os.Getenv("SENTINEL_MAIN_SUBSCRIBER_WALLET_ADDRESS"),
+			MainSubscriberMnemonic:           os.Getenv("SENTINEL_MAIN_SUBSCRIBER_WALLET_MNEMONIC"),
+			SubscriptionUpdaterWalletAddress: os.Getenv("SENTINEL_SUBSCRIPTION_UPDATER_WALLET_ADDRESS"),
+			SubscriptionUpdaterMnemonic:      os.Getenv("SENTINEL_SUBSCRIPTION_UPDATER_WALLET_MNEMONIC"),
+			WalletEnrollerWalletAddress:      os.Getenv("SENTINEL_WALLET_ENROLLER_WALLET_ADDRESS"),
+			WalletEnrollerMnemonic:           os.Getenv("SENTINEL_WALLET_ENROLLER_WALLET_MNEMONIC"),
+			DefaultDenom:                     os.Getenv("SENTINEL_DEFAULT_DENOM"),
+			ChainID:                          os.Getenv("SENTINEL_CHAIN_ID"),
+			GasPrice:                         os.Getenv("SENTINEL_GAS_PRICE"),
+			GasBase:                          gasBase,
+			Transport:                        transport,
+			NodeTransport:                    sentinelAPI.DefaultNodeTransport(),
+			HealthTransport:                  sentinelAPI.DefaultTransport(),
+			Gas:                              gasEstimator,
+			Logger:                           logger.With("client", "sentinel"),
+		}
+
+		gateway.Protocol = detectSentinelProtocol(gateway, os.Getenv("SENTINEL_PROTOCOL_VERSION"), logger)
+		sentinel = gateway
+	}
+
+	bus := events.NewBus()
+
+	recordDeviceUsageJob := &jobs.RecordDeviceUsageJob{
+		DB:       db,
+		Logger:   logger,
+		Sentinel: sentinel,
+		Events:   bus,
+		Interval: envDuration("DEVICE_USAGE_INTERVAL", time.Hour),
 	}
 
-	gasBase, err := strconv.ParseInt(os.Getenv("SENTINEL_GAS_BASE"), 10, 64)
+	models.ReservationHalfLife = envDuration("SERVER_RESERVATION_HALF_LIFE", models.ReservationHalfLife)
+
+	failures := selection.NewFailures(envDuration("SERVER_SELECTION_FAILURE_WINDOW", 15*time.Minute))
+	serverSelection, err := selection.NewFromEnv(failures)
 	if err != nil {
 		panic(err)
 	}
 
-	sentinel := &sentinelAPI.Sentinel{
-		APIEndpoint:                      os.Getenv("SENTINEL_API_ENDPOINT"),
-		RPCEndpoint:                      os.Getenv("SENTINEL_RPC_ENDPOINT"),
-		ProviderPlanID:                   os.Getenv("SENTINEL_PROVIDER_PLAN_ID"),
-		ProviderWalletAddress:            os.Getenv("SENTINEL_PROVIDER_WALLET_ADDRESS"),
-		ProviderMnemonic:                 os.Getenv("SENTINEL_PROVIDER_WALLET_MNEMONIC"),
-		NodeSubscriberWalletAddress:      os.Getenv("SENTINEL_NODE_SUBSCRIBER_WALLET_ADDRESS"),
-		NodeSubscriberMnemonic:           os.Getenv("SENTINEL_NODE_SUBSCRIBER_WALLET_MNEMONIC"),
-		NodeLinkerWalletAddress:          os.Getenv("SENTINEL_NODE_LINKER_WALLET_ADDRESS"),
-		NodeLinkerMnemonic:               os.Getenv("SENTINEL_NODE_LINKER_WALLET_MNEMONIC"),
-		NodeRemoverWalletAddress:         os.Getenv("SENTINEL_NODE_REMOVER_WALLET_ADDRESS"),
-		NodeRemoverMnemonic:              os.Getenv("SENTINEL_NODE_REMOVER_WALLET_MNEMONIC"),
-		FeeGranterWalletAddress:          os.Getenv("SENTINEL_FEE_GRANTER_WALLET_ADDRESS"),
-		FeeGranterMnemonic:               os.Getenv("SENTINEL_FEE_GRANTER_WALLET_MNEMONIC"),
-		MainSubscriberWalletAddress:      os.Getenv("SENTINEL_MAIN_SUBSCRIBER_WALLET_ADDRESS"),
-		MainSubscriberMnemonic:           os.Getenv("SENTINEL_MAIN_SUBSCRIBER_WALLET_MNEMONIC"),
-		SubscriptionUpdaterWalletAddress: os.Getenv("SENTINEL_SUBSCRIPTION_UPDATER_WALLET_ADDRESS"),
-		SubscriptionUpdaterMnemonic:      os.Getenv("SENTINEL_SUBSCRIPTION_UPDATER_WALLET_MNEMONIC"),
-		WalletEnrollerWalletAddress:      os.Getenv("SENTINEL_WALLET_ENROLLER_WALLET_ADDRESS"),
-		WalletEnrollerMnemonic:           os.Getenv("SENTINEL_WALLET_ENROLLER_WALLET_MNEMONIC"),
-		DefaultDenom:                     os.Getenv("SENTINEL_DEFAULT_DENOM"),
-		ChainID:                          os.Getenv("SENTINEL_CHAIN_ID"),
-		GasPrice:                         os.Getenv("SENTINEL_GAS_PRICE"),
-		GasBase:                          gasBase,
+	quarantineNodesJob := &jobs.QuarantineNodesJob{
+		DB:        db,
+		Logger:    logger,
+		Sentinel:  sentinel,
+		Threshold: envInt("NODE_QUARANTINE_THRESHOLD", 5),
+		Cooldown:  envDuration("NODE_QUARANTINE_COOLDOWN", 10*time.Minute),
+	}
+
+	connectDevicesJob := &jobs.ConnectDevicesJob{
+		DB:          db,
+		Logger:      logger,
+		Sentinel:    sentinel,
+		Vault:       walletVault,
+		Events:      bus,
+		Failures:    failures,
+		Quarantine:  quarantineNodesJob,
+		Concurrency: envInt("CONNECTIONS_CONCURRENCY", 10),
+		Timeout:     envDuration("CONNECTION_TIMEOUT", 2*time.Minute),
+		TTL:         envDuration("CONNECTION_TTL", 15*time.Minute),
+
+		FailoverAttempts: envInt("CONNECT_FAILOVER_ATTEMPTS", 3),
+		FailoverBudget:   envDuration("CONNECT_FAILOVER_BUDGET", 20*time.Second),
 	}
 
 	router := routers.Router{
@@ -97,12 +210,43 @@ func main() {
 			DB:     db,
 			Logger: logger.With("controller", "devices"),
 			Auth:   auth,
+			Vault:  walletVault,
+			Usage:  recordDeviceUsageJob,
 		},
 		VPNController: &controllers.VPNController{
+			DB:          db,
+			Logger:      logger.With("controller", "vpn"),
+			Auth:        auth,
+			Sentinel:    sentinel,
+			Vault:       walletVault,
+			Usage:       recordDeviceUsageJob,
+			Selection:   serverSelection,
+			Connections: connectDevicesJob,
+		},
+		SessionsController: &controllers.SessionsController{
 			DB:       db,
-			Logger:   logger.With("controller", "vpn"),
+			Logger:   logger.With("controller", "sessions"),
 			Auth:     auth,
 			Sentinel: sentinel,
+			Cache:    controllers.NewSessionsCache(envDuration("SESSIONS_CACHE_TTL", 30*time.Second)),
+		},
+		AdminController: &controllers.AdminController{
+			DB:     db,
+			Logger: logger.With("controller", "admin"),
+			Auth:   auth,
+			Events: bus,
+			UnlinkNodes: &jobs.UnlinkNodesFromPlanJob{
+				DB:       db,
+				Logger:   logger,
+				Sentinel: sentinel,
+			},
+		},
+		EventsController: &controllers.EventsController{
+			DB:        db,
+			Logger:    logger.With("controller", "events"),
+			Auth:      auth,
+			Events:    bus,
+			Heartbeat: envDuration("EVENTS_HEARTBEAT", 15*time.Second),
 		},
 	}
 
@@ -118,12 +262,26 @@ func main() {
 			DB:       db,
 			Logger:   logger,
 			Sentinel: sentinel,
+			Events:   bus,
+		}
+
+		quotas := jobs.QuotaPolicies{
+			DefaultBytes: int64(envInt("DEVICE_DEFAULT_QUOTA_BYTES", 100000000000000)),
 		}
 
 		enrollWalletJob := jobs.EnrollWalletsJob{
 			DB:       db,
 			Logger:   logger,
 			Sentinel: sentinel,
+			Quotas:   quotas,
+			Events:   bus,
+		}
+
+		adjustAllocationsJob := jobs.AdjustAllocationsJob{
+			DB:       db,
+			Logger:   logger,
+			Sentinel: sentinel,
+			Quotas:   quotas,
 		}
 
 		linkNodesWithPlanJob := jobs.LinkNodesWithPlanJob{
@@ -138,6 +296,29 @@ func main() {
 			Sentinel: sentinel,
 		}
 
+		refreshServerLoadsJob := jobs.RefreshServerLoadsJob{
+			DB:          db,
+			Logger:      logger,
+			Sentinel:    sentinel,
+			Concurrency: envInt("SERVER_LOADS_CONCURRENCY", 16),
+
+			FailureThreshold: envInt("SERVER_LOADS_FAILURE_THRESHOLD", 3),
+		}
+
+		expireBansJob := jobs.ExpireBansJob{
+			DB:     db,
+			Logger: logger,
+		}
+
+		trackTransactionsJob := jobs.TrackTransactionsJob{
+			DB:       db,
+			Logger:   logger,
+			Sentinel: sentinel,
+			Events:   bus,
+			Timeout:  envDuration("SENTINEL_TX_TIMEOUT", 5*time.Minute),
+			Gas:      gasEstimator,
+		}
+
 		sentinelScheduler := gocron.NewScheduler(time.UTC)
 		sentinelScheduler.SetMaxConcurrentJobs(1, gocron.RescheduleMode)
 		sentinelScheduler.Every(1).Hour().Do(func() {
@@ -145,6 +326,13 @@ func main() {
 		})
 		sentinelScheduler.StartAsync()
 
+		loadsScheduler := gocron.NewScheduler(time.UTC)
+		loadsScheduler.SetMaxConcurrentJobs(1, gocron.RescheduleMode)
+		loadsScheduler.Every(envDuration("SERVER_LOADS_INTERVAL", time.Minute)).Do(func() {
+			refreshServerLoadsJob.Run()
+		})
+		loadsScheduler.StartAsync()
+
 		grantFeeScheduler := gocron.NewScheduler(time.UTC)
 		grantFeeScheduler.SetMaxConcurrentJobs(1, gocron.RescheduleMode)
 		grantFeeScheduler.Every(1).Seconds().Do(func() {
@@ -156,6 +344,7 @@ func main() {
 		enrollWalletScheduler.SetMaxConcurrentJobs(1, gocron.RescheduleMode)
 		enrollWalletScheduler.Every(1).Seconds().Do(func() {
 			enrollWalletJob.Run()
+			adjustAllocationsJob.Run()
 		})
 		enrollWalletScheduler.StartAsync()
 
@@ -166,11 +355,105 @@ func main() {
 			unlinkNodesFromPlanJob.Run()
 		})
 		planScheduler.StartAsync()
+
+		bansScheduler := gocron.NewScheduler(time.UTC)
+		bansScheduler.SetMaxConcurrentJobs(1, gocron.RescheduleMode)
+		bansScheduler.Every(1).Minute().Do(func() {
+			expireBansJob.Run()
+		})
+		bansScheduler.StartAsync()
+
+		usageScheduler := gocron.NewScheduler(time.UTC)
+		usageScheduler.SetMaxConcurrentJobs(1, gocron.RescheduleMode)
+		usageScheduler.Every(10).Seconds().Do(func() {
+			recordDeviceUsageJob.Run()
+		})
+		usageScheduler.StartAsync()
+
+		transactionsScheduler := gocron.NewScheduler(time.UTC)
+		transactionsScheduler.SetMaxConcurrentJobs(1, gocron.RescheduleMode)
+		transactionsScheduler.Every(3).Seconds().Do(func() {
+			trackTransactionsJob.Run()
+		})
+		transactionsScheduler.StartAsync()
+
+		quarantineScheduler := gocron.NewScheduler(time.UTC)
+		quarantineScheduler.SetMaxConcurrentJobs(1, gocron.RescheduleMode)
+		quarantineScheduler.Every(30).Seconds().Do(func() {
+			quarantineNodesJob.Run()
+		})
+		quarantineScheduler.StartAsync()
 	}
 
+	// Connections are processed in debug too, asynchronous connects would stay queued forever otherwise
+	connectionsScheduler := gocron.NewScheduler(time.UTC)
+	connectionsScheduler.SetMaxConcurrentJobs(1, gocron.RescheduleMode)
+	connectionsScheduler.Every(1).Seconds().Do(func() {
+		connectDevicesJob.Run()
+	})
+	connectionsScheduler.StartAsync()
+
 	logger.Info("Registering routes...")
 	router.RegisterRoutes(engine)
 
 	logger.Info("Launching API server...")
 	engine.Run()
 }
+
+// detectSentinelProtocol resolves the configured protocol version and checks it against the one the gateway speaks
+func detectSentinelProtocol(gateway *sentinelAPI.Sentinel, version string, logger *zap.SugaredLogger) *sentinelAPI.Protocol {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	detected, err := gateway.DetectProtocol(ctx)
+
+	if version == "" || version == sentinelAPI.ProtocolVersionAuto {
+		if err != nil {
+			panic(err)
+		}
+
+		logger.Infof("Detected Sentinel protocol %s, role wallets should be granted %v", detected.Version, detected.Messages())
+		return detected
+	}
+
+	protocol, perr := sentinelAPI.ProtocolForVersion(version)
+	if perr != nil {
+		panic(perr)
+	}
+
+	if err != nil {
+		logger.Warnf("Using Sentinel protocol %s, failed to check it against the gateway: %s", protocol.Version, err)
+	} else if detected.Version != protocol.Version {
+		logger.Warnf("Using Sentinel protocol %s, but the gateway speaks %s", protocol.Version, detected.Version)
+	}
+
+	logger.Infof("Using Sentinel protocol %s, role wallets should be granted %v", protocol.Version, protocol.Messages())
+	return protocol
+}
+
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+func envFloat(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"dvpn/core"
+	"dvpn/internal/vault"
+	"dvpn/models"
+	"flag"
+
+	"github.com/joho/godotenv"
+)
+
+// Re-encrypts wallet entropy of devices with the current master key.
+// To rotate master keys, add a new key next to the old ones, point WALLET_MASTER_KEY_VERSION to it,
+// restart the API and run this command. The old key can be removed once no rows reference it.
+// Rows written before wallet entropy was encrypted are only migrated with -migrate-plaintext.
+func main() {
+	batchSize := flag.Int("batch", 500, "number of devices re-encrypted in a single transaction")
+	migratePlaintext := flag.Bool("migrate-plaintext", false, "encrypt wallet entropy of devices which is not encrypted yet")
+	flag.Parse()
+
+	godotenv.Load()
+
+	logger, err := core.NewLogger()
+	if err != nil {
+		panic(err)
+	}
+
+	db, err := core.InitDB()
+	if err != nil {
+		panic(err)
+	}
+
+	err = db.AutoMigrate(&models.Device{})
+	if err != nil {
+		panic(err)
+	}
+
+	walletVault, err := vault.NewFromEnv()
+	if err != nil {
+		panic(err)
+	}
+
+	if *migratePlaintext {
+		walletVault.AllowPlaintext = true
+	}
+
+	logger.Infof("Rotating wallet entropy of devices to master key %d, configured keys are %v", walletVault.CurrentVersion(), walletVault.Versions())
+
+	result, err := walletVault.RotateDevices(db, *batchSize, logger)
+	if err != nil {
+		logger.Fatalf("failed to rotate wallet entropy of devices: %s", err)
+	}
+
+	var remaining []struct {
+		WalletKeyVersion int
+		Count            int64
+	}
+	tx := db.Model(&models.Device{}).Select("wallet_key_version, count(*) as count").Group("wallet_key_version").Scan(&remaining)
+	if tx.Error != nil {
+		logger.Fatalf("failed to count devices by master key: %s", tx.Error)
+	}
+
+	logger.Infof("Rotated %d devices, failed to decrypt %d, devices by master key: %v", result.Rotated, result.Failed, remaining)
+	logger.Sync()
+}
@@ -0,0 +1,348 @@
+package controllers
+
+import (
+	"dvpn/internal/events"
+	"dvpn/jobs"
+	"dvpn/middleware"
+	"dvpn/models"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const adminListDefaultLimit = 50
+const adminListMaxLimit = 500
+
+type AdminController struct {
+	DB     *gorm.DB
+	Logger *zap.SugaredLogger
+	Auth   *middleware.AuthMiddleware
+	Events *events.Bus
+
+	// Banned servers are removed from the plan right away instead of waiting for the next run of the job
+	UnlinkNodes *jobs.UnlinkNodesFromPlanJob
+}
+
+type adminDevice struct {
+	Device     models.Device `json:"device"`
+	Ban        models.Ban    `json:"ban"`
+	QuotaBytes int64         `json:"quota_bytes"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+type adminServer struct {
+	Server           models.Server `json:"server"`
+	Ban              models.Ban    `json:"ban"`
+	IsIncludedInPlan bool          `json:"is_included_in_plan"`
+	PlanTxHash       *string       `json:"plan_tx_hash,omitempty"`
+}
+
+type banPayload struct {
+	Reason    string     `json:"reason" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (ac AdminController) ListDevices(c *gin.Context) {
+	query, ok := ac.listQuery(c, ac.DB.Model(&models.Device{}))
+	if !ok {
+		return
+	}
+
+	if search := c.Query("query"); search != "" {
+		id, _ := strconv.ParseUint(search, 10, 64)
+		query = query.Where("id = ? OR wallet_address ILIKE ?", id, "%"+search+"%")
+	}
+
+	var devices []models.Device
+	tx := query.Order("id").Find(&devices)
+	if tx.Error != nil {
+		reason := "failed to get devices: " + tx.Error.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		ac.Logger.Error(reason)
+		return
+	}
+
+	result := make([]adminDevice, 0, len(devices))
+	for _, device := range devices {
+		result = append(result, newAdminDevice(device))
+	}
+
+	middleware.RespondOK(c, result)
+}
+
+func (ac AdminController) BanDevice(c *gin.Context) {
+	var payload banPayload
+	if err := c.BindJSON(&payload); err != nil {
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid request payload: "+err.Error())
+		return
+	}
+
+	ac.updateDeviceBan(c, &payload)
+}
+
+func (ac AdminController) UnbanDevice(c *gin.Context) {
+	ac.updateDeviceBan(c, nil)
+}
+
+func (ac AdminController) ListServers(c *gin.Context) {
+	query, ok := ac.listQuery(c, ac.DB.Model(&models.Server{}))
+	if !ok {
+		return
+	}
+
+	if search := c.Query("query"); search != "" {
+		id, _ := strconv.ParseUint(search, 10, 64)
+		query = query.Where("id = ? OR name ILIKE ? OR \"configuration\"->>'address' ILIKE ?", id, "%"+search+"%", "%"+search+"%")
+	}
+
+	var servers []models.Server
+	tx := query.Order("id").Find(&servers)
+	if tx.Error != nil {
+		reason := "failed to get servers: " + tx.Error.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		ac.Logger.Error(reason)
+		return
+	}
+
+	result := make([]adminServer, 0, len(servers))
+	for _, server := range servers {
+		result = append(result, newAdminServer(server))
+	}
+
+	middleware.RespondOK(c, result)
+}
+
+func (ac AdminController) BanServer(c *gin.Context) {
+	var payload banPayload
+	if err := c.BindJSON(&payload); err != nil {
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid request payload: "+err.Error())
+		return
+	}
+
+	ac.updateServerBan(c, &payload)
+}
+
+func (ac AdminController) UnbanServer(c *gin.Context) {
+	ac.updateServerBan(c, nil)
+}
+
+// updateDeviceBan bans the device of `device_id` param, or lifts its ban when payload is nil
+func (ac AdminController) updateDeviceBan(c *gin.Context, payload *banPayload) {
+	deviceId, err := strconv.ParseUint(c.Params.ByName("device_id"), 10, 64)
+	if err != nil {
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid device id: "+err.Error())
+		return
+	}
+
+	ban, ok := ac.newBan(c, payload)
+	if !ok {
+		return
+	}
+
+	var device models.Device
+	tx := ac.DB.First(&device, "id = ?", deviceId)
+	if tx.Error != nil {
+		ac.respondLookupErr(c, "device", tx.Error)
+		return
+	}
+
+	tx = ac.DB.Model(&device).Updates(models.BanColumns(ban))
+	if tx.Error != nil {
+		reason := "failed to update device ban: " + tx.Error.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		ac.Logger.Error(reason)
+		return
+	}
+
+	device.Ban = ban
+	ac.logBan(c, "device", device.ID, ban)
+
+	if ban.IsBanned {
+		ac.Events.Publish(events.TypeDisconnect, device.ID, events.Disconnect{Reason: events.DisconnectReasonBanned})
+	}
+
+	middleware.RespondOK(c, newAdminDevice(device))
+}
+
+// updateServerBan bans the server of `server_id` param, or lifts its ban when payload is nil.
+// Banned servers are unlinked from the plan right away, unbanned ones are linked back by LinkNodesWithPlanJob.
+func (ac AdminController) updateServerBan(c *gin.Context, payload *banPayload) {
+	serverId, err := strconv.ParseUint(c.Params.ByName("server_id"), 10, 64)
+	if err != nil {
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid server id: "+err.Error())
+		return
+	}
+
+	ban, ok := ac.newBan(c, payload)
+	if !ok {
+		return
+	}
+
+	var server models.Server
+	tx := ac.DB.First(&server, "id = ?", serverId)
+	if tx.Error != nil {
+		ac.respondLookupErr(c, "server", tx.Error)
+		return
+	}
+
+	tx = ac.DB.Model(&server).Updates(models.BanColumns(ban))
+	if tx.Error != nil {
+		reason := "failed to update server ban: " + tx.Error.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		ac.Logger.Error(reason)
+		return
+	}
+
+	server.Ban = ban
+	ac.logBan(c, "server", server.ID, ban)
+
+	if ban.IsBanned {
+		err = ac.UnlinkNodes.UnlinkServer(c.Request.Context(), &server)
+		if err != nil {
+			ac.Logger.Errorf("failed to unlink banned server %d from the plan, it will be retried by the job: %s", server.ID, err)
+		}
+	}
+
+	middleware.RespondOK(c, newAdminServer(server))
+}
+
+func (ac AdminController) newBan(c *gin.Context, payload *banPayload) (models.Ban, bool) {
+	if payload == nil {
+		return models.Ban{}, true
+	}
+
+	if payload.ExpiresAt != nil && payload.ExpiresAt.Before(time.Now()) {
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "ban expiry should be in the future")
+		return models.Ban{}, false
+	}
+
+	admin, err := ac.Auth.CurrentAdmin(c)
+	if err != nil {
+		reason := "failed to retrieve admin: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		ac.Logger.Error(reason)
+		return models.Ban{}, false
+	}
+
+	return models.NewBan(payload.Reason, admin.Name, payload.ExpiresAt), true
+}
+
+// actor returns name of the current admin for logs
+func (ac AdminController) actor(c *gin.Context) string {
+	admin, err := ac.Auth.CurrentAdmin(c)
+	if err != nil {
+		return "unknown admin"
+	}
+
+	return admin.Name
+}
+
+func (ac AdminController) logBan(c *gin.Context, kind string, id uint, ban models.Ban) {
+	actor := ac.actor(c)
+
+	if !ban.IsBanned {
+		ac.Logger.Infof("%s %d was unbanned by %s", kind, id, actor)
+		return
+	}
+
+	expiry := "permanently"
+	if ban.BanExpiresAt != nil {
+		expiry = "until " + ban.BanExpiresAt.Format(time.RFC3339)
+	}
+
+	ac.Logger.Infof("%s %d was banned by %s %s: %s", kind, id, actor, expiry, *ban.BanReason)
+}
+
+func (ac AdminController) respondLookupErr(c *gin.Context, kind string, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		middleware.RespondErr(c, middleware.APIErrorNotFound, kind+" not found")
+		return
+	}
+
+	reason := "failed to get " + kind + ": " + err.Error()
+	middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+	ac.Logger.Error(reason)
+}
+
+// listQuery applies `banned`, `offset` and `limit` query params shared by admin listings
+func (ac AdminController) listQuery(c *gin.Context, query *gorm.DB) (*gorm.DB, bool) {
+	banned := c.Query("banned")
+	if banned != "" {
+		isBanned, err := strconv.ParseBool(banned)
+		if err != nil {
+			middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid banned: "+err.Error())
+			return nil, false
+		}
+
+		query = query.Where("is_banned = ?", isBanned)
+	}
+
+	offset := c.Query("offset")
+	if offset != "" {
+		offset, err := strconv.Atoi(offset)
+		if err != nil || offset < 0 {
+			middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid offset")
+			return nil, false
+		}
+
+		query = query.Offset(offset)
+	}
+
+	limit := adminListDefaultLimit
+	if c.Query("limit") != "" {
+		l, err := strconv.Atoi(c.Query("limit"))
+		if err != nil || l <= 0 || l > adminListMaxLimit {
+			middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid limit")
+			return nil, false
+		}
+
+		limit = l
+	}
+
+	return query.Limit(limit), true
+}
+
+func newAdminDevice(device models.Device) adminDevice {
+	return adminDevice{
+		Device:     device,
+		Ban:        device.Ban,
+		QuotaBytes: device.QuotaBytes,
+		CreatedAt:  device.CreatedAt,
+	}
+}
+
+func newAdminServer(server models.Server) adminServer {
+	return adminServer{
+		Server:           server,
+		Ban:              server.Ban,
+		IsIncludedInPlan: server.IsIncludedInPlan,
+		PlanTxHash:       server.PlanTxHash,
+	}
+}
+
+// GetAdmin returns the current admin with permissions of its role
+func (ac AdminController) GetAdmin(c *gin.Context) {
+	admin, err := ac.Auth.CurrentAdmin(c)
+	if err != nil {
+		reason := "failed to retrieve admin: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		ac.Logger.Error(reason)
+		return
+	}
+
+	permissions := make([]middleware.AdminPermission, 0)
+	for _, permission := range middleware.AdminPermissions {
+		if middleware.HasAdminPermission(admin.Role, permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+
+	middleware.RespondOK(c, gin.H{
+		"admin":       admin,
+		"permissions": permissions,
+	})
+}
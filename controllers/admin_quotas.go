@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"dvpn/middleware"
+	"dvpn/models"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListQuotaPolicies returns all quota policies, see models.QuotaPolicy
+func (ac AdminController) ListQuotaPolicies(c *gin.Context) {
+	var policies []models.QuotaPolicy
+	tx := ac.DB.Order("id").Find(&policies)
+	if tx.Error != nil {
+		reason := "failed to get quota policies: " + tx.Error.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		ac.Logger.Error(reason)
+		return
+	}
+
+	middleware.RespondOK(c, policies)
+}
+
+// SetQuotaPolicy creates or updates the quota policy of a platform, a tier or a device.
+// Allocations of enrolled devices are adjusted to the new policy by AdjustAllocationsJob.
+func (ac AdminController) SetQuotaPolicy(c *gin.Context) {
+	type requestPayload struct {
+		Platform *models.DevicePlatform `json:"platform"`
+		Tier     *string                `json:"tier"`
+		DeviceID *uint                  `json:"device_id"`
+		Bytes    int64                  `json:"bytes" binding:"required,gt=0"`
+	}
+
+	var payload requestPayload
+	if err := c.BindJSON(&payload); err != nil {
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid request payload: "+err.Error())
+		return
+	}
+
+	var policy models.QuotaPolicy
+	query := ac.DB.Model(&models.QuotaPolicy{})
+
+	switch {
+	case payload.Platform != nil && payload.Tier == nil && payload.DeviceID == nil:
+		query = query.Where("platform = ?", *payload.Platform)
+	case payload.Platform == nil && payload.Tier != nil && payload.DeviceID == nil:
+		query = query.Where("tier = ?", *payload.Tier)
+	case payload.Platform == nil && payload.Tier == nil && payload.DeviceID != nil:
+		query = query.Where("device_id = ?", *payload.DeviceID)
+
+		var device models.Device
+		tx := ac.DB.First(&device, "id = ?", *payload.DeviceID)
+		if tx.Error != nil {
+			ac.respondLookupErr(c, "device", tx.Error)
+			return
+		}
+	default:
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "exactly one of platform, tier and device_id should be set")
+		return
+	}
+
+	tx := query.Limit(1).Find(&policy)
+	if tx.Error != nil {
+		reason := "failed to get quota policy: " + tx.Error.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		ac.Logger.Error(reason)
+		return
+	}
+
+	policy.Platform = payload.Platform
+	policy.Tier = payload.Tier
+	policy.DeviceID = payload.DeviceID
+	policy.Bytes = payload.Bytes
+
+	tx = ac.DB.Save(&policy)
+	if tx.Error != nil {
+		reason := "failed to save quota policy: " + tx.Error.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		ac.Logger.Error(reason)
+		return
+	}
+
+	ac.Logger.Infof("quota policy %d was set to %d bytes by %s", policy.ID, policy.Bytes, ac.actor(c))
+
+	middleware.RespondOK(c, policy)
+}
+
+func (ac AdminController) DeleteQuotaPolicy(c *gin.Context) {
+	policyId, err := strconv.ParseUint(c.Params.ByName("policy_id"), 10, 64)
+	if err != nil {
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid policy id: "+err.Error())
+		return
+	}
+
+	tx := ac.DB.Delete(&models.QuotaPolicy{}, policyId)
+	if tx.Error != nil {
+		reason := "failed to delete quota policy: " + tx.Error.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		ac.Logger.Error(reason)
+		return
+	}
+
+	if tx.RowsAffected == 0 {
+		middleware.RespondErr(c, middleware.APIErrorNotFound, "quota policy not found")
+		return
+	}
+
+	middleware.RespondOK(c, nil)
+}
+
+// SetDeviceTier moves a device to a tier, or out of any tier when `tier` is null
+func (ac AdminController) SetDeviceTier(c *gin.Context) {
+	type requestPayload struct {
+		Tier *string `json:"tier"`
+	}
+
+	deviceId, err := strconv.ParseUint(c.Params.ByName("device_id"), 10, 64)
+	if err != nil {
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid device id: "+err.Error())
+		return
+	}
+
+	var payload requestPayload
+	if err := c.BindJSON(&payload); err != nil {
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid request payload: "+err.Error())
+		return
+	}
+
+	var device models.Device
+	tx := ac.DB.First(&device, "id = ?", deviceId)
+	if tx.Error != nil {
+		ac.respondLookupErr(c, "device", tx.Error)
+		return
+	}
+
+	tx = ac.DB.Model(&device).Update("tier", payload.Tier)
+	if tx.Error != nil {
+		reason := "failed to update device tier: " + tx.Error.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		ac.Logger.Error(reason)
+		return
+	}
+
+	device.Tier = payload.Tier
+	middleware.RespondOK(c, newAdminDevice(device))
+}
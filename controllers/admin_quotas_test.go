@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"dvpn/middleware"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+)
+
+func TestSetQuotaPolicy(t *testing.T) {
+	columns := []string{"id", "platform", "tier", "device_id", "bytes"}
+
+	tests := []struct {
+		name   string
+		body   string
+		expect func(mock sqlmock.Sqlmock)
+		want   int
+	}{
+		{"new platform policy", `{"platform":"IOS","bytes":1000}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "quota_policies" WHERE platform = \$1 LIMIT 1`).WithArgs("IOS").WillReturnRows(sqlmock.NewRows(columns))
+			mock.ExpectQuery(`INSERT INTO "quota_policies"`).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "IOS", nil, nil, 1000).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		}, http.StatusOK},
+		{"existing tier policy", `{"tier":"premium","bytes":5000}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "quota_policies" WHERE tier = \$1 LIMIT 1`).WithArgs("premium").
+				WillReturnRows(sqlmock.NewRows(columns).AddRow(2, nil, "premium", nil, 2000))
+			mock.ExpectExec(`UPDATE "quota_policies" SET .+ WHERE "id" = \$7`).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, "premium", nil, 5000, 2).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, http.StatusOK},
+		{"unknown device", `{"device_id":9,"bytes":1000}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "devices" WHERE id = \$1`).WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		}, http.StatusNotFound},
+		{"platform and tier", `{"platform":"IOS","tier":"premium","bytes":1000}`, func(mock sqlmock.Sqlmock) {}, http.StatusBadRequest},
+		{"without bytes", `{"platform":"IOS"}`, func(mock sqlmock.Sqlmock) {}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			tt.expect(mock)
+
+			ac := AdminController{DB: db, Logger: zap.NewNop().Sugar(), Auth: &middleware.AuthMiddleware{Logger: zap.NewNop().Sugar()}}
+
+			recorder := serve("/admin/quota-policies", http.MethodPut, "/admin/quota-policies", tt.body, testAdmin, ac.SetQuotaPolicy)
+			if recorder.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", recorder.Code, tt.want, recorder.Body)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"context"
+	"dvpn/internal/events"
+	"dvpn/internal/sentinel"
+	"dvpn/jobs"
+	"dvpn/middleware"
+	"dvpn/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{
+		Logger:                 logger.Discard,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to open mock DB: %s", err)
+	}
+
+	return db, mock
+}
+
+// serve handles the request with the handler of the route, as the admin when admin is set
+func serve(route string, method string, target string, body string, admin *models.Admin, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Handle(method, route, func(c *gin.Context) {
+		if admin != nil {
+			c.Set("currentAdmin", *admin)
+		}
+	}, handler)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+
+	return recorder
+}
+
+var testAdmin = &models.Admin{Name: "operator", Role: models.AdminRoleOperator}
+
+func TestBanServer(t *testing.T) {
+	fake := sentinel.NewFakeSentinel(sentinel.FakeSentinelConfig{ProviderPlanID: "1", ProviderWalletAddress: "sentprov1provider", Nodes: 1, Seed: 1})
+	page, err := fake.FetchNodes(context.Background(), sentinel.PageRequest{Limit: 1})
+	if err != nil {
+		t.Fatalf("failed to fetch nodes: %s", err)
+	}
+	address := page.Items[0].Address
+
+	configuration := `{"address":"` + address + `"}`
+	columns := []string{"id", "is_included_in_plan", "is_banned", "plan_tx_hash", "protocols", "configuration"}
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		expect func(mock sqlmock.Sqlmock)
+		want   int
+	}{
+		{"ban unlinks server from plan", http.MethodPut, `{"reason":"abuse"}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "servers" WHERE id = \$1`).WithArgs(5).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow(5, true, false, nil, `["WIREGUARD"]`, configuration))
+			mock.ExpectExec(`UPDATE "servers" SET "ban_expires_at"=\$1,"ban_reason"=\$2,"banned_at"=\$3,"banned_by"=\$4,"is_banned"=\$5,"updated_at"=\$6 WHERE "id" = \$7`).
+				WithArgs(nil, "abuse", sqlmock.AnyArg(), testAdmin.Name, true, sqlmock.AnyArg(), 5).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(`SELECT \* FROM "servers" WHERE "servers"."id" = \$1 AND "servers"."id" = \$2`).WithArgs(5, 5).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow(5, true, true, nil, `["WIREGUARD"]`, configuration))
+			mock.ExpectBegin()
+			mock.ExpectQuery(`INSERT INTO "sentinel_transactions"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			mock.ExpectExec(`UPDATE "servers" SET "plan_tx_hash"=\$1,"updated_at"=\$2 WHERE "id" = \$3`).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 5).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}, http.StatusOK},
+		{"temporary ban", http.MethodPut, `{"reason":"abuse","expires_at":"` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "servers" WHERE id = \$1`).WithArgs(5).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow(5, false, false, nil, `["WIREGUARD"]`, configuration))
+			mock.ExpectExec(`UPDATE "servers" SET "ban_expires_at"=\$1`).
+				WithArgs(sqlmock.AnyArg(), "abuse", sqlmock.AnyArg(), testAdmin.Name, true, sqlmock.AnyArg(), 5).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			// Servers which are not in the plan have nothing to unlink
+			mock.ExpectQuery(`SELECT \* FROM "servers" WHERE "servers"."id" = \$1 AND "servers"."id" = \$2`).WithArgs(5, 5).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow(5, false, true, nil, `["WIREGUARD"]`, configuration))
+		}, http.StatusOK},
+		{"unban", http.MethodDelete, "", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "servers" WHERE id = \$1`).WithArgs(5).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow(5, false, true, nil, `["WIREGUARD"]`, configuration))
+			mock.ExpectExec(`UPDATE "servers" SET "ban_expires_at"=\$1,"ban_reason"=\$2,"banned_at"=\$3,"banned_by"=\$4,"is_banned"=\$5,"updated_at"=\$6 WHERE "id" = \$7`).
+				WithArgs(nil, nil, nil, nil, false, sqlmock.AnyArg(), 5).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, http.StatusOK},
+		{"expiry in the past", http.MethodPut, `{"reason":"abuse","expires_at":"2020-01-01T00:00:00Z"}`, func(mock sqlmock.Sqlmock) {}, http.StatusBadRequest},
+		{"missing reason", http.MethodPut, `{}`, func(mock sqlmock.Sqlmock) {}, http.StatusBadRequest},
+		{"unknown server", http.MethodPut, `{"reason":"abuse"}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "servers" WHERE id = \$1`).WithArgs(5).WillReturnRows(sqlmock.NewRows(columns))
+		}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := fake.AddNodeToPlan(context.Background(), []string{address}); err != nil {
+				t.Fatalf("failed to link node: %s", err)
+			}
+
+			db, mock := newMockDB(t)
+			tt.expect(mock)
+
+			ac := AdminController{
+				DB:          db,
+				Logger:      zap.NewNop().Sugar(),
+				Auth:        &middleware.AuthMiddleware{Logger: zap.NewNop().Sugar()},
+				UnlinkNodes: &jobs.UnlinkNodesFromPlanJob{DB: db, Logger: zap.NewNop().Sugar(), Sentinel: fake},
+			}
+
+			handler := ac.BanServer
+			if tt.method == http.MethodDelete {
+				handler = ac.UnbanServer
+			}
+
+			recorder := serve("/admin/servers/:server_id/ban", tt.method, "/admin/servers/5/ban", tt.body, testAdmin, handler)
+			if recorder.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", recorder.Code, tt.want, recorder.Body)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestBanDevice(t *testing.T) {
+	columns := []string{"id", "is_banned"}
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		expect     func(mock sqlmock.Sqlmock)
+		want       int
+		disconnect bool
+	}{
+		{"ban disconnects device", http.MethodPut, `{"reason":"abuse"}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "devices" WHERE id = \$1`).WithArgs(9).WillReturnRows(sqlmock.NewRows(columns).AddRow(9, false))
+			mock.ExpectExec(`UPDATE "devices" SET "ban_expires_at"=\$1,"ban_reason"=\$2,"banned_at"=\$3,"banned_by"=\$4,"is_banned"=\$5,"updated_at"=\$6 WHERE "id" = \$7`).
+				WithArgs(nil, "abuse", sqlmock.AnyArg(), testAdmin.Name, true, sqlmock.AnyArg(), 9).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, http.StatusOK, true},
+		{"unban", http.MethodDelete, "", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "devices" WHERE id = \$1`).WithArgs(9).WillReturnRows(sqlmock.NewRows(columns).AddRow(9, true))
+			mock.ExpectExec(`UPDATE "devices" SET "ban_expires_at"=\$1,"ban_reason"=\$2,"banned_at"=\$3,"banned_by"=\$4,"is_banned"=\$5,"updated_at"=\$6 WHERE "id" = \$7`).
+				WithArgs(nil, nil, nil, nil, false, sqlmock.AnyArg(), 9).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, http.StatusOK, false},
+		{"without admin", http.MethodPut, `{"reason":"abuse"}`, func(mock sqlmock.Sqlmock) {}, http.StatusInternalServerError, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			tt.expect(mock)
+
+			bus := events.NewBus()
+			stream, unsubscribe := bus.Subscribe(9)
+			defer unsubscribe()
+
+			ac := AdminController{
+				DB:     db,
+				Logger: zap.NewNop().Sugar(),
+				Auth:   &middleware.AuthMiddleware{Logger: zap.NewNop().Sugar()},
+				Events: bus,
+			}
+
+			handler, admin := ac.BanDevice, testAdmin
+			if tt.method == http.MethodDelete {
+				handler = ac.UnbanDevice
+			}
+			if tt.want == http.StatusInternalServerError {
+				admin = nil
+			}
+
+			recorder := serve("/admin/devices/:device_id/ban", tt.method, "/admin/devices/9/ban", tt.body, admin, handler)
+			if recorder.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", recorder.Code, tt.want, recorder.Body)
+			}
+
+			disconnected := false
+			for len(stream) > 0 {
+				event := <-stream
+				disconnected = disconnected || event.Type == events.TypeDisconnect
+			}
+
+			if disconnected != tt.disconnect {
+				t.Errorf("device disconnected = %t, want %t", disconnected, tt.disconnect)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
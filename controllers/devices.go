@@ -1,21 +1,18 @@
 package controllers
 
 import (
-	"crypto/sha256"
+	"dvpn/internal/sentinel"
+	"dvpn/internal/vault"
+	"dvpn/jobs"
 	"dvpn/middleware"
 	"dvpn/models"
-	"fmt"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/ripemd160"
 	"gorm.io/gorm"
-	"math/rand"
 	"strconv"
-	"strings"
 	"time"
 
-	bech32 "github.com/cosmos/btcutil/bech32"
-	bip32 "github.com/tyler-smith/go-bip32"
 	bip39 "github.com/tyler-smith/go-bip39"
 )
 
@@ -23,6 +20,10 @@ type DevicesController struct {
 	DB     *gorm.DB
 	Logger *zap.SugaredLogger
 	Auth   *middleware.AuthMiddleware
+	Vault  *vault.Vault
+
+	// Usage of devices is refreshed from Sentinel when they ask for it
+	Usage *jobs.RecordDeviceUsageJob
 }
 
 func (dc DevicesController) CreateDevice(c *gin.Context) {
@@ -39,37 +40,34 @@ func (dc DevicesController) CreateDevice(c *gin.Context) {
 	entropy, _ := bip39.NewEntropy(256)
 	mnemonic, _ := bip39.NewMnemonic(entropy)
 
-	seed := bip39.NewSeed(mnemonic, "")
-	masterKey, _ := bip32.NewMasterKey(seed)
-
-	childKey, err := deriveKeyFromPath(masterKey, "m/44'/118'/0'/0/0")
-
-	sha256hash := sha256.Sum256(childKey.PublicKey().Key)
-	hash := ripemd160.New()
-	hash.Write(sha256hash[:])
-
-	converted, err := bech32.ConvertBits(hash.Sum(nil), 8, 5, true)
+	signer, err := sentinel.NewSignerFromMnemonic(mnemonic)
 	if err != nil {
-		reason := "failed to convert bits: " + err.Error()
+		reason := "failed to derive wallet address: " + err.Error()
 		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
 		dc.Logger.Error(reason)
 		return
 	}
 
-	walletAddress, err := bech32.Encode("sent", converted)
+	device := models.Device{
+		Platform:      payload.Platform,
+		WalletAddress: signer.Address,
+		IsFeeGranted:  false,
+	}
+
+	err = dc.Vault.SealDeviceEntropy(&device, entropy)
 	if err != nil {
-		reason := "failed to encode wallet address: " + err.Error()
+		reason := "failed to encrypt wallet entropy: " + err.Error()
 		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
 		dc.Logger.Error(reason)
 		return
 	}
 
-	device := models.Device{
-		Platform:      payload.Platform,
-		Token:         generateDeviceToken(128),
-		WalletAddress: walletAddress,
-		WalletEntropy: entropy,
-		IsFeeGranted:  false,
+	err = dc.Auth.IssueToken(&device)
+	if err != nil {
+		reason := "failed to issue device token: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		dc.Logger.Error(reason)
+		return
 	}
 
 	tx := dc.DB.Create(&device)
@@ -95,54 +93,199 @@ func (dc DevicesController) GetDevice(c *gin.Context) {
 	middleware.RespondOK(c, device)
 }
 
-func generateDeviceToken(l int) string {
-	var charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+// GetUsage returns granted, used and remaining bytes of the current device together with recorded usage history
+func (dc DevicesController) GetUsage(c *gin.Context) {
+	device, err := dc.Auth.CurrentDevice(c)
+	if err != nil {
+		reason := "failed to retrieve device: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		dc.Logger.Error(reason)
+		return
+	}
+
+	if device.SubscriptionId == nil {
+		reason := "wallet " + device.WalletAddress + " is not yet enrolled"
+		middleware.RespondErr(c, middleware.APIErrorDeviceNotEnrolled, reason)
+		return
+	}
 
-	b := make([]byte, l)
-	for i := range b {
-		b[i] = charset[seededRand.Intn(len(charset))]
+	limit := 24
+	if c.Query("limit") != "" {
+		limit, err = strconv.Atoi(c.Query("limit"))
+		if err != nil || limit < 0 || limit > 500 {
+			middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "limit should be between 0 and 500")
+			return
+		}
 	}
 
-	return string(b)
+	usage, err := dc.Usage.Refresh(c.Request.Context(), device)
+	if err != nil {
+		dc.Logger.Warnf("failed to refresh usage of device %d, responding with the latest recorded one: %s", device.ID, err)
+		usage = device.Usage()
+		if usage == nil {
+			reason := "failed to fetch usage of device: " + err.Error()
+			middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+			dc.Logger.Error(reason)
+			return
+		}
+	}
+
+	var records []models.DeviceUsage
+	tx := dc.DB.Model(&models.DeviceUsage{}).Where("device_id = ? AND subscription_id = ?", device.ID, *device.SubscriptionId).Order("created_at desc").Limit(limit).Find(&records)
+	if tx.Error != nil {
+		reason := "failed to get usage history of device: " + tx.Error.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		dc.Logger.Error(reason)
+		return
+	}
+
+	type usageRecord struct {
+		GrantedBytes  int64     `json:"granted_bytes"`
+		UtilisedBytes int64     `json:"utilised_bytes"`
+		RecordedAt    time.Time `json:"recorded_at"`
+	}
+
+	history := make([]usageRecord, len(records))
+	for i, record := range records {
+		history[len(records)-1-i] = usageRecord{
+			GrantedBytes:  record.GrantedBytes,
+			UtilisedBytes: record.UtilisedBytes,
+			RecordedAt:    record.CreatedAt,
+		}
+	}
+
+	middleware.RespondOK(c, &struct {
+		SubscriptionID int64         `json:"subscription_id"`
+		Usage          *models.Usage `json:"usage"`
+		History        []usageRecord `json:"history"`
+	}{
+		SubscriptionID: *device.SubscriptionId,
+		Usage:          usage,
+		History:        history,
+	})
 }
 
-func deriveKeyFromPath(masterKey *bip32.Key, path string) (*bip32.Key, error) {
-	const (
-		hardenedKeyStart = 0x80000000
-	)
+// RotateToken issues a new token pair for the current device, its previous tokens stop working
+func (dc DevicesController) RotateToken(c *gin.Context) {
+	device, err := dc.Auth.CurrentDevice(c)
+	if err != nil {
+		reason := "failed to retrieve device: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		dc.Logger.Error(reason)
+		return
+	}
 
-	var indexes []uint32
+	err = dc.Auth.IssueToken(device)
+	if err != nil {
+		reason := "failed to issue device token: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		dc.Logger.Error(reason)
+		return
+	}
 
-	keys := []string{"Purpose", "CoinType", "Account", "Change", "AccountIndex"}
-	segments := strings.Split(path, "/")
-	if len(segments) == 0 || segments[0] != "m" {
-		return nil, fmt.Errorf("invalid path")
+	tx := dc.DB.Model(&models.Device{}).Where("id = ?", device.ID).Updates(tokenColumns(device))
+	if tx.Error != nil {
+		reason := "failed to save device token: " + tx.Error.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		dc.Logger.Error(reason)
+		return
+	}
+
+	middleware.RespondOK(c, device)
+}
+
+// RefreshToken exchanges a refresh token for a new token pair. Each refresh token can be used only once.
+func (dc DevicesController) RefreshToken(c *gin.Context) {
+	type requestPayload struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
 	}
 
-	for _, segment := range segments[1:] {
-		segment = strings.TrimRight(segment, "'")
-		index, err := strconv.Atoi(segment)
-		if err != nil {
-			return nil, fmt.Errorf(" segment %s invalid path: %s", segment, err)
+	var payload requestPayload
+	if err := c.BindJSON(&payload); err != nil {
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid request payload: "+err.Error())
+		return
+	}
+
+	refreshTokenHash := middleware.HashToken(payload.RefreshToken)
+
+	var device models.Device
+	tx := dc.DB.First(&device, "refresh_token_hash = ?", refreshTokenHash)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			middleware.RespondErr(c, middleware.APIErrorUnauthorizedDevice, "invalid refresh token")
+			return
 		}
-		indexes = append(indexes, uint32(index))
+
+		reason := "failed to find device by refresh token: " + tx.Error.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		dc.Logger.Error(reason)
+		return
+	}
+
+	if device.RefreshTokenExpiresAt != nil && device.RefreshTokenExpiresAt.Before(time.Now()) {
+		middleware.RespondErr(c, middleware.APIErrorExpiredDeviceToken, "refresh token is expired")
+		return
+	}
+
+	if device.IsBanActive() {
+		middleware.RespondErr(c, middleware.APIErrorBannedDevice, "device is banned")
+		return
+	}
+
+	err := dc.Auth.IssueToken(&device)
+	if err != nil {
+		reason := "failed to issue device token: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		dc.Logger.Error(reason)
+		return
+	}
+
+	tx = dc.DB.Model(&models.Device{}).Where("id = ? AND refresh_token_hash = ?", device.ID, refreshTokenHash).Updates(tokenColumns(&device))
+	if tx.Error != nil {
+		reason := "failed to save device token: " + tx.Error.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		dc.Logger.Error(reason)
+		return
 	}
 
-	if len(indexes) != 5 {
-		return nil, fmt.Errorf("invalid path length")
+	if tx.RowsAffected == 0 {
+		middleware.RespondErr(c, middleware.APIErrorUnauthorizedDevice, "refresh token is already used")
+		return
+	}
+
+	middleware.RespondOK(c, device)
+}
+
+// RevokeToken revokes all tokens of the current device
+func (dc DevicesController) RevokeToken(c *gin.Context) {
+	device, err := dc.Auth.CurrentDevice(c)
+	if err != nil {
+		reason := "failed to retrieve device: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		dc.Logger.Error(reason)
+		return
 	}
 
-	pathIndex := make(map[string]uint32)
-	for i, k := range keys {
-		pathIndex[k] = indexes[i]
+	dc.Auth.RevokeToken(device)
+
+	tx := dc.DB.Model(&models.Device{}).Where("id = ?", device.ID).Updates(tokenColumns(device))
+	if tx.Error != nil {
+		reason := "failed to revoke device token: " + tx.Error.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		dc.Logger.Error(reason)
+		return
 	}
 
-	purpose, _ := masterKey.NewChildKey(pathIndex["Purpose"] + hardenedKeyStart)
-	coinType, _ := purpose.NewChildKey(pathIndex["CoinType"] + hardenedKeyStart)
-	account, _ := coinType.NewChildKey(pathIndex["Account"] + hardenedKeyStart)
-	change, _ := account.NewChildKey(pathIndex["Change"])
-	child, _ := change.NewChildKey(pathIndex["AccountIndex"])
+	middleware.RespondOK(c, nil)
+}
 
-	return child, nil
+// tokenColumns lists token columns of a device, including cleared ones, which `Save` of the whole row would also write
+func tokenColumns(device *models.Device) map[string]interface{} {
+	return map[string]interface{}{
+		"token_hash":               device.TokenHash,
+		"token_expires_at":         device.TokenExpiresAt,
+		"refresh_token_hash":       device.RefreshTokenHash,
+		"refresh_token_expires_at": device.RefreshTokenExpiresAt,
+		"token":                    device.LegacyToken,
+	}
 }
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"dvpn/internal/events"
+	"dvpn/middleware"
+	"dvpn/models"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type EventsController struct {
+	DB     *gorm.DB
+	Logger *zap.SugaredLogger
+	Auth   *middleware.AuthMiddleware
+	Events *events.Bus
+
+	// Heartbeat is how often an idle stream is written to, so proxies don't close it
+	Heartbeat time.Duration
+}
+
+// StreamEvents streams enrollment progress, stages of connections and forced disconnects of the current device
+// as server-sent events. The current enrollment and unfinished connections are sent first, so the device doesn't
+// miss what happened before it subscribed.
+func (ec EventsController) StreamEvents(c *gin.Context) {
+	device, err := ec.Auth.CurrentDevice(c)
+	if err != nil {
+		reason := "failed to retrieve device: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		ec.Logger.Error(reason)
+		return
+	}
+
+	ch, cancel := ec.Events.Subscribe(device.ID)
+	defer cancel()
+
+	var connections []models.Connection
+	tx := ec.DB.Order("id").Find(&connections, "device_id = ? AND status NOT IN ? AND expires_at > ?", device.ID, []models.ConnectionStatus{models.ConnectionStatusReady, models.ConnectionStatusFailed}, time.Now())
+	if tx.Error != nil {
+		reason := "failed to get connections: " + tx.Error.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		ec.Logger.Error(reason)
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	now := time.Now()
+	c.SSEvent(string(events.TypeEnrollment), events.Event{Type: events.TypeEnrollment, Data: device.Enrollment(), Time: now})
+	for _, connection := range connections {
+		c.SSEvent(string(events.TypeConnection), events.Event{Type: events.TypeConnection, Data: connection, Time: now})
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(ec.Heartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event := <-ch:
+			c.SSEvent(string(event.Type), event)
+		case t := <-heartbeat.C:
+			c.SSEvent("heartbeat", gin.H{"time": t})
+		}
+
+		return true
+	})
+}
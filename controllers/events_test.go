@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"dvpn/internal/events"
+	"dvpn/middleware"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestStreamEvents(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "devices" WHERE id = \$1`).WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectQuery(`SELECT \* FROM "connections" WHERE device_id = \$1 AND status NOT IN \(\$2,\$3\) AND expires_at > \$4 ORDER BY id`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "device_id", "status"}).AddRow(3, 9, "PENDING"))
+
+	bus := events.NewBus()
+	ec := EventsController{
+		DB:        db,
+		Logger:    zap.NewNop().Sugar(),
+		Auth:      &middleware.AuthMiddleware{DB: db, Logger: zap.NewNop().Sugar()},
+		Events:    bus,
+		Heartbeat: time.Hour,
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/events", func(c *gin.Context) {
+		c.Set("currentDeviceID", uint(9))
+	}, ec.StreamEvents)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	defer disconnect()
+
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events", nil)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err)
+	}
+	defer response.Body.Close()
+
+	lines := bufio.NewScanner(response.Body)
+	expectEvent := func(want events.Type) {
+		t.Helper()
+		for lines.Scan() {
+			if line := lines.Text(); strings.HasPrefix(line, "event:") {
+				if got := strings.TrimSpace(strings.TrimPrefix(line, "event:")); got != string(want) {
+					t.Fatalf("event = %s, want %s", got, want)
+				}
+				return
+			}
+		}
+		t.Fatalf("stream ended before %s event: %v", want, lines.Err())
+	}
+
+	// The current state is sent before events published afterwards
+	expectEvent(events.TypeEnrollment)
+	expectEvent(events.TypeConnection)
+
+	bus.Publish(events.TypeDisconnect, 9, events.Disconnect{Reason: events.DisconnectReasonBanned})
+	expectEvent(events.TypeDisconnect)
+
+	disconnect()
+
+	deadline := time.Now().Add(time.Second)
+	for bus.Subscribers(9) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription is kept after the device disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"dvpn/internal/sentinel"
+	"dvpn/middleware"
+	"dvpn/models"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const sessionsDefaultLimit = 20
+const sessionsMaxLimit = 100
+
+type SessionsController struct {
+	DB       *gorm.DB
+	Logger   *zap.SugaredLogger
+	Auth     *middleware.AuthMiddleware
+	Sentinel sentinel.SentinelClient
+	Cache    *SessionsCache
+}
+
+type sessionServer struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	CountryID   uint   `json:"country_id"`
+	CountryName string `json:"country_name"`
+	CountryCode string `json:"country_code"`
+}
+
+type session struct {
+	ID          int64          `json:"id"`
+	NodeAddress string         `json:"node_address"`
+	Server      *sessionServer `json:"server"`
+	Duration    int64          `json:"duration"`
+	Upload      int64          `json:"upload"`
+	Download    int64          `json:"download"`
+	Status      string         `json:"status"`
+}
+
+type sessionsPage struct {
+	Sessions   []session `json:"sessions"`
+	NextOffset *int      `json:"next_offset"`
+}
+
+// GetSessions returns past and active sessions of the current device, newest first.
+// Servers are looked up by node address, sessions on nodes we don't know have no server.
+func (sc SessionsController) GetSessions(c *gin.Context) {
+	device, err := sc.Auth.CurrentDevice(c)
+	if err != nil {
+		reason := "failed to retrieve device: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		sc.Logger.Error(reason)
+		return
+	}
+
+	limit := sessionsDefaultLimit
+	if c.Query("limit") != "" {
+		limit, err = strconv.Atoi(c.Query("limit"))
+		if err != nil || limit <= 0 || limit > sessionsMaxLimit {
+			middleware.RespondErr(c, middleware.APIErrorInvalidRequest, fmt.Sprintf("limit should be between 1 and %d", sessionsMaxLimit))
+			return
+		}
+	}
+
+	offset := 0
+	if c.Query("offset") != "" {
+		offset, err = strconv.Atoi(c.Query("offset"))
+		if err != nil || offset < 0 {
+			middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid offset")
+			return
+		}
+	}
+
+	cacheKey := fmt.Sprintf("%s/%d/%d", device.WalletAddress, limit, offset)
+	if page, ok := sc.Cache.get(cacheKey); ok {
+		middleware.RespondOK(c, page)
+		return
+	}
+
+	// One more session is requested to tell whether there is a next page
+	sentinelSessions, err := sc.Sentinel.FetchSessions(c.Request.Context(), device.WalletAddress, limit+1, offset)
+	if err != nil {
+		reason := "failed to fetch sentinel sessions: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		sc.Logger.Error(reason)
+		return
+	}
+
+	var fetched []sentinel.SentinelSession
+	if sentinelSessions != nil {
+		fetched = *sentinelSessions
+	}
+
+	page := &sessionsPage{Sessions: make([]session, 0, limit)}
+	if len(fetched) > limit {
+		fetched = fetched[:limit]
+		nextOffset := offset + limit
+		page.NextOffset = &nextOffset
+	}
+
+	servers, err := sc.findServers(fetched)
+	if err != nil {
+		reason := "failed to get servers of sessions: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		sc.Logger.Error(reason)
+		return
+	}
+
+	for _, s := range fetched {
+		dto := s.DTO()
+
+		page.Sessions = append(page.Sessions, session{
+			ID:          dto.ID,
+			NodeAddress: dto.NodeAddress,
+			Server:      servers[dto.NodeAddress],
+			Duration:    int64(time.Duration(dto.Duration).Seconds()),
+			Upload:      dto.Bandwidth.Upload,
+			Download:    dto.Bandwidth.Download,
+			Status:      sessionStatus(s.Status),
+		})
+	}
+
+	sc.Cache.set(cacheKey, page)
+	middleware.RespondOK(c, page)
+}
+
+func (sc SessionsController) findServers(sessions []sentinel.SentinelSession) (map[string]*sessionServer, error) {
+	result := make(map[string]*sessionServer)
+	if len(sessions) == 0 {
+		return result, nil
+	}
+
+	nodeAddresses := make([]string, 0, len(sessions))
+	for _, s := range sessions {
+		nodeAddresses = append(nodeAddresses, s.NodeAddress)
+	}
+
+	var servers []models.Server
+	tx := sc.DB.Preload("Country").Find(&servers, "\"configuration\"->>'address' IN ?", nodeAddresses)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	for _, server := range servers {
+		result[server.Configuration.Data().Address] = &sessionServer{
+			ID:          server.ID,
+			Name:        server.Name,
+			CountryID:   server.CountryID,
+			CountryName: server.Country.Name,
+			CountryCode: server.Country.Code,
+		}
+	}
+
+	return result, nil
+}
+
+func sessionStatus(status sentinel.SentinelSessionStatus) string {
+	switch status {
+	case sentinel.SentinelSessionStatusActive:
+		return "ACTIVE"
+	case sentinel.SentinelSessionStatusInactivePending:
+		return "INACTIVE_PENDING"
+	case sentinel.SentinelSessionStatusInactive:
+		return "INACTIVE"
+	}
+
+	return "UNSPECIFIED"
+}
+
+type sessionsCacheEntry struct {
+	page      *sessionsPage
+	expiresAt time.Time
+}
+
+// SessionsCache keeps pages of sessions for a short time, so repeated app opens don't hit Sentinel API
+type SessionsCache struct {
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]sessionsCacheEntry
+}
+
+func NewSessionsCache(ttl time.Duration) *SessionsCache {
+	return &SessionsCache{
+		TTL:     ttl,
+		entries: make(map[string]sessionsCacheEntry),
+	}
+}
+
+func (sc *SessionsCache) get(key string) (*sessionsPage, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	entry, ok := sc.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+
+	return entry.page, true
+}
+
+func (sc *SessionsCache) set(key string, page *sessionsPage) {
+	if sc.TTL <= 0 {
+		return
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range sc.entries {
+		if now.After(entry.expiresAt) {
+			delete(sc.entries, k)
+		}
+	}
+
+	sc.entries[key] = sessionsCacheEntry{page: page, expiresAt: now.Add(sc.TTL)}
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+	"dvpn/internal/sentinel"
+	"dvpn/middleware"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// sessionsClient is FakeSentinel with a fixed history of sessions, counting how often it is fetched
+type sessionsClient struct {
+	*sentinel.FakeSentinel
+	sessions []sentinel.SentinelSession
+	fetches  *int
+}
+
+func (c sessionsClient) FetchSessions(ctx context.Context, walletAddress string, limit int, offset int) (*[]sentinel.SentinelSession, error) {
+	*c.fetches++
+
+	var sessions []sentinel.SentinelSession
+	for i := offset; i < len(c.sessions) && i < offset+limit; i++ {
+		sessions = append(sessions, c.sessions[i])
+	}
+
+	return &sessions, nil
+}
+
+func TestGetSessions(t *testing.T) {
+	client := sessionsClient{
+		FakeSentinel: sentinel.NewFakeSentinel(sentinel.FakeSentinelConfig{Seed: 1}),
+		sessions: []sentinel.SentinelSession{
+			{ID: 3, NodeAddress: "sentnode1known", Duration: int64(90 * time.Second), Bandwidth: sentinel.SentinelSessionBandwidth{Download: "2000", Upload: "1000"}, Status: sentinel.SentinelSessionStatusActive},
+			{ID: 2, NodeAddress: "sentnode1unknown", Status: sentinel.SentinelSessionStatusInactive},
+			{ID: 1, NodeAddress: "sentnode1known", Status: sentinel.SentinelSessionStatusInactive},
+		},
+		fetches: new(int),
+	}
+
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "devices" WHERE id = \$1`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "wallet_address"}).AddRow(9, "sent1wallet"))
+	mock.ExpectQuery(`SELECT \* FROM "servers" WHERE "configuration"->>'address' IN \(\$1,\$2\)`).WithArgs("sentnode1known", "sentnode1unknown").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "country_id", "name", "protocols", "configuration"}).AddRow(5, 4, "Berlin #1", `["WIREGUARD"]`, `{"address":"sentnode1known"}`))
+	mock.ExpectQuery(`SELECT \* FROM "countries" WHERE "countries"."id" = \$1`).WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "code"}).AddRow(4, "Germany", "DE"))
+	// The second request is answered from the cache, only the device is looked up again
+	mock.ExpectQuery(`SELECT \* FROM "devices" WHERE id = \$1`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "wallet_address"}).AddRow(9, "sent1wallet"))
+
+	sc := SessionsController{
+		DB:       db,
+		Logger:   zap.NewNop().Sugar(),
+		Auth:     &middleware.AuthMiddleware{DB: db, Logger: zap.NewNop().Sugar()},
+		Sentinel: client,
+		Cache:    NewSessionsCache(time.Minute),
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/sessions", func(c *gin.Context) {
+		c.Set("currentDeviceID", uint(9))
+	}, sc.GetSessions)
+
+	var bodies []string
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/sessions?limit=2", nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", recorder.Code, recorder.Body)
+		}
+		bodies = append(bodies, recorder.Body.String())
+	}
+
+	if *client.fetches != 1 || bodies[0] != bodies[1] {
+		t.Errorf("sessions were fetched %d times, want a cached page for the second request", *client.fetches)
+	}
+
+	var response struct {
+		Data sessionsPage `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(bodies[0]), &response); err != nil {
+		t.Fatalf("failed to decode response: %s", err)
+	}
+	page := response.Data
+
+	if page.NextOffset == nil || *page.NextOffset != 2 {
+		t.Errorf("next offset = %v, want 2", page.NextOffset)
+	}
+
+	if len(page.Sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(page.Sessions))
+	}
+
+	wantServer := sessionServer{ID: 5, Name: "Berlin #1", CountryID: 4, CountryName: "Germany", CountryCode: "DE"}
+	if got := page.Sessions[0]; got.Server == nil || *got.Server != wantServer {
+		t.Errorf("server of session = %+v, want %+v", got.Server, wantServer)
+	}
+
+	want := session{ID: 3, NodeAddress: "sentnode1known", Duration: 90, Upload: 1000, Download: 2000, Status: "ACTIVE"}
+	got := page.Sessions[0]
+	got.Server = nil
+	if got != want {
+		t.Errorf("session = %+v, want %+v", got, want)
+	}
+
+	if got := page.Sessions[1]; got.Server != nil || got.Status != "INACTIVE" {
+		t.Errorf("session on an unknown node = %+v, want no server", got)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
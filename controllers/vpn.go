@@ -1,7 +1,11 @@
 package controllers
 
 import (
+	"dvpn/internal/clientconfig"
+	"dvpn/internal/selection"
 	"dvpn/internal/sentinel"
+	"dvpn/internal/vault"
+	"dvpn/jobs"
 	"dvpn/middleware"
 	"dvpn/models"
 	"encoding/json"
@@ -9,22 +13,31 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/tyler-smith/go-bip39"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
+// maxServerLoad is the load at which servers of a city are no longer selected while others are left
+const maxServerLoad = 0.9
+
 type VPNController struct {
 	DB       *gorm.DB
 	Logger   *zap.SugaredLogger
 	Auth     *middleware.AuthMiddleware
-	Sentinel *sentinel.Sentinel
+	Sentinel sentinel.SentinelClient
+	Vault    *vault.Vault
+
+	// Usage of devices which look out of quota is refreshed before refusing to connect them
+	Usage *jobs.RecordDeviceUsageJob
+	// Selection picks the server of a city devices connect to
+	Selection selection.Strategy
+	// Connections subscribes to nodes and exchanges keys, right away or in the background for async connects
+	Connections *jobs.ConnectDevicesJob
 }
 
 func (vc VPNController) GetIPAddress(c *gin.Context) {
@@ -110,9 +123,9 @@ func (vc VPNController) GetCountries(c *gin.Context) {
 
 	protocol := c.Query("protocol")
 	if protocol != "" && protocol != "ALL" {
-		tx = vc.DB.Raw("SELECT c.id, c.created_at, c.updated_at, c.name, c.code, COUNT(s.id) as servers_available FROM countries AS c INNER JOIN servers AS s ON s.country_id = c.id WHERE s.is_active = true AND s.is_included_in_plan = true AND s.is_banned = false AND s.protocols->>0 = ? GROUP BY c.id ORDER BY c.name", protocol).Scan(&countries)
+		tx = vc.DB.Raw("SELECT c.id, c.created_at, c.updated_at, c.name, c.code, COUNT(s.id) as servers_available FROM countries AS c INNER JOIN servers AS s ON s.country_id = c.id WHERE s.is_active = true AND s.is_included_in_plan = true AND s.is_banned = false AND s.quarantined_until IS NULL AND s.protocols->>0 = ? GROUP BY c.id ORDER BY c.name", protocol).Scan(&countries)
 	} else {
-		tx = vc.DB.Raw("SELECT c.id, c.created_at, c.updated_at, c.name, c.code, COUNT(s.id) as servers_available FROM countries AS c INNER JOIN servers AS s ON s.country_id = c.id WHERE s.is_active = true AND s.is_included_in_plan = true AND s.is_banned = false GROUP BY c.id ORDER BY c.name").Scan(&countries)
+		tx = vc.DB.Raw("SELECT c.id, c.created_at, c.updated_at, c.name, c.code, COUNT(s.id) as servers_available FROM countries AS c INNER JOIN servers AS s ON s.country_id = c.id WHERE s.is_active = true AND s.is_included_in_plan = true AND s.is_banned = false AND s.quarantined_until IS NULL GROUP BY c.id ORDER BY c.name").Scan(&countries)
 	}
 
 	if tx.Error != nil {
@@ -137,9 +150,9 @@ func (vc VPNController) GetCities(c *gin.Context) {
 
 	protocol := c.Query("protocol")
 	if protocol != "" && protocol != "ALL" {
-		tx = vc.DB.Raw("SELECT c.id, c.created_at, c.updated_at, c.country_id, c.name, COUNT(s.id) as servers_available FROM cities AS c INNER JOIN servers AS s ON s.city_id = c.id WHERE s.is_active = true AND s.is_included_in_plan = true AND s.is_banned = false AND s.protocols->>0 = ? AND c.country_id = ? GROUP BY c.id ORDER BY servers_available DESC", protocol, countryId).Scan(&cities)
+		tx = vc.DB.Raw("SELECT c.id, c.created_at, c.updated_at, c.country_id, c.name, COUNT(s.id) as servers_available FROM cities AS c INNER JOIN servers AS s ON s.city_id = c.id WHERE s.is_active = true AND s.is_included_in_plan = true AND s.is_banned = false AND s.quarantined_until IS NULL AND s.protocols->>0 = ? AND c.country_id = ? GROUP BY c.id ORDER BY servers_available DESC", protocol, countryId).Scan(&cities)
 	} else {
-		tx = vc.DB.Raw("SELECT c.id, c.created_at, c.updated_at, c.country_id, c.name, COUNT(s.id) as servers_available FROM cities AS c INNER JOIN servers AS s ON s.city_id = c.id WHERE s.is_active = true AND s.is_included_in_plan = true AND s.is_banned = false AND c.country_id = ? GROUP BY c.id ORDER BY servers_available DESC", countryId).Scan(&cities)
+		tx = vc.DB.Raw("SELECT c.id, c.created_at, c.updated_at, c.country_id, c.name, COUNT(s.id) as servers_available FROM cities AS c INNER JOIN servers AS s ON s.city_id = c.id WHERE s.is_active = true AND s.is_included_in_plan = true AND s.is_banned = false AND s.quarantined_until IS NULL AND c.country_id = ? GROUP BY c.id ORDER BY servers_available DESC", countryId).Scan(&cities)
 	}
 
 	if tx.Error != nil {
@@ -172,7 +185,7 @@ func (vc VPNController) GetServers(c *gin.Context) {
 
 	var servers []models.Server
 
-	query := vc.DB.Model(&models.Server{}).Where("country_id = ? AND city_id = ? AND is_active = ? AND is_banned = ?", countryId, cityId, true, false)
+	query := vc.DB.Model(&models.Server{}).Where("country_id = ? AND city_id = ? AND is_active = ? AND is_banned = ? AND quarantined_until IS NULL", countryId, cityId, true, false)
 
 	sortBy := c.Query("sortBy")
 	if sortBy != "" {
@@ -252,28 +265,103 @@ func (vc VPNController) ConnectToCity(c *gin.Context) {
 		return
 	}
 
-	protocol := c.Params.ByName("protocol")
+	client, ok := vc.selectionClient(c)
+	if !ok {
+		return
+	}
 
-	var server models.Server
+	query := vc.DB.Where("country_id = ? AND city_id = ? AND is_included_in_plan = ? AND is_banned = ? AND is_active = ? AND quarantined_until IS NULL", countryId, cityId, true, false, true)
+	if protocol := c.Params.ByName("protocol"); protocol != "" {
+		query = query.Where("protocols->>0 = ?", protocol)
+	}
 
-	if protocol != "" {
-		err = vc.DB.Order("RANDOM()").First(&server, "current_load < ? AND country_id = ? AND city_id = ? AND is_included_in_plan = ? AND is_banned = ? AND is_active = ? AND protocols->>0 = ?", 0.9, countryId, cityId, true, false, true, protocol).Error
-		if err != nil {
-			middleware.RespondErr(c, middleware.APIErrorUnknown, "failed to get server: "+err.Error())
+	var servers []models.Server
+	err = query.Find(&servers).Error
+	if err != nil {
+		reason := "failed to get servers: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return
+	}
+
+	choices, err := vc.Selection.Rank(unsaturated(servers), client)
+	if err != nil {
+		if errors.Is(err, selection.ErrNoCandidates) {
+			middleware.RespondErr(c, middleware.APIErrorNotFound, "no servers available in the city")
 			return
 		}
 
-		vc.createCredentials(device, &server, c)
+		reason := "failed to select server: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
 		return
 	}
 
-	err = vc.DB.Order("RANDOM()").First(&server, "current_load < ? AND country_id = ? AND city_id = ? AND is_included_in_plan = ? AND is_banned = ? AND is_active = ?", 0.9, countryId, cityId, true, false, true).Error
-	if err != nil {
-		middleware.RespondErr(c, middleware.APIErrorUnknown, "failed to get server: "+err.Error())
+	// The rest of the ranked servers are fallbacks when credentials can't be created on the selected one
+	ranked := make([]models.Server, len(choices))
+	for i, choice := range choices {
+		ranked[i] = *choice.Server
+	}
+
+	vc.createCredentials(device, ranked, choices, c)
+}
+
+// describeSelection returns the choice of the server credentials were created on for debugging in a header,
+// so it is there whatever format credentials are rendered in
+func (vc VPNController) describeSelection(c *gin.Context, device *models.Device, choices []selection.Choice, serverID uint) {
+	for i, choice := range choices {
+		if choice.ServerID != serverID {
+			continue
+		}
+
+		if debug, err := json.Marshal(choice); err == nil {
+			c.Header("X-Server-Selection", string(debug))
+		}
+		vc.Logger.Debugf("selected server %d for device %d out of %d with score %f after %d fallbacks: %v", choice.ServerID, device.ID, choice.Candidates, choice.Score, i, choice.Factors)
+
 		return
 	}
+}
+
+// unsaturated leaves out servers at or above maxServerLoad, unless every server is saturated
+func unsaturated(servers []models.Server) []models.Server {
+	var available []models.Server
+	for _, server := range servers {
+		if server.Load() < maxServerLoad {
+			available = append(available, server)
+		}
+	}
+
+	if len(available) == 0 {
+		return servers
+	}
+
+	return available
+}
+
+// selectionClient reads optional `latitude` and `longitude` params of the device location, as returned by `GET /ip`
+func (vc VPNController) selectionClient(c *gin.Context) (selection.Client, bool) {
+	var client selection.Client
+	if c.Query("latitude") == "" && c.Query("longitude") == "" {
+		return client, true
+	}
 
-	vc.createCredentials(device, &server, c)
+	latitude, err := strconv.ParseFloat(c.Query("latitude"), 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid latitude")
+		return client, false
+	}
+
+	longitude, err := strconv.ParseFloat(c.Query("longitude"), 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid longitude")
+		return client, false
+	}
+
+	client.Latitude = &latitude
+	client.Longitude = &longitude
+
+	return client, true
 }
 
 func (vc VPNController) ConnectToServer(c *gin.Context) {
@@ -285,22 +373,123 @@ func (vc VPNController) ConnectToServer(c *gin.Context) {
 		return
 	}
 
+	server, ok := vc.findServer(c)
+	if !ok {
+		return
+	}
+
+	vc.createCredentials(device, []models.Server{*server}, nil, c)
+}
+
+// ConnectToServerManually starts a session for a key the device generated on its own — WireGuard public key
+// or V2Ray UID — and proxies the key exchange to the node, so private keys never leave the device.
+// The node response is returned as it is.
+func (vc VPNController) ConnectToServerManually(c *gin.Context) {
+	device, err := vc.Auth.CurrentDevice(c)
+	if err != nil {
+		reason := "failed to retrieve device: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return
+	}
+
+	var payload struct {
+		Key string `json:"key" binding:"required"`
+	}
+	if err := c.BindJSON(&payload); err != nil {
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid request payload: "+err.Error())
+		return
+	}
+
+	server, ok := vc.findServer(c)
+	if !ok {
+		return
+	}
+
+	// The key is checked before the session is prepared, so an invalid one doesn't reach the chain
+	key, err := sentinel.ParseManualKey(jobs.NodeType(server), payload.Key)
+	if err != nil {
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, err.Error())
+		return
+	}
+
+	request, ok := vc.sessionRequest(device, server, c)
+	if !ok {
+		return
+	}
+
+	sessionID, err := vc.Sentinel.StartSession(c.Request.Context(), *request)
+	if err != nil {
+		reason := "failed to start sentinel session: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return
+	}
+
+	keyExchange, err := sentinel.NewManualKeyExchangePayload(request.Signer, sessionID, key)
+	if err != nil {
+		reason := "failed to sign key exchange: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return
+	}
+
+	response, err := vc.Sentinel.ProxyManualCredentialsRequest(c.Request.Context(), request.RemoteURL, request.Signer.Address, sessionID, keyExchange)
+	if err != nil {
+		vc.Connections.RecordKeyExchange(server, err)
+
+		reason := "failed to proxy key exchange to sentinel node: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return
+	}
+
+	if !json.Valid(response) {
+		reason := fmt.Sprintf("invalid key exchange response returned from sentinel node %s: %s", request.NodeAddress, response)
+		vc.Connections.RecordKeyExchange(server, &sentinel.NodeError{Err: errors.New(reason)})
+
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return
+	}
+
+	// The node answered, a failure it reports is about the key of the device, not about the node
+	vc.Connections.RecordKeyExchange(server, nil)
+	vc.Connections.Reserve(server)
+
+	middleware.RespondOK(c, &struct {
+		Protocol  string          `json:"protocol"`
+		SessionID int64           `json:"session_id"`
+		Response  json.RawMessage `json:"response"`
+		Latitude  float64         `json:"latitude,omitempty"`
+		Longitude float64         `json:"longitude,omitempty"`
+	}{
+		Protocol:  string(server.Protocols.Data()[0]),
+		SessionID: sessionID,
+		Response:  response,
+		Latitude:  server.Configuration.Data().LocationLat,
+		Longitude: server.Configuration.Data().LocationLon,
+	})
+}
+
+// findServer looks up the server of `country_id`, `city_id` and `server_id` params and checks it can be connected to
+func (vc VPNController) findServer(c *gin.Context) (*models.Server, bool) {
 	countryId, err := strconv.ParseUint(c.Params.ByName("country_id"), 10, 64)
 	if err != nil {
 		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid country id: "+err.Error())
-		return
+		return nil, false
 	}
 
 	cityId, err := strconv.ParseUint(c.Params.ByName("city_id"), 10, 64)
 	if err != nil {
 		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid city id: "+err.Error())
-		return
+		return nil, false
 	}
 
 	serverId, err := strconv.ParseUint(c.Params.ByName("server_id"), 10, 64)
 	if err != nil {
 		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid server id: "+err.Error())
-		return
+		return nil, false
 	}
 
 	var server models.Server
@@ -313,83 +502,64 @@ func (vc VPNController) ConnectToServer(c *gin.Context) {
 			middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
 			vc.Logger.Error(reason)
 		}
-		return
+		return nil, false
 	}
 
 	if !server.IsActive {
 		middleware.RespondErr(c, middleware.APIErrorServerInactive, "server is not active")
-		return
+		return nil, false
 	}
 
-	if server.IsBanned {
+	if server.IsBanActive() {
 		middleware.RespondErr(c, middleware.APIErrorServerInactive, "server is banned")
-		return
+		return nil, false
 	}
 
 	if !server.IsIncludedInPlan {
 		middleware.RespondErr(c, middleware.APIErrorServerNotCovered, "server is not available with subscription")
-		return
+		return nil, false
 	}
 
-	vc.createCredentials(device, &server, c)
+	return &server, true
 }
 
-func (vc VPNController) createCredentials(device *models.Device, server *models.Server, c *gin.Context) {
-	deviceMnemonic, _ := bip39.NewMnemonic(device.WalletEntropy)
+// createCredentials starts a session on the first of servers it succeeds on and responds with its credentials,
+// or with a client-ready config when `format` param is given. The server finally used is in the response,
+// along with its selection choice when servers were ranked. Queued connections are not described, the server
+// is known only once they are processed.
+func (vc VPNController) createCredentials(device *models.Device, servers []models.Server, choices []selection.Choice, c *gin.Context) {
+	// The format is checked before the session is started, so an unsupported one doesn't spend it.
+	// Servers of protocols the format doesn't support are not failed over to.
+	var format clientconfig.Format
+	compatible := make([]models.Server, 0, len(servers))
+	var formatErr error
+	for _, server := range servers {
+		parsed, err := clientconfig.ParseFormat(c.Query("format"), server.Protocols.Data()[0])
+		if err != nil {
+			formatErr = err
+			continue
+		}
 
-	if device.SubscriptionId == nil || device.IsFeeGranted == false {
-		reason := "wallet " + device.WalletAddress + " is not yet enrolled"
-		middleware.RespondErr(c, middleware.APIErrorDeviceNotEnrolled, reason)
-		vc.Logger.Warn(reason)
+		format = parsed
+		compatible = append(compatible, server)
+	}
+
+	if len(compatible) == 0 {
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, formatErr.Error())
 		return
 	}
 
-	var sentinelNodeSubscription *models.SentinelNodeSubscription
-	tx := vc.DB.Model(&models.SentinelNodeSubscription{}).Order("id desc").First(&sentinelNodeSubscription, "node_address = ? AND inactive_at > ?", server.Configuration.Data().Address, time.Now())
-	if tx.Error != nil {
-		if tx.Error != nil {
-			if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
-				hours, err := strconv.ParseInt(os.Getenv("SENTINEL_NODE_HOURS"), 10, 64)
-				if err != nil {
-					reason := fmt.Sprintf("failed to parse sentinel node hours: %s", err.Error())
-					middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
-					vc.Logger.Error(reason)
-					return
-				}
-
-				s, err := vc.Sentinel.CreateNodeSubscription(server.Configuration.Data().Address, 0, hours)
-				if err != nil {
-					reason := "failed to create sentinel node subscription: " + err.Error()
-					middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
-					vc.Logger.Error(reason)
-					return
-				}
-
-				sentinelNodeSubscription = &models.SentinelNodeSubscription{
-					ID:          s.Base.ID,
-					NodeAddress: s.NodeAddress,
-					InactiveAt:  s.Base.InactiveAt,
-				}
-
-				tx := vc.DB.Create(sentinelNodeSubscription)
-				if tx.Error != nil {
-					reason := "failed to save create sentinel node subscription to the DB: " + tx.Error.Error()
-					middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
-					vc.Logger.Error(reason)
-					return
-				}
+	if c.Query("async") == "true" {
+		vc.queueConnection(device, compatible, c)
+		return
+	}
 
-			} else {
-				reason := "failed to get sentinel node subscription from DB: " + tx.Error.Error()
-				middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
-				vc.Logger.Error(reason)
-				return
-			}
-		}
+	if !vc.checkDevice(device, c) {
+		return
 	}
 
 	tStart := time.Now()
-	credentials, err := vc.Sentinel.CreateCredentials(server.Configuration.Data().Address, *device.SubscriptionId, deviceMnemonic, device.WalletAddress)
+	result, err := vc.Connections.Connect(c.Request.Context(), device, compatible, nil)
 	vc.Logger.Infoln(fmt.Sprintf("time took: %s, error: %s", time.Since(tStart), err))
 
 	if err != nil {
@@ -399,20 +569,289 @@ func (vc VPNController) createCredentials(device *models.Device, server *models.
 		return
 	}
 
-	middleware.RespondOK(c, &struct {
-		Protocol   string  `json:"protocol"`
-		Payload    string  `json:"payload,omitempty"`
-		PrivateKey string  `json:"private_key,omitempty"`
-		Uid        string  `json:"uid,omitempty"`
-		Latitude   float64 `json:"latitude,omitempty"`
-		Longitude  float64 `json:"longitude,omitempty"`
-	}{
+	vc.describeSelection(c, device, choices, result.Server.ID)
+
+	if format != "" {
+		c.Header("X-Server-ID", strconv.FormatUint(uint64(result.Server.ID), 10))
+		vc.respondConfig(c, result.Server, result.Credentials, format)
+		return
+	}
+
+	response := newCredentialsResponse(result.Server, result.Credentials)
+	response.Attempts = result.Attempts
+	middleware.RespondOK(c, response)
+}
+
+type credentialsResponse struct {
+	ServerID   uint    `json:"server_id"`
+	Attempts   int     `json:"attempts,omitempty"`
+	Protocol   string  `json:"protocol"`
+	Payload    string  `json:"payload,omitempty"`
+	PrivateKey string  `json:"private_key,omitempty"`
+	Uid        string  `json:"uid,omitempty"`
+	Latitude   float64 `json:"latitude,omitempty"`
+	Longitude  float64 `json:"longitude,omitempty"`
+}
+
+func newCredentialsResponse(server *models.Server, credentials *sentinel.SentinelCredentials) *credentialsResponse {
+	return &credentialsResponse{
+		ServerID:   server.ID,
 		Protocol:   string(server.Protocols.Data()[0]),
 		Payload:    credentials.DTO().Payload,
 		PrivateKey: credentials.DTO().PrivateKey,
 		Uid:        credentials.DTO().Uid,
 		Latitude:   server.Configuration.Data().LocationLat,
 		Longitude:  server.Configuration.Data().LocationLon,
+	}
+}
+
+type connectionResponse struct {
+	models.Connection
+	Credentials *credentialsResponse `json:"credentials,omitempty"`
+}
+
+// queueConnection saves the connection for ConnectDevicesJob and responds with it right away,
+// the device polls it with `GET /connections/:connection_id`.
+func (vc VPNController) queueConnection(device *models.Device, servers []models.Server, c *gin.Context) {
+	if !vc.checkDevice(device, c) {
+		return
+	}
+
+	connection, err := vc.Connections.Queue(device, servers)
+	if err != nil {
+		reason := "failed to queue connection: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return
+	}
+
+	middleware.RespondOK(c, connectionResponse{Connection: *connection})
+}
+
+// GetConnection reports the stage of a connection of the current device, with its credentials once it is ready.
+// Ready credentials can be rendered with `format` param the same way as on the credentials routes.
+func (vc VPNController) GetConnection(c *gin.Context) {
+	device, err := vc.Auth.CurrentDevice(c)
+	if err != nil {
+		reason := "failed to retrieve device: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return
+	}
+
+	connectionId, err := strconv.ParseUint(c.Params.ByName("connection_id"), 10, 64)
+	if err != nil {
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, "invalid connection id: "+err.Error())
+		return
+	}
+
+	var connection models.Connection
+	tx := vc.DB.Preload("Server").First(&connection, "id = ? AND device_id = ? AND expires_at > ?", connectionId, device.ID, time.Now())
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			middleware.RespondErr(c, middleware.APIErrorNotFound, "connection not found")
+		} else {
+			reason := "failed to get connection: " + tx.Error.Error()
+			middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+			vc.Logger.Error(reason)
+		}
+		return
+	}
+
+	format, err := clientconfig.ParseFormat(c.Query("format"), connection.Server.Protocols.Data()[0])
+	if err != nil {
+		middleware.RespondErr(c, middleware.APIErrorInvalidRequest, err.Error())
+		return
+	}
+
+	if connection.Status != models.ConnectionStatusReady {
+		middleware.RespondOK(c, connectionResponse{Connection: connection})
+		return
+	}
+
+	sealed, err := vc.Vault.OpenConnectionCredentials(&connection)
+	if err != nil {
+		reason := "failed to decrypt credentials of connection: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return
+	}
+
+	var credentials sentinel.SentinelCredentials
+	err = json.Unmarshal(sealed, &credentials)
+	if err != nil {
+		reason := "failed to decode credentials of connection: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return
+	}
+
+	if format != "" {
+		vc.respondConfig(c, &connection.Server, &credentials, format)
+		return
+	}
+
+	middleware.RespondOK(c, connectionResponse{
+		Connection:  connection,
+		Credentials: newCredentialsResponse(&connection.Server, &credentials),
 	})
+}
+
+func (vc VPNController) respondConfig(c *gin.Context, server *models.Server, credentials *sentinel.SentinelCredentials, format clientconfig.Format) {
+	config, err := clientconfig.New(server, credentials.DTO())
+	if err != nil {
+		reason := fmt.Sprintf("failed to decode credentials of server %d: %s", server.ID, err)
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return
+	}
+
+	body, err := config.Render(format)
+	if err != nil {
+		reason := fmt.Sprintf("failed to render %s config of server %d: %s", format, server.ID, err)
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return
+	}
+
+	if fileName := format.FileName(server); fileName != "" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	}
+
+	c.Data(http.StatusOK, format.ContentType(), body)
+}
+
+// checkDevice refuses to connect devices which are not enrolled yet or have used up their quota
+func (vc VPNController) checkDevice(device *models.Device, c *gin.Context) bool {
+	if device.SubscriptionId == nil || device.IsFeeGranted == false {
+		reason := "wallet " + device.WalletAddress + " is not yet enrolled"
+		middleware.RespondErr(c, middleware.APIErrorDeviceNotEnrolled, reason)
+		vc.Logger.Warn(reason)
+		return false
+	}
+
+	if device.IsQuotaExceeded() {
+		usage, err := vc.Usage.Refresh(c.Request.Context(), device)
+		if err != nil {
+			vc.Logger.Warnf("failed to refresh usage of device %d, relying on the latest recorded one: %s", device.ID, err)
+		} else {
+			device.UsageGrantedBytes = usage.GrantedBytes
+			device.UsageUtilisedBytes = usage.UtilisedBytes
+			device.UsageUpdatedAt = usage.UpdatedAt
+		}
+
+		if device.IsQuotaExceeded() {
+			reason := fmt.Sprintf("wallet %s has used up its quota of %d bytes", device.WalletAddress, device.UsageGrantedBytes)
+			middleware.RespondErr(c, middleware.APIErrorQuotaExceeded, reason)
+			vc.Logger.Warn(reason)
+			return false
+		}
+	}
+
+	return true
+}
+
+// sessionRequest checks the device can start a session on the server and prepares the request which starts it
+func (vc VPNController) sessionRequest(device *models.Device, server *models.Server, c *gin.Context) (*sentinel.SentinelCredentialsRequest, bool) {
+	if !vc.checkDevice(device, c) {
+		return nil, false
+	}
+
+	request, err := vc.Connections.Prepare(c.Request.Context(), device, server)
+	if err != nil {
+		reason := "failed to prepare session: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return nil, false
+	}
+
+	return request, true
+}
+
+// Disconnect ends the active on-chain session of the current device and records its end
+func (vc VPNController) Disconnect(c *gin.Context) {
+	device, err := vc.Auth.CurrentDevice(c)
+	if err != nil {
+		reason := "failed to retrieve device: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return
+	}
+
+	// Every page is scanned, the active session is not necessarily among the first ones of a long history
+	var active *sentinel.SentinelSession
+	err = sentinel.SessionsPager(vc.Sentinel, device.WalletAddress, sentinel.DefaultPageLimit).Each(c.Request.Context(), func(s sentinel.SentinelSession) bool {
+		if s.Status == sentinel.SentinelSessionStatusActive && (active == nil || s.ID > active.ID) {
+			session := s
+			active = &session
+		}
+		return true
+	})
+	if err != nil {
+		reason := "failed to fetch sentinel sessions: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return
+	}
+
+	if active == nil {
+		middleware.RespondErr(c, middleware.APIErrorNotFound, "wallet "+device.WalletAddress+" has no active session")
+		return
+	}
+
+	signer, err := vc.deviceSigner(device)
+	if err != nil {
+		reason := "failed to derive wallet key of device: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return
+	}
+
+	receipt, err := vc.Sentinel.EndSession(c.Request.Context(), signer, active.ID)
+	if err != nil {
+		reason := "failed to end sentinel session: " + err.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return
+	}
+
+	dto := active.DTO()
+	endedAt := time.Now()
+	session := models.SentinelSession{
+		ID:             dto.ID,
+		DeviceID:       device.ID,
+		SubscriptionID: dto.SubscriptionID,
+		NodeAddress:    dto.NodeAddress,
+		Duration:       int64(time.Duration(dto.Duration).Seconds()),
+		UploadBytes:    dto.Bandwidth.Upload,
+		DownloadBytes:  dto.Bandwidth.Download,
+		EndedAt:        &endedAt,
+		EndTxHash:      &receipt.TxHash,
+	}
+
+	tx := vc.DB.Save(&session)
+	if tx.Error != nil {
+		reason := "failed to save ended sentinel session to the DB: " + tx.Error.Error()
+		middleware.RespondErr(c, middleware.APIErrorUnknown, reason)
+		vc.Logger.Error(reason)
+		return
+	}
+
+	middleware.RespondOK(c, &struct {
+		SessionID int64  `json:"session_id"`
+		TxHash    string `json:"tx_hash"`
+	}{
+		SessionID: session.ID,
+		TxHash:    receipt.TxHash,
+	})
+}
+
+// deviceSigner derives the key of the device wallet, which signs transactions of its sessions
+func (vc VPNController) deviceSigner(device *models.Device) (*sentinel.Signer, error) {
+	entropy, err := vc.Vault.OpenDeviceEntropy(device)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt wallet entropy: %w", err)
+	}
 
+	return sentinel.SignerForEntropy(entropy)
 }
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"dvpn/internal/selection"
+	"dvpn/internal/sentinel"
+	"dvpn/internal/vault"
+	"dvpn/middleware"
+	"dvpn/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestDescribeSelection(t *testing.T) {
+	choices := []selection.Choice{
+		{ServerID: 1, Strategy: selection.StrategyWeighted, Score: 0.9, Candidates: 2},
+		{ServerID: 2, Strategy: selection.StrategyWeighted, Score: 0.4, Candidates: 2},
+	}
+
+	tests := []struct {
+		name     string
+		choices  []selection.Choice
+		serverID uint
+		want     uint
+	}{
+		{"selected server", choices, 1, 1},
+		{"fallback server", choices, 2, 2},
+		{"server which was not ranked", nil, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+
+			vc := VPNController{Logger: zap.NewNop().Sugar()}
+			vc.describeSelection(c, &models.Device{}, tt.choices, tt.serverID)
+
+			header := recorder.Header().Get("X-Server-Selection")
+			if tt.want == 0 {
+				if header != "" {
+					t.Errorf("X-Server-Selection = %s, want none", header)
+				}
+				return
+			}
+
+			var choice selection.Choice
+			if err := json.Unmarshal([]byte(header), &choice); err != nil {
+				t.Fatalf("failed to parse X-Server-Selection %q: %s", header, err)
+			}
+
+			if choice.ServerID != tt.want {
+				t.Errorf("X-Server-Selection describes server %d, want %d", choice.ServerID, tt.want)
+			}
+		})
+	}
+}
+
+// endingClient is sessionsClient which remembers the session it ended
+type endingClient struct {
+	sessionsClient
+	ended *int64
+}
+
+func (c endingClient) EndSession(ctx context.Context, signer *sentinel.Signer, sessionID int64) (*sentinel.SentinelReceipt, error) {
+	*c.ended = sessionID
+	return &sentinel.SentinelReceipt{SentinelTransaction: sentinel.SentinelTransaction{TxHash: "ENDED"}}, nil
+}
+
+func TestDisconnect(t *testing.T) {
+	v, err := vault.New(map[int][]byte{1: bytes.Repeat([]byte{1}, 32)}, 1)
+	if err != nil {
+		t.Fatalf("failed to create vault: %s", err)
+	}
+
+	entropy := bytes.Repeat([]byte{7}, 32)
+	signer, err := sentinel.NewSignerFromEntropy(entropy)
+	if err != nil {
+		t.Fatalf("failed to create signer: %s", err)
+	}
+
+	device := models.Device{WalletAddress: signer.Address}
+	if err := v.SealDeviceEntropy(&device, entropy); err != nil {
+		t.Fatalf("failed to seal entropy: %s", err)
+	}
+
+	// The active session is on the third page of a long history
+	client := endingClient{
+		sessionsClient: sessionsClient{FakeSentinel: sentinel.NewFakeSentinel(sentinel.FakeSentinelConfig{Seed: 1}), fetches: new(int)},
+		ended:          new(int64),
+	}
+	for i := 0; i < 2*sentinel.DefaultPageLimit+50; i++ {
+		status := sentinel.SentinelSessionStatusInactive
+		if i == 2*sentinel.DefaultPageLimit+10 {
+			status = sentinel.SentinelSessionStatusActive
+		}
+		client.sessions = append(client.sessions, sentinel.SentinelSession{ID: int64(i + 1), Address: signer.Address, Status: status})
+	}
+
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "devices" WHERE id = \$1`).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "wallet_address", "wallet_entropy", "wallet_data_key", "wallet_key_version"}).
+			AddRow(9, device.WalletAddress, device.WalletEntropy, device.WalletDataKey, device.WalletKeyVersion))
+	mock.ExpectExec(`UPDATE "sentinel_sessions"`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	vc := VPNController{
+		DB:       db,
+		Logger:   zap.NewNop().Sugar(),
+		Auth:     &middleware.AuthMiddleware{DB: db, Logger: zap.NewNop().Sugar()},
+		Sentinel: client,
+		Vault:    v,
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/vpn/disconnect", func(c *gin.Context) {
+		c.Set("currentDeviceID", uint(9))
+	}, vc.Disconnect)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/vpn/disconnect", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", recorder.Code, recorder.Body)
+	}
+
+	if want := int64(2*sentinel.DefaultPageLimit + 11); *client.ended != want {
+		t.Errorf("ended session %d, want %d", *client.ended, want)
+	}
+
+	if *client.fetches != 3 {
+		t.Errorf("sessions were fetched %d times, want every one of 3 pages", *client.fetches)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
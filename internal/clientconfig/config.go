@@ -0,0 +1,174 @@
+package clientconfig
+
+import (
+	"dvpn/internal/sentinel"
+	"dvpn/models"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Format is a client-ready representation of credentials, selected with `format` param of the credentials routes
+type Format string
+
+const (
+	FormatWireGuard Format = "wireguard"
+	FormatV2Ray     Format = "v2ray"
+	FormatLink      Format = "link"
+	FormatQR        Format = "qr"
+)
+
+// qrCodeSize is the side of rendered QR codes in pixels
+const qrCodeSize = 512
+
+var ErrUnsupportedFormat = errors.New("format is not supported by the protocol of the server")
+
+// ParseFormat validates `format` param against the protocol of the server. Empty format is returned as it is,
+// meaning the raw payload is wanted.
+func ParseFormat(format string, protocol models.ServerProtocol) (Format, error) {
+	f := Format(format)
+
+	switch f {
+	case "", FormatQR:
+		return f, nil
+	case FormatWireGuard:
+		if protocol == models.ServerProtocolWireGuard {
+			return f, nil
+		}
+	case FormatV2Ray, FormatLink:
+		if protocol == models.ServerProtocolV2Ray {
+			return f, nil
+		}
+	default:
+		return "", fmt.Errorf("unknown format %q, should be one of %s, %s, %s or %s", format, FormatWireGuard, FormatV2Ray, FormatLink, FormatQR)
+	}
+
+	return "", fmt.Errorf("%s %w %s", format, ErrUnsupportedFormat, protocol)
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case FormatV2Ray:
+		return "application/json"
+	case FormatQR:
+		return "image/png"
+	}
+
+	return "text/plain; charset=utf-8"
+}
+
+// FileName names the file the rendered config is downloaded as, links have none
+func (f Format) FileName(server *models.Server) string {
+	switch f {
+	case FormatWireGuard:
+		return fmt.Sprintf("dvpn-%d.conf", server.ID)
+	case FormatV2Ray:
+		return fmt.Sprintf("dvpn-%d.json", server.ID)
+	case FormatQR:
+		return fmt.Sprintf("dvpn-%d.png", server.ID)
+	}
+
+	return ""
+}
+
+// Config is everything a client needs to connect to a server, decoded from the key exchange payload
+type Config struct {
+	Protocol models.ServerProtocol
+	Name     string
+	Host     string
+
+	WireGuard *WireGuard
+	V2Ray     *V2Ray
+}
+
+// New builds the config of a session from credentials returned by the key exchange and the server configuration
+func New(server *models.Server, credentials sentinel.SentinelCredentialsDTO) (*Config, error) {
+	remoteURL, err := url.Parse(server.Configuration.Data().RemoteURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote URL of server %d: %w", server.ID, err)
+	}
+
+	if remoteURL.Hostname() == "" {
+		return nil, fmt.Errorf("remote URL of server %d has no host", server.ID)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(credentials.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("payload should be base64 encoded: %w", err)
+	}
+
+	config := &Config{
+		Protocol: server.Protocols.Data()[0],
+		Name:     server.Name,
+		Host:     remoteURL.Hostname(),
+	}
+
+	switch config.Protocol {
+	case models.ServerProtocolWireGuard:
+		config.WireGuard, err = newWireGuard(payload, credentials.PrivateKey)
+	case models.ServerProtocolV2Ray:
+		config.V2Ray, err = newV2Ray(payload, credentials.Uid)
+	default:
+		err = fmt.Errorf("unknown protocol %s", config.Protocol)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Render renders the config in the format, which should be validated with ParseFormat first
+func (c *Config) Render(format Format) ([]byte, error) {
+	switch format {
+	case FormatWireGuard:
+		if c.WireGuard == nil {
+			return nil, fmt.Errorf("%s %w %s", format, ErrUnsupportedFormat, c.Protocol)
+		}
+
+		return []byte(c.WireGuard.Conf(c.Host)), nil
+	case FormatV2Ray:
+		if c.V2Ray == nil {
+			return nil, fmt.Errorf("%s %w %s", format, ErrUnsupportedFormat, c.Protocol)
+		}
+
+		return c.V2Ray.JSON(c.Host)
+	case FormatLink:
+		if c.V2Ray == nil {
+			return nil, fmt.Errorf("%s %w %s", format, ErrUnsupportedFormat, c.Protocol)
+		}
+
+		link, err := c.V2Ray.Link(c.Host, c.Name)
+		return []byte(link), err
+	case FormatQR:
+		return c.qrCode()
+	}
+
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// qrCode encodes the WireGuard config or the V2Ray share link, which is what client apps scan
+func (c *Config) qrCode() ([]byte, error) {
+	var content string
+	if c.WireGuard != nil {
+		content = c.WireGuard.Conf(c.Host)
+	} else {
+		link, err := c.V2Ray.Link(c.Host, c.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		content = link
+	}
+
+	return qrcode.Encode(content, qrcode.Medium, qrCodeSize)
+}
+
+func hostPort(host string, port uint16) string {
+	return net.JoinHostPort(host, fmt.Sprint(port))
+}
@@ -0,0 +1,280 @@
+package clientconfig
+
+import (
+	"bytes"
+	"dvpn/internal/sentinel"
+	"dvpn/models"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"testing"
+
+	"github.com/skip2/go-qrcode"
+	"gorm.io/datatypes"
+)
+
+const testUid = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+func testServer(protocol models.ServerProtocol, remoteURL string) *models.Server {
+	server := &models.Server{
+		Name:          "berlin-01",
+		Protocols:     datatypes.NewJSONType([]models.ServerProtocol{protocol}),
+		Configuration: datatypes.NewJSONType(models.ServerConfiguration{RemoteURL: remoteURL}),
+	}
+	server.ID = 3
+
+	return server
+}
+
+// wireGuardPayload is 10.8.0.2, fd00:0:8::2, port 51820 and a public key of 0x01 bytes
+func wireGuardPayload() []byte {
+	payload := []byte{10, 8, 0, 2, 0xfd, 0x00, 0x00, 0x00, 0x00, 0x08, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x02, 0xca, 0x6c}
+	return append(payload, bytes.Repeat([]byte{0x01}, 32)...)
+}
+
+func wireGuardCredentials(payload []byte) sentinel.SentinelCredentialsDTO {
+	return sentinel.SentinelCredentialsDTO{PrivateKey: "cHJpdmF0ZSBrZXk=", Payload: base64.StdEncoding.EncodeToString(payload)}
+}
+
+// v2RayCredentials are of a node listening on port 8686 with the proxy and transport bytes
+func v2RayCredentials(proxy V2RayProxy, transport byte) sentinel.SentinelCredentialsDTO {
+	return sentinel.SentinelCredentialsDTO{Uid: testUid, Payload: base64.StdEncoding.EncodeToString([]byte{byte(proxy), 0x21, 0xee, transport})}
+}
+
+const wireGuardConf = `[Interface]
+PrivateKey = cHJpdmF0ZSBrZXk=
+Address = 10.8.0.2/32, fd00:0:8::2/128
+DNS = 1.1.1.1, 1.0.0.1
+
+[Peer]
+PublicKey = AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=
+Endpoint = node.example.com:51820
+AllowedIPs = 0.0.0.0/0, ::/0
+PersistentKeepalive = 15
+`
+
+const v2RayJSON = `{
+  "inbounds": [
+    {
+      "listen": "127.0.0.1",
+      "port": 10808,
+      "protocol": "socks",
+      "settings": {
+        "udp": true
+      },
+      "tag": "socks"
+    }
+  ],
+  "log": {
+    "loglevel": "warning"
+  },
+  "outbounds": [
+    {
+      "protocol": "vless",
+      "settings": {
+        "vnext": [
+          {
+            "address": "node.example.com",
+            "port": 8686,
+            "users": [
+              {
+                "encryption": "none",
+                "id": "b831381d-6324-4d53-ad4f-8cda48b30811"
+              }
+            ]
+          }
+        ]
+      },
+      "streamSettings": {
+        "network": "grpc",
+        "security": "none"
+      },
+      "tag": "proxy"
+    },
+    {
+      "protocol": "freedom",
+      "tag": "direct"
+    }
+  ]
+}`
+
+func TestRender(t *testing.T) {
+	wireGuard := testServer(models.ServerProtocolWireGuard, "https://node.example.com:8585")
+	v2Ray := testServer(models.ServerProtocolV2Ray, "https://node.example.com:8585")
+
+	tests := []struct {
+		name        string
+		server      *models.Server
+		credentials sentinel.SentinelCredentialsDTO
+		format      Format
+		want        string
+	}{
+		{"wireguard conf", wireGuard, wireGuardCredentials(wireGuardPayload()), FormatWireGuard, wireGuardConf},
+		{"v2ray json", v2Ray, v2RayCredentials(V2RayProxyVLess, 0x03), FormatV2Ray, v2RayJSON},
+		{"vless link", v2Ray, v2RayCredentials(V2RayProxyVLess, 0x03), FormatLink, "vless://" + testUid + "@node.example.com:8686?encryption=none&security=none&type=grpc#berlin-01"},
+		{"vmess link", v2Ray, v2RayCredentials(V2RayProxyVMess, 0x08), FormatLink, "vmess://eyJhZGQiOiJub2RlLmV4YW1wbGUuY29tIiwiYWlkIjoiMCIsImlkIjoiYjgzMTM4MWQtNjMyNC00ZDUzLWFkNGYtOGNkYTQ4YjMwODExIiwibmV0Ijoid3MiLCJwb3J0IjoiODY4NiIsInBzIjoiYmVybGluLTAxIiwic2N5IjoiYXV0byIsInRscyI6IiIsInR5cGUiOiJub25lIiwidiI6IjIifQ=="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := New(tt.server, tt.credentials)
+			if err != nil {
+				t.Fatalf("failed to create config: %s", err)
+			}
+
+			rendered, err := config.Render(tt.format)
+			if err != nil {
+				t.Fatalf("failed to render: %s", err)
+			}
+
+			if string(rendered) != tt.want {
+				t.Errorf("rendered\n%s\nwant\n%s", rendered, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderQR(t *testing.T) {
+	wireGuard := testServer(models.ServerProtocolWireGuard, "https://node.example.com:8585")
+	v2Ray := testServer(models.ServerProtocolV2Ray, "https://node.example.com:8585")
+
+	tests := []struct {
+		name        string
+		server      *models.Server
+		credentials sentinel.SentinelCredentialsDTO
+		content     string
+	}{
+		{"wireguard encodes conf", wireGuard, wireGuardCredentials(wireGuardPayload()), wireGuardConf},
+		{"v2ray encodes link", v2Ray, v2RayCredentials(V2RayProxyVLess, 0x03), "vless://" + testUid + "@node.example.com:8686?encryption=none&security=none&type=grpc#berlin-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := New(tt.server, tt.credentials)
+			if err != nil {
+				t.Fatalf("failed to create config: %s", err)
+			}
+
+			rendered, err := config.Render(FormatQR)
+			if err != nil {
+				t.Fatalf("failed to render: %s", err)
+			}
+
+			want, err := qrcode.Encode(tt.content, qrcode.Medium, qrCodeSize)
+			if err != nil {
+				t.Fatalf("failed to encode QR code: %s", err)
+			}
+
+			if !bytes.Equal(rendered, want) {
+				t.Errorf("QR code doesn't encode %q", tt.content)
+			}
+
+			image, err := png.Decode(bytes.NewReader(rendered))
+			if err != nil {
+				t.Fatalf("QR code is not a PNG: %s", err)
+			}
+
+			if image.Bounds().Dx() != qrCodeSize || image.Bounds().Dy() != qrCodeSize {
+				t.Errorf("QR code is %s, want %dx%d", image.Bounds(), qrCodeSize, qrCodeSize)
+			}
+		})
+	}
+}
+
+func TestV2RayTransports(t *testing.T) {
+	server := testServer(models.ServerProtocolV2Ray, "https://node.example.com:8585")
+
+	tests := []struct {
+		transport byte
+		network   string
+	}{
+		{0x01, "domainsocket"},
+		{0x02, "grpc"},
+		{0x03, "grpc"},
+		{0x04, "http"},
+		{0x05, "kcp"},
+		{0x06, "quic"},
+		{0x07, "tcp"},
+		{0x08, "ws"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.network, func(t *testing.T) {
+			config, err := New(server, v2RayCredentials(V2RayProxyVLess, tt.transport))
+			if err != nil {
+				t.Fatalf("failed to create config: %s", err)
+			}
+
+			if config.V2Ray.Network != tt.network || config.V2Ray.Port != 8686 {
+				t.Errorf("transport %#x is %s on port %d, want %s on 8686", tt.transport, config.V2Ray.Network, config.V2Ray.Port, tt.network)
+			}
+		})
+	}
+}
+
+func TestNewMalformed(t *testing.T) {
+	wireGuard := testServer(models.ServerProtocolWireGuard, "https://node.example.com:8585")
+	v2Ray := testServer(models.ServerProtocolV2Ray, "https://node.example.com:8585")
+
+	tests := []struct {
+		name        string
+		server      *models.Server
+		credentials sentinel.SentinelCredentialsDTO
+	}{
+		{"payload which is not base64", wireGuard, sentinel.SentinelCredentialsDTO{PrivateKey: "key", Payload: "not base64!"}},
+		{"short wireguard payload", wireGuard, wireGuardCredentials(wireGuardPayload()[:53])},
+		{"long wireguard payload", wireGuard, wireGuardCredentials(append(wireGuardPayload(), 0))},
+		{"empty wireguard payload", wireGuard, wireGuardCredentials(nil)},
+		{"wireguard without private key", wireGuard, sentinel.SentinelCredentialsDTO{Payload: base64.StdEncoding.EncodeToString(wireGuardPayload())}},
+		{"short v2ray payload", v2Ray, sentinel.SentinelCredentialsDTO{Uid: testUid, Payload: base64.StdEncoding.EncodeToString([]byte{0x01, 0x21, 0xee})}},
+		{"v2ray without uid", v2Ray, sentinel.SentinelCredentialsDTO{Payload: base64.StdEncoding.EncodeToString([]byte{0x01, 0x21, 0xee, 0x07})}},
+		{"unknown v2ray transport", v2Ray, v2RayCredentials(V2RayProxyVLess, 0x09)},
+		{"zero v2ray transport", v2Ray, v2RayCredentials(V2RayProxyVLess, 0x00)},
+		{"unknown v2ray proxy", v2Ray, v2RayCredentials(0x03, 0x07)},
+		{"wireguard payload of v2ray server", v2Ray, wireGuardCredentials(wireGuardPayload())},
+		{"remote url without host", testServer(models.ServerProtocolWireGuard, "/node"), wireGuardCredentials(wireGuardPayload())},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if config, err := New(tt.server, tt.credentials); err == nil {
+				t.Errorf("created config %+v, want error", config)
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		format      string
+		protocol    models.ServerProtocol
+		want        Format
+		unsupported bool
+		wantErr     bool
+	}{
+		{"", models.ServerProtocolWireGuard, "", false, false},
+		{"qr", models.ServerProtocolV2Ray, FormatQR, false, false},
+		{"wireguard", models.ServerProtocolWireGuard, FormatWireGuard, false, false},
+		{"wireguard", models.ServerProtocolV2Ray, "", true, true},
+		{"v2ray", models.ServerProtocolV2Ray, FormatV2Ray, false, false},
+		{"link", models.ServerProtocolWireGuard, "", true, true},
+		{"openvpn", models.ServerProtocolWireGuard, "", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format+" "+string(tt.protocol), func(t *testing.T) {
+			got, err := ParseFormat(tt.format, tt.protocol)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+
+			if errors.Is(err, ErrUnsupportedFormat) != tt.unsupported {
+				t.Errorf("err = %v, want unsupported %t", err, tt.unsupported)
+			}
+
+			if got != tt.want {
+				t.Errorf("format = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package clientconfig
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// v2RayPayloadLength is proxy protocol (1), port (2) and transport (1) of the node
+const v2RayPayloadLength = 4
+
+// v2RayLocalSocksPort is the port of the SOCKS inbound rendered V2Ray configs listen on
+const v2RayLocalSocksPort = 10808
+
+// V2RayProxy is the proxy protocol byte of V2Ray key exchange payloads
+type V2RayProxy byte
+
+const (
+	V2RayProxyVLess V2RayProxy = 0x01
+	V2RayProxyVMess V2RayProxy = 0x02
+)
+
+// v2RayNetworks maps transport bytes of V2Ray key exchange payloads to V2Ray/Xray stream networks
+var v2RayNetworks = map[byte]string{
+	0x01: "domainsocket",
+	0x02: "grpc", // gun is the grpc transport without its service metadata
+	0x03: "grpc",
+	0x04: "http",
+	0x05: "kcp",
+	0x06: "quic",
+	0x07: "tcp",
+	0x08: "ws",
+}
+
+type V2Ray struct {
+	Uid     string
+	Proxy   V2RayProxy
+	Port    uint16
+	Network string
+}
+
+func newV2Ray(payload []byte, uid string) (*V2Ray, error) {
+	if len(payload) != v2RayPayloadLength {
+		return nil, fmt.Errorf("V2Ray payload should be %d bytes long, got %d", v2RayPayloadLength, len(payload))
+	}
+
+	if uid == "" {
+		return nil, fmt.Errorf("V2Ray UID is missing")
+	}
+
+	proxy := V2RayProxy(payload[0])
+	if proxy != V2RayProxyVLess && proxy != V2RayProxyVMess {
+		return nil, fmt.Errorf("unknown V2Ray proxy protocol %d", payload[0])
+	}
+
+	network, ok := v2RayNetworks[payload[3]]
+	if !ok {
+		return nil, fmt.Errorf("unknown V2Ray transport %d", payload[3])
+	}
+
+	return &V2Ray{
+		Uid:     uid,
+		Proxy:   proxy,
+		Port:    binary.BigEndian.Uint16(payload[1:3]),
+		Network: network,
+	}, nil
+}
+
+func (v *V2Ray) protocol() string {
+	if v.Proxy == V2RayProxyVMess {
+		return "vmess"
+	}
+
+	return "vless"
+}
+
+// JSON renders a V2Ray/Xray client config with a local SOCKS inbound and the node as the outbound
+func (v *V2Ray) JSON(host string) ([]byte, error) {
+	user := map[string]any{"id": v.Uid}
+	if v.Proxy == V2RayProxyVMess {
+		user["alterId"] = 0
+		user["security"] = "auto"
+	} else {
+		user["encryption"] = "none"
+	}
+
+	config := map[string]any{
+		"log": map[string]any{"loglevel": "warning"},
+		"inbounds": []any{
+			map[string]any{
+				"tag":      "socks",
+				"listen":   "127.0.0.1",
+				"port":     v2RayLocalSocksPort,
+				"protocol": "socks",
+				"settings": map[string]any{"udp": true},
+			},
+		},
+		"outbounds": []any{
+			map[string]any{
+				"tag":      "proxy",
+				"protocol": v.protocol(),
+				"settings": map[string]any{
+					"vnext": []any{
+						map[string]any{
+							"address": host,
+							"port":    v.Port,
+							"users":   []any{user},
+						},
+					},
+				},
+				"streamSettings": map[string]any{
+					"network":  v.Network,
+					"security": "none",
+				},
+			},
+			map[string]any{
+				"tag":      "direct",
+				"protocol": "freedom",
+			},
+		},
+	}
+
+	return json.MarshalIndent(config, "", "  ")
+}
+
+// Link renders a vless:// or vmess:// share link, which V2Ray apps import from clipboard or QR codes
+func (v *V2Ray) Link(host string, name string) (string, error) {
+	if v.Proxy == V2RayProxyVMess {
+		// vmess:// links carry base64 encoded JSON, as defined by V2RayN
+		payload, err := json.Marshal(map[string]string{
+			"v":    "2",
+			"ps":   name,
+			"add":  host,
+			"port": fmt.Sprint(v.Port),
+			"id":   v.Uid,
+			"aid":  "0",
+			"scy":  "auto",
+			"net":  v.Network,
+			"type": "none",
+			"tls":  "",
+		})
+		if err != nil {
+			return "", err
+		}
+
+		return "vmess://" + base64.StdEncoding.EncodeToString(payload), nil
+	}
+
+	link := url.URL{
+		Scheme:   "vless",
+		User:     url.User(v.Uid),
+		Host:     hostPort(host, v.Port),
+		RawQuery: url.Values{"encryption": {"none"}, "security": {"none"}, "type": {v.Network}}.Encode(),
+		Fragment: name,
+	}
+
+	return link.String(), nil
+}
@@ -0,0 +1,58 @@
+package clientconfig
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// wireGuardPayloadLength is IPv4 (4) and IPv6 (16) addresses, port (2) and public key (32) of the node
+const wireGuardPayloadLength = 54
+
+// WireGuardDNS are resolvers set in rendered WireGuard configs
+var WireGuardDNS = []string{"1.1.1.1", "1.0.0.1"}
+
+type WireGuard struct {
+	PrivateKey    string
+	IPv4          net.IP
+	IPv6          net.IP
+	Port          uint16
+	PeerPublicKey string
+}
+
+func newWireGuard(payload []byte, privateKey string) (*WireGuard, error) {
+	if len(payload) != wireGuardPayloadLength {
+		return nil, fmt.Errorf("WireGuard payload should be %d bytes long, got %d", wireGuardPayloadLength, len(payload))
+	}
+
+	if privateKey == "" {
+		return nil, fmt.Errorf("WireGuard private key is missing")
+	}
+
+	return &WireGuard{
+		PrivateKey:    privateKey,
+		IPv4:          net.IP(payload[0:4]),
+		IPv6:          net.IP(payload[4:20]),
+		Port:          binary.BigEndian.Uint16(payload[20:22]),
+		PeerPublicKey: base64.StdEncoding.EncodeToString(payload[22:54]),
+	}, nil
+}
+
+// Conf renders the config in wg-quick format, which WireGuard apps import as it is
+func (wg *WireGuard) Conf(host string) string {
+	var b strings.Builder
+
+	b.WriteString("[Interface]\n")
+	fmt.Fprintf(&b, "PrivateKey = %s\n", wg.PrivateKey)
+	fmt.Fprintf(&b, "Address = %s/32, %s/128\n", wg.IPv4, wg.IPv6)
+	fmt.Fprintf(&b, "DNS = %s\n", strings.Join(WireGuardDNS, ", "))
+	b.WriteString("\n[Peer]\n")
+	fmt.Fprintf(&b, "PublicKey = %s\n", wg.PeerPublicKey)
+	fmt.Fprintf(&b, "Endpoint = %s\n", hostPort(host, wg.Port))
+	b.WriteString("AllowedIPs = 0.0.0.0/0, ::/0\n")
+	b.WriteString("PersistentKeepalive = 15\n")
+
+	return b.String()
+}
@@ -0,0 +1,105 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Type names an event as it is sent to devices in the `event` field of server-sent events
+type Type string
+
+const (
+	// TypeEnrollment carries models.Enrollment of the device whenever its fee grant or enrollment progresses
+	TypeEnrollment Type = "enrollment"
+	// TypeConnection carries models.Connection whenever an asynchronous connection reaches the next stage
+	TypeConnection Type = "connection"
+	// TypeDisconnect carries a Disconnect notice when the device is forced to disconnect
+	TypeDisconnect Type = "disconnect"
+)
+
+// subscriberBuffer is the number of events kept for a slow subscriber before new ones are dropped
+const subscriberBuffer = 16
+
+type Event struct {
+	Type     Type      `json:"type"`
+	DeviceID uint      `json:"-"`
+	Data     any       `json:"data"`
+	Time     time.Time `json:"time"`
+}
+
+// DisconnectReason tells the device why it should disconnect
+type DisconnectReason string
+
+const (
+	DisconnectReasonBanned        DisconnectReason = "BANNED"
+	DisconnectReasonQuotaExceeded DisconnectReason = "QUOTA_EXCEEDED"
+)
+
+type Disconnect struct {
+	Reason DisconnectReason `json:"reason"`
+}
+
+// Bus fans events published by jobs and controllers out to streams of devices they are about.
+// Events are kept in memory only: devices which are not subscribed miss them and should fetch
+// the current state when they subscribe.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[uint]map[chan Event]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[uint]map[chan Event]struct{})}
+}
+
+// Publish sends an event to every subscriber of its device without waiting for them.
+// Publishing to a nil Bus does nothing, so jobs can run without one.
+func (b *Bus) Publish(eventType Type, deviceID uint, data any) {
+	if b == nil {
+		return
+	}
+
+	event := Event{Type: eventType, DeviceID: deviceID, Data: data, Time: time.Now()}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subscribers[deviceID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of events of the device and a function which cancels the subscription
+func (b *Bus) Subscribe(deviceID uint) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.subscribers[deviceID] == nil {
+		b.subscribers[deviceID] = make(map[chan Event]struct{})
+	}
+	b.subscribers[deviceID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			delete(b.subscribers[deviceID], ch)
+			if len(b.subscribers[deviceID]) == 0 {
+				delete(b.subscribers, deviceID)
+			}
+		})
+	}
+}
+
+// Subscribers returns the number of streams subscribed to events of the device
+func (b *Bus) Subscribers(deviceID uint) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.subscribers[deviceID])
+}
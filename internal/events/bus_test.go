@@ -0,0 +1,96 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPublishFansOut(t *testing.T) {
+	bus := NewBus()
+
+	first, unsubscribeFirst := bus.Subscribe(1)
+	defer unsubscribeFirst()
+	second, unsubscribeSecond := bus.Subscribe(1)
+	defer unsubscribeSecond()
+	other, unsubscribeOther := bus.Subscribe(2)
+	defer unsubscribeOther()
+
+	bus.Publish(TypeDisconnect, 1, Disconnect{Reason: DisconnectReasonBanned})
+
+	for name, ch := range map[string]<-chan Event{"first": first, "second": second} {
+		select {
+		case event := <-ch:
+			if event.Type != TypeDisconnect || event.DeviceID != 1 || event.Data != (Disconnect{Reason: DisconnectReasonBanned}) {
+				t.Errorf("%s subscriber got %+v", name, event)
+			}
+		default:
+			t.Errorf("%s subscriber got no event", name)
+		}
+	}
+
+	select {
+	case event := <-other:
+		t.Errorf("subscriber of another device got %+v", event)
+	default:
+	}
+}
+
+func TestPublishDropsForSlowSubscribers(t *testing.T) {
+	bus := NewBus()
+
+	slow, unsubscribe := bus.Subscribe(1)
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < subscriberBuffer*2; i++ {
+			bus.Publish(TypeConnection, 1, i)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publishing blocked on a slow subscriber")
+	}
+
+	if len(slow) != subscriberBuffer {
+		t.Fatalf("slow subscriber kept %d events, want %d", len(slow), subscriberBuffer)
+	}
+
+	// The oldest events are kept, newer ones are dropped
+	if event := <-slow; event.Data != 0 {
+		t.Errorf("first kept event = %v, want 0", event.Data)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	bus := NewBus()
+
+	ch, unsubscribe := bus.Subscribe(1)
+	_, unsubscribeOther := bus.Subscribe(1)
+	defer unsubscribeOther()
+
+	unsubscribe()
+	unsubscribe()
+
+	if n := bus.Subscribers(1); n != 1 {
+		t.Errorf("subscribers = %d, want 1", n)
+	}
+
+	bus.Publish(TypeConnection, 1, nil)
+	if len(ch) != 0 {
+		t.Errorf("cancelled subscription got an event")
+	}
+
+	unsubscribeOther()
+	if _, ok := bus.subscribers[1]; ok {
+		t.Errorf("device without subscribers is kept")
+	}
+}
+
+func TestPublishToNilBus(t *testing.T) {
+	var bus *Bus
+	bus.Publish(TypeConnection, 1, nil)
+}
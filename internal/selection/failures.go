@@ -0,0 +1,87 @@
+package selection
+
+import (
+	"sync"
+	"time"
+)
+
+// minAttempts is the number of recent attempts below which a server is not judged by its failures
+const minAttempts = 3
+
+type attempt struct {
+	at     time.Time
+	failed bool
+}
+
+// Failures keeps outcomes of key exchanges with nodes per server for Window, in memory of the API process.
+// Methods of a nil Failures do nothing, so the tracking is optional.
+type Failures struct {
+	Window time.Duration
+
+	mu       sync.Mutex
+	attempts map[uint][]attempt
+}
+
+func NewFailures(window time.Duration) *Failures {
+	return &Failures{
+		Window:   window,
+		attempts: make(map[uint][]attempt),
+	}
+}
+
+// Record records the outcome of a key exchange with the node of the server, err is nil when it succeeded.
+// Callers pass only failures of the node itself, see ConnectDevicesJob.RecordKeyExchange.
+func (f *Failures) Record(serverID uint, err error) {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	f.attempts[serverID] = append(f.prune(serverID, now), attempt{at: now, failed: err != nil})
+}
+
+// Rate is the share of recent attempts on the server which failed, 0 when there were too few of them
+func (f *Failures) Rate(serverID uint) float64 {
+	if f == nil {
+		return 0
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	attempts := f.prune(serverID, time.Now())
+	if len(attempts) < minAttempts {
+		return 0
+	}
+
+	failed := 0
+	for _, a := range attempts {
+		if a.failed {
+			failed++
+		}
+	}
+
+	return float64(failed) / float64(len(attempts))
+}
+
+// prune drops attempts older than Window, the caller holds f.mu
+func (f *Failures) prune(serverID uint, now time.Time) []attempt {
+	attempts := f.attempts[serverID]
+
+	i := 0
+	for i < len(attempts) && now.Sub(attempts[i].at) > f.Window {
+		i++
+	}
+
+	attempts = attempts[i:]
+	if len(attempts) == 0 {
+		delete(f.attempts, serverID)
+		return nil
+	}
+
+	f.attempts[serverID] = attempts
+	return attempts
+}
@@ -0,0 +1,69 @@
+package selection
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFailuresRate(t *testing.T) {
+	failed := errors.New("key exchange failed")
+
+	tests := []struct {
+		name     string
+		outcomes []error
+		want     float64
+	}{
+		{"no attempts", nil, 0},
+		{"too few attempts", []error{failed, failed}, 0},
+		{"all failed", []error{failed, failed, failed}, 1},
+		{"all succeeded", []error{nil, nil, nil, nil}, 0},
+		{"some failed", []error{failed, nil, nil, failed}, 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFailures(time.Minute)
+			for _, err := range tt.outcomes {
+				f.Record(1, err)
+			}
+
+			if got := f.Rate(1); got != tt.want {
+				t.Errorf("Rate = %v, want %v", got, tt.want)
+			}
+
+			if got := f.Rate(2); got != 0 {
+				t.Errorf("Rate of another server = %v, want 0", got)
+			}
+		})
+	}
+}
+
+func TestFailuresForgetsAttemptsOutsideWindow(t *testing.T) {
+	f := NewFailures(20 * time.Millisecond)
+	failed := errors.New("key exchange failed")
+
+	for i := 0; i < 3; i++ {
+		f.Record(1, failed)
+	}
+
+	if got := f.Rate(1); got != 1 {
+		t.Fatalf("Rate = %v, want 1", got)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	f.Record(1, nil)
+
+	if got := f.Rate(1); got != 0 {
+		t.Errorf("Rate after window = %v, want 0", got)
+	}
+}
+
+func TestNilFailures(t *testing.T) {
+	var f *Failures
+	f.Record(1, errors.New("key exchange failed"))
+
+	if got := f.Rate(1); got != 0 {
+		t.Errorf("Rate = %v, want 0", got)
+	}
+}
@@ -0,0 +1,155 @@
+package selection
+
+import (
+	"dvpn/models"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StrategyRandom   = "random"
+	StrategyWeighted = "weighted"
+)
+
+var ErrNoCandidates = errors.New("no servers to select from")
+
+// Client is what is known about the device which connects, its location is optional
+type Client struct {
+	Latitude  *float64
+	Longitude *float64
+}
+
+// Choice is the selected server together with how it was scored, for debugging
+type Choice struct {
+	Server     *models.Server     `json:"-"`
+	ServerID   uint               `json:"server_id"`
+	Strategy   string             `json:"strategy"`
+	Score      float64            `json:"score"`
+	Factors    map[Factor]float64 `json:"factors,omitempty"`
+	Candidates int                `json:"candidates"`
+}
+
+// Strategy orders candidate servers the way a device should try to connect to them:
+// the first one is picked by the strategy, the rest are fallbacks when connecting to it fails.
+type Strategy interface {
+	Rank(candidates []models.Server, client Client) ([]Choice, error)
+}
+
+// NewFromEnv creates the strategy of SERVER_SELECTION_STRATEGY, `weighted` by default.
+// Weights of the weighted strategy are read from SERVER_SELECTION_WEIGHTS as `factor=weight` pairs
+// separated by commas, factors which are not listed keep their default weights.
+func NewFromEnv(failures *Failures) (Strategy, error) {
+	switch os.Getenv("SERVER_SELECTION_STRATEGY") {
+	case StrategyRandom:
+		return NewRandom(), nil
+	case "", StrategyWeighted:
+		weights, err := ParseWeights(os.Getenv("SERVER_SELECTION_WEIGHTS"))
+		if err != nil {
+			return nil, err
+		}
+
+		sharpness := DefaultSharpness
+		if raw := os.Getenv("SERVER_SELECTION_SHARPNESS"); raw != "" {
+			sharpness, err = strconv.ParseFloat(raw, 64)
+			if err != nil || sharpness < 0 {
+				return nil, fmt.Errorf("invalid SERVER_SELECTION_SHARPNESS %q", raw)
+			}
+		}
+
+		return NewWeighted(weights, sharpness, failures), nil
+	}
+
+	return nil, fmt.Errorf("unknown SERVER_SELECTION_STRATEGY %q, should be %s or %s", os.Getenv("SERVER_SELECTION_STRATEGY"), StrategyWeighted, StrategyRandom)
+}
+
+// ParseWeights parses `factor=weight` pairs over DefaultWeights
+func ParseWeights(raw string) (Weights, error) {
+	weights := make(Weights, len(DefaultWeights))
+	for factor, weight := range DefaultWeights {
+		weights[factor] = weight
+	}
+
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, value, found := strings.Cut(pair, "=")
+		factor := Factor(strings.TrimSpace(name))
+		if _, ok := DefaultWeights[factor]; !found || !ok {
+			return nil, fmt.Errorf("invalid server selection weight %q, factors are %v", pair, Factors)
+		}
+
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid server selection weight %q", pair)
+		}
+
+		weights[factor] = weight
+	}
+
+	return weights, nil
+}
+
+// lockedRand is a random source shared by concurrent requests
+type lockedRand struct {
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+func newLockedRand() *lockedRand {
+	return &lockedRand{random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (r *lockedRand) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.random.Float64()
+}
+
+func (r *lockedRand) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.random.Intn(n)
+}
+
+// Random picks any of the candidates, as servers were picked before scoring
+type Random struct {
+	random *lockedRand
+}
+
+func NewRandom() *Random {
+	return &Random{random: newLockedRand()}
+}
+
+func (r *Random) Rank(candidates []models.Server, client Client) ([]Choice, error) {
+	if len(candidates) == 0 {
+		return nil, ErrNoCandidates
+	}
+
+	choices := make([]Choice, len(candidates))
+	for i := range candidates {
+		choices[i] = Choice{
+			Server:     &candidates[i],
+			ServerID:   candidates[i].ID,
+			Strategy:   StrategyRandom,
+			Candidates: len(candidates),
+		}
+	}
+
+	for i := len(choices) - 1; i > 0; i-- {
+		j := r.random.Intn(i + 1)
+		choices[i], choices[j] = choices[j], choices[i]
+	}
+
+	return choices, nil
+}
@@ -0,0 +1,262 @@
+package selection
+
+import (
+	"dvpn/models"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Factor is a property of a server which is scored from 0 (worst) to 1 (best) among the candidates
+type Factor string
+
+const (
+	FactorLoad      Factor = "load"
+	FactorBandwidth Factor = "bandwidth"
+	FactorVersion   Factor = "version"
+	FactorFailures  Factor = "failures"
+	FactorDistance  Factor = "distance"
+)
+
+var Factors = []Factor{FactorLoad, FactorBandwidth, FactorVersion, FactorFailures, FactorDistance}
+
+// Weights tell how much every factor contributes to the score of a server
+type Weights map[Factor]float64
+
+var DefaultWeights = Weights{
+	FactorLoad:      0.35,
+	FactorBandwidth: 0.2,
+	FactorVersion:   0.1,
+	FactorFailures:  0.25,
+	FactorDistance:  0.1,
+}
+
+// DefaultSharpness is the power scores are raised to before picking. 0 picks uniformly, higher values favour
+// the best servers more, while every server keeps a chance, so devices don't herd onto the best one.
+const DefaultSharpness = 2.0
+
+// maxDistanceKm is roughly half of the Earth circumference, the farthest a server can be
+const maxDistanceKm = 20000.0
+
+// minWeight keeps a chance for servers which score 0 in every factor
+const minWeight = 1e-6
+
+type Weighted struct {
+	Weights   Weights
+	Sharpness float64
+	Failures  *Failures
+
+	random *lockedRand
+}
+
+func NewWeighted(weights Weights, sharpness float64, failures *Failures) *Weighted {
+	return &Weighted{
+		Weights:   weights,
+		Sharpness: sharpness,
+		Failures:  failures,
+		random:    newLockedRand(),
+	}
+}
+
+// Rank picks the first server with weighted randomness and orders the rest by score, best first
+func (w *Weighted) Rank(candidates []models.Server, client Client) ([]Choice, error) {
+	if len(candidates) == 0 {
+		return nil, ErrNoCandidates
+	}
+
+	scores := w.score(candidates, client)
+
+	total := 0.0
+	weights := make([]float64, len(candidates))
+	for i, score := range scores {
+		weights[i] = math.Pow(score.total, w.Sharpness) + minWeight
+		total += weights[i]
+	}
+
+	picked := len(candidates) - 1
+	threshold := w.random.Float64() * total
+	for i, weight := range weights {
+		threshold -= weight
+		if threshold < 0 {
+			picked = i
+			break
+		}
+	}
+
+	choices := make([]Choice, len(candidates))
+	for i := range candidates {
+		choices[i] = Choice{
+			Server:     &candidates[i],
+			ServerID:   candidates[i].ID,
+			Strategy:   StrategyWeighted,
+			Score:      scores[i].total,
+			Factors:    scores[i].factors,
+			Candidates: len(candidates),
+		}
+	}
+
+	choices[0], choices[picked] = choices[picked], choices[0]
+	sort.SliceStable(choices[1:], func(i, j int) bool {
+		return choices[1+i].Score > choices[1+j].Score
+	})
+
+	return choices, nil
+}
+
+type score struct {
+	total   float64
+	factors map[Factor]float64
+}
+
+// score scores every candidate relative to the others. Factors which can't be scored,
+// like distance of a client with unknown location, are left out and the rest are weighed as they are.
+func (w *Weighted) score(candidates []models.Server, client Client) []score {
+	maxBandwidth := int64(0)
+	for _, server := range candidates {
+		bandwidth := server.Configuration.Data().BandwidthDownload + server.Configuration.Data().BandwidthUpload
+		if bandwidth > maxBandwidth {
+			maxBandwidth = bandwidth
+		}
+	}
+
+	versions := rankVersions(candidates)
+
+	scores := make([]score, len(candidates))
+	for i, server := range candidates {
+		configuration := server.Configuration.Data()
+
+		factors := map[Factor]float64{
+			FactorLoad:     1 - math.Min(math.Max(server.Load(), 0), 1),
+			FactorVersion:  versions[configuration.Version],
+			FactorFailures: 1 - w.Failures.Rate(server.ID),
+		}
+
+		if maxBandwidth > 0 {
+			factors[FactorBandwidth] = float64(configuration.BandwidthDownload+configuration.BandwidthUpload) / float64(maxBandwidth)
+		}
+
+		if client.Latitude != nil && client.Longitude != nil {
+			distance := haversineKm(*client.Latitude, *client.Longitude, configuration.LocationLat, configuration.LocationLon)
+			factors[FactorDistance] = 1 - math.Min(distance/maxDistanceKm, 1)
+		}
+
+		weighted, weights := 0.0, 0.0
+		for factor, value := range factors {
+			weighted += w.Weights[factor] * value
+			weights += w.Weights[factor]
+		}
+
+		scores[i] = score{factors: factors}
+		if weights > 0 {
+			scores[i].total = weighted / weights
+		}
+	}
+
+	return scores
+}
+
+// rankVersions scores node versions of candidates from 0 for the oldest to 1 for the newest,
+// versions which can't be parsed score 0.
+func rankVersions(candidates []models.Server) map[string]float64 {
+	var versions [][]int
+	raw := make(map[string][]int)
+	for _, server := range candidates {
+		version := server.Configuration.Data().Version
+		if _, ok := raw[version]; ok {
+			continue
+		}
+
+		parsed, ok := parseVersion(version)
+		raw[version] = parsed
+		if ok {
+			versions = append(versions, parsed)
+		}
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+
+	// Equal versions written differently, like `v0.7.1` and `0.7.1`, share a rank
+	distinct := versions[:0]
+	for _, version := range versions {
+		if len(distinct) == 0 || compareVersions(distinct[len(distinct)-1], version) != 0 {
+			distinct = append(distinct, version)
+		}
+	}
+
+	ranks := make(map[string]float64, len(raw))
+	for version, parsed := range raw {
+		if parsed == nil {
+			ranks[version] = 0
+			continue
+		}
+
+		if len(distinct) == 1 {
+			ranks[version] = 1
+			continue
+		}
+
+		rank := sort.Search(len(distinct), func(i int) bool {
+			return compareVersions(distinct[i], parsed) >= 0
+		})
+		ranks[version] = float64(rank) / float64(len(distinct)-1)
+	}
+
+	return ranks
+}
+
+// parseVersion parses numeric parts of versions like `v0.7.1` or `0.7.1-rc1`
+func parseVersion(version string) ([]int, bool) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	version, _, _ = strings.Cut(version, "-")
+	if version == "" {
+		return nil, false
+	}
+
+	var parts []int
+	for _, part := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, false
+		}
+
+		parts = append(parts, n)
+	}
+
+	return parts, true
+}
+
+func compareVersions(a []int, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func haversineKm(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	const earthRadiusKm = 6371.0
+
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+	dLat := toRadians(lat2 - lat1)
+	dLon := toRadians(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
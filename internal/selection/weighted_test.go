@@ -0,0 +1,195 @@
+package selection
+
+import (
+	"dvpn/models"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+func testServer(id uint, load float64, bandwidth int64, version string, lat float64, lon float64) models.Server {
+	server := models.Server{
+		CurrentLoad: load,
+		Configuration: datatypes.NewJSONType(models.ServerConfiguration{
+			BandwidthDownload: bandwidth,
+			BandwidthUpload:   bandwidth,
+			Version:           version,
+			LocationLat:       lat,
+			LocationLon:       lon,
+		}),
+	}
+	server.ID = id
+
+	return server
+}
+
+func seededWeighted(weights Weights, sharpness float64, failures *Failures) *Weighted {
+	w := NewWeighted(weights, sharpness, failures)
+	w.random = &lockedRand{random: rand.New(rand.NewSource(1))}
+
+	return w
+}
+
+func TestWeightedScoresFactors(t *testing.T) {
+	failures := NewFailures(time.Hour)
+	for i := 0; i < 4; i++ {
+		failures.Record(2, errors.New("key exchange failed"))
+	}
+
+	candidates := []models.Server{
+		testServer(1, 0.25, 100, "v0.7.1", 52.52, 13.40),
+		testServer(2, 1, 50, "0.7.0", 40.71, -74.01),
+		testServer(3, 0.5, 0, "unknown", 52.52, 13.40),
+	}
+
+	latitude, longitude := 52.52, 13.40
+
+	tests := []struct {
+		name   string
+		client Client
+		want   []map[Factor]float64
+	}{
+		{"client without location", Client{}, []map[Factor]float64{
+			{FactorLoad: 0.75, FactorBandwidth: 1, FactorVersion: 1, FactorFailures: 1},
+			{FactorLoad: 0, FactorBandwidth: 0.5, FactorVersion: 0, FactorFailures: 0},
+			{FactorLoad: 0.5, FactorBandwidth: 0, FactorVersion: 0, FactorFailures: 1},
+		}},
+		{"client with location", Client{Latitude: &latitude, Longitude: &longitude}, []map[Factor]float64{
+			{FactorLoad: 0.75, FactorBandwidth: 1, FactorVersion: 1, FactorFailures: 1, FactorDistance: 1},
+			{FactorLoad: 0, FactorBandwidth: 0.5, FactorVersion: 0, FactorFailures: 0, FactorDistance: 1 - haversineKm(latitude, longitude, 40.71, -74.01)/maxDistanceKm},
+			{FactorLoad: 0.5, FactorBandwidth: 0, FactorVersion: 0, FactorFailures: 1, FactorDistance: 1},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scores := seededWeighted(DefaultWeights, DefaultSharpness, failures).score(candidates, tt.client)
+
+			for i, score := range scores {
+				if !reflect.DeepEqual(score.factors, tt.want[i]) {
+					t.Errorf("factors of server %d = %v, want %v", candidates[i].ID, score.factors, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWeightedRank(t *testing.T) {
+	candidates := []models.Server{
+		testServer(1, 0.9, 10, "0.7.0", 0, 0),
+		testServer(2, 0.1, 100, "0.7.1", 0, 0),
+		testServer(3, 0.5, 50, "0.7.1", 0, 0),
+	}
+
+	tests := []struct {
+		name      string
+		sharpness float64
+		picks     func(counts map[uint]int) bool
+	}{
+		{"sharp picks the best server", 50, func(counts map[uint]int) bool { return counts[2] == 1000 }},
+		{"default favours the best server", DefaultSharpness, func(counts map[uint]int) bool {
+			return counts[2] > counts[3] && counts[3] > counts[1] && counts[1] > 0
+		}},
+		{"zero sharpness picks uniformly", 0, func(counts map[uint]int) bool {
+			return counts[1] > 250 && counts[2] > 250 && counts[3] > 250
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := seededWeighted(DefaultWeights, tt.sharpness, nil)
+
+			counts := make(map[uint]int)
+			for i := 0; i < 1000; i++ {
+				choices, err := w.Rank(candidates, Client{})
+				if err != nil {
+					t.Fatalf("failed to rank: %s", err)
+				}
+
+				if len(choices) != len(candidates) {
+					t.Fatalf("ranked %d choices, want %d", len(choices), len(candidates))
+				}
+
+				for j := 2; j < len(choices); j++ {
+					if choices[j].Score > choices[j-1].Score {
+						t.Fatalf("fallbacks are not ordered by score: %+v", choices)
+					}
+				}
+
+				counts[choices[0].ServerID]++
+			}
+
+			if !tt.picks(counts) {
+				t.Errorf("unexpected picks: %v", counts)
+			}
+		})
+	}
+}
+
+func TestRankWithoutCandidates(t *testing.T) {
+	strategies := map[string]Strategy{
+		StrategyWeighted: NewWeighted(DefaultWeights, DefaultSharpness, nil),
+		StrategyRandom:   NewRandom(),
+	}
+
+	for name, strategy := range strategies {
+		t.Run(name, func(t *testing.T) {
+			if _, err := strategy.Rank(nil, Client{}); !errors.Is(err, ErrNoCandidates) {
+				t.Errorf("err = %v, want ErrNoCandidates", err)
+			}
+		})
+	}
+}
+
+func TestRankVersions(t *testing.T) {
+	candidates := []models.Server{
+		testServer(1, 0, 0, "v0.7.1", 0, 0),
+		testServer(2, 0, 0, "0.7.1", 0, 0),
+		testServer(3, 0, 0, "0.6.10-rc1", 0, 0),
+		testServer(4, 0, 0, "0.10", 0, 0),
+		testServer(5, 0, 0, "latest", 0, 0),
+	}
+
+	want := map[string]float64{"v0.7.1": 0.5, "0.7.1": 0.5, "0.6.10-rc1": 0, "0.10": 1, "latest": 0}
+	if got := rankVersions(candidates); !reflect.DeepEqual(got, want) {
+		t.Errorf("rankVersions = %v, want %v", got, want)
+	}
+}
+
+func TestParseWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    Weights
+		wantErr bool
+	}{
+		{"empty keeps defaults", "", DefaultWeights, false},
+		{"overrides listed factors", "load=1, distance=0", Weights{
+			FactorLoad:      1,
+			FactorBandwidth: DefaultWeights[FactorBandwidth],
+			FactorVersion:   DefaultWeights[FactorVersion],
+			FactorFailures:  DefaultWeights[FactorFailures],
+			FactorDistance:  0,
+		}, false},
+		{"unknown factor", "price=1", nil, true},
+		{"missing weight", "load", nil, true},
+		{"negative weight", "load=-1", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseWeights(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("weights = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package sentinel
 
 import (
+	"encoding/json"
 	"strconv"
 	"time"
 )
@@ -88,7 +89,20 @@ type SentinelTransactionEvent struct {
 }
 
 type SentinelTransactionResult struct {
-	Events []SentinelTransactionEvent `json:"events"`
+	Code      int64                      `json:"code"`
+	Log       string                     `json:"log"`
+	GasWanted json.Number                `json:"gas_wanted"`
+	GasUsed   json.Number                `json:"gas_used"`
+	Events    []SentinelTransactionEvent `json:"events"`
+}
+
+type SentinelAccount struct {
+	AccountNumber json.Number `json:"account_number"`
+	Sequence      json.Number `json:"sequence"`
+}
+
+type SentinelSimulation struct {
+	GasUsed json.Number `json:"gas_used"`
 }
 
 type SentinelTransaction struct {
@@ -183,6 +197,22 @@ func (sa SentinelAllocation) DTO() SentinelAllocationDTO {
 	}
 }
 
+// SentinelWalletAllocation is the number of bytes of a subscription allocated to a wallet.
+// Allocating to a wallet which already has an allocation tops it up or reduces it to Bytes.
+type SentinelWalletAllocation struct {
+	Address string
+	Bytes   int64
+}
+
+// SentinelCredentialsRequest selects the node to start a session on and the device wallet which signs it
+type SentinelCredentialsRequest struct {
+	NodeAddress    string
+	RemoteURL      string
+	NodeType       int64
+	SubscriptionID int64
+	Signer         *Signer
+}
+
 type SentinelCredentials struct {
 	Uid        string `json:"uid,omitempty"`
 	PrivateKey string `json:"private_key,omitempty"`
@@ -0,0 +1,71 @@
+package sentinel
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	type step struct {
+		action  string // allow, success, failure, abandon or wait
+		wantErr bool
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"stays closed below threshold", []step{
+			{"failure", false}, {"failure", false}, {"allow", false},
+		}},
+		{"opens at threshold", []step{
+			{"failure", false}, {"failure", false}, {"failure", false}, {"allow", true},
+		}},
+		{"success resets failures", []step{
+			{"failure", false}, {"failure", false}, {"success", false}, {"failure", false}, {"allow", false},
+		}},
+		{"lets a single probe through after cooldown", []step{
+			{"failure", false}, {"failure", false}, {"failure", false}, {"wait", false}, {"allow", false}, {"allow", true},
+		}},
+		{"closes after a successful probe", []step{
+			{"failure", false}, {"failure", false}, {"failure", false}, {"wait", false}, {"allow", false}, {"success", false}, {"allow", false}, {"allow", false},
+		}},
+		{"opens again after a failed probe", []step{
+			{"failure", false}, {"failure", false}, {"failure", false}, {"wait", false}, {"allow", false}, {"failure", false}, {"allow", true},
+		}},
+		{"abandoned probe lets another one through", []step{
+			{"failure", false}, {"failure", false}, {"failure", false}, {"wait", false}, {"allow", false}, {"abandon", false}, {"allow", false},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := &CircuitBreaker{FailureThreshold: 3, Cooldown: 10 * time.Millisecond}
+
+			for i, s := range tt.steps {
+				var err error
+				switch s.action {
+				case "allow":
+					err = cb.Allow()
+				case "success":
+					cb.Success()
+				case "failure":
+					cb.Failure()
+				case "abandon":
+					cb.Abandon()
+				case "wait":
+					time.Sleep(2 * cb.Cooldown)
+				}
+
+				if (err != nil) != s.wantErr {
+					t.Fatalf("step %d (%s): err = %v, want error %t", i, s.action, err, s.wantErr)
+				}
+
+				if err != nil && !errors.Is(err, ErrCircuitOpen) {
+					t.Fatalf("step %d (%s): err = %v, want ErrCircuitOpen", i, s.action, err)
+				}
+			}
+		})
+	}
+}
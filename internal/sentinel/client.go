@@ -0,0 +1,38 @@
+package sentinel
+
+import "context"
+
+// SentinelClient is implemented by everything the API and the jobs use to talk
+// to the Sentinel chain — the gateway backed Sentinel and the in-memory FakeSentinel.
+type SentinelClient interface {
+	Denom() string
+
+	FetchNodes(ctx context.Context, page PageRequest) (*SentinelPage[SentinelNode], error)
+	FetchNodeStatus(ctx context.Context, node SentinelNode) (*SentinelNodeStatus, error)
+	FetchBalance(ctx context.Context, walletAddress string) (int64, error)
+	FetchSessions(ctx context.Context, walletAddress string, limit int, offset int) (*[]SentinelSession, error)
+	FetchSubscriptions(ctx context.Context, walletAddress string, limit int, offset int) (*[]SentinelSubscription, error)
+	FindSubscriptionForNode(ctx context.Context, walletAddress string, nodeAddress string) (*SentinelSubscription, error)
+	FindSubscriptionByID(ctx context.Context, subscriptionID int64) (*SentinelSubscription, error)
+	CreateNodeSubscription(ctx context.Context, nodeAddress string, gigabytes int64, hours int64) (*SentinelSubscription, error)
+	FetchAllocationsForSubscription(ctx context.Context, subscriptionID int64) (*SentinelAllocation, error)
+	FetchAllocationForWallet(ctx context.Context, subscriptionID int64, walletAddress string) (*SentinelAllocation, error)
+	CreateCredentials(ctx context.Context, request SentinelCredentialsRequest) (*SentinelCredentials, error)
+	StartSession(ctx context.Context, request SentinelCredentialsRequest) (int64, error)
+	EndSession(ctx context.Context, signer *Signer, sessionID int64) (*SentinelReceipt, error)
+	ProxyManualCredentialsRequest(ctx context.Context, remoteURL string, walletAddress string, sessionID int64, payload []byte) ([]byte, error)
+	FetchPlanNodes(ctx context.Context, page PageRequest) (*SentinelPage[SentinelNode], error)
+	AddNodeToPlan(ctx context.Context, nodeAddresses []string) (*SentinelReceipt, error)
+	RemoveNodeFromPlan(ctx context.Context, nodeAddress string) (*SentinelReceipt, error)
+	FetchFeeGrantAllowances(ctx context.Context, page PageRequest) (*SentinelPage[SentinelAllowance], error)
+	GrantFeeToWallet(ctx context.Context, walletAddresses []string) (*SentinelReceipt, error)
+	EnrollWalletToSubscription(ctx context.Context, allocations []SentinelWalletAllocation, subscriptionID int64) (*SentinelReceipt, error)
+	CreatePlanSubscription(ctx context.Context) (*SentinelSubscription, error)
+	FetchTransaction(ctx context.Context, txHash string) (*SentinelTransaction, error)
+	FetchHealthChecks(ctx context.Context) (*[]SentinelHealthCheck, error)
+}
+
+var (
+	_ SentinelClient = Sentinel{}
+	_ SentinelClient = (*FakeSentinel)(nil)
+)
@@ -0,0 +1,102 @@
+package sentinel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// codeNotFound is the gRPC code Sentinel API passes on when a queried object, like a transaction, doesn't exist
+const codeNotFound = 5
+
+var ErrCircuitOpen = errors.New("Sentinel API is unavailable, circuit breaker is open")
+
+// APIError is returned when Sentinel API or a Sentinel dVPN node answers with `success: false`.
+// It keeps the original SentinelError code so callers can react to specific chain errors.
+type APIError struct {
+	Source  string
+	Action  string
+	Code    int64
+	Message string
+}
+
+func (e *APIError) Error() string {
+	reason := ""
+	if e.Code != 0 {
+		reason = fmt.Sprintf(" (code %d, message %s)", e.Code, e.Message)
+	} else if e.Message != "" {
+		reason = " (" + e.Message + ")"
+	}
+
+	return "success `false` returned from " + e.Source + " " + e.Action + reason
+}
+
+// HTTPError is returned when Sentinel API or a node responds with a status code that indicates
+// a failure of the service itself rather than of the request.
+type HTTPError struct {
+	Source     string
+	Action     string
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %d returned from %s %s (%s)", e.StatusCode, e.Source, e.Action, e.Body)
+}
+
+// NodeError is returned when a Sentinel dVPN node fails the key exchange of a started session, by not responding,
+// responding with a failure or with an invalid payload. Failures to start the session on chain are not NodeErrors.
+type NodeError struct {
+	Err error
+}
+
+func (e *NodeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *NodeError) Unwrap() error {
+	return e.Err
+}
+
+// nodeError wraps an error of the key exchange into NodeError, unless the caller gave up on it
+// through ctx, which says nothing about the node.
+func nodeError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+
+	return &NodeError{Err: err}
+}
+
+// IsNodeError tells whether the node itself failed the key exchange, as opposed to the chain or the caller
+func IsNodeError(err error) bool {
+	var nodeError *NodeError
+	return errors.As(err, &nodeError)
+}
+
+// IsNotFound tells whether Sentinel API answered that the queried object doesn't exist, as opposed to failing to answer
+func IsNotFound(err error) bool {
+	var apiError *APIError
+	if errors.As(err, &apiError) {
+		return apiError.Code == codeNotFound || strings.Contains(strings.ToLower(apiError.Message), "not found")
+	}
+
+	var httpError *HTTPError
+	if errors.As(err, &httpError) {
+		return httpError.StatusCode == http.StatusNotFound
+	}
+
+	return false
+}
+
+// ErrorCode extracts SentinelError code from an error returned by SentinelClient.
+func ErrorCode(err error) (int64, bool) {
+	var apiError *APIError
+	if errors.As(err, &apiError) {
+		return apiError.Code, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,61 @@
+package sentinel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestIsNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"not found code", &APIError{Source: sourceAPI, Code: codeNotFound, Message: "tx not found"}, true},
+		{"not found message", &APIError{Source: sourceAPI, Code: 2, Message: "transaction ABC not found"}, true},
+		{"wrapped", fmt.Errorf("failed: %w", &APIError{Code: codeNotFound}), true},
+		{"http not found", &HTTPError{StatusCode: 404}, true},
+		{"other api error", &APIError{Source: sourceAPI, Code: 13, Message: "internal error"}, false},
+		{"server error", &HTTPError{StatusCode: 503}, false},
+		{"open circuit", ErrCircuitOpen, false},
+		{"network error", errors.New("connection refused"), false},
+		{"nil", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsNotFound(tt.err); got != tt.want {
+				t.Errorf("IsNotFound(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsNodeError(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"node failure", nodeError(context.Background(), &APIError{Source: sourceNode, Code: 3}), true},
+		{"node unreachable", nodeError(context.Background(), errors.New("connection refused")), true},
+		{"wrapped", fmt.Errorf("failed to create credentials: %w", &NodeError{Err: &HTTPError{StatusCode: 502}}), true},
+		{"abandoned by caller", nodeError(cancelled, context.Canceled), false},
+		{"chain failure", &APIError{Source: sourceAPI, Code: 11, Message: "out of gas"}, false},
+		{"open circuit", ErrCircuitOpen, false},
+		{"nil", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsNodeError(tt.err); got != tt.want {
+				t.Errorf("IsNodeError(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
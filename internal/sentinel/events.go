@@ -0,0 +1,218 @@
+package sentinel
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type SentinelSubscriptionCreatedEvent struct {
+	ID              int64  `event:"id"`
+	Address         string `event:"address,acc_address"`
+	NodeAddress     string `event:"node_address"`
+	PlanID          int64  `event:"plan_id"`
+	ProviderAddress string `event:"provider_address,prov_address"`
+}
+
+type SentinelAllocationUpdatedEvent struct {
+	SubscriptionID int64  `event:"id"`
+	Address        string `event:"address,acc_address"`
+	GrantedBytes   int64  `event:"granted_bytes"`
+	UtilisedBytes  int64  `event:"utilised_bytes"`
+}
+
+type SentinelNodeLinkedEvent struct {
+	PlanID          int64  `event:"id"`
+	ProviderAddress string `event:"address,prov_address"`
+	NodeAddress     string `event:"node_address"`
+}
+
+type SentinelNodeUnlinkedEvent struct {
+	PlanID          int64  `event:"id"`
+	ProviderAddress string `event:"address,prov_address"`
+	NodeAddress     string `event:"node_address"`
+}
+
+type SentinelSessionStartedEvent struct {
+	ID             int64  `event:"id"`
+	Address        string `event:"address,acc_address"`
+	NodeAddress    string `event:"node_address"`
+	SubscriptionID int64  `event:"subscription_id"`
+}
+
+type SentinelFeeAllowanceGrantedEvent struct {
+	Granter string `event:"granter"`
+	Grantee string `event:"grantee"`
+}
+
+// SentinelEvents holds typed events emitted by a transaction
+type SentinelEvents struct {
+	SubscriptionsCreated []SentinelSubscriptionCreatedEvent
+	AllocationsUpdated   []SentinelAllocationUpdatedEvent
+	NodesLinked          []SentinelNodeLinkedEvent
+	NodesUnlinked        []SentinelNodeUnlinkedEvent
+	FeeAllowancesGranted []SentinelFeeAllowanceGrantedEvent
+	SessionsStarted      []SentinelSessionStartedEvent
+}
+
+// SentinelReceipt is a broadcast transaction together with its decoded events
+type SentinelReceipt struct {
+	SentinelTransaction
+	Events SentinelEvents
+
+	// MessageType is the type gas of the transaction was estimated for
+	MessageType string
+}
+
+// DecodeEvents decodes events of the protocol emitted by a transaction, unknown events are skipped
+func (p Protocol) DecodeEvents(events []SentinelTransactionEvent) (SentinelEvents, error) {
+	var result SentinelEvents
+
+	for _, event := range events {
+		var err error
+
+		switch event.Type {
+		case p.EventNodeCreateSubscription, p.EventPlanCreateSubscription:
+			err = appendEvent(event, &result.SubscriptionsCreated)
+		case p.EventAllocate:
+			err = appendEvent(event, &result.AllocationsUpdated)
+		case p.EventLinkNode:
+			err = appendEvent(event, &result.NodesLinked)
+		case p.EventUnlinkNode:
+			err = appendEvent(event, &result.NodesUnlinked)
+		case p.EventSetFeeGrant:
+			err = appendEvent(event, &result.FeeAllowancesGranted)
+		case p.EventStartSession:
+			err = appendEvent(event, &result.SessionsStarted)
+		}
+
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+func appendEvent[T any](event SentinelTransactionEvent, events *[]T) error {
+	var decoded T
+	err := DecodeSentinelEvent(event, &decoded)
+	if err != nil {
+		return err
+	}
+
+	*events = append(*events, decoded)
+	return nil
+}
+
+// DecodeSentinelEvent fills fields of the struct pointed by out, tagged with `event:"<attribute key>[,<alias>...]"`,
+// from attributes of the event. Attributes may be either base64 encoded or plain, values may be JSON encoded.
+func DecodeSentinelEvent(event SentinelTransactionEvent, out any) error {
+	pointer := reflect.ValueOf(out)
+	if pointer.Kind() != reflect.Pointer || pointer.Elem().Kind() != reflect.Struct {
+		return errors.New("event can be decoded only into a pointer to struct")
+	}
+
+	attributes := decodeEventAttributes(event.Attributes)
+
+	value := pointer.Elem()
+	for i := 0; i < value.NumField(); i++ {
+		tag := value.Type().Field(i).Tag.Get("event")
+		if tag == "" {
+			continue
+		}
+
+		var key, attribute string
+		var ok bool
+		for _, key = range strings.Split(tag, ",") {
+			if attribute, ok = attributes[key]; ok {
+				break
+			}
+		}
+
+		if !ok {
+			continue
+		}
+
+		err := setEventField(value.Field(i), attribute)
+		if err != nil {
+			return fmt.Errorf("failed to decode attribute `%s` of event %s: %w", key, event.Type, err)
+		}
+	}
+
+	return nil
+}
+
+var eventAttributeKey = regexp.MustCompile(`^[a-z][a-z0-9_.]*$`)
+
+func decodeEventAttributes(attributes []SentinelTransactionEventAttribute) map[string]string {
+	decoded := make(map[string]string, len(attributes))
+
+	for _, attribute := range attributes {
+		key, value := attribute.Key, attribute.Value
+
+		// Older Tendermint versions encode attributes in base64, which is told apart from plain ones by the key
+		keyBytes, err := base64.StdEncoding.DecodeString(attribute.Key)
+		if err == nil && !eventAttributeKey.MatchString(attribute.Key) && eventAttributeKey.Match(keyBytes) {
+			valueBytes, err := base64.StdEncoding.DecodeString(attribute.Value)
+			if err == nil {
+				key, value = string(keyBytes), string(valueBytes)
+			}
+		}
+
+		decoded[key] = unquoteEventValue(value)
+	}
+
+	return decoded
+}
+
+func unquoteEventValue(value string) string {
+	if !strings.HasPrefix(value, `"`) {
+		return value
+	}
+
+	var unquoted string
+	err := json.Unmarshal([]byte(value), &unquoted)
+	if err != nil {
+		return value
+	}
+
+	return unquoted
+}
+
+func setEventField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(number)
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		number, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		field.SetUint(number)
+	case reflect.Bool:
+		boolean, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+
+		field.SetBool(boolean)
+	default:
+		return errors.New("unsupported field type " + field.Type().String())
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package sentinel
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func attributes(pairs ...string) []SentinelTransactionEventAttribute {
+	var result []SentinelTransactionEventAttribute
+	for i := 0; i+1 < len(pairs); i += 2 {
+		result = append(result, SentinelTransactionEventAttribute{Key: pairs[i], Value: pairs[i+1]})
+	}
+
+	return result
+}
+
+func base64Attributes(pairs ...string) []SentinelTransactionEventAttribute {
+	var encoded []string
+	for _, value := range pairs {
+		encoded = append(encoded, base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+
+	return attributes(encoded...)
+}
+
+func TestDecodeSentinelEvent(t *testing.T) {
+	want := SentinelSessionStartedEvent{ID: 42, Address: "sent1wallet", NodeAddress: "sentnode1node", SubscriptionID: 7}
+
+	tests := []struct {
+		name       string
+		attributes []SentinelTransactionEventAttribute
+	}{
+		{"plain", attributes("id", "42", "address", "sent1wallet", "node_address", "sentnode1node", "subscription_id", "7")},
+		{"json quoted", attributes("id", `"42"`, "address", `"sent1wallet"`, "node_address", `"sentnode1node"`, "subscription_id", `"7"`)},
+		{"base64", base64Attributes("id", "42", "address", "sent1wallet", "node_address", "sentnode1node", "subscription_id", "7")},
+		{"alias", attributes("id", "42", "acc_address", "sent1wallet", "node_address", "sentnode1node", "subscription_id", "7")},
+		{"unknown attributes", attributes("id", "42", "address", "sent1wallet", "node_address", "sentnode1node", "subscription_id", "7", "msg_index", "0")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got SentinelSessionStartedEvent
+			err := DecodeSentinelEvent(SentinelTransactionEvent{Type: ProtocolV2.EventStartSession, Attributes: tt.attributes}, &got)
+			if err != nil {
+				t.Fatalf("failed to decode: %s", err)
+			}
+
+			if got != want {
+				t.Errorf("decoded %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeSentinelEventErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		event SentinelTransactionEvent
+		out   any
+	}{
+		{"not a number", SentinelTransactionEvent{Attributes: attributes("id", "abc")}, &SentinelSessionStartedEvent{}},
+		{"not a pointer", SentinelTransactionEvent{}, SentinelSessionStartedEvent{}},
+		{"not a struct", SentinelTransactionEvent{}, new(int)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := DecodeSentinelEvent(tt.event, tt.out); err == nil {
+				t.Errorf("decoded without an error")
+			}
+		})
+	}
+}
+
+func TestDecodeEvents(t *testing.T) {
+	events := []SentinelTransactionEvent{
+		{Type: "message", Attributes: attributes("action", "/cosmos.authz.v1beta1.MsgExec")},
+		{Type: ProtocolV2.EventLinkNode, Attributes: attributes("id", "3", "address", "sentprov1provider", "node_address", "sentnode1a")},
+		{Type: ProtocolV2.EventLinkNode, Attributes: attributes("id", "3", "prov_address", "sentprov1provider", "node_address", "sentnode1b")},
+		{Type: ProtocolV2.EventAllocate, Attributes: attributes("id", "9", "address", "sent1wallet", "granted_bytes", "1000", "utilised_bytes", "10")},
+		{Type: ProtocolV2.EventSetFeeGrant, Attributes: attributes("granter", "sent1granter", "grantee", "sent1wallet")},
+	}
+
+	got, err := ProtocolV2.DecodeEvents(events)
+	if err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+
+	want := SentinelEvents{
+		NodesLinked: []SentinelNodeLinkedEvent{
+			{PlanID: 3, ProviderAddress: "sentprov1provider", NodeAddress: "sentnode1a"},
+			{PlanID: 3, ProviderAddress: "sentprov1provider", NodeAddress: "sentnode1b"},
+		},
+		AllocationsUpdated:   []SentinelAllocationUpdatedEvent{{SubscriptionID: 9, Address: "sent1wallet", GrantedBytes: 1000, UtilisedBytes: 10}},
+		FeeAllowancesGranted: []SentinelFeeAllowanceGrantedEvent{{Granter: "sent1granter", Grantee: "sent1wallet"}},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %+v, want %+v", got, want)
+	}
+}
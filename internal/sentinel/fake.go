@@ -0,0 +1,862 @@
+package sentinel
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cosmos/btcutil/bech32"
+)
+
+type FakeSentinelConfig struct {
+	ProviderPlanID        string
+	ProviderWalletAddress string
+	DefaultDenom          string
+
+	Nodes int
+	Seed  int64
+}
+
+type fakeLocation struct {
+	Country   string
+	City      string
+	Latitude  float64
+	Longitude float64
+}
+
+var fakeLocations = []fakeLocation{
+	{Country: "Germany", City: "Frankfurt am Main", Latitude: 50.1109, Longitude: 8.6821},
+	{Country: "Germany", City: "Berlin", Latitude: 52.5200, Longitude: 13.4050},
+	{Country: "Netherlands", City: "Amsterdam", Latitude: 52.3676, Longitude: 4.9041},
+	{Country: "United Kingdom", City: "London", Latitude: 51.5072, Longitude: -0.1276},
+	{Country: "France", City: "Paris", Latitude: 48.8566, Longitude: 2.3522},
+	{Country: "United States", City: "New York", Latitude: 40.7128, Longitude: -74.0060},
+	{Country: "United States", City: "Los Angeles", Latitude: 34.0522, Longitude: -118.2437},
+	{Country: "Canada", City: "Toronto", Latitude: 43.6532, Longitude: -79.3832},
+	{Country: "Japan", City: "Tokyo", Latitude: 35.6762, Longitude: 139.6503},
+	{Country: "Singapore", City: "Singapore", Latitude: 1.3521, Longitude: 103.8198},
+	{Country: "India", City: "Mumbai", Latitude: 19.0760, Longitude: 72.8777},
+	{Country: "Brazil", City: "São Paulo", Latitude: -23.5558, Longitude: -46.6396},
+	{Country: "Australia", City: "Sydney", Latitude: -33.8688, Longitude: 151.2093},
+	{Country: "Turkey", City: "Istanbul", Latitude: 41.0082, Longitude: 28.9784},
+}
+
+type fakeNode struct {
+	node      SentinelNode
+	status    SentinelNodeStatus
+	isHealthy bool
+}
+
+type fakeTransaction struct {
+	transaction SentinelTransaction
+	includedAt  time.Time
+}
+
+type fakeSession struct {
+	session   SentinelSession
+	startedAt time.Time
+	rate      int64
+}
+
+// FakeSentinel is an in-memory stand-in for the Sentinel chain and its gateway. It keeps
+// nodes, the plan, subscriptions, allocations, fee grants and sessions in memory so the API
+// and the jobs can run without network access. Select it with `SENTINEL_CLIENT=fake`.
+type FakeSentinel struct {
+	ProviderPlanID        string
+	ProviderWalletAddress string
+	DefaultDenom          string
+
+	mu     sync.Mutex
+	random *mathrand.Rand
+
+	nodes       []*fakeNode
+	planNodes   map[string]bool
+	feeGrants   []SentinelAllowance
+	balances    map[string]int64
+	sessions    []*fakeSession
+	allocations map[int64][]SentinelAllocation
+
+	subscriptions      map[int64]*SentinelSubscription
+	transactions       map[string]*fakeTransaction
+	nextSubscriptionID int64
+	nextSessionID      int64
+	height             int64
+}
+
+func NewFakeSentinel(config FakeSentinelConfig) *FakeSentinel {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	nodes := config.Nodes
+	if nodes <= 0 {
+		nodes = 50
+	}
+
+	fs := &FakeSentinel{
+		ProviderPlanID:        config.ProviderPlanID,
+		ProviderWalletAddress: config.ProviderWalletAddress,
+		DefaultDenom:          config.DefaultDenom,
+
+		random:        mathrand.New(mathrand.NewSource(seed)),
+		planNodes:     make(map[string]bool),
+		balances:      make(map[string]int64),
+		allocations:   make(map[int64][]SentinelAllocation),
+		subscriptions: make(map[int64]*SentinelSubscription),
+		transactions:  make(map[string]*fakeTransaction),
+
+		nextSubscriptionID: 1000,
+		nextSessionID:      5000000,
+		height:             15000000,
+	}
+
+	if fs.ProviderWalletAddress != "" {
+		fs.balances[fs.ProviderWalletAddress] = 100000000000
+	}
+
+	for i := 0; i < nodes; i++ {
+		fs.nodes = append(fs.nodes, fs.seedNode(i))
+	}
+
+	return fs
+}
+
+func (fs *FakeSentinel) seedNode(index int) *fakeNode {
+	location := fakeLocations[index%len(fakeLocations)]
+	address := fs.randomAddress("sentnode")
+
+	nodeType := int64(1 + fs.random.Intn(2))
+	maxPeers := int64(100 + 50*fs.random.Intn(5))
+
+	return &fakeNode{
+		node: SentinelNode{
+			Address: address,
+			GigabytePrices: []SentinelNodePrice{
+				{Amount: strconv.FormatInt(int64(2000000+fs.random.Intn(8000000)), 10), Denom: fs.DefaultDenom},
+			},
+			HourlyPrices: []SentinelNodePrice{
+				{Amount: strconv.FormatInt(int64(4000000+fs.random.Intn(12000000)), 10), Denom: fs.DefaultDenom},
+			},
+			RemoteURL: fmt.Sprintf("https://node-%d.sentinel.fake:%d", index, 8585+fs.random.Intn(100)),
+			Status:    1,
+		},
+		status: SentinelNodeStatus{
+			Address: address,
+			Bandwidth: SentinelNodeBandwidth{
+				Download: int64(10+fs.random.Intn(990)) * 125000,
+				Upload:   int64(10+fs.random.Intn(990)) * 125000,
+			},
+			Location: SentinelNodeLocation{
+				Latitude:  location.Latitude + (fs.random.Float64()-0.5)/10,
+				Longitude: location.Longitude + (fs.random.Float64()-0.5)/10,
+				City:      location.City,
+				Country:   location.Country,
+			},
+			Moniker: fmt.Sprintf("%s-%02d", strings.ReplaceAll(strings.ToLower(location.City), " ", "-"), index),
+			Peers:   int64(fs.random.Intn(int(maxPeers))),
+			QoS:     SentinelNodeQoS{MaxPeers: maxPeers},
+			Type:    nodeType,
+			Version: []string{"0.7.0", "0.7.1", "0.7.2"}[fs.random.Intn(3)],
+		},
+		isHealthy: fs.random.Intn(10) != 0,
+	}
+}
+
+func (fs *FakeSentinel) randomAddress(prefix string) string {
+	data := make([]byte, 20)
+	fs.random.Read(data)
+
+	converted, _ := bech32.ConvertBits(data, 8, 5, true)
+	address, _ := bech32.Encode(prefix, converted)
+
+	return address
+}
+
+func (fs *FakeSentinel) findNode(nodeAddress string) *fakeNode {
+	for _, n := range fs.nodes {
+		if n.node.Address == nodeAddress {
+			return n
+		}
+	}
+
+	return nil
+}
+
+// SetNodeHealthy makes the node answer status requests and key exchanges, or fail them when healthy is false
+func (fs *FakeSentinel) SetNodeHealthy(nodeAddress string, healthy bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if n := fs.findNode(nodeAddress); n != nil {
+		n.isHealthy = healthy
+	}
+}
+
+func (fs *FakeSentinel) hasFeeGrant(walletAddress string) bool {
+	for _, allowance := range fs.feeGrants {
+		if allowance.Grantee == walletAddress {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (fs *FakeSentinel) allocation(subscriptionID int64, walletAddress string) *SentinelAllocation {
+	for i := range fs.allocations[subscriptionID] {
+		if fs.allocations[subscriptionID][i].Address == walletAddress {
+			return &fs.allocations[subscriptionID][i]
+		}
+	}
+
+	return nil
+}
+
+// tick advances simulated sessions: active sessions accumulate duration and bandwidth, and
+// sessions which have been running for a while are closed, just like on the chain.
+func (fs *FakeSentinel) tick() {
+	now := time.Now()
+
+	for _, s := range fs.sessions {
+		if s.session.Status != SentinelSessionStatusActive {
+			continue
+		}
+
+		elapsed := now.Sub(s.startedAt)
+		if elapsed > 2*time.Hour {
+			s.session.Status = SentinelSessionStatusInactive
+			elapsed = 2 * time.Hour
+		}
+
+		seconds := int64(elapsed.Seconds())
+		s.session.Duration = elapsed.Nanoseconds()
+		s.session.Bandwidth = SentinelSessionBandwidth{
+			Download: strconv.FormatInt(seconds*s.rate, 10),
+			Upload:   strconv.FormatInt(seconds*s.rate/8, 10),
+		}
+	}
+}
+
+func (fs *FakeSentinel) utilisedBytes(subscriptionID int64, walletAddress string) int64 {
+	var total int64
+	for _, s := range fs.sessions {
+		if s.session.SubscriptionID == subscriptionID && s.session.Address == walletAddress {
+			bandwidth := s.session.Bandwidth.DTO()
+			total += bandwidth.Download + bandwidth.Upload
+		}
+	}
+
+	return total
+}
+
+func (fs *FakeSentinel) createSubscription(subscription SentinelSubscription) *SentinelSubscription {
+	fs.nextSubscriptionID++
+	fs.height++
+
+	subscription.Base.ID = fs.nextSubscriptionID
+	subscription.Base.Status = 1
+	fs.subscriptions[subscription.Base.ID] = &subscription
+
+	return &subscription
+}
+
+// newTransaction records a broadcast transaction which becomes visible to FetchTransaction
+// after a block time, the same way a transaction appears on chain once it is included.
+func (fs *FakeSentinel) newTransaction(events ...SentinelTransactionEvent) *SentinelReceipt {
+	hash := make([]byte, 32)
+	fs.random.Read(hash)
+
+	fs.height++
+	transaction := SentinelTransaction{
+		Height: fs.height,
+		TxHash: strings.ToUpper(fmt.Sprintf("%x", hash)),
+		TxResult: SentinelTransactionResult{
+			Events: events,
+		},
+	}
+
+	fs.transactions[transaction.TxHash] = &fakeTransaction{
+		transaction: transaction,
+		includedAt:  time.Now().Add(6 * time.Second),
+	}
+
+	// Events are built by the fake itself, so they always decode
+	decoded, _ := ProtocolV2.DecodeEvents(events)
+	return &SentinelReceipt{SentinelTransaction: transaction, Events: decoded}
+}
+
+// fakeEvent builds an event with plain attributes and JSON encoded values, as newer chain versions emit them
+func fakeEvent(eventType string, attributes ...string) SentinelTransactionEvent {
+	event := SentinelTransactionEvent{Type: eventType}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		event.Attributes = append(event.Attributes, SentinelTransactionEventAttribute{
+			Key:   attributes[i],
+			Value: strconv.Quote(attributes[i+1]),
+		})
+	}
+
+	return event
+}
+
+func fakePage[T any](items []T, limit int, offset int) *[]T {
+	if offset >= len(items) || limit <= 0 {
+		return nil
+	}
+
+	end := offset + limit
+	if end > len(items) || end < offset {
+		end = len(items)
+	}
+
+	page := make([]T, end-offset)
+	copy(page, items[offset:end])
+
+	return &page
+}
+
+// fakeKeyPage pages items the way key paginated endpoints do, the key being the encoded offset of the next page
+func fakeKeyPage[T any](items []T, request PageRequest) (*SentinelPage[T], error) {
+	offset := request.Offset
+	if request.Key != "" {
+		decoded, err := base64.StdEncoding.DecodeString(request.Key)
+		if err != nil {
+			return nil, errors.New("success `false` returned from Sentinel API (invalid pagination key)")
+		}
+
+		offset, err = strconv.Atoi(string(decoded))
+		if err != nil {
+			return nil, errors.New("success `false` returned from Sentinel API (invalid pagination key)")
+		}
+	}
+
+	page := &SentinelPage[T]{}
+	if p := fakePage(items, request.Limit, offset); p != nil {
+		page.Items = *p
+	}
+
+	if next := offset + len(page.Items); len(page.Items) > 0 && next < len(items) {
+		page.NextKey = base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(next)))
+	}
+
+	return page, nil
+}
+
+func (fs *FakeSentinel) Denom() string {
+	return fs.DefaultDenom
+}
+
+func (fs *FakeSentinel) FetchNodes(ctx context.Context, page PageRequest) (*SentinelPage[SentinelNode], error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var nodes []SentinelNode
+	for _, n := range fs.nodes {
+		nodes = append(nodes, n.node)
+	}
+
+	return fakeKeyPage(nodes, page)
+}
+
+func (fs *FakeSentinel) FetchNodeStatus(ctx context.Context, node SentinelNode) (*SentinelNodeStatus, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	n := fs.findNode(node.Address)
+	if n == nil || n.node.RemoteURL != node.RemoteURL {
+		return nil, errors.New("failed to reach Sentinel dVPN node at " + node.RemoteURL)
+	}
+
+	if !n.isHealthy {
+		return nil, errors.New("success `false` returned from Sentinel dVPN node when fetching status (node is unhealthy)")
+	}
+
+	n.status.Peers += int64(fs.random.Intn(21) - 10)
+	if n.status.Peers < 0 {
+		n.status.Peers = 0
+	}
+	if n.status.Peers > n.status.QoS.MaxPeers {
+		n.status.Peers = n.status.QoS.MaxPeers
+	}
+
+	status := n.status
+	return &status, nil
+}
+
+func (fs *FakeSentinel) FetchBalance(ctx context.Context, walletAddress string) (int64, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.balances[walletAddress], nil
+}
+
+func (fs *FakeSentinel) FetchSessions(ctx context.Context, walletAddress string, limit int, offset int) (*[]SentinelSession, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.tick()
+
+	var sessions []SentinelSession
+	for i := len(fs.sessions) - 1; i >= 0; i-- {
+		if fs.sessions[i].session.Address == walletAddress {
+			sessions = append(sessions, fs.sessions[i].session)
+		}
+	}
+
+	return fakePage(sessions, limit, offset), nil
+}
+
+func (fs *FakeSentinel) FetchSubscriptions(ctx context.Context, walletAddress string, limit int, offset int) (*[]SentinelSubscription, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var subscriptions []SentinelSubscription
+	for id := int64(1001); id <= fs.nextSubscriptionID; id++ {
+		subscription, ok := fs.subscriptions[id]
+		if ok && subscription.Base.Address == walletAddress {
+			subscriptions = append(subscriptions, *subscription)
+		}
+	}
+
+	return fakePage(subscriptions, limit, offset), nil
+}
+
+func (fs *FakeSentinel) FindSubscriptionForNode(ctx context.Context, walletAddress string, nodeAddress string) (*SentinelSubscription, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for id := int64(1001); id <= fs.nextSubscriptionID; id++ {
+		subscription, ok := fs.subscriptions[id]
+		if ok && subscription.Base.Address == walletAddress && subscription.NodeAddress == nodeAddress {
+			s := *subscription
+			return &s, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (fs *FakeSentinel) FindSubscriptionByID(ctx context.Context, subscriptionID int64) (*SentinelSubscription, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	subscription, ok := fs.subscriptions[subscriptionID]
+	if !ok {
+		return nil, errors.New("success `false` returned from Sentinel API when fetching subscription with ID" + strconv.FormatInt(subscriptionID, 10) + " (subscription does not exist)")
+	}
+
+	s := *subscription
+	return &s, nil
+}
+
+func (fs *FakeSentinel) CreateNodeSubscription(ctx context.Context, nodeAddress string, gigabytes int64, hours int64) (*SentinelSubscription, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.findNode(nodeAddress) == nil {
+		return nil, errors.New("success `false` returned  from Sentinel API during creation of subscription for node " + nodeAddress + " (node does not exist)")
+	}
+
+	subscription := fs.createSubscription(SentinelSubscription{
+		Base: SentinelSubscriptionBase{
+			Address:    fs.ProviderWalletAddress,
+			InactiveAt: time.Now().Add(time.Duration(hours) * time.Hour).UTC(),
+		},
+		NodeAddress: nodeAddress,
+		Gigabytes:   gigabytes,
+		Hours:       hours,
+	})
+
+	return subscription, nil
+}
+
+func (fs *FakeSentinel) FetchAllocationsForSubscription(ctx context.Context, subscriptionID int64) (*SentinelAllocation, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.tick()
+
+	allocations := fs.allocations[subscriptionID]
+	if len(allocations) == 0 {
+		return nil, nil
+	}
+
+	allocation := allocations[len(allocations)-1]
+	allocation.UtilisedBytes = strconv.FormatInt(fs.utilisedBytes(subscriptionID, allocation.Address), 10)
+
+	return &allocation, nil
+}
+
+func (fs *FakeSentinel) FetchAllocationForWallet(ctx context.Context, subscriptionID int64, walletAddress string) (*SentinelAllocation, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.tick()
+
+	found := fs.allocation(subscriptionID, walletAddress)
+	if found == nil {
+		return nil, nil
+	}
+
+	allocation := *found
+	allocation.UtilisedBytes = strconv.FormatInt(fs.utilisedBytes(subscriptionID, walletAddress), 10)
+
+	return &allocation, nil
+}
+
+func (fs *FakeSentinel) EndSession(ctx context.Context, signer *Signer, sessionID int64) (*SentinelReceipt, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.tick()
+
+	for _, s := range fs.sessions {
+		if s.session.ID != sessionID {
+			continue
+		}
+
+		if s.session.Address != signer.Address {
+			break
+		}
+
+		if s.session.Status != SentinelSessionStatusActive {
+			return nil, errors.New("success `false` returned from Sentinel API while ending session " + strconv.FormatInt(sessionID, 10) + " of wallet " + signer.Address + " (session is not active)")
+		}
+
+		s.session.Status = SentinelSessionStatusInactivePending
+		return fs.newTransaction(), nil
+	}
+
+	return nil, errors.New("success `false` returned from Sentinel API while ending session " + strconv.FormatInt(sessionID, 10) + " of wallet " + signer.Address + " (session does not exist)")
+}
+
+func (fs *FakeSentinel) CreateCredentials(ctx context.Context, request SentinelCredentialsRequest) (*SentinelCredentials, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	n, sessionID, err := fs.startSession(request, "during creation of credentials for node "+request.NodeAddress+" using wallet "+request.Signer.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if !n.isHealthy {
+		return nil, &NodeError{Err: errors.New("failed to reach Sentinel dVPN node at " + n.node.RemoteURL + " during key exchange for session " + strconv.FormatInt(sessionID, 10))}
+	}
+
+	key, err := newSessionKey(n.status.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	// The key exchange is signed the same way the gateway backed client does, so signing errors surface offline too
+	_, err = newKeyExchangeRequest(request.Signer, uint64(sessionID), key.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SentinelCredentials{
+		Uid:        key.Uid,
+		PrivateKey: key.PrivateKey,
+		Result:     base64.StdEncoding.EncodeToString(fs.keyExchangeResult(n)),
+	}, nil
+}
+
+func (fs *FakeSentinel) StartSession(ctx context.Context, request SentinelCredentialsRequest) (int64, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	_, sessionID, err := fs.startSession(request, "while starting session on node "+request.NodeAddress+" using wallet "+request.Signer.Address)
+	return sessionID, err
+}
+
+// startSession checks the node and the wallet the way the chain does and starts a session,
+// marking previous active sessions of the wallet as ending. The caller holds fs.mu.
+func (fs *FakeSentinel) startSession(request SentinelCredentialsRequest, action string) (*fakeNode, int64, error) {
+	nodeAddress := request.NodeAddress
+	subscriptionID := request.SubscriptionID
+	walletAddress := request.Signer.Address
+
+	fail := func(reason string) (*fakeNode, int64, error) {
+		return nil, 0, errors.New("success `false` returned from Sentinel API " + action + " (" + reason + ")")
+	}
+
+	n := fs.findNode(nodeAddress)
+	if n == nil {
+		return fail("node does not exist")
+	}
+
+	if fs.allocation(subscriptionID, walletAddress) == nil {
+		return fail("wallet has no allocation in subscription " + strconv.FormatInt(subscriptionID, 10))
+	}
+
+	if !fs.hasFeeGrant(walletAddress) {
+		return fail("wallet has no fee allowance")
+	}
+
+	for _, s := range fs.sessions {
+		if s.session.Address == walletAddress && s.session.Status == SentinelSessionStatusActive {
+			s.session.Status = SentinelSessionStatusInactivePending
+		}
+	}
+
+	fs.nextSessionID++
+	fs.height++
+	fs.sessions = append(fs.sessions, &fakeSession{
+		session: SentinelSession{
+			ID:             fs.nextSessionID,
+			SubscriptionID: subscriptionID,
+			NodeAddress:    nodeAddress,
+			Address:        walletAddress,
+			Status:         SentinelSessionStatusActive,
+			Bandwidth:      SentinelSessionBandwidth{Download: "0", Upload: "0"},
+		},
+		startedAt: time.Now(),
+		rate:      int64(50000 + fs.random.Intn(500000)),
+	})
+
+	return n, fs.nextSessionID, nil
+}
+
+// keyExchangeResult mimics the payload Sentinel dVPN nodes return after a key exchange:
+// assigned IPv4 and IPv6 addresses, port and public key for WireGuard, and port plus
+// transport for V2Ray.
+func (fs *FakeSentinel) keyExchangeResult(n *fakeNode) []byte {
+	if n.status.Type == 1 {
+		result := make([]byte, 54)
+		copy(result[0:4], []byte{10, 8, byte(fs.random.Intn(256)), byte(2 + fs.random.Intn(250))})
+		copy(result[4:20], []byte{0xfd, 0x00, 0x0, 0x8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, byte(2 + fs.random.Intn(250))})
+		binary.BigEndian.PutUint16(result[20:22], 51820)
+		rand.Read(result[22:54])
+
+		return result
+	}
+
+	result := make([]byte, 4)
+	result[0] = 0x01
+	binary.BigEndian.PutUint16(result[1:3], 8686)
+	result[3] = 0x02
+
+	return result
+}
+
+func (fs *FakeSentinel) ProxyManualCredentialsRequest(ctx context.Context, remoteURL string, walletAddress string, sessionID int64, payload []byte) ([]byte, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var n *fakeNode
+	for _, candidate := range fs.nodes {
+		if candidate.node.RemoteURL == remoteURL {
+			n = candidate
+		}
+	}
+
+	if n == nil || !n.isHealthy {
+		return nil, &NodeError{Err: errors.New("failed to reach Sentinel dVPN node at " + remoteURL)}
+	}
+
+	var session *fakeSession
+	for _, s := range fs.sessions {
+		if s.session.ID == sessionID && s.session.Address == walletAddress && s.session.NodeAddress == n.node.Address {
+			session = s
+		}
+	}
+
+	type nodeResponse struct {
+		Success bool           `json:"success"`
+		Error   *SentinelError `json:"error,omitempty"`
+		Result  string         `json:"result,omitempty"`
+	}
+
+	var request keyExchangeRequest
+	if err := json.Unmarshal(payload, &request); err != nil || request.Key == "" || request.Signature == "" {
+		return json.Marshal(nodeResponse{
+			Success: false,
+			Error:   &SentinelError{Code: 3, Message: "invalid key exchange request"},
+		})
+	}
+
+	if session == nil || session.session.Status != SentinelSessionStatusActive {
+		return json.Marshal(nodeResponse{
+			Success: false,
+			Error:   &SentinelError{Code: 5, Message: "active session does not exist"},
+		})
+	}
+
+	return json.Marshal(nodeResponse{
+		Success: true,
+		Result:  base64.StdEncoding.EncodeToString(fs.keyExchangeResult(n)),
+	})
+}
+
+func (fs *FakeSentinel) FetchPlanNodes(ctx context.Context, page PageRequest) (*SentinelPage[SentinelNode], error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var nodes []SentinelNode
+	for _, n := range fs.nodes {
+		if fs.planNodes[n.node.Address] {
+			nodes = append(nodes, n.node)
+		}
+	}
+
+	return fakeKeyPage(nodes, page)
+}
+
+func (fs *FakeSentinel) AddNodeToPlan(ctx context.Context, nodeAddresses []string) (*SentinelReceipt, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for _, nodeAddress := range nodeAddresses {
+		if fs.findNode(nodeAddress) == nil {
+			return nil, errors.New("success `false` returned from Sentinel API while adding nodes to plan " + fs.ProviderPlanID + " (node " + nodeAddress + " does not exist)")
+		}
+	}
+
+	var events []SentinelTransactionEvent
+	for _, nodeAddress := range nodeAddresses {
+		fs.planNodes[nodeAddress] = true
+		events = append(events, fakeEvent(ProtocolV2.EventLinkNode, "address", fs.ProviderWalletAddress, "node_address", nodeAddress, "id", fs.ProviderPlanID))
+	}
+
+	return fs.newTransaction(events...), nil
+}
+
+func (fs *FakeSentinel) RemoveNodeFromPlan(ctx context.Context, nodeAddress string) (*SentinelReceipt, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if !fs.planNodes[nodeAddress] {
+		return nil, errors.New("success `false` returned from Sentinel API while removing node  " + nodeAddress + " from plan " + fs.ProviderPlanID + " (node is not linked)")
+	}
+
+	delete(fs.planNodes, nodeAddress)
+
+	return fs.newTransaction(fakeEvent(ProtocolV2.EventUnlinkNode, "address", fs.ProviderWalletAddress, "node_address", nodeAddress, "id", fs.ProviderPlanID)), nil
+}
+
+func (fs *FakeSentinel) FetchFeeGrantAllowances(ctx context.Context, page PageRequest) (*SentinelPage[SentinelAllowance], error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fakeKeyPage(fs.feeGrants, page)
+}
+
+func (fs *FakeSentinel) GrantFeeToWallet(ctx context.Context, walletAddresses []string) (*SentinelReceipt, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for _, walletAddress := range walletAddresses {
+		if fs.hasFeeGrant(walletAddress) {
+			return nil, errors.New("success `false` returned from Sentinel API while granting fee to wallets (fee allowance already exists for " + walletAddress + ")")
+		}
+	}
+
+	var events []SentinelTransactionEvent
+	for _, walletAddress := range walletAddresses {
+		fs.feeGrants = append(fs.feeGrants, SentinelAllowance{
+			Grantee: walletAddress,
+			Granter: fs.ProviderWalletAddress,
+		})
+		events = append(events, fakeEvent(ProtocolV2.EventSetFeeGrant, "granter", fs.ProviderWalletAddress, "grantee", walletAddress))
+	}
+
+	return fs.newTransaction(events...), nil
+}
+
+func (fs *FakeSentinel) EnrollWalletToSubscription(ctx context.Context, allocations []SentinelWalletAllocation, subscriptionID int64) (*SentinelReceipt, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.subscriptions[subscriptionID]; !ok {
+		return nil, errors.New("success `false` returned from Sentinel API while adding wallets to subscription (subscription does not exist)")
+	}
+
+	var events []SentinelTransactionEvent
+	for _, walletAllocation := range allocations {
+		walletAddress := walletAllocation.Address
+		allocation := fs.allocation(subscriptionID, walletAddress)
+		if allocation == nil {
+			fs.allocations[subscriptionID] = append(fs.allocations[subscriptionID], SentinelAllocation{
+				Address:       walletAddress,
+				UtilisedBytes: "0",
+			})
+			allocation = fs.allocation(subscriptionID, walletAddress)
+		}
+
+		allocation.GrantedBytes = strconv.FormatInt(walletAllocation.Bytes, 10)
+		events = append(events, fakeEvent(
+			ProtocolV2.EventAllocate,
+			"address", walletAddress,
+			"granted_bytes", allocation.GrantedBytes,
+			"utilised_bytes", allocation.UtilisedBytes,
+			"id", strconv.FormatInt(subscriptionID, 10),
+		))
+	}
+
+	return fs.newTransaction(events...), nil
+}
+
+func (fs *FakeSentinel) CreatePlanSubscription(ctx context.Context) (*SentinelSubscription, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	planID, _ := strconv.ParseInt(fs.ProviderPlanID, 10, 64)
+
+	subscription := fs.createSubscription(SentinelSubscription{
+		Base: SentinelSubscriptionBase{
+			Address:    fs.ProviderWalletAddress,
+			InactiveAt: time.Now().Add(30 * 24 * time.Hour).UTC(),
+		},
+		PlanId: planID,
+	})
+
+	return subscription, nil
+}
+
+func (fs *FakeSentinel) FetchTransaction(ctx context.Context, txHash string) (*SentinelTransaction, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	transaction, ok := fs.transactions[txHash]
+	if !ok || time.Now().Before(transaction.includedAt) {
+		return nil, &APIError{Source: sourceAPI, Action: "when fetching transaction " + txHash, Code: codeNotFound, Message: "tx not found"}
+	}
+
+	t := transaction.transaction
+	return &t, nil
+}
+
+func (fs *FakeSentinel) FetchHealthChecks(ctx context.Context) (*[]SentinelHealthCheck, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	var checks []SentinelHealthCheck
+	for _, n := range fs.nodes {
+		check := SentinelHealthCheck{
+			Address:                 n.node.Address,
+			ConfigExchangeTimestamp: now,
+			InfoFetchTimestamp:      now,
+			LocationFetchTimestamp:  now,
+			Status:                  1,
+		}
+
+		if !n.isHealthy {
+			check.ConfigExchangeError = "context deadline exceeded"
+		}
+
+		checks = append(checks, check)
+	}
+
+	return &checks, nil
+}
@@ -0,0 +1,71 @@
+package sentinel
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFakeCreateCredentials(t *testing.T) {
+	ctx := context.Background()
+
+	fake := NewFakeSentinel(FakeSentinelConfig{ProviderPlanID: "1", ProviderWalletAddress: "sentprov1provider", Nodes: 2, Seed: 1})
+	fake.nodes[0].isHealthy = true
+	fake.nodes[1].isHealthy = false
+
+	signer, err := NewSignerFromMnemonic(testMnemonic)
+	if err != nil {
+		t.Fatalf("failed to create signer: %s", err)
+	}
+
+	subscription, err := fake.CreatePlanSubscription(ctx)
+	if err != nil {
+		t.Fatalf("failed to subscribe to plan: %s", err)
+	}
+
+	tests := []struct {
+		name          string
+		node          int
+		enroll        bool
+		wantErr       bool
+		wantNodeError bool
+	}{
+		{"chain rejects wallet without allocation", 0, false, true, false},
+		{"healthy node", 0, true, false, false},
+		{"unhealthy node fails key exchange", 1, true, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.enroll && !fake.hasFeeGrant(signer.Address) {
+				if _, err := fake.EnrollWalletToSubscription(ctx, []SentinelWalletAllocation{{Address: signer.Address, Bytes: 1 << 30}}, subscription.Base.ID); err != nil {
+					t.Fatalf("failed to enroll wallet: %s", err)
+				}
+
+				if _, err := fake.GrantFeeToWallet(ctx, []string{signer.Address}); err != nil {
+					t.Fatalf("failed to grant fee: %s", err)
+				}
+			}
+
+			node := fake.nodes[tt.node]
+			credentials, err := fake.CreateCredentials(ctx, SentinelCredentialsRequest{
+				NodeAddress:    node.node.Address,
+				RemoteURL:      node.node.RemoteURL,
+				NodeType:       node.status.Type,
+				SubscriptionID: subscription.Base.ID,
+				Signer:         signer,
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+
+			if IsNodeError(err) != tt.wantNodeError {
+				t.Errorf("IsNodeError(%v) = %t, want %t", err, IsNodeError(err), tt.wantNodeError)
+			}
+
+			if !tt.wantErr && credentials.Result == "" {
+				t.Errorf("no key exchange result in credentials")
+			}
+		})
+	}
+}
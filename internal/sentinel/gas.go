@@ -0,0 +1,109 @@
+package sentinel
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// gasCacheKey is a message type and the number of messages in a transaction. Usage is cached per batch size,
+// as the fixed cost of a transaction (signature verification, fee grant lookup) doesn't scale with messages.
+type gasCacheKey struct {
+	messageType string
+	messages    int
+}
+
+type gasCacheEntry struct {
+	gasUsed   int64
+	expiresAt time.Time
+}
+
+// GasEstimator turns simulated gas usage into the gas limit of a transaction. Simulated usage is
+// cached per message type and batch size, so a batch of the same messages is only simulated once per CacheTTL.
+type GasEstimator struct {
+	Multiplier float64
+	Cap        int64
+	CacheTTL   time.Duration
+
+	mu    sync.Mutex
+	cache map[gasCacheKey]gasCacheEntry
+}
+
+func NewGasEstimator(multiplier float64, cap int64, cacheTTL time.Duration) *GasEstimator {
+	return &GasEstimator{
+		Multiplier: multiplier,
+		Cap:        cap,
+		CacheTTL:   cacheTTL,
+		cache:      make(map[gasCacheKey]gasCacheEntry),
+	}
+}
+
+// Estimate returns gas limit for a transaction with given number of messages of messageType.
+// simulate is called only when there is no cached estimate for the message type and number of messages.
+func (ge *GasEstimator) Estimate(messageType string, messages int, simulate func() (int64, error)) (int64, error) {
+	if messages < 1 {
+		messages = 1
+	}
+
+	key := gasCacheKey{messageType: messageType, messages: messages}
+	gasUsed, ok := ge.cached(key)
+	if !ok {
+		var err error
+		gasUsed, err = simulate()
+		if err != nil {
+			return 0, err
+		}
+
+		ge.store(key, gasUsed)
+	}
+
+	gas := int64(math.Ceil(float64(gasUsed) * ge.Multiplier))
+	if ge.Cap > 0 && gas > ge.Cap {
+		gas = ge.Cap
+	}
+
+	return gas, nil
+}
+
+// Forget drops cached estimates of every batch size for the message type, e.g. after a transaction ran out of gas.
+// Forget of a nil GasEstimator does nothing.
+func (ge *GasEstimator) Forget(messageType string) {
+	if ge == nil {
+		return
+	}
+
+	ge.mu.Lock()
+	defer ge.mu.Unlock()
+
+	for key := range ge.cache {
+		if key.messageType == messageType {
+			delete(ge.cache, key)
+		}
+	}
+}
+
+func (ge *GasEstimator) cached(key gasCacheKey) (int64, bool) {
+	ge.mu.Lock()
+	defer ge.mu.Unlock()
+
+	entry, ok := ge.cache[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return 0, false
+	}
+
+	return entry.gasUsed, true
+}
+
+func (ge *GasEstimator) store(key gasCacheKey, gasUsed int64) {
+	ge.mu.Lock()
+	defer ge.mu.Unlock()
+
+	if ge.cache == nil {
+		ge.cache = make(map[gasCacheKey]gasCacheEntry)
+	}
+
+	ge.cache[key] = gasCacheEntry{
+		gasUsed:   gasUsed,
+		expiresAt: time.Now().Add(ge.CacheTTL),
+	}
+}
@@ -0,0 +1,119 @@
+package sentinel
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGasEstimatorCachesPerBatchSize(t *testing.T) {
+	// Simulated usage is a fixed overhead of 60000 and 20000 per message
+	simulations := 0
+	simulate := func(messages int) func() (int64, error) {
+		return func() (int64, error) {
+			simulations++
+			return 60000 + 20000*int64(messages), nil
+		}
+	}
+
+	ge := NewGasEstimator(1.5, 0, time.Minute)
+
+	tests := []struct {
+		name        string
+		messages    int
+		want        int64
+		simulations int
+	}{
+		{"single message is simulated", 1, 120000, 1},
+		{"batch is simulated on its own", 15, 540000, 2},
+		{"single message is cached", 1, 120000, 2},
+		{"batch is cached", 15, 540000, 2},
+		{"no messages count as one", 0, 120000, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages := tt.messages
+			if messages < 1 {
+				messages = 1
+			}
+
+			gas, err := ge.Estimate("/sentinel.plan.v2.MsgLinkNodeRequest", tt.messages, simulate(messages))
+			if err != nil {
+				t.Fatalf("failed to estimate: %s", err)
+			}
+
+			if gas != tt.want {
+				t.Errorf("gas = %d, want %d", gas, tt.want)
+			}
+
+			if simulations != tt.simulations {
+				t.Errorf("simulations = %d, want %d", simulations, tt.simulations)
+			}
+		})
+	}
+}
+
+func TestGasEstimatorForgetDropsEveryBatchSize(t *testing.T) {
+	ge := NewGasEstimator(1, 0, time.Minute)
+
+	simulations := 0
+	simulate := func() (int64, error) {
+		simulations++
+		return 100000, nil
+	}
+
+	for _, messages := range []int{1, 5} {
+		ge.Estimate("a", messages, simulate)
+	}
+	ge.Estimate("b", 1, simulate)
+
+	ge.Forget("a")
+
+	for _, messages := range []int{1, 5} {
+		ge.Estimate("a", messages, simulate)
+	}
+	ge.Estimate("b", 1, simulate)
+
+	if simulations != 5 {
+		t.Errorf("simulations = %d, want 5", simulations)
+	}
+
+	var nilEstimator *GasEstimator
+	nilEstimator.Forget("a")
+}
+
+func TestGasEstimatorCapAndErrors(t *testing.T) {
+	ge := NewGasEstimator(2, 300000, time.Minute)
+
+	gas, err := ge.Estimate("a", 1, func() (int64, error) { return 200000, nil })
+	if err != nil || gas != 300000 {
+		t.Errorf("gas = %d, %v, want capped 300000", gas, err)
+	}
+
+	_, err = ge.Estimate("b", 1, func() (int64, error) { return 0, errors.New("simulation failed") })
+	if err == nil {
+		t.Error("expected simulation error to be returned")
+	}
+
+	gas, err = ge.Estimate("b", 1, func() (int64, error) { return 1000, nil })
+	if err != nil || gas != 2000 {
+		t.Errorf("gas = %d, %v, want 2000 after a failed simulation", gas, err)
+	}
+}
+
+func TestGasEstimatorExpires(t *testing.T) {
+	ge := NewGasEstimator(1, 0, -time.Second)
+
+	simulations := 0
+	for i := 0; i < 3; i++ {
+		ge.Estimate("a", 1, func() (int64, error) {
+			simulations++
+			return 1000, nil
+		})
+	}
+
+	if simulations != 3 {
+		t.Errorf("simulations = %d, want 3 with expired cache", simulations)
+	}
+}
@@ -0,0 +1,116 @@
+package sentinel
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Types of Sentinel dVPN nodes, as reported in SentinelNodeStatus.Type
+const (
+	SentinelNodeTypeWireGuard int64 = 1
+	SentinelNodeTypeV2Ray     int64 = 2
+)
+
+// sessionKey is the key a device presents to a node during the key exchange, together with
+// the part of it the device keeps to connect.
+type sessionKey struct {
+	Key        []byte
+	PrivateKey string
+	Uid        string
+}
+
+func newSessionKey(nodeType int64) (*sessionKey, error) {
+	switch nodeType {
+	case SentinelNodeTypeWireGuard:
+		privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		return &sessionKey{
+			Key:        privateKey.PublicKey().Bytes(),
+			PrivateKey: base64.StdEncoding.EncodeToString(privateKey.Bytes()),
+		}, nil
+	case SentinelNodeTypeV2Ray:
+		uid := make([]byte, 16)
+		_, err := rand.Read(uid)
+		if err != nil {
+			return nil, err
+		}
+
+		uid[6] = (uid[6] & 0x0f) | 0x40
+		uid[8] = (uid[8] & 0x3f) | 0x80
+
+		return &sessionKey{
+			Key: uid,
+			Uid: fmt.Sprintf("%x-%x-%x-%x-%x", uid[0:4], uid[4:6], uid[6:8], uid[8:10], uid[10:16]),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown Sentinel node type %d", nodeType)
+}
+
+// keyExchangeRequest is the payload of the node key exchange, signed with the wallet which started the session
+type keyExchangeRequest struct {
+	Key       string `json:"key"`
+	Signature string `json:"signature"`
+}
+
+func newKeyExchangeRequest(signer *Signer, sessionID uint64, key []byte) (*keyExchangeRequest, error) {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, sessionID)
+
+	signature, err := signer.Sign(message)
+	if err != nil {
+		return nil, err
+	}
+
+	return &keyExchangeRequest{
+		Key:       base64.StdEncoding.EncodeToString(key),
+		Signature: base64.StdEncoding.EncodeToString(signature),
+	}, nil
+}
+
+// ParseManualKey decodes the key a device generated on its own: base64 encoded WireGuard public key
+// or V2Ray UID, depending on the type of the node.
+func ParseManualKey(nodeType int64, key string) ([]byte, error) {
+	switch nodeType {
+	case SentinelNodeTypeWireGuard:
+		publicKey, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("WireGuard public key should be base64 encoded: %w", err)
+		}
+
+		if _, err := ecdh.X25519().NewPublicKey(publicKey); err != nil {
+			return nil, fmt.Errorf("invalid WireGuard public key: %w", err)
+		}
+
+		return publicKey, nil
+	case SentinelNodeTypeV2Ray:
+		uid, err := hex.DecodeString(strings.ReplaceAll(key, "-", ""))
+		if err != nil || len(uid) != 16 {
+			return nil, fmt.Errorf("invalid V2Ray UID %q", key)
+		}
+
+		return uid, nil
+	}
+
+	return nil, fmt.Errorf("unknown Sentinel node type %d", nodeType)
+}
+
+// NewManualKeyExchangePayload signs the key exchange of a device generated key, so it can be proxied to the node
+// with ProxyManualCredentialsRequest.
+func NewManualKeyExchangePayload(signer *Signer, sessionID int64, key []byte) ([]byte, error) {
+	request, err := newKeyExchangeRequest(signer, uint64(sessionID), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(request)
+}
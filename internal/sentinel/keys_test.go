@@ -0,0 +1,50 @@
+package sentinel
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseManualKey(t *testing.T) {
+	publicKey := bytes.Repeat([]byte{9}, 32)
+	uid := []byte{0x6f, 0x1e, 0x2a, 0x7c, 0x0b, 0x4d, 0x4e, 0x1a, 0x9c, 0x3e, 0x5f, 0x2d, 0x8a, 0x1b, 0x7e, 0x40}
+
+	tests := []struct {
+		name     string
+		nodeType int64
+		key      string
+		want     []byte
+	}{
+		{"WireGuard public key", SentinelNodeTypeWireGuard, base64.StdEncoding.EncodeToString(publicKey), publicKey},
+		{"WireGuard key which isn't base64", SentinelNodeTypeWireGuard, "not base64!", nil},
+		{"short WireGuard key", SentinelNodeTypeWireGuard, base64.StdEncoding.EncodeToString(publicKey[:31]), nil},
+		{"long WireGuard key", SentinelNodeTypeWireGuard, base64.StdEncoding.EncodeToString(append(publicKey, 9)), nil},
+		{"V2Ray UID", SentinelNodeTypeV2Ray, "6f1e2a7c-0b4d-4e1a-9c3e-5f2d8a1b7e40", uid},
+		{"V2Ray UID without dashes", SentinelNodeTypeV2Ray, "6f1e2a7c0b4d4e1a9c3e5f2d8a1b7e40", uid},
+		{"V2Ray UID which isn't hex", SentinelNodeTypeV2Ray, "6f1e2a7c-0b4d-4e1a-9c3e-5f2d8a1b7ezz", nil},
+		{"short V2Ray UID", SentinelNodeTypeV2Ray, "6f1e2a7c-0b4d-4e1a-9c3e-5f2d8a1b7e", nil},
+		{"long V2Ray UID", SentinelNodeTypeV2Ray, "6f1e2a7c-0b4d-4e1a-9c3e-5f2d8a1b7e4000", nil},
+		{"unknown node type", 0, base64.StdEncoding.EncodeToString(publicKey), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseManualKey(tt.nodeType, tt.key)
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("parsed invalid key as %x", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("failed to parse key: %s", err)
+			}
+
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("key = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package sentinel
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// DefaultPageLimit is the page size used by the jobs, small enough for the gateway to return in full
+const DefaultPageLimit = 100
+
+// PageRequest selects a page either by Key, returned as NextKey of the previous page, or by Offset
+type PageRequest struct {
+	Limit  int
+	Offset int
+	Key    string
+}
+
+func (r PageRequest) args() string {
+	if r.Key != "" {
+		return "&limit=" + strconv.Itoa(r.Limit) + "&key=" + url.QueryEscape(r.Key)
+	}
+
+	return "&limit=" + strconv.Itoa(r.Limit) + "&offset=" + strconv.Itoa(r.Offset)
+}
+
+type SentinelPagination struct {
+	NextKey string `json:"next_key"`
+	Total   string `json:"total"`
+}
+
+type SentinelPage[T any] struct {
+	Items   []T
+	NextKey string
+}
+
+// Pager streams items of a paginated endpoint page by page. It follows NextKey when the endpoint
+// returns one and falls back to offsets otherwise.
+type Pager[T any] struct {
+	fetch   func(ctx context.Context, page PageRequest) (*SentinelPage[T], error)
+	request PageRequest
+	isDone  bool
+}
+
+func NewPager[T any](limit int, fetch func(ctx context.Context, page PageRequest) (*SentinelPage[T], error)) *Pager[T] {
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+
+	return &Pager[T]{
+		fetch:   fetch,
+		request: PageRequest{Limit: limit},
+	}
+}
+
+func NodesPager(client SentinelClient, limit int) *Pager[SentinelNode] {
+	return NewPager(limit, client.FetchNodes)
+}
+
+func PlanNodesPager(client SentinelClient, limit int) *Pager[SentinelNode] {
+	return NewPager(limit, client.FetchPlanNodes)
+}
+
+func FeeGrantAllowancesPager(client SentinelClient, limit int) *Pager[SentinelAllowance] {
+	return NewPager(limit, client.FetchFeeGrantAllowances)
+}
+
+// SessionsPager pages through sessions of the wallet, which are only paginated by offset
+func SessionsPager(client SentinelClient, walletAddress string, limit int) *Pager[SentinelSession] {
+	return NewPager(limit, func(ctx context.Context, page PageRequest) (*SentinelPage[SentinelSession], error) {
+		sessions, err := client.FetchSessions(ctx, walletAddress, page.Limit, page.Offset)
+		if err != nil || sessions == nil {
+			return nil, err
+		}
+
+		return &SentinelPage[SentinelSession]{Items: *sessions}, nil
+	})
+}
+
+// Next returns items of the next page, nil once all pages are fetched
+func (p *Pager[T]) Next(ctx context.Context) ([]T, error) {
+	if p.isDone {
+		return nil, nil
+	}
+
+	page, err := p.fetch(ctx, p.request)
+	if err != nil {
+		return nil, err
+	}
+
+	if page == nil || len(page.Items) == 0 {
+		p.isDone = true
+		return nil, nil
+	}
+
+	switch {
+	case page.NextKey != "":
+		p.request.Key = page.NextKey
+	case p.request.Key != "" || len(page.Items) < p.request.Limit:
+		// Either the last page of key pagination or a short page of offset pagination
+		p.isDone = true
+	default:
+		p.request.Offset += len(page.Items)
+	}
+
+	return page.Items, nil
+}
+
+// Each calls fn for every item until fn returns false or all pages are fetched
+func (p *Pager[T]) Each(ctx context.Context, fn func(item T) bool) error {
+	for {
+		items, err := p.Next(ctx)
+		if err != nil {
+			return err
+		}
+
+		if items == nil {
+			return nil
+		}
+
+		for _, item := range items {
+			if !fn(item) {
+				return nil
+			}
+		}
+	}
+}
+
+// All collects items of all pages
+func (p *Pager[T]) All(ctx context.Context) ([]T, error) {
+	var all []T
+	err := p.Each(ctx, func(item T) bool {
+		all = append(all, item)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return all, nil
+}
@@ -0,0 +1,165 @@
+package sentinel
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// pagedItems serves items either by key, when byKey is set, or by offset, recording requested pages
+type pagedItems struct {
+	items    []int
+	byKey    bool
+	requests []PageRequest
+}
+
+func (p *pagedItems) fetch(ctx context.Context, page PageRequest) (*SentinelPage[int], error) {
+	p.requests = append(p.requests, page)
+
+	start := page.Offset
+	if page.Key != "" {
+		start, _ = strconv.Atoi(page.Key)
+	}
+
+	if start >= len(p.items) {
+		return &SentinelPage[int]{}, nil
+	}
+
+	end := start + page.Limit
+	if end > len(p.items) {
+		end = len(p.items)
+	}
+
+	result := &SentinelPage[int]{Items: p.items[start:end]}
+	if p.byKey && end < len(p.items) {
+		result.NextKey = strconv.Itoa(end)
+	}
+
+	return result, nil
+}
+
+func items(n int) []int {
+	result := make([]int, n)
+	for i := range result {
+		result[i] = i
+	}
+
+	return result
+}
+
+func TestPagerAll(t *testing.T) {
+	tests := []struct {
+		name     string
+		items    int
+		limit    int
+		byKey    bool
+		requests int
+	}{
+		{"offsets, short last page", 25, 10, false, 3},
+		{"offsets, full last page", 20, 10, false, 3},
+		{"offsets, empty", 0, 10, false, 1},
+		{"keys, short last page", 25, 10, true, 3},
+		{"keys, full last page", 20, 10, true, 2},
+		{"default limit", 150, 0, false, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &pagedItems{items: items(tt.items), byKey: tt.byKey}
+
+			all, err := NewPager(tt.limit, source.fetch).All(context.Background())
+			if err != nil {
+				t.Fatalf("failed to page: %s", err)
+			}
+
+			if len(all) != tt.items || (tt.items > 0 && !reflect.DeepEqual(all, source.items)) {
+				t.Errorf("paged %d items, want %d in order", len(all), tt.items)
+			}
+
+			if len(source.requests) != tt.requests {
+				t.Errorf("requested %d pages, want %d: %v", len(source.requests), tt.requests, source.requests)
+			}
+		})
+	}
+}
+
+func TestPagerEachStopsEarly(t *testing.T) {
+	source := &pagedItems{items: items(50)}
+
+	var seen []int
+	err := NewPager(10, source.fetch).Each(context.Background(), func(item int) bool {
+		seen = append(seen, item)
+		return item < 12
+	})
+	if err != nil {
+		t.Fatalf("failed to page: %s", err)
+	}
+
+	if len(seen) != 13 || len(source.requests) != 2 {
+		t.Errorf("saw %d items in %d pages, want 13 in 2", len(seen), len(source.requests))
+	}
+}
+
+func TestPlanNodesPagerWithFake(t *testing.T) {
+	fake := NewFakeSentinel(FakeSentinelConfig{ProviderPlanID: "1", Nodes: 30, Seed: 1})
+
+	nodes, err := NodesPager(fake, 7).All(context.Background())
+	if err != nil {
+		t.Fatalf("failed to page nodes: %s", err)
+	}
+
+	if len(nodes) != 30 {
+		t.Fatalf("paged %d nodes, want 30", len(nodes))
+	}
+
+	var addresses []string
+	for _, node := range nodes[:12] {
+		addresses = append(addresses, node.Address)
+	}
+
+	_, err = fake.AddNodeToPlan(context.Background(), addresses)
+	if err != nil {
+		t.Fatalf("failed to add nodes to plan: %s", err)
+	}
+
+	planNodes, err := PlanNodesPager(fake, 5).All(context.Background())
+	if err != nil {
+		t.Fatalf("failed to page plan nodes: %s", err)
+	}
+
+	if len(planNodes) != 12 {
+		t.Errorf("paged %d plan nodes, want 12", len(planNodes))
+	}
+}
+
+// sessionsClient is FakeSentinel with a fixed history of sessions
+type sessionsClient struct {
+	*FakeSentinel
+	sessions []SentinelSession
+}
+
+func (c sessionsClient) FetchSessions(ctx context.Context, walletAddress string, limit int, offset int) (*[]SentinelSession, error) {
+	var sessions []SentinelSession
+	for i := offset; i < len(c.sessions) && i < offset+limit; i++ {
+		sessions = append(sessions, c.sessions[i])
+	}
+
+	return &sessions, nil
+}
+
+func TestSessionsPager(t *testing.T) {
+	client := sessionsClient{FakeSentinel: NewFakeSentinel(FakeSentinelConfig{Nodes: 1, Seed: 1})}
+	for i := 0; i < 45; i++ {
+		client.sessions = append(client.sessions, SentinelSession{ID: int64(i + 1)})
+	}
+
+	sessions, err := SessionsPager(client, "sent1wallet", 20).All(context.Background())
+	if err != nil {
+		t.Fatalf("failed to page sessions: %s", err)
+	}
+
+	if len(sessions) != 45 || sessions[44].ID != 45 {
+		t.Errorf("paged %d sessions, want all 45", len(sessions))
+	}
+}
@@ -0,0 +1,120 @@
+package sentinel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Protocol describes a version of Sentinel modules: gateway endpoints which speak it, message type URLs
+// used in authz grants and gas estimates, and types of events emitted by transactions.
+type Protocol struct {
+	Version string
+
+	// APIPrefix is prepended to paths of the gateway endpoints
+	APIPrefix string
+
+	MessageNodeSubscribe  string
+	MessagePlanSubscribe  string
+	MessageLinkNode       string
+	MessageUnlinkNode     string
+	MessageGrantAllowance string
+	MessageAllocate       string
+	MessageStartSession   string
+	MessageEndSession     string
+
+	EventNodeCreateSubscription string
+	EventPlanCreateSubscription string
+	EventAllocate               string
+	EventLinkNode               string
+	EventUnlinkNode             string
+	EventSetFeeGrant            string
+	EventStartSession           string
+}
+
+const (
+	ProtocolVersionV2   = "v2"
+	ProtocolVersionAuto = "auto"
+
+	// ProtocolVersionV3 is recognised only to be refused, see Protocols
+	ProtocolVersionV3 = "v3"
+)
+
+var ErrUnsupportedProtocol = errors.New("unsupported Sentinel protocol version")
+
+var ProtocolV2 = Protocol{
+	Version:   ProtocolVersionV2,
+	APIPrefix: "/api/v1",
+
+	MessageNodeSubscribe:  "/sentinel.node.v2.MsgSubscribeRequest",
+	MessagePlanSubscribe:  "/sentinel.plan.v2.MsgSubscribeRequest",
+	MessageLinkNode:       "/sentinel.plan.v2.MsgLinkNodeRequest",
+	MessageUnlinkNode:     "/sentinel.plan.v2.MsgUnlinkNodeRequest",
+	MessageGrantAllowance: "/cosmos.feegrant.v1beta1.MsgGrantAllowance",
+	MessageAllocate:       "/sentinel.subscription.v2.MsgAllocateRequest",
+	MessageStartSession:   "/sentinel.session.v2.MsgStartRequest",
+	MessageEndSession:     "/sentinel.session.v2.MsgEndRequest",
+
+	EventNodeCreateSubscription: "sentinel.node.v2.EventCreateSubscription",
+	EventPlanCreateSubscription: "sentinel.plan.v2.EventCreateSubscription",
+	EventAllocate:               "sentinel.subscription.v2.EventAllocate",
+	EventLinkNode:               "sentinel.plan.v2.EventLinkNode",
+	EventUnlinkNode:             "sentinel.plan.v2.EventUnlinkNode",
+	EventSetFeeGrant:            "set_feegrant",
+	EventStartSession:           "sentinel.session.v2.EventStart",
+}
+
+// Protocols are ordered from the newest one, which is probed first during detection.
+// Only v2 is supported: v3 starts sessions through node, plan and subscription messages with their own
+// field layouts, which the encoders in tx.go don't model yet, so configuring it fails instead of signing
+// transactions the chain would reject.
+var Protocols = []Protocol{ProtocolV2}
+
+// ProtocolForVersion returns protocol of the version, `auto` is resolved with Sentinel.DetectProtocol
+func ProtocolForVersion(version string) (*Protocol, error) {
+	if version == ProtocolVersionV3 {
+		return nil, fmt.Errorf("%w `%s`, its messages aren't modelled yet", ErrUnsupportedProtocol, version)
+	}
+
+	for _, protocol := range Protocols {
+		if protocol.Version == version {
+			p := protocol
+			return &p, nil
+		}
+	}
+
+	return nil, errors.New("unknown Sentinel protocol version `" + version + "`")
+}
+
+// Messages returns message types the role wallets should be granted with authz
+func (p Protocol) Messages() []string {
+	return []string{
+		p.MessageNodeSubscribe,
+		p.MessagePlanSubscribe,
+		p.MessageLinkNode,
+		p.MessageUnlinkNode,
+		p.MessageGrantAllowance,
+		p.MessageAllocate,
+	}
+}
+
+// DetectProtocol probes gateway endpoints of every known protocol, starting from the newest one,
+// and returns the first protocol the gateway answers for.
+func (s Sentinel) DetectProtocol(ctx context.Context) (*Protocol, error) {
+	var failures []string
+
+	for _, protocol := range Protocols {
+		p := protocol
+		s.Protocol = &p
+
+		_, err := s.FetchPlanNodes(ctx, PageRequest{Limit: 1})
+		if err == nil {
+			return &p, nil
+		}
+
+		failures = append(failures, fmt.Sprintf("%s: %s", p.Version, err))
+	}
+
+	return nil, errors.New("failed to detect Sentinel protocol version (" + strings.Join(failures, "; ") + ")")
+}
@@ -0,0 +1,43 @@
+package sentinel
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestProtocolForVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+		is      error
+	}{
+		{ProtocolVersionV2, ProtocolVersionV2, nil},
+		{ProtocolVersionV3, "", ErrUnsupportedProtocol},
+		{"v1", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			protocol, err := ProtocolForVersion(tt.version)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("resolved protocol %s, want error", protocol.Version)
+				}
+
+				if tt.is != nil && !errors.Is(err, tt.is) {
+					t.Errorf("err = %v, want %v", err, tt.is)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("failed to resolve protocol: %s", err)
+			}
+
+			if protocol.Version != tt.want {
+				t.Errorf("protocol = %s, want %s", protocol.Version, tt.want)
+			}
+		})
+	}
+}
@@ -1,17 +1,14 @@
 package sentinel
 
 import (
-	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
+	"go.uber.org/zap"
+	"math/big"
 	"net/http"
 	"strconv"
-	"time"
 )
 
 type Sentinel struct {
@@ -48,580 +45,398 @@ type Sentinel struct {
 	ChainID      string
 	GasPrice     string
 	GasBase      int64
-}
 
-func (s Sentinel) FetchNodes(limit int, offset int) (*[]SentinelNode, error) {
-	type blockchainResponse struct {
-		Success bool            `json:"success"`
-		Error   *SentinelError  `json:"error"`
-		Result  *[]SentinelNode `json:"result"`
-	}
+	// Transport is used for Sentinel API, NodeTransport for requests sent directly to dVPN nodes
+	// and HealthTransport for Health API.
+	Transport       *Transport
+	NodeTransport   *Transport
+	HealthTransport *Transport
 
-	args := fmt.Sprintf(
-		"?rpc_address=%s&chain_id=%s&limit=%d&offset=%d&status=%s",
-		s.RPCEndpoint,
-		s.ChainID,
-		limit,
-		offset,
-		"Active",
-	)
+	// Protocol is the version of Sentinel modules the gateway speaks, ProtocolV2 when nil
+	Protocol *Protocol
 
-	url := s.APIEndpoint + "/api/v1/nodes" + args
-	req, _ := http.NewRequest("GET", url, nil)
+	// Gas estimates gas limits of transactions by simulating them. When nil, gas is computed from GasBase.
+	Gas    *GasEstimator
+	Logger *zap.SugaredLogger
+}
 
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
+// CodeOutOfGas is the SDK error code of a transaction which exceeded its gas limit
+const CodeOutOfGas = 11
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
+const (
+	sourceAPI    = "Sentinel API"
+	sourceNode   = "Sentinel dVPN node"
+	sourceHealth = "Health API"
+)
 
-	var response *blockchainResponse
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return nil, err
-	}
+var (
+	defaultTransport       = DefaultTransport()
+	defaultNodeTransport   = DefaultNodeTransport()
+	defaultHealthTransport = DefaultTransport()
+)
 
-	if response.Success == false {
-		apiError := ""
-		if response.Error != nil {
-			apiError = " (" + response.Error.Message + ")"
-		}
+func (s Sentinel) Denom() string {
+	return s.DefaultDenom
+}
 
-		return nil, errors.New("success `false` returned from Sentinel API when fetching nodes" + apiError)
+func (s Sentinel) protocol() Protocol {
+	if s.Protocol == nil {
+		return ProtocolV2
 	}
 
-	return response.Result, nil
+	return *s.Protocol
 }
 
-func (s Sentinel) FetchNodeStatus(node SentinelNode) (*SentinelNodeStatus, error) {
-	type nodeResponse struct {
-		Success bool                `json:"success"`
-		Error   *SentinelError      `json:"error"`
-		Result  *SentinelNodeStatus `json:"result"`
+func (s Sentinel) api() *Transport {
+	if s.Transport == nil {
+		return defaultTransport
 	}
 
-	url := fmt.Sprintf("%s/status", node.RemoteURL)
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
+	return s.Transport
+}
 
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   4 * time.Second,
+func (s Sentinel) node() *Transport {
+	if s.NodeTransport == nil {
+		return defaultNodeTransport
 	}
 
-	res, err := client.Get(url)
-	if err != nil {
-		return nil, err
+	return s.NodeTransport
+}
+
+func (s Sentinel) health() *Transport {
+	if s.HealthTransport == nil {
+		return defaultHealthTransport
 	}
 
-	defer res.Body.Close()
+	return s.HealthTransport
+}
+
+func (s Sentinel) query(ctx context.Context, url string, action string, result any) error {
+	return s.api().Do(ctx, TransportRequest{
+		Method:     http.MethodGet,
+		URL:        url,
+		Idempotent: true,
+		Source:     sourceAPI,
+		Action:     action,
+	}, result)
+}
 
-	body, err := ioutil.ReadAll(res.Body)
+// queryPage fetches a page of a list endpoint, path should already contain the query arguments
+func queryPage[T any](ctx context.Context, s Sentinel, url string, action string) (*SentinelPage[T], error) {
+	var items *[]T
+	pagination, err := s.api().DoPaginated(ctx, TransportRequest{
+		Method:     http.MethodGet,
+		URL:        url,
+		Idempotent: true,
+		Source:     sourceAPI,
+		Action:     action,
+	}, &items)
 	if err != nil {
 		return nil, err
 	}
 
-	var response nodeResponse
-
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return nil, errors.New("failed to unmarshal JSON from Sentinel dVPN node response — " + err.Error() + " (" + string(body) + ")")
+	page := &SentinelPage[T]{}
+	if items != nil {
+		page.Items = *items
 	}
 
-	if response.Success == false {
-		apiError := ""
-		if response.Error != nil {
-			apiError = " (" + response.Error.Message + ")"
-		}
-
-		return nil, errors.New("success `false` returned from Sentinel dVPN node when fetching status" + apiError)
+	if pagination != nil {
+		page.NextKey = pagination.NextKey
 	}
 
-	return response.Result, nil
+	return page, nil
 }
 
-func (s Sentinel) FetchBalance(walletAddress string) (int64, error) {
-	type blockchainResponse struct {
-		Success bool               `json:"success"`
-		Error   *SentinelError     `json:"error"`
-		Result  *[]SentinelBalance `json:"result"`
-	}
-
+func (s Sentinel) fetchAccount(ctx context.Context, address string) (*SentinelAccount, error) {
 	args := fmt.Sprintf(
 		"?rpc_address=%s&chain_id=%s",
 		s.RPCEndpoint,
 		s.ChainID,
 	)
 
-	url := s.APIEndpoint + "/api/v1/accounts/" + walletAddress + "/balances" + args
-	req, _ := http.NewRequest("GET", url, nil)
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return 0, err
-	}
-
-	body, err := io.ReadAll(res.Body)
+	var account *SentinelAccount
+	err := s.query(ctx, s.APIEndpoint+s.protocol().APIPrefix+"/accounts/"+address+args, "when fetching account "+address, &account)
 	if err != nil {
-		return 0, err
-	}
-
-	defer res.Body.Close()
-
-	var response *blockchainResponse
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return 0, err
-	}
-
-	if response.Success == false {
-		apiError := ""
-		if response.Error != nil {
-			apiError = " (" + response.Error.Message + ")"
-		}
-
-		return 0, errors.New("success `false` returned from Sentinel API when fetching balance for wallet " + walletAddress + apiError)
+		return nil, err
 	}
 
-	var walletBalance int64
-	for _, balance := range *response.Result {
-		if balance.Denom == s.DefaultDenom {
-			walletBalance, _ = strconv.ParseInt(balance.Amount, 10, 64)
-		}
+	if account == nil {
+		return nil, errors.New("no account returned from Sentinel API for " + address)
 	}
 
-	return walletBalance, nil
+	return account, nil
 }
 
-func (s Sentinel) FetchSessions(walletAddress string, limit int, offset int) (*[]SentinelSession, error) {
-	type blockchainResponse struct {
-		Success bool               `json:"success"`
-		Error   *SentinelError     `json:"error"`
-		Result  *[]SentinelSession `json:"result"`
-	}
-
+func (s Sentinel) broadcast(ctx context.Context, txBytes []byte, action string) (*SentinelTransaction, error) {
 	args := fmt.Sprintf(
-		"?rpc_address=%s&chain_id=%s&limit=%d&offset=%d",
+		"?rpc_address=%s&chain_id=%s",
 		s.RPCEndpoint,
 		s.ChainID,
-		limit,
-		offset,
 	)
 
-	url := s.APIEndpoint + "/api/v1/accounts/" + walletAddress + "/sessions" + args
-	req, _ := http.NewRequest("GET", url, nil)
-
-	res, err := http.DefaultClient.Do(req)
+	var transaction *SentinelTransaction
+	err := s.api().Do(ctx, TransportRequest{
+		Method:  http.MethodPost,
+		URL:     s.APIEndpoint + s.protocol().APIPrefix + "/txs" + args,
+		Payload: signedTxRequest{TxBytes: base64.StdEncoding.EncodeToString(txBytes)},
+		Source:  sourceAPI,
+		Action:  action,
+	}, &transaction)
 	if err != nil {
 		return nil, err
 	}
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
+	if transaction == nil {
+		return nil, errors.New("no transaction returned from Sentinel API " + action)
 	}
 
-	defer res.Body.Close()
+	return transaction, nil
+}
+
+type signedTxRequest struct {
+	TxBytes string `json:"tx_bytes"`
+}
+
+// execute signs a transaction with the messages locally, estimates its gas and broadcasts it.
+// Only signed bytes are sent to the gateway.
+func (s Sentinel) execute(ctx context.Context, signer *Signer, messages []anyMessage, messageType string, count int, action string) (*SentinelReceipt, error) {
+	signer.mu.Lock()
+	defer signer.mu.Unlock()
 
-	var response *blockchainResponse
-	err = json.Unmarshal(body, &response)
+	account, err := s.fetchAccount(ctx, signer.Address)
 	if err != nil {
 		return nil, err
 	}
 
-	if response.Success == false {
-		apiError := ""
-		if response.Error != nil {
-			apiError = " (" + response.Error.Message + ")"
-		}
-
-		return nil, errors.New("success `false` returned from Sentinel API when fetching sessions for wallet " + walletAddress + apiError)
+	accountNumber, err := strconv.ParseUint(account.AccountNumber.String(), 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid account number of " + signer.Address + ": " + err.Error())
 	}
 
-	return response.Result, nil
-}
-
-func (s Sentinel) FetchSubscriptions(walletAddress string, limit int, offset int) (*[]SentinelSubscription, error) {
-	type blockchainResponse struct {
-		Success bool                    `json:"success"`
-		Error   *SentinelError          `json:"error"`
-		Result  *[]SentinelSubscription `json:"result"`
+	sequence, err := strconv.ParseUint(account.Sequence.String(), 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid sequence of " + signer.Address + ": " + err.Error())
 	}
 
-	args := fmt.Sprintf(
-		"?rpc_address=%s&chain_id=%s&limit=%d&offset=%d",
-		s.RPCEndpoint,
-		s.ChainID,
-		limit,
-		offset,
-	)
-
-	url := s.APIEndpoint + "/api/v1/accounts/" + walletAddress + "/subscriptions" + args
-	req, _ := http.NewRequest("GET", url, nil)
+	tx := unsignedTx{
+		Messages:      messages,
+		FeeGranter:    s.ProviderWalletAddress,
+		ChainID:       s.ChainID,
+		AccountNumber: accountNumber,
+		Sequence:      sequence,
+	}
 
-	res, err := http.DefaultClient.Do(req)
+	gas := s.estimateGas(ctx, signer, tx, messageType, count, action)
+	tx.GasLimit = uint64(gas)
+	tx.Fee, err = s.fee(gas)
 	if err != nil {
 		return nil, err
 	}
 
-	body, err := io.ReadAll(res.Body)
+	txBytes, err := tx.sign(signer)
 	if err != nil {
 		return nil, err
 	}
 
-	defer res.Body.Close()
-
-	var response *blockchainResponse
-	err = json.Unmarshal(body, &response)
+	transaction, err := s.broadcast(ctx, txBytes, action)
 	if err != nil {
 		return nil, err
 	}
 
-	if response.Success == false {
-		apiError := ""
-		if response.Error != nil {
-			apiError = " (" + response.Error.Message + ")"
-		}
-
-		return nil, errors.New("success `false` returned from Sentinel API when fetching subscriptions for wallet " + walletAddress + apiError)
+	if s.Logger != nil {
+		s.Logger.Infof(
+			"gas for %d x %s: estimated %d, wanted %s, used %s (tx %s)",
+			count,
+			messageType,
+			gas,
+			transaction.TxResult.GasWanted,
+			transaction.TxResult.GasUsed,
+			transaction.TxHash,
+		)
 	}
 
-	return response.Result, nil
-}
-
-func (s Sentinel) FindSubscriptionForNode(walletAddress string, nodeAddress string) (*SentinelSubscription, error) {
-	var fetchInProgress bool
-	var limit int
-	var offset int
-
-	fetchInProgress = true
-	limit = 1000000
-	offset = 0
-
-	var subscriptions []SentinelSubscription
-
-	for fetchInProgress {
-		s, err := s.FetchSubscriptions(walletAddress, limit, offset)
-		if err != nil {
-			return nil, err
-		}
-
-		if s == nil {
-			fetchInProgress = false
-		} else {
-			subscriptions = append(subscriptions, *s...)
-		}
-
-		offset += limit
+	// Cached estimate is too low for the message type, next transaction simulates it again
+	if s.Gas != nil && transaction.TxResult.Code == CodeOutOfGas {
+		s.Gas.Forget(messageType)
 	}
 
-	for _, subscription := range subscriptions {
-		if subscription.NodeAddress == nodeAddress {
-			return &subscription, nil
-		}
+	if transaction.TxResult.Code != 0 {
+		return nil, &APIError{Source: sourceAPI, Action: action + " (tx " + transaction.TxHash + ")", Code: transaction.TxResult.Code, Message: transaction.TxResult.Log}
 	}
 
-	return nil, nil
+	// The transaction is already broadcast, so events which can't be decoded don't fail the call
+	events, err := s.protocol().DecodeEvents(transaction.TxResult.Events)
+	if err != nil && s.Logger != nil {
+		s.Logger.Warnf("failed to decode events of transaction %s: %s", transaction.TxHash, err)
+	}
 
+	return &SentinelReceipt{SentinelTransaction: *transaction, Events: events, MessageType: messageType}, nil
 }
 
-func (s Sentinel) FindSubscriptionByID(subscriptionID int64) (*SentinelSubscription, error) {
-	type blockchainResponse struct {
-		Success bool                  `json:"success"`
-		Error   *SentinelError        `json:"error"`
-		Result  *SentinelSubscription `json:"result"`
+// exec wraps messages into authz MsgExec, sent by a role wallet on behalf of the granter
+func (s Sentinel) exec(ctx context.Context, mnemonic string, messages []anyMessage, messageType string, action string) (*SentinelReceipt, error) {
+	signer, err := signerForMnemonic(mnemonic)
+	if err != nil {
+		return nil, err
 	}
 
-	args := fmt.Sprintf(
-		"?rpc_address=%s&chain_id=%s",
-		s.RPCEndpoint,
-		s.ChainID,
-	)
-
-	url := s.APIEndpoint + "/api/v1/subscriptions/" + strconv.FormatInt(subscriptionID, 10) + args
-	req, _ := http.NewRequest("GET", url, nil)
+	return s.execute(ctx, signer, []anyMessage{s.protocol().msgExec(signer.Address, messages)}, messageType, len(messages), action)
+}
 
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
+// fee returns fee for the gas at GasPrice, rounded up
+func (s Sentinel) fee(gas int64) (protoMessage, error) {
+	price, ok := new(big.Rat).SetString(s.GasPrice)
+	if !ok {
+		return nil, errors.New("invalid gas price " + s.GasPrice)
 	}
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
+	amount := new(big.Rat).Mul(price, new(big.Rat).SetInt64(gas))
+	quotient, remainder := new(big.Int).QuoRem(amount.Num(), amount.Denom(), new(big.Int))
+	if remainder.Sign() > 0 {
+		quotient.Add(quotient, big.NewInt(1))
 	}
 
-	defer res.Body.Close()
+	return coin(quotient.String(), s.DefaultDenom), nil
+}
 
-	var response *blockchainResponse
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return nil, err
+// estimateGas returns gas limit for the transaction, falling back to GasBase per message
+// when there is no estimator or the simulation fails.
+func (s Sentinel) estimateGas(ctx context.Context, signer *Signer, tx unsignedTx, messageType string, messages int, action string) int64 {
+	fallback := s.GasBase * int64(messages+1)
+	if s.Gas == nil {
+		return fallback
 	}
 
-	if response.Success == false {
-		apiError := ""
-		if response.Error != nil {
-			apiError = " (" + response.Error.Message + ")"
+	gas, err := s.Gas.Estimate(messageType, messages, func() (int64, error) {
+		return s.simulate(ctx, signer, tx, action)
+	})
+	if err != nil {
+		if s.Logger != nil {
+			s.Logger.Warnf("failed to simulate %d x %s, using %d gas: %s", messages, messageType, fallback, err)
 		}
 
-		return nil, errors.New("success `false` returned from Sentinel API when fetching subscription with ID" + strconv.FormatInt(subscriptionID, 10) + apiError)
+		return fallback
 	}
 
-	return response.Result, nil
+	return gas
 }
 
-func (s Sentinel) CreateNodeSubscription(nodeAddress string, gigabytes int64, hours int64) (*SentinelSubscription, error) {
-	type blockchainResponse struct {
-		Success bool                 `json:"success"`
-		Error   *SentinelError       `json:"error"`
-		Result  *SentinelTransaction `json:"result"`
-	}
-
-	type blockchainRequest struct {
-		AuthzGranter string `json:"authz_granter"`
-		FeeGranter   string `json:"fee_granter"`
-		Mnemonic     string `json:"mnemonic"`
-		Denom        string `json:"denom"`
-		Gigabytes    int64  `json:"gigabytes,omitempty"`
-		Hours        int64  `json:"hours,omitempty"`
-	}
-
-	payload, err := json.Marshal(blockchainRequest{
-		AuthzGranter: s.ProviderWalletAddress,
-		FeeGranter:   s.ProviderWalletAddress,
-		Mnemonic:     s.NodeSubscriberMnemonic,
-		Denom:        s.DefaultDenom,
-		Gigabytes:    gigabytes,
-		Hours:        hours,
-	})
-
+func (s Sentinel) simulate(ctx context.Context, signer *Signer, tx unsignedTx, action string) (int64, error) {
+	txBytes, err := tx.sign(signer)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
 	args := fmt.Sprintf(
-		"?rpc_address=%s&chain_id=%s&gas_prices=%s&gas=%d&simulate_and_execute=false",
+		"?rpc_address=%s&chain_id=%s",
 		s.RPCEndpoint,
 		s.ChainID,
-		s.GasPrice+s.DefaultDenom,
-		s.GasBase*2,
 	)
 
-	url := s.APIEndpoint + "/api/v1/nodes/" + nodeAddress + "/subscriptions" + args
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(payload))
-	req.Header.Set("Content-Type", "application/json")
-
-	res, err := http.DefaultClient.Do(req)
+	// Simulation doesn't change the chain state, so it's safe to retry
+	var simulation *SentinelSimulation
+	err = s.api().Do(ctx, TransportRequest{
+		Method:     http.MethodPost,
+		URL:        s.APIEndpoint + s.protocol().APIPrefix + "/txs/simulate" + args,
+		Payload:    signedTxRequest{TxBytes: base64.StdEncoding.EncodeToString(txBytes)},
+		Idempotent: true,
+		Source:     sourceAPI,
+		Action:     "when simulating transaction " + action,
+	}, &simulation)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
+	if simulation == nil {
+		return 0, errors.New("no simulation returned from Sentinel API " + action)
 	}
 
-	defer res.Body.Close()
-
-	var response *blockchainResponse
-	err = json.Unmarshal(body, &response)
+	gasUsed, err := simulation.GasUsed.Int64()
 	if err != nil {
-		return nil, err
-	}
-
-	if response.Success == false {
-		apiError := ""
-		if response.Error != nil {
-			apiError = " (" + response.Error.Message + ")"
-		}
-
-		return nil, errors.New("success `false` returned  from Sentinel API during creation of subscription for node " + nodeAddress + apiError)
+		return 0, err
 	}
 
-	for _, event := range response.Result.TxResult.Events {
-		if event.Type == "sentinel.node.v2.EventCreateSubscription" {
-			for _, attribute := range event.Attributes {
-
-				keyBytes, err := base64.StdEncoding.DecodeString(attribute.Key)
-				if err != nil {
-					return nil, err
-				}
-
-				if string(keyBytes) == "id" {
-					valueBytes, err := base64.StdEncoding.DecodeString(attribute.Value)
-					if err != nil {
-						return nil, err
-					}
-
-					value := string(valueBytes)
-					subscriptionID, err := strconv.ParseInt(value[1:len(value)-1], 10, 64)
-					if err != nil {
-						return nil, err
-					}
-
-					return s.FindSubscriptionByID(subscriptionID)
-				}
-			}
-		}
+	if gasUsed <= 0 {
+		return 0, errors.New("no gas used returned from Sentinel API simulation " + action)
 	}
 
-	return nil, errors.New("No subscription ID found in events returned from Sentinel API during creation of subscription for node " + nodeAddress + " (response: " + string(body) + ")")
+	return gasUsed, nil
 }
 
-func (s Sentinel) FetchAllocationsForSubscription(subscriptionID int64) (*SentinelAllocation, error) {
-	type blockchainResponse struct {
-		Success bool                  `json:"success"`
-		Error   *SentinelError        `json:"error"`
-		Result  *[]SentinelAllocation `json:"result"`
-	}
-
+func (s Sentinel) FetchNodes(ctx context.Context, page PageRequest) (*SentinelPage[SentinelNode], error) {
 	args := fmt.Sprintf(
-		"?rpc_address=%s&chain_id=%s",
+		"?rpc_address=%s&chain_id=%s&status=%s%s",
 		s.RPCEndpoint,
 		s.ChainID,
+		"Active",
+		page.args(),
 	)
 
-	url := s.APIEndpoint + "/api/v1/subscriptions/" + strconv.FormatInt(subscriptionID, 10) + "/allocations" + args
-	req, _ := http.NewRequest("GET", url, nil)
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	defer res.Body.Close()
+	return queryPage[SentinelNode](ctx, s, s.APIEndpoint+s.protocol().APIPrefix+"/nodes"+args, "when fetching nodes")
+}
 
-	var response *blockchainResponse
-	err = json.Unmarshal(body, &response)
+func (s Sentinel) FetchNodeStatus(ctx context.Context, node SentinelNode) (*SentinelNodeStatus, error) {
+	var status *SentinelNodeStatus
+	err := s.node().Do(ctx, TransportRequest{
+		Method:     http.MethodGet,
+		URL:        fmt.Sprintf("%s/status", node.RemoteURL),
+		Idempotent: true,
+		Source:     sourceNode,
+		Action:     "when fetching status",
+	}, &status)
 	if err != nil {
 		return nil, err
 	}
 
-	if response.Success == false {
-		apiError := ""
-		if response.Error != nil {
-			apiError = " (" + response.Error.Message + ")"
-		}
-
-		return nil, errors.New("success `false` returned from Sentinel API when fetching allocation for subscription with ID " + strconv.FormatInt(subscriptionID, 10) + apiError)
-	}
-
-	if response.Result == nil {
-		return nil, nil
-	}
-
-	lastIndex := len(*response.Result) - 1
-	return &(*response.Result)[lastIndex], nil
+	return status, nil
 }
 
-func (s Sentinel) CreateCredentials(nodeAddress string, subscriptionID int64, mnemonic string, walletAddress string) (*SentinelCredentials, error) {
-	type blockchainResponse struct {
-		Success bool                 `json:"success"`
-		Error   *SentinelError       `json:"error"`
-		Result  *SentinelCredentials `json:"result"`
-	}
-
-	type blockchainRequest struct {
-		FeeGranter string `json:"fee_granter"`
-		Mnemonic   string `json:"mnemonic"`
-	}
-
-	payload, err := json.Marshal(blockchainRequest{
-		FeeGranter: s.ProviderWalletAddress,
-		Mnemonic:   mnemonic,
-	})
-	if err != nil {
-		return nil, err
-	}
-
+func (s Sentinel) FetchBalance(ctx context.Context, walletAddress string) (int64, error) {
 	args := fmt.Sprintf(
-		"?rpc_address=%s&chain_id=%s&gas_prices=%s&gas=%d&simulate_and_execute=false",
+		"?rpc_address=%s&chain_id=%s",
 		s.RPCEndpoint,
 		s.ChainID,
-		s.GasPrice+s.DefaultDenom,
-		s.GasBase*2,
 	)
 
-	url := s.APIEndpoint + "/api/v1/nodes/" + nodeAddress + "/sessions/" + strconv.FormatInt(subscriptionID, 10) + "/keys" + args
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(payload))
-	req.Header.Set("Content-Type", "application/json")
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	body, err := io.ReadAll(res.Body)
+	var balances *[]SentinelBalance
+	err := s.query(ctx, s.APIEndpoint+s.protocol().APIPrefix+"/accounts/"+walletAddress+"/balances"+args, "when fetching balance for wallet "+walletAddress, &balances)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	defer res.Body.Close()
-
-	var response *blockchainResponse
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return nil, err
+	if balances == nil {
+		return 0, nil
 	}
 
-	if response.Success == false {
-		apiError := ""
-		if response.Error != nil {
-			apiError = fmt.Sprintf(" (code %d, message %s)", response.Error.Code, response.Error.Message)
+	var walletBalance int64
+	for _, balance := range *balances {
+		if balance.Denom == s.DefaultDenom {
+			walletBalance, _ = strconv.ParseInt(balance.Amount, 10, 64)
 		}
-
-		return nil, errors.New("success `false` returned from Sentinel API during creation of credentials for node " + nodeAddress + " using wallet " + walletAddress + apiError)
 	}
 
-	return response.Result, nil
+	return walletBalance, nil
 }
 
-func (s Sentinel) ProxyManualCredentialsRequest(remoteURL string, walletAddress string, sessionID int64, payload []byte) ([]byte, error) {
-	url := fmt.Sprintf("%s/accounts/%s/sessions/%d", remoteURL, walletAddress, sessionID)
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-
-	client := &http.Client{
-		Transport: transport,
-	}
-
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(payload))
-
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	defer res.Body.Close()
+func (s Sentinel) FetchSessions(ctx context.Context, walletAddress string, limit int, offset int) (*[]SentinelSession, error) {
+	args := fmt.Sprintf(
+		"?rpc_address=%s&chain_id=%s&limit=%d&offset=%d",
+		s.RPCEndpoint,
+		s.ChainID,
+		limit,
+		offset,
+	)
 
-	body, err := ioutil.ReadAll(res.Body)
+	var sessions *[]SentinelSession
+	err := s.query(ctx, s.APIEndpoint+s.protocol().APIPrefix+"/accounts/"+walletAddress+"/sessions"+args, "when fetching sessions for wallet "+walletAddress, &sessions)
 	if err != nil {
 		return nil, err
 	}
 
-	return body, nil
+	return sessions, nil
 }
 
-func (s Sentinel) FetchPlanNodes(limit int, offset int) (*[]SentinelNode, error) {
-	type blockchainResponse struct {
-		Success bool            `json:"success"`
-		Error   *SentinelError  `json:"error"`
-		Result  *[]SentinelNode `json:"result"`
-	}
-
+func (s Sentinel) FetchSubscriptions(ctx context.Context, walletAddress string, limit int, offset int) (*[]SentinelSubscription, error) {
 	args := fmt.Sprintf(
 		"?rpc_address=%s&chain_id=%s&limit=%d&offset=%d",
 		s.RPCEndpoint,
@@ -630,488 +445,332 @@ func (s Sentinel) FetchPlanNodes(limit int, offset int) (*[]SentinelNode, error)
 		offset,
 	)
 
-	url := s.APIEndpoint + "/api/v1/plans/" + s.ProviderPlanID + "/nodes" + args
-	req, _ := http.NewRequest("GET", url, nil)
-
-	res, err := http.DefaultClient.Do(req)
+	var subscriptions *[]SentinelSubscription
+	err := s.query(ctx, s.APIEndpoint+s.protocol().APIPrefix+"/accounts/"+walletAddress+"/subscriptions"+args, "when fetching subscriptions for wallet "+walletAddress, &subscriptions)
 	if err != nil {
 		return nil, err
 	}
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
+	return subscriptions, nil
+}
 
-	var response *blockchainResponse
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return nil, err
-	}
+func (s Sentinel) FindSubscriptionForNode(ctx context.Context, walletAddress string, nodeAddress string) (*SentinelSubscription, error) {
+	var fetchInProgress bool
+	var limit int
+	var offset int
 
-	if response.Success == false {
-		apiError := ""
-		if response.Error != nil {
-			apiError = " (" + response.Error.Message + ")"
-		}
+	fetchInProgress = true
+	limit = 1000000
+	offset = 0
 
-		return nil, errors.New("success `false` returned from Sentinel API when fetching nodes for plan" + apiError)
-	}
+	var subscriptions []SentinelSubscription
 
-	return response.Result, nil
-}
+	for fetchInProgress {
+		s, err := s.FetchSubscriptions(ctx, walletAddress, limit, offset)
+		if err != nil {
+			return nil, err
+		}
 
-func (s Sentinel) AddNodeToPlan(nodeAddresses []string) error {
-	type blockchainResponse struct {
-		Success bool                 `json:"success"`
-		Error   *SentinelError       `json:"error"`
-		Result  *SentinelTransaction `json:"result"`
-	}
+		if s == nil {
+			fetchInProgress = false
+		} else {
+			subscriptions = append(subscriptions, *s...)
+		}
 
-	type blockchainRequest struct {
-		AuthzGranter string   `json:"authz_granter"`
-		FeeGranter   string   `json:"fee_granter"`
-		Mnemonic     string   `json:"mnemonic"`
-		NodeAddress  []string `json:"node_addresses"`
+		offset += limit
 	}
 
-	payload, err := json.Marshal(blockchainRequest{
-		AuthzGranter: s.ProviderWalletAddress,
-		FeeGranter:   s.ProviderWalletAddress,
-		Mnemonic:     s.NodeLinkerMnemonic,
-		NodeAddress:  nodeAddresses,
-	})
-
-	if err != nil {
-		return err
+	for _, subscription := range subscriptions {
+		if subscription.NodeAddress == nodeAddress {
+			return &subscription, nil
+		}
 	}
 
-	gas := s.GasBase * int64(len(nodeAddresses)+1)
+	return nil, nil
+
+}
 
+func (s Sentinel) FindSubscriptionByID(ctx context.Context, subscriptionID int64) (*SentinelSubscription, error) {
 	args := fmt.Sprintf(
-		"?rpc_address=%s&chain_id=%s&gas_prices=%s&gas=%d&simulate_and_execute=false",
+		"?rpc_address=%s&chain_id=%s",
 		s.RPCEndpoint,
 		s.ChainID,
-		s.GasPrice+s.DefaultDenom,
-		gas,
 	)
 
-	url := s.APIEndpoint + "/api/v1/plans/" + s.ProviderPlanID + "/nodes" + args
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(payload))
-	req.Header.Set("Content-Type", "application/json")
-
-	res, err := http.DefaultClient.Do(req)
+	var subscription *SentinelSubscription
+	err := s.query(ctx, s.APIEndpoint+s.protocol().APIPrefix+"/subscriptions/"+strconv.FormatInt(subscriptionID, 10)+args, "when fetching subscription with ID "+strconv.FormatInt(subscriptionID, 10), &subscription)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return err
-	}
+	return subscription, nil
+}
 
-	defer res.Body.Close()
+func (s Sentinel) CreateNodeSubscription(ctx context.Context, nodeAddress string, gigabytes int64, hours int64) (*SentinelSubscription, error) {
+	p := s.protocol()
+	message := p.msgNodeSubscribe(s.ProviderWalletAddress, nodeAddress, gigabytes, hours, s.DefaultDenom)
 
-	var response *blockchainResponse
-	err = json.Unmarshal(body, &response)
+	receipt, err := s.exec(ctx, s.NodeSubscriberMnemonic, []anyMessage{message}, p.MessageNodeSubscribe, "during creation of subscription for node "+nodeAddress)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if response.Success == false {
-		apiError := ""
-		if response.Error != nil {
-			apiError = " (" + response.Error.Message + ")"
-		}
-
-		return errors.New("success `false` returned from Sentinel API while adding nodes to plan " + s.ProviderPlanID + apiError)
+	if len(receipt.Events.SubscriptionsCreated) == 0 {
+		return nil, errors.New("No subscription ID found in events returned from Sentinel API during creation of subscription for node " + nodeAddress + " (tx " + receipt.TxHash + ")")
 	}
 
-	return nil
+	return s.FindSubscriptionByID(ctx, receipt.Events.SubscriptionsCreated[0].ID)
 }
 
-func (s Sentinel) RemoveNodeFromPlan(nodeAddress string) error {
-	type blockchainResponse struct {
-		Success bool                 `json:"success"`
-		Error   *SentinelError       `json:"error"`
-		Result  *SentinelTransaction `json:"result"`
-	}
-
-	type blockchainRequest struct {
-		AuthzGranter string `json:"authz_granter"`
-		FeeGranter   string `json:"fee_granter"`
-		Mnemonic     string `json:"mnemonic"`
-	}
-
-	payload, err := json.Marshal(blockchainRequest{
-		AuthzGranter: s.ProviderWalletAddress,
-		FeeGranter:   s.ProviderWalletAddress,
-		Mnemonic:     s.NodeRemoverMnemonic,
-	})
-
-	if err != nil {
-		return err
-	}
-
+func (s Sentinel) FetchAllocationsForSubscription(ctx context.Context, subscriptionID int64) (*SentinelAllocation, error) {
 	args := fmt.Sprintf(
-		"?rpc_address=%s&chain_id=%s&gas_prices=%s&gas=%d&simulate_and_execute=false",
+		"?rpc_address=%s&chain_id=%s",
 		s.RPCEndpoint,
 		s.ChainID,
-		s.GasPrice+s.DefaultDenom,
-		s.GasBase*2,
 	)
 
-	url := s.APIEndpoint + "/api/v1/plans/" + s.ProviderPlanID + "/nodes/" + nodeAddress + args
-	req, _ := http.NewRequest("PUT", url, bytes.NewBuffer(payload))
-	req.Header.Set("Content-Type", "application/json")
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return err
-	}
-
-	defer res.Body.Close()
-
-	var response *blockchainResponse
-	err = json.Unmarshal(body, &response)
+	var allocations *[]SentinelAllocation
+	err := s.query(ctx, s.APIEndpoint+s.protocol().APIPrefix+"/subscriptions/"+strconv.FormatInt(subscriptionID, 10)+"/allocations"+args, "when fetching allocation for subscription with ID "+strconv.FormatInt(subscriptionID, 10), &allocations)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if response.Success == false {
-		apiError := ""
-		if response.Error != nil {
-			apiError = " (" + response.Error.Message + ")"
-		}
-
-		return errors.New("success `false` returned from Sentinel API while removing node  " + nodeAddress + " from plan " + s.ProviderPlanID + apiError)
+	if allocations == nil || len(*allocations) == 0 {
+		return nil, nil
 	}
 
-	return nil
+	lastIndex := len(*allocations) - 1
+	return &(*allocations)[lastIndex], nil
 }
 
-func (s Sentinel) FetchFeeGrantAllowances(limit int, offset int) (*[]SentinelAllowance, error) {
-	type blockchainResponse struct {
-		Success bool                 `json:"success"`
-		Error   *SentinelError       `json:"error"`
-		Result  *[]SentinelAllowance `json:"result"`
-	}
-
+// FetchAllocationForWallet returns bytes granted to and utilised by a wallet enrolled to the subscription,
+// or nil when the wallet has no allocation in it.
+func (s Sentinel) FetchAllocationForWallet(ctx context.Context, subscriptionID int64, walletAddress string) (*SentinelAllocation, error) {
 	args := fmt.Sprintf(
-		"?rpc_address=%s&chain_id=%s&limit=%d&offset=%d",
+		"?rpc_address=%s&chain_id=%s",
 		s.RPCEndpoint,
 		s.ChainID,
-		limit,
-		offset,
 	)
 
-	url := s.APIEndpoint + "/api/v1/feegrants/" + s.ProviderWalletAddress + args
-	req, _ := http.NewRequest("GET", url, nil)
-
-	res, err := http.DefaultClient.Do(req)
+	var allocation *SentinelAllocation
+	err := s.query(ctx, s.APIEndpoint+s.protocol().APIPrefix+"/subscriptions/"+strconv.FormatInt(subscriptionID, 10)+"/allocations/"+walletAddress+args, "when fetching allocation of wallet "+walletAddress+" for subscription with ID "+strconv.FormatInt(subscriptionID, 10), &allocation)
 	if err != nil {
 		return nil, err
 	}
 
-	body, err := io.ReadAll(res.Body)
+	return allocation, nil
+}
+
+func (s Sentinel) CreateCredentials(ctx context.Context, request SentinelCredentialsRequest) (*SentinelCredentials, error) {
+	signer := request.Signer
+	action := "during creation of credentials for node " + request.NodeAddress + " using wallet " + signer.Address
+
+	key, err := newSessionKey(request.NodeType)
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
 
-	var response *blockchainResponse
-	err = json.Unmarshal(body, &response)
+	sessionID, err := s.startSession(ctx, request, action)
 	if err != nil {
 		return nil, err
 	}
 
-	if response.Success == false {
-		apiError := ""
-		if response.Error != nil {
-			apiError = " (" + response.Error.Message + ")"
-		}
-
-		return nil, errors.New("success `false` returned from Sentinel API when fetching fee grant allowances: " + apiError)
-	}
-
-	return response.Result, nil
-}
-
-func (s Sentinel) GrantFeeToWallet(walletAddresses []string) error {
-	type blockchainResponse struct {
-		Success bool                 `json:"success"`
-		Error   *SentinelError       `json:"error"`
-		Result  *SentinelTransaction `json:"result"`
-	}
-
-	type blockchainRequest struct {
-		AuthzGranter string   `json:"authz_granter"`
-		FeeGranter   string   `json:"fee_granter"`
-		Mnemonic     string   `json:"mnemonic"`
-		AccAddresses []string `json:"acc_addresses"`
-	}
-
-	payload, err := json.Marshal(blockchainRequest{
-		AuthzGranter: s.ProviderWalletAddress,
-		FeeGranter:   s.ProviderWalletAddress,
-		Mnemonic:     s.FeeGranterMnemonic,
-		AccAddresses: walletAddresses,
-	})
-
+	payload, err := newKeyExchangeRequest(signer, uint64(sessionID), key.Key)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	gas := s.GasBase * int64(len(walletAddresses)+1)
-
-	args := fmt.Sprintf(
-		"?rpc_address=%s&chain_id=%s&gas_prices=%s&gas=%d&simulate_and_execute=false",
-		s.RPCEndpoint,
-		s.ChainID,
-		s.GasPrice+s.DefaultDenom,
-		gas,
-	)
-
-	url := s.APIEndpoint + "/api/v1/feegrants" + args
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(payload))
-	req.Header.Set("Content-Type", "application/json")
-
-	res, err := http.DefaultClient.Do(req)
+	var result string
+	err = s.node().Do(ctx, TransportRequest{
+		Method:  http.MethodPost,
+		URL:     fmt.Sprintf("%s/accounts/%s/sessions/%d", request.RemoteURL, signer.Address, sessionID),
+		Payload: payload,
+		Source:  sourceNode,
+		Action:  "during key exchange for session " + strconv.FormatInt(sessionID, 10),
+	}, &result)
 	if err != nil {
-		return err
+		return nil, nodeError(ctx, err)
 	}
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return err
-	}
+	return &SentinelCredentials{
+		Uid:        key.Uid,
+		PrivateKey: key.PrivateKey,
+		Result:     result,
+	}, nil
+}
+
+// StartSession starts a session on the node without the key exchange, which is left to the caller
+func (s Sentinel) StartSession(ctx context.Context, request SentinelCredentialsRequest) (int64, error) {
+	return s.startSession(ctx, request, "while starting session on node "+request.NodeAddress+" using wallet "+request.Signer.Address)
+}
 
-	defer res.Body.Close()
+func (s Sentinel) startSession(ctx context.Context, request SentinelCredentialsRequest, action string) (int64, error) {
+	p := s.protocol()
+	signer := request.Signer
 
-	var response *blockchainResponse
-	err = json.Unmarshal(body, &response)
+	message := p.msgStartSession(signer.Address, uint64(request.SubscriptionID), request.NodeAddress)
+	receipt, err := s.execute(ctx, signer, []anyMessage{message}, p.MessageStartSession, 1, action)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	if response.Success == false {
-		apiError := ""
-		if response.Error != nil {
-			apiError = " (" + response.Error.Message + ")"
-		}
-
-		return errors.New("success `false` returned from Sentinel API while granting fee to wallets" + apiError)
+	if len(receipt.Events.SessionsStarted) == 0 {
+		return 0, errors.New("No session ID found in events returned from Sentinel API " + action + " (tx " + receipt.TxHash + ")")
 	}
 
-	return nil
+	return receipt.Events.SessionsStarted[0].ID, nil
 }
 
-func (s Sentinel) EnrollWalletToSubscription(walletAddresses []string, subscriptionID int64) error {
-	type blockchainResponse struct {
-		Success bool                 `json:"success"`
-		Error   *SentinelError       `json:"error"`
-		Result  *SentinelTransaction `json:"result"`
-	}
-
-	type blockchainRequest struct {
-		FeeGranter   string   `json:"fee_granter"`
-		Mnemonic     string   `json:"mnemonic"`
-		AccAddresses []string `json:"acc_addresses"`
-		Bytes        []int64  `json:"bytes"`
-	}
+// EndSession ends a session started by the device wallet, so the node stops serving it right away
+func (s Sentinel) EndSession(ctx context.Context, signer *Signer, sessionID int64) (*SentinelReceipt, error) {
+	p := s.protocol()
+	message := p.msgEndSession(signer.Address, uint64(sessionID))
 
-	var bytesArr []int64 = make([]int64, len(walletAddresses))
-	for i := 0; i < len(walletAddresses); i++ {
-		bytesArr[i] = 100000000000000
-	}
+	return s.execute(ctx, signer, []anyMessage{message}, p.MessageEndSession, 1, "while ending session "+strconv.FormatInt(sessionID, 10)+" of wallet "+signer.Address)
+}
 
-	payload, err := json.Marshal(blockchainRequest{
-		FeeGranter:   s.ProviderWalletAddress,
-		Mnemonic:     s.MainSubscriberMnemonic,
-		AccAddresses: walletAddresses,
-		Bytes:        bytesArr,
+func (s Sentinel) ProxyManualCredentialsRequest(ctx context.Context, remoteURL string, walletAddress string, sessionID int64, payload []byte) ([]byte, error) {
+	response, err := s.node().DoRaw(ctx, TransportRequest{
+		Method: http.MethodPost,
+		URL:    fmt.Sprintf("%s/accounts/%s/sessions/%d", remoteURL, walletAddress, sessionID),
+		Body:   payload,
+		Source: sourceNode,
+		Action: "during key exchange for session " + strconv.FormatInt(sessionID, 10),
 	})
-
 	if err != nil {
-		return err
+		return nil, nodeError(ctx, err)
 	}
 
-	gas := s.GasBase * int64(len(walletAddresses)+1)
+	return response, nil
+}
 
+func (s Sentinel) FetchPlanNodes(ctx context.Context, page PageRequest) (*SentinelPage[SentinelNode], error) {
 	args := fmt.Sprintf(
-		"?rpc_address=%s&chain_id=%s&gas_prices=%s&gas=%d&simulate_and_execute=false",
+		"?rpc_address=%s&chain_id=%s%s",
 		s.RPCEndpoint,
 		s.ChainID,
-		s.GasPrice+s.DefaultDenom,
-		gas,
+		page.args(),
 	)
 
-	url := s.APIEndpoint + "/api/v1/subscriptions/" + strconv.FormatInt(subscriptionID, 10) + "/allocations" + args
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(payload))
-	req.Header.Set("Content-Type", "application/json")
+	return queryPage[SentinelNode](ctx, s, s.APIEndpoint+s.protocol().APIPrefix+"/plans/"+s.ProviderPlanID+"/nodes"+args, "when fetching nodes for plan")
+}
 
-	res, err := http.DefaultClient.Do(req)
+func (s Sentinel) AddNodeToPlan(ctx context.Context, nodeAddresses []string) (*SentinelReceipt, error) {
+	p := s.protocol()
+	planID, err := strconv.ParseUint(s.ProviderPlanID, 10, 64)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return err
+	var messages []anyMessage
+	for _, nodeAddress := range nodeAddresses {
+		messages = append(messages, p.msgLinkNode(s.ProviderWalletAddress, planID, nodeAddress))
 	}
 
-	defer res.Body.Close()
+	return s.exec(ctx, s.NodeLinkerMnemonic, messages, p.MessageLinkNode, "while adding nodes to plan "+s.ProviderPlanID)
+}
 
-	var response *blockchainResponse
-	err = json.Unmarshal(body, &response)
+func (s Sentinel) RemoveNodeFromPlan(ctx context.Context, nodeAddress string) (*SentinelReceipt, error) {
+	p := s.protocol()
+	planID, err := strconv.ParseUint(s.ProviderPlanID, 10, 64)
 	if err != nil {
-		return err
-	}
-
-	if response.Success == false {
-		apiError := ""
-		if response.Error != nil {
-			apiError = " (" + response.Error.Message + ")"
-		}
-
-		return errors.New("success `false` returned from Sentinel API while adding wallets to subscription" + apiError)
+		return nil, err
 	}
 
-	return nil
+	message := p.msgUnlinkNode(s.ProviderWalletAddress, planID, nodeAddress)
+	return s.exec(ctx, s.NodeRemoverMnemonic, []anyMessage{message}, p.MessageUnlinkNode, "while removing node "+nodeAddress+" from plan "+s.ProviderPlanID)
 }
 
-func (s Sentinel) CreatePlanSubscription() (*SentinelSubscription, error) {
-	type blockchainResponse struct {
-		Success bool                 `json:"success"`
-		Error   *SentinelError       `json:"error"`
-		Result  *SentinelTransaction `json:"result"`
-	}
+func (s Sentinel) FetchFeeGrantAllowances(ctx context.Context, page PageRequest) (*SentinelPage[SentinelAllowance], error) {
+	args := fmt.Sprintf(
+		"?rpc_address=%s&chain_id=%s%s",
+		s.RPCEndpoint,
+		s.ChainID,
+		page.args(),
+	)
 
-	type blockchainRequest struct {
-		AuthzGranter string `json:"authz_granter"`
-		FeeGranter   string `json:"fee_granter"`
-		Mnemonic     string `json:"mnemonic"`
-		Denom        string `json:"denom"`
-	}
+	return queryPage[SentinelAllowance](ctx, s, s.APIEndpoint+s.protocol().APIPrefix+"/feegrants/"+s.ProviderWalletAddress+args, "when fetching fee grant allowances")
+}
 
-	payload, err := json.Marshal(blockchainRequest{
-		AuthzGranter: s.MainSubscriberWalletAddress,
-		FeeGranter:   s.ProviderWalletAddress,
-		Mnemonic:     s.SubscriptionUpdaterMnemonic,
-		Denom:        s.DefaultDenom,
-	})
+func (s Sentinel) GrantFeeToWallet(ctx context.Context, walletAddresses []string) (*SentinelReceipt, error) {
+	p := s.protocol()
 
-	if err != nil {
-		return nil, err
+	var messages []anyMessage
+	for _, walletAddress := range walletAddresses {
+		messages = append(messages, p.msgGrantAllowance(s.ProviderWalletAddress, walletAddress))
 	}
 
-	args := fmt.Sprintf(
-		"?rpc_address=%s&chain_id=%s&gas_prices=%s&gas=%d&simulate_and_execute=false",
-		s.RPCEndpoint,
-		s.ChainID,
-		s.GasPrice+s.DefaultDenom,
-		s.GasBase*2,
-	)
+	return s.exec(ctx, s.FeeGranterMnemonic, messages, p.MessageGrantAllowance, "while granting fee to wallets")
+}
 
-	url := s.APIEndpoint + "/api/v1/plans/" + s.ProviderPlanID + "/subscriptions" + args
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(payload))
-	req.Header.Set("Content-Type", "application/json")
+func (s Sentinel) EnrollWalletToSubscription(ctx context.Context, allocations []SentinelWalletAllocation, subscriptionID int64) (*SentinelReceipt, error) {
+	p := s.protocol()
 
-	res, err := http.DefaultClient.Do(req)
+	signer, err := signerForMnemonic(s.MainSubscriberMnemonic)
 	if err != nil {
 		return nil, err
 	}
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
+	var messages []anyMessage
+	for _, allocation := range allocations {
+		messages = append(messages, p.msgAllocate(signer.Address, uint64(subscriptionID), allocation.Address, allocation.Bytes))
 	}
 
-	defer res.Body.Close()
+	return s.execute(ctx, signer, messages, p.MessageAllocate, len(messages), "while adding wallets to subscription")
+}
 
-	var response *blockchainResponse
-	err = json.Unmarshal(body, &response)
+func (s Sentinel) CreatePlanSubscription(ctx context.Context) (*SentinelSubscription, error) {
+	p := s.protocol()
+	planID, err := strconv.ParseUint(s.ProviderPlanID, 10, 64)
 	if err != nil {
 		return nil, err
 	}
 
-	if response.Success == false {
-		apiError := ""
-		if response.Error != nil {
-			apiError = " (" + response.Error.Message + ")"
-		}
-
-		return nil, errors.New("success `false` returned  from Sentinel API during creation of subscription for plan " + s.ProviderPlanID + apiError)
+	message := p.msgPlanSubscribe(s.MainSubscriberWalletAddress, planID, s.DefaultDenom)
+	receipt, err := s.exec(ctx, s.SubscriptionUpdaterMnemonic, []anyMessage{message}, p.MessagePlanSubscribe, "during creation of subscription for plan "+s.ProviderPlanID)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, event := range response.Result.TxResult.Events {
-		if event.Type == "sentinel.plan.v2.EventCreateSubscription" {
-			for _, attribute := range event.Attributes {
-
-				keyBytes, err := base64.StdEncoding.DecodeString(attribute.Key)
-				if err != nil {
-					return nil, err
-				}
-
-				if string(keyBytes) == "id" {
-					valueBytes, err := base64.StdEncoding.DecodeString(attribute.Value)
-					if err != nil {
-						return nil, err
-					}
-
-					value := string(valueBytes)
-					subscriptionID, err := strconv.ParseInt(value[1:len(value)-1], 10, 64)
-					if err != nil {
-						return nil, err
-					}
-
-					return s.FindSubscriptionByID(subscriptionID)
-				}
-			}
-		}
+	if len(receipt.Events.SubscriptionsCreated) == 0 {
+		return nil, errors.New("No subscription ID found in events returned from Sentinel API during creation of subscription for plan " + s.ProviderPlanID + " (tx " + receipt.TxHash + ")")
 	}
 
-	return nil, errors.New("No subscription ID found in events returned from Sentinel API during creation of subscription for plan " + s.ProviderPlanID + " (response: " + string(body) + ")")
+	return s.FindSubscriptionByID(ctx, receipt.Events.SubscriptionsCreated[0].ID)
 }
 
-func (s Sentinel) FetchHealthChecks() (*[]SentinelHealthCheck, error) {
-	type blockchainResponse struct {
-		Success bool                   `json:"success"`
-		Result  *[]SentinelHealthCheck `json:"result"`
-	}
-
-	req, _ := http.NewRequest("GET", "https://api.health.sentinel.co/v1/records/", nil)
+func (s Sentinel) FetchTransaction(ctx context.Context, txHash string) (*SentinelTransaction, error) {
+	args := fmt.Sprintf(
+		"?rpc_address=%s&chain_id=%s",
+		s.RPCEndpoint,
+		s.ChainID,
+	)
 
-	res, err := http.DefaultClient.Do(req)
+	var transaction *SentinelTransaction
+	err := s.query(ctx, s.APIEndpoint+s.protocol().APIPrefix+"/txs/"+txHash+args, "when fetching transaction "+txHash, &transaction)
 	if err != nil {
 		return nil, err
 	}
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
+	return transaction, nil
+}
 
-	var response *blockchainResponse
-	err = json.Unmarshal(body, &response)
+func (s Sentinel) FetchHealthChecks(ctx context.Context) (*[]SentinelHealthCheck, error) {
+	var checks *[]SentinelHealthCheck
+	err := s.health().Do(ctx, TransportRequest{
+		Method:     http.MethodGet,
+		URL:        "https://api.health.sentinel.co/v1/records/",
+		Idempotent: true,
+		Source:     sourceHealth,
+		Action:     "when fetching checks",
+	}, &checks)
 	if err != nil {
 		return nil, err
 	}
 
-	if response.Success == false {
-		return nil, errors.New("success `false` returned from Health API when fetching checks")
-	}
-
-	if response.Result == nil {
+	if checks == nil {
 		return nil, errors.New("`nil` returned from Health API when fetching checks")
 	}
 
-	return response.Result, nil
+	return checks, nil
 }
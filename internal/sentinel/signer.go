@@ -0,0 +1,142 @@
+package sentinel
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+
+	bech32 "github.com/cosmos/btcutil/bech32"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	bip32 "github.com/tyler-smith/go-bip32"
+	bip39 "github.com/tyler-smith/go-bip39"
+	"golang.org/x/crypto/ripemd160"
+)
+
+const (
+	WalletAddressPrefix = "sent"
+	WalletHDPath        = "m/44'/118'/0'/0/0"
+)
+
+// Signer holds a secp256k1 key derived from a wallet mnemonic and signs transactions and
+// key exchange requests in-process, so mnemonics never leave the API.
+type Signer struct {
+	Address string
+
+	privateKey *secp256k1.PrivateKey
+	publicKey  []byte
+
+	// Transactions of a wallet are serialized, as each of them consumes the account sequence
+	mu sync.Mutex
+}
+
+func NewSignerFromEntropy(entropy []byte) (*Signer, error) {
+	mnemonic, err := bip39.NewMnemonic(entropy)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSignerFromMnemonic(mnemonic)
+}
+
+func NewSignerFromMnemonic(mnemonic string) (*Signer, error) {
+	if mnemonic == "" {
+		return nil, errors.New("no mnemonic to derive wallet key from")
+	}
+
+	seed := bip39.NewSeed(mnemonic, "")
+	masterKey, err := bip32.NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	key := masterKey
+	for _, index := range []uint32{44 + bip32.FirstHardenedChild, 118 + bip32.FirstHardenedChild, bip32.FirstHardenedChild, 0, 0} {
+		key, err = key.NewChildKey(index)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive %s: %w", WalletHDPath, err)
+		}
+	}
+
+	privateKey := secp256k1.PrivKeyFromBytes(key.Key)
+	publicKey := privateKey.PubKey().SerializeCompressed()
+	address, err := WalletAddress(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Signer{
+		Address:    address,
+		privateKey: privateKey,
+		publicKey:  publicKey,
+	}, nil
+}
+
+// WalletAddress returns bech32 address of the compressed secp256k1 public key
+func WalletAddress(publicKey []byte) (string, error) {
+	sha256hash := sha256.Sum256(publicKey)
+	hash := ripemd160.New()
+	hash.Write(sha256hash[:])
+
+	converted, err := bech32.ConvertBits(hash.Sum(nil), 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	return bech32.Encode(WalletAddressPrefix, converted)
+}
+
+// PublicKey returns the compressed public key
+func (s *Signer) PublicKey() []byte {
+	return s.publicKey
+}
+
+// Sign returns 64 bytes `r || s` secp256k1 signature of SHA-256 of message, with deterministic
+// nonce (RFC 6979) and low S, as Cosmos SDK expects.
+func (s *Signer) Sign(message []byte) ([]byte, error) {
+	hash := sha256.Sum256(message)
+	signature := ecdsa.Sign(s.privateKey, hash[:])
+
+	r, sig := signature.R(), signature.S()
+	rBytes, sBytes := r.Bytes(), sig.Bytes()
+
+	return append(rBytes[:], sBytes[:]...), nil
+}
+
+var (
+	signersMu sync.Mutex
+	signers   = make(map[[32]byte]*Signer)
+)
+
+// signerForMnemonic returns the same Signer for the same mnemonic, so transactions of a role
+// wallet sent from concurrent requests and jobs are serialized.
+func signerForMnemonic(mnemonic string) (*Signer, error) {
+	key := sha256.Sum256([]byte(mnemonic))
+
+	signersMu.Lock()
+	defer signersMu.Unlock()
+
+	if signer, ok := signers[key]; ok {
+		return signer, nil
+	}
+
+	signer, err := NewSignerFromMnemonic(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+
+	signers[key] = signer
+	return signer, nil
+}
+
+// SignerForEntropy returns the same Signer for the same wallet entropy. Transactions of a device wallet
+// sent from concurrent requests are serialized, otherwise they would be signed with the same account sequence.
+func SignerForEntropy(entropy []byte) (*Signer, error) {
+	mnemonic, err := bip39.NewMnemonic(entropy)
+	if err != nil {
+		return nil, err
+	}
+
+	return signerForMnemonic(mnemonic)
+}
@@ -0,0 +1,180 @@
+package sentinel
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+// Expected keys, addresses and signatures are produced by Cosmos SDK v0.47 (`hd.Secp256k1` with
+// m/44'/118'/0'/0/0, `secp256k1.PrivKey.Sign` and bech32 with `sent` prefix).
+func TestSignerMatchesCosmosSDK(t *testing.T) {
+	entropy := make([]byte, 32)
+	for i := range entropy {
+		entropy[i] = byte(i + 1)
+	}
+
+	tests := []struct {
+		name       string
+		signer     func() (*Signer, error)
+		address    string
+		publicKey  string
+		signatures map[string]string
+	}{
+		{
+			name:      "mnemonic",
+			signer:    func() (*Signer, error) { return NewSignerFromMnemonic(testMnemonic) },
+			address:   "sent19rl4cm2hmr8afy4kldpxz3fka4jguq0a8mmym6",
+			publicKey: "024f4e2ad99c34d60b9ba6283c9431a8418af8673212961f97a77b6377fcd05b62",
+			signatures: map[string]string{
+				"":         "a12625612110e6b4f71030b7e1e15cfe1dc51afca01e942fc79aa6126622183d3ee76b75460f6b23939ae70fbc4314d8c103a4f7ad6b9619f94b91732c114d24",
+				"hello":    "0d9965f88eb873e79314a550982dfa66444026c028d942ac46209a9d8b3bd4227721ae7a0abf018ba71baf74160c6f8bc80ea5e3e89df29e8bc66f1a712a8957",
+				"sentinel": "618b791c8a10d8c058fa00c22316e9ea738a66c202f6807c51077937eec6608e3fd9832b6bc6dd1d7f92e7684fbff5de58757cb8e8b7eab950c3f987c75e26ef",
+			},
+		},
+		{
+			name:      "entropy",
+			signer:    func() (*Signer, error) { return NewSignerFromEntropy(entropy) },
+			address:   "sent15d7vrdy80j2mjk7xk58t2qyennmuv75ztzvlje",
+			publicKey: "03a2b53145544913e5214bd82f4f6353177f8dc84a252624174101e446e19aa7b5",
+			signatures: map[string]string{
+				"":         "d5aa75f016eef9ead711c77cbfe643bb4436c7ebf29ed072c18142b93eae79a55d788cbd15cd09e6b8a7c2efc08187a343a13db06de378eff4f71a4c7779d051",
+				"hello":    "018ea6e932ddc77da5e2af3f735cebfe04d9147ab51d57bd1e4793b8a35fa9497ca0b6c5dedcd312da75a8e2ce423049a854c125a57396da1c8c47f6d9772703",
+				"sentinel": "4a12c20543156de0167650b64c02a3b7a3220068a58d198f4e8d24260f87e21d3764710335b0f296179adc8d5f3a9cbf83ae6e1081ffed1e639c11e7bbfdb0ba",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := tt.signer()
+			if err != nil {
+				t.Fatalf("failed to create signer: %s", err)
+			}
+
+			if signer.Address != tt.address {
+				t.Errorf("address = %s, want %s", signer.Address, tt.address)
+			}
+
+			if got := hex.EncodeToString(signer.PublicKey()); got != tt.publicKey {
+				t.Errorf("public key = %s, want %s", got, tt.publicKey)
+			}
+
+			for message, want := range tt.signatures {
+				signature, err := signer.Sign([]byte(message))
+				if err != nil {
+					t.Fatalf("failed to sign %q: %s", message, err)
+				}
+
+				if got := hex.EncodeToString(signature); got != want {
+					t.Errorf("signature of %q = %s, want %s", message, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSignIsLowS(t *testing.T) {
+	signer, err := NewSignerFromMnemonic(testMnemonic)
+	if err != nil {
+		t.Fatalf("failed to create signer: %s", err)
+	}
+
+	halfN := new(big.Int).Rsh(secp256k1.S256().N, 1)
+	for i := 0; i < 256; i++ {
+		message := []byte{byte(i), byte(i >> 8), 0x42}
+
+		signature, err := signer.Sign(message)
+		if err != nil {
+			t.Fatalf("failed to sign message %d: %s", i, err)
+		}
+
+		if len(signature) != 64 {
+			t.Fatalf("signature of message %d is %d bytes long, want 64", i, len(signature))
+		}
+
+		if s := new(big.Int).SetBytes(signature[32:]); s.Cmp(halfN) > 0 {
+			t.Errorf("signature of message %d has high S %x", i, s)
+		}
+
+		again, _ := signer.Sign(message)
+		if !bytes.Equal(signature, again) {
+			t.Errorf("signature of message %d is not deterministic", i)
+		}
+	}
+}
+
+func TestNewSignerFromMnemonicRejectsEmptyMnemonic(t *testing.T) {
+	_, err := NewSignerFromMnemonic("")
+	if err == nil {
+		t.Fatal("expected an error for an empty mnemonic")
+	}
+}
+
+func TestSignerForEntropySerializesTransactions(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		broadcasts int
+		sequences  []int
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case strings.Contains(r.URL.Path, "/accounts/"):
+			sequences = append(sequences, broadcasts)
+			fmt.Fprintf(w, `{"success":true,"result":{"account_number":"7","sequence":"%d"}}`, broadcasts)
+		case strings.HasSuffix(r.URL.Path, "/txs"):
+			// The account sequence is only consumed after a while, like a transaction being included in a block
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			broadcasts++
+			fmt.Fprintf(w, `{"success":true,"result":{"height":1,"txhash":"TX%d","tx_result":{"code":0}}}`, broadcasts)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	s := Sentinel{APIEndpoint: server.URL, ChainID: "sentinelhub-2", GasPrice: "0.1", GasBase: 100000, DefaultDenom: "udvpn", Transport: testTransport(0)}
+	entropy := bytes.Repeat([]byte{7}, 32)
+
+	// Every request derives the signer of the device on its own
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(sessionID int64) {
+			defer wg.Done()
+
+			signer, err := SignerForEntropy(entropy)
+			if err != nil {
+				t.Errorf("failed to derive signer: %s", err)
+				return
+			}
+
+			if _, err := s.EndSession(context.Background(), signer, sessionID); err != nil {
+				t.Errorf("failed to end session %d: %s", sessionID, err)
+			}
+		}(int64(i + 1))
+	}
+	wg.Wait()
+
+	if len(sequences) != 2 || sequences[0] == sequences[1] {
+		t.Errorf("transactions were signed with sequences %v, want distinct ones", sequences)
+	}
+}
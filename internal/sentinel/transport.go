@@ -0,0 +1,283 @@
+package sentinel
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+type TransportConfig struct {
+	Timeout     time.Duration
+	MaxRetries  int
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	InsecureSkipVerify bool
+}
+
+// Transport performs every HTTP call made by Sentinel. It applies a deadline to each attempt,
+// retries idempotent queries with jittered exponential backoff and, when Breaker is set,
+// fails fast while the remote side is down.
+type Transport struct {
+	Client      *http.Client
+	Timeout     time.Duration
+	MaxRetries  int
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	Breaker     *CircuitBreaker
+}
+
+type TransportRequest struct {
+	Method string
+	URL    string
+
+	// Payload is encoded as JSON, Body is sent as is.
+	Payload any
+	Body    []byte
+
+	// Idempotent requests are retried on network failures and 5xx responses.
+	Idempotent bool
+
+	// Source and Action describe the request in errors, e.g. "Sentinel API" and "when fetching nodes".
+	Source string
+	Action string
+}
+
+func NewTransport(config TransportConfig) *Transport {
+	client := &http.Client{}
+	if config.InsecureSkipVerify {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	transport := &Transport{
+		Client:      client,
+		Timeout:     config.Timeout,
+		MaxRetries:  config.MaxRetries,
+		BackoffBase: config.BackoffBase,
+		BackoffMax:  config.BackoffMax,
+	}
+
+	if config.BreakerThreshold > 0 {
+		transport.Breaker = &CircuitBreaker{
+			FailureThreshold: config.BreakerThreshold,
+			Cooldown:         config.BreakerCooldown,
+		}
+	}
+
+	return transport
+}
+
+// DefaultTransport is used for Sentinel API when Sentinel is created without a Transport.
+func DefaultTransport() *Transport {
+	return NewTransport(TransportConfig{
+		Timeout:          30 * time.Second,
+		MaxRetries:       2,
+		BackoffBase:      250 * time.Millisecond,
+		BackoffMax:       5 * time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+	})
+}
+
+// DefaultNodeTransport is used for requests sent directly to Sentinel dVPN nodes. Nodes commonly
+// use self-signed certificates and one unreachable node must not block calls to the others,
+// so there is no circuit breaker here.
+func DefaultNodeTransport() *Transport {
+	return NewTransport(TransportConfig{
+		Timeout:            4 * time.Second,
+		InsecureSkipVerify: true,
+	})
+}
+
+// Do performs the request and decodes `result` of the standard `{success, error, result}`
+// response envelope into result.
+func (t *Transport) Do(ctx context.Context, r TransportRequest, result any) error {
+	_, err := t.DoPaginated(ctx, r, result)
+	return err
+}
+
+// DoPaginated is Do for list endpoints, it also returns `pagination` of the response envelope if there is one.
+func (t *Transport) DoPaginated(ctx context.Context, r TransportRequest, result any) (*SentinelPagination, error) {
+	body, err := t.DoRaw(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Success    bool                `json:"success"`
+		Error      *SentinelError      `json:"error"`
+		Result     json.RawMessage     `json:"result"`
+		Pagination *SentinelPagination `json:"pagination"`
+	}
+
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, errors.New("failed to unmarshal JSON from " + r.Source + " response — " + err.Error() + " (" + string(body) + ")")
+	}
+
+	if response.Success == false {
+		apiError := &APIError{Source: r.Source, Action: r.Action}
+		if response.Error != nil {
+			apiError.Code = response.Error.Code
+			apiError.Message = response.Error.Message
+		}
+
+		return nil, apiError
+	}
+
+	if result == nil || len(response.Result) == 0 {
+		return response.Pagination, nil
+	}
+
+	err = json.Unmarshal(response.Result, result)
+	if err != nil {
+		return nil, errors.New("failed to unmarshal result from " + r.Source + " response — " + err.Error())
+	}
+
+	return response.Pagination, nil
+}
+
+// DoRaw performs the request and returns the response body as is.
+func (t *Transport) DoRaw(ctx context.Context, r TransportRequest) ([]byte, error) {
+	payload := r.Body
+	if r.Payload != nil {
+		var err error
+		payload, err = json.Marshal(r.Payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	attempts := 1
+	if r.Idempotent {
+		attempts += t.MaxRetries
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			err := t.wait(ctx, attempt)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if t.Breaker != nil {
+			err := t.Breaker.Allow()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		body, retryable, err := t.attempt(ctx, r, payload)
+		if err == nil {
+			if t.Breaker != nil {
+				t.Breaker.Success()
+			}
+
+			return body, nil
+		}
+
+		if ctx.Err() != nil {
+			if t.Breaker != nil {
+				t.Breaker.Abandon()
+			}
+
+			return nil, ctx.Err()
+		}
+
+		if t.Breaker != nil {
+			if retryable {
+				t.Breaker.Failure()
+			} else {
+				t.Breaker.Success()
+			}
+		}
+
+		if !retryable {
+			return nil, err
+		}
+
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+func (t *Transport) attempt(ctx context.Context, r TransportRequest, payload []byte) ([]byte, bool, error) {
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+
+	var reader io.Reader
+	if payload != nil {
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.Method, r.URL, reader)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if r.Payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if res.StatusCode >= http.StatusInternalServerError || res.StatusCode == http.StatusTooManyRequests {
+		return nil, true, &HTTPError{Source: r.Source, Action: r.Action, StatusCode: res.StatusCode, Body: string(body)}
+	}
+
+	return body, false, nil
+}
+
+func (t *Transport) wait(ctx context.Context, attempt int) error {
+	backoff := t.BackoffBase << (attempt - 1)
+	if backoff > t.BackoffMax || backoff <= 0 {
+		backoff = t.BackoffMax
+	}
+
+	var delay time.Duration
+	if backoff > 0 {
+		delay = time.Duration(rand.Int63n(int64(backoff)))
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
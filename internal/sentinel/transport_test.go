@@ -0,0 +1,130 @@
+package sentinel
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testTransport(breakerThreshold int) *Transport {
+	return NewTransport(TransportConfig{
+		Timeout:          time.Second,
+		MaxRetries:       2,
+		BackoffBase:      time.Millisecond,
+		BackoffMax:       5 * time.Millisecond,
+		BreakerThreshold: breakerThreshold,
+		BreakerCooldown:  time.Minute,
+	})
+}
+
+func TestTransportDo(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		body       string
+		idempotent bool
+		want       string
+		wantErr    func(err error) bool
+		requests   int32
+	}{
+		{"success", []int{200}, `{"success":true,"result":"ok"}`, true, "ok", nil, 1},
+		{"retries server errors", []int{503, 502, 200}, `{"success":true,"result":"ok"}`, true, "ok", nil, 3},
+		{"retries too many requests", []int{429, 200}, `{"success":true,"result":"ok"}`, true, "ok", nil, 2},
+		{"gives up after retries", []int{500, 500, 500, 500}, `{}`, true, "", isHTTPError, 3},
+		{"doesn't retry non idempotent requests", []int{503, 200}, `{"success":true,"result":"ok"}`, false, "", isHTTPError, 1},
+		{"doesn't retry failures of the request", []int{200}, `{"success":false,"error":{"code":5,"message":"not found"}}`, true, "", IsNotFound, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := atomic.AddInt32(&requests, 1) - 1
+				w.WriteHeader(tt.statuses[i])
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			var result string
+			err := testTransport(0).Do(context.Background(), TransportRequest{
+				Method:     http.MethodGet,
+				URL:        server.URL,
+				Idempotent: tt.idempotent,
+				Source:     sourceAPI,
+				Action:     "in test",
+			}, &result)
+
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("failed: %s", err)
+			}
+
+			if tt.wantErr != nil && !tt.wantErr(err) {
+				t.Fatalf("err = %v, not of the expected kind", err)
+			}
+
+			if result != tt.want {
+				t.Errorf("result = %q, want %q", result, tt.want)
+			}
+
+			if requests != tt.requests {
+				t.Errorf("requests = %d, want %d", requests, tt.requests)
+			}
+		})
+	}
+}
+
+func isHTTPError(err error) bool {
+	var httpError *HTTPError
+	return errors.As(err, &httpError)
+}
+
+func TestTransportBreakerFailsFast(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	transport := testTransport(3)
+	request := TransportRequest{Method: http.MethodGet, URL: server.URL, Idempotent: true, Source: sourceAPI}
+
+	err := transport.Do(context.Background(), request, nil)
+	if !isHTTPError(err) {
+		t.Fatalf("err = %v, want HTTPError", err)
+	}
+
+	err = transport.Do(context.Background(), request, nil)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want ErrCircuitOpen", err)
+	}
+
+	if requests != 3 {
+		t.Errorf("requests = %d, want 3", requests)
+	}
+}
+
+func TestTransportReturnsContextError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	transport := testTransport(1)
+	err := transport.Do(ctx, TransportRequest{Method: http.MethodGet, URL: server.URL, Idempotent: true, Source: sourceAPI}, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+
+	// The request was abandoned by the caller, so it doesn't open the breaker
+	if err := transport.Breaker.Allow(); err != nil {
+		t.Errorf("breaker is open after an abandoned request: %s", err)
+	}
+}
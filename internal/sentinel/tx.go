@@ -0,0 +1,185 @@
+package sentinel
+
+import (
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	typeURLExec           = "/cosmos.authz.v1beta1.MsgExec"
+	typeURLBasicAllowance = "/cosmos.feegrant.v1beta1.BasicAllowance"
+	typeURLPubKey         = "/cosmos.crypto.secp256k1.PubKey"
+
+	signModeDirect = 1
+)
+
+// protoMessage is a protobuf message encoded by hand, field by field, which is all the API needs
+// to sign the handful of Cosmos SDK and Sentinel messages it sends.
+type protoMessage []byte
+
+func (m protoMessage) string(field protowire.Number, value string) protoMessage {
+	if value == "" {
+		return m
+	}
+
+	m = protowire.AppendTag(m, field, protowire.BytesType)
+	return protowire.AppendString(m, value)
+}
+
+func (m protoMessage) bytes(field protowire.Number, value []byte) protoMessage {
+	if len(value) == 0 {
+		return m
+	}
+
+	m = protowire.AppendTag(m, field, protowire.BytesType)
+	return protowire.AppendBytes(m, value)
+}
+
+func (m protoMessage) uint(field protowire.Number, value uint64) protoMessage {
+	if value == 0 {
+		return m
+	}
+
+	m = protowire.AppendTag(m, field, protowire.VarintType)
+	return protowire.AppendVarint(m, value)
+}
+
+func (m protoMessage) message(field protowire.Number, value protoMessage) protoMessage {
+	m = protowire.AppendTag(m, field, protowire.BytesType)
+	return protowire.AppendBytes(m, value)
+}
+
+// anyMessage is google.protobuf.Any
+type anyMessage struct {
+	TypeURL string
+	Value   protoMessage
+}
+
+func (a anyMessage) encode() protoMessage {
+	return protoMessage{}.string(1, a.TypeURL).bytes(2, a.Value)
+}
+
+func coin(amount string, denom string) protoMessage {
+	return protoMessage{}.string(1, denom).string(2, amount)
+}
+
+func (p Protocol) msgExec(grantee string, messages []anyMessage) anyMessage {
+	m := protoMessage{}.string(1, grantee)
+	for _, message := range messages {
+		m = m.message(2, message.encode())
+	}
+
+	return anyMessage{TypeURL: typeURLExec, Value: m}
+}
+
+func (p Protocol) msgNodeSubscribe(from string, nodeAddress string, gigabytes int64, hours int64, denom string) anyMessage {
+	return anyMessage{
+		TypeURL: p.MessageNodeSubscribe,
+		Value:   protoMessage{}.string(1, from).string(2, nodeAddress).uint(3, uint64(gigabytes)).uint(4, uint64(hours)).string(5, denom),
+	}
+}
+
+func (p Protocol) msgPlanSubscribe(from string, planID uint64, denom string) anyMessage {
+	return anyMessage{
+		TypeURL: p.MessagePlanSubscribe,
+		Value:   protoMessage{}.string(1, from).uint(2, planID).string(3, denom),
+	}
+}
+
+func (p Protocol) msgLinkNode(from string, planID uint64, nodeAddress string) anyMessage {
+	return anyMessage{
+		TypeURL: p.MessageLinkNode,
+		Value:   protoMessage{}.string(1, from).uint(2, planID).string(3, nodeAddress),
+	}
+}
+
+func (p Protocol) msgUnlinkNode(from string, planID uint64, nodeAddress string) anyMessage {
+	return anyMessage{
+		TypeURL: p.MessageUnlinkNode,
+		Value:   protoMessage{}.string(1, from).uint(2, planID).string(3, nodeAddress),
+	}
+}
+
+func (p Protocol) msgGrantAllowance(granter string, grantee string) anyMessage {
+	allowance := anyMessage{TypeURL: typeURLBasicAllowance}
+
+	return anyMessage{
+		TypeURL: p.MessageGrantAllowance,
+		Value:   protoMessage{}.string(1, granter).string(2, grantee).message(3, allowance.encode()),
+	}
+}
+
+func (p Protocol) msgAllocate(from string, subscriptionID uint64, address string, bytes int64) anyMessage {
+	return anyMessage{
+		TypeURL: p.MessageAllocate,
+		Value:   protoMessage{}.string(1, from).uint(2, subscriptionID).string(3, address).string(4, strconv.FormatInt(bytes, 10)),
+	}
+}
+
+func (p Protocol) msgStartSession(from string, subscriptionID uint64, nodeAddress string) anyMessage {
+	return anyMessage{
+		TypeURL: p.MessageStartSession,
+		Value:   protoMessage{}.string(1, from).uint(2, subscriptionID).string(3, nodeAddress),
+	}
+}
+
+func (p Protocol) msgEndSession(from string, sessionID uint64) anyMessage {
+	return anyMessage{
+		TypeURL: p.MessageEndSession,
+		Value:   protoMessage{}.string(1, from).uint(2, sessionID),
+	}
+}
+
+// unsignedTx holds everything needed to sign a transaction in SIGN_MODE_DIRECT
+type unsignedTx struct {
+	Messages      []anyMessage
+	Memo          string
+	GasLimit      uint64
+	Fee           protoMessage
+	FeeGranter    string
+	ChainID       string
+	AccountNumber uint64
+	Sequence      uint64
+}
+
+// sign returns bytes of TxRaw, ready to be broadcast
+func (tx unsignedTx) sign(signer *Signer) ([]byte, error) {
+	body := protoMessage{}
+	for _, message := range tx.Messages {
+		body = body.message(1, message.encode())
+	}
+	body = body.string(2, tx.Memo)
+
+	publicKey := anyMessage{
+		TypeURL: typeURLPubKey,
+		Value:   protoMessage{}.bytes(1, signer.PublicKey()),
+	}
+	modeInfo := protoMessage{}.message(1, protoMessage{}.uint(1, signModeDirect))
+	signerInfo := protoMessage{}.
+		message(1, publicKey.encode()).
+		message(2, modeInfo).
+		uint(3, tx.Sequence)
+
+	fee := protoMessage{}
+	if len(tx.Fee) > 0 {
+		fee = fee.message(1, tx.Fee)
+	}
+	fee = fee.uint(2, tx.GasLimit).string(4, tx.FeeGranter)
+
+	authInfo := protoMessage{}.message(1, signerInfo).message(2, fee)
+
+	signDoc := protoMessage{}.
+		bytes(1, body).
+		bytes(2, authInfo).
+		string(3, tx.ChainID).
+		uint(4, tx.AccountNumber)
+
+	signature, err := signer.Sign(signDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := protoMessage{}.bytes(1, body).bytes(2, authInfo).bytes(3, signature)
+	return raw, nil
+}
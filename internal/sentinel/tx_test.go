@@ -0,0 +1,100 @@
+package sentinel
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// Expected encodings are produced by marshalling the same messages with Cosmos SDK v0.47 and Sentinel hub v12 types
+// (`x/*/types/v2`), signed with the Cosmos SDK key of testMnemonic.
+func TestMessagesMatchSentinelHub(t *testing.T) {
+	p := ProtocolV2
+	from, node := "sent1from", "sentnode1node"
+
+	tests := []struct {
+		name    string
+		message anyMessage
+		typeURL string
+		want    string
+	}{
+		{"node subscribe by hours", p.msgNodeSubscribe(from, node, 0, 720, "udvpn"), "/sentinel.node.v2.MsgSubscribeRequest", "0a0973656e743166726f6d120d73656e746e6f6465316e6f646520d0052a05756476706e"},
+		{"node subscribe by gigabytes", p.msgNodeSubscribe(from, node, 5, 0, "udvpn"), "/sentinel.node.v2.MsgSubscribeRequest", "0a0973656e743166726f6d120d73656e746e6f6465316e6f646518052a05756476706e"},
+		{"plan subscribe", p.msgPlanSubscribe(from, 42, "udvpn"), "/sentinel.plan.v2.MsgSubscribeRequest", "0a0973656e743166726f6d102a1a05756476706e"},
+		{"link node", p.msgLinkNode(from, 42, node), "/sentinel.plan.v2.MsgLinkNodeRequest", "0a0973656e743166726f6d102a1a0d73656e746e6f6465316e6f6465"},
+		{"unlink node", p.msgUnlinkNode(from, 42, node), "/sentinel.plan.v2.MsgUnlinkNodeRequest", "0a0973656e743166726f6d102a1a0d73656e746e6f6465316e6f6465"},
+		{"allocate", p.msgAllocate(from, 7, "sent1wallet", 1000000000), "/sentinel.subscription.v2.MsgAllocateRequest", "0a0973656e743166726f6d10071a0b73656e743177616c6c6574220a31303030303030303030"},
+		{"start session", p.msgStartSession(from, 7, node), "/sentinel.session.v2.MsgStartRequest", "0a0973656e743166726f6d10071a0d73656e746e6f6465316e6f6465"},
+		{"end session", p.msgEndSession(from, 99), "/sentinel.session.v2.MsgEndRequest", "0a0973656e743166726f6d1063"},
+		{"grant allowance", p.msgGrantAllowance(from, "sent1wallet"), "/cosmos.feegrant.v1beta1.MsgGrantAllowance", "0a0973656e743166726f6d120b73656e743177616c6c65741a290a272f636f736d6f732e6665656772616e742e763162657461312e4261736963416c6c6f77616e6365"},
+		{
+			"exec",
+			p.msgExec("sent1grantee", []anyMessage{p.msgLinkNode(from, 42, node), p.msgLinkNode(from, 42, "sentnode1other")}),
+			"/cosmos.authz.v1beta1.MsgExec",
+			"0a0c73656e74316772616e74656512440a242f73656e74696e656c2e706c616e2e76322e4d73674c696e6b4e6f646552657175657374121c0a0973656e743166726f6d102a1a0d73656e746e6f6465316e6f646512450a242f73656e74696e656c2e706c616e2e76322e4d73674c696e6b4e6f646552657175657374121d0a0973656e743166726f6d102a1a0e73656e746e6f6465316f74686572",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.message.TypeURL != tt.typeURL {
+				t.Errorf("type URL = %s, want %s", tt.message.TypeURL, tt.typeURL)
+			}
+
+			if got := hex.EncodeToString(tt.message.Value); got != tt.want {
+				t.Errorf("encoding = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignedTxMatchesCosmosSDK(t *testing.T) {
+	signer, err := NewSignerFromMnemonic(testMnemonic)
+	if err != nil {
+		t.Fatalf("failed to create signer: %s", err)
+	}
+
+	p := ProtocolV2
+	from, node := "sent1from", "sentnode1node"
+
+	tests := []struct {
+		name string
+		tx   unsignedTx
+		want string
+	}{
+		{
+			name: "exec with fee and granter",
+			tx: unsignedTx{
+				Messages:      []anyMessage{p.msgExec("sent1grantee", []anyMessage{p.msgLinkNode(from, 42, node), p.msgLinkNode(from, 42, "sentnode1other")})},
+				GasLimit:      250000,
+				Fee:           coin("12500", "udvpn"),
+				FeeGranter:    "sent1granter",
+				ChainID:       "sentinelhub-2",
+				AccountNumber: 7,
+				Sequence:      3,
+			},
+			want: "0ac0010abd010a1d2f636f736d6f732e617574687a2e763162657461312e4d736745786563129b010a0c73656e74316772616e74656512440a242f73656e74696e656c2e706c616e2e76322e4d73674c696e6b4e6f646552657175657374121c0a0973656e743166726f6d102a1a0d73656e746e6f6465316e6f646512450a242f73656e74696e656c2e706c616e2e76322e4d73674c696e6b4e6f646552657175657374121d0a0973656e743166726f6d102a1a0e73656e746e6f6465316f7468657212760a500a460a1f2f636f736d6f732e63727970746f2e736563703235366b312e5075624b657912230a21024f4e2ad99c34d60b9ba6283c9431a8418af8673212961f97a77b6377fcd05b6212040a020801180312220a0e0a05756476706e120531323530301090a10f220c73656e74316772616e7465721a400a8e67c887e40fe78cde83a0a8bdc6cf837d7cf4add4105b1aeb3a4d1ef3d57b1ed9f8f787f5c25965839224e28b3b1d0a82b25a9364a736ff12eb1240e8a402",
+		},
+		{
+			name: "session with memo and no fee",
+			tx: unsignedTx{
+				Messages: []anyMessage{p.msgStartSession(signer.Address, 7, node)},
+				Memo:     "dvpn",
+				ChainID:  "sentinelhub-2",
+			},
+			want: "0a6e0a660a242f73656e74696e656c2e73657373696f6e2e76322e4d7367537461727452657175657374123e0a2b73656e743139726c34636d32686d7238616679346b6c6470787a33666b61346a6775713061386d6d796d3610071a0d73656e746e6f6465316e6f646512046476706e12520a4e0a460a1f2f636f736d6f732e63727970746f2e736563703235366b312e5075624b657912230a21024f4e2ad99c34d60b9ba6283c9431a8418af8673212961f97a77b6377fcd05b6212040a02080112001a40984b1d83d75e2379094fbba0d61d24d40d38116a39714115f970db027fd34073175df48927df5a46dd02e3273ab5ea524c98abe1bfa38aba872a80c86ff18363",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := tt.tx.sign(signer)
+			if err != nil {
+				t.Fatalf("failed to sign tx: %s", err)
+			}
+
+			if got := hex.EncodeToString(raw); got != tt.want {
+				t.Errorf("TxRaw = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
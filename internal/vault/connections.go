@@ -0,0 +1,38 @@
+package vault
+
+import (
+	"dvpn/models"
+	"errors"
+	"strconv"
+)
+
+// SealConnectionCredentials encrypts credentials of a connection, which should be already saved,
+// the credentials are bound to its ID.
+func (v *Vault) SealConnectionCredentials(connection *models.Connection, credentials []byte) error {
+	if connection.ID == 0 {
+		return errors.New("connection is not saved")
+	}
+
+	envelope, err := v.Seal(credentials, connectionData(connection))
+	if err != nil {
+		return err
+	}
+
+	connection.CredentialsCiphertext = envelope.Ciphertext
+	connection.CredentialsDataKey = envelope.DataKey
+	connection.CredentialsKeyVersion = envelope.KeyVersion
+
+	return nil
+}
+
+func (v *Vault) OpenConnectionCredentials(connection *models.Connection) ([]byte, error) {
+	return v.Open(Envelope{
+		Ciphertext: connection.CredentialsCiphertext,
+		DataKey:    connection.CredentialsDataKey,
+		KeyVersion: connection.CredentialsKeyVersion,
+	}, connectionData(connection))
+}
+
+func connectionData(connection *models.Connection) []byte {
+	return []byte("connection:" + strconv.FormatUint(uint64(connection.ID), 10))
+}
@@ -0,0 +1,112 @@
+package vault
+
+import (
+	"dvpn/models"
+	"errors"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// SealDeviceEntropy encrypts wallet entropy of a device. Device wallet address should be already set,
+// the entropy is bound to it.
+func (v *Vault) SealDeviceEntropy(device *models.Device, entropy []byte) error {
+	if device.WalletAddress == "" {
+		return errors.New("wallet address of device is not set")
+	}
+
+	envelope, err := v.Seal(entropy, []byte(device.WalletAddress))
+	if err != nil {
+		return err
+	}
+
+	device.WalletEntropy = envelope.Ciphertext
+	device.WalletDataKey = envelope.DataKey
+	device.WalletKeyVersion = envelope.KeyVersion
+
+	return nil
+}
+
+// OpenDeviceEntropy is the only way wallet entropy of a device should be read, it works with every
+// configured master key version and, when the vault allows plaintext, with rows that are not encrypted yet.
+func (v *Vault) OpenDeviceEntropy(device *models.Device) ([]byte, error) {
+	return v.Open(Envelope{
+		Ciphertext: device.WalletEntropy,
+		DataKey:    device.WalletDataKey,
+		KeyVersion: device.WalletKeyVersion,
+	}, []byte(device.WalletAddress))
+}
+
+// RotationResult counts devices processed by RotateDevices
+type RotationResult struct {
+	Rotated int
+	Failed  int
+}
+
+// RotateDevices re-encrypts wallet entropy of devices sealed with a master key other than the current one,
+// including rows which are not encrypted yet when the vault allows plaintext. Devices are processed in batches
+// of `batchSize`, each batch is committed in its own transaction, so the rotation can be interrupted and started again.
+func (v *Vault) RotateDevices(db *gorm.DB, batchSize int, logger *zap.SugaredLogger) (*RotationResult, error) {
+	result := &RotationResult{}
+	var lastID uint
+
+	for {
+		var devices []models.Device
+		tx := db.Model(&models.Device{}).
+			Where("id > ? AND wallet_key_version <> ?", lastID, v.current).
+			Order("id").
+			Limit(batchSize).
+			Find(&devices)
+		if tx.Error != nil {
+			return result, tx.Error
+		}
+
+		if len(devices) == 0 {
+			return result, nil
+		}
+
+		lastID = devices[len(devices)-1].ID
+
+		// Devices of the batch are counted once it is committed, a rolled back batch rotated nothing
+		var batch RotationResult
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for _, device := range devices {
+				from := device.WalletKeyVersion
+
+				entropy, err := v.OpenDeviceEntropy(&device)
+				if err != nil {
+					logger.Errorf("failed to decrypt wallet entropy of device %d sealed with master key %d: %s", device.ID, from, err)
+					batch.Failed++
+					continue
+				}
+
+				if err := v.SealDeviceEntropy(&device, entropy); err != nil {
+					return err
+				}
+
+				update := tx.Model(&models.Device{}).
+					Where("id = ? AND wallet_key_version = ?", device.ID, from).
+					Updates(map[string]interface{}{
+						"wallet_entropy":     device.WalletEntropy,
+						"wallet_data_key":    device.WalletDataKey,
+						"wallet_key_version": device.WalletKeyVersion,
+					})
+				if update.Error != nil {
+					return update.Error
+				}
+
+				batch.Rotated += int(update.RowsAffected)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+
+		result.Rotated += batch.Rotated
+		result.Failed += batch.Failed
+
+		logger.Infof("rotated wallet entropy of devices up to %d to master key %d, %d rotated and %d failed so far", lastID, v.current, result.Rotated, result.Failed)
+	}
+}
@@ -0,0 +1,101 @@
+package vault
+
+import (
+	"dvpn/models"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestRotateDevices(t *testing.T) {
+	old, err := New(map[int][]byte{1: testKey(1)}, 1)
+	if err != nil {
+		t.Fatalf("failed to create vault: %s", err)
+	}
+
+	entropy := []byte("wallet entropy")
+
+	sealed := models.Device{WalletAddress: "sent1sealed"}
+	if err := old.SealDeviceEntropy(&sealed, entropy); err != nil {
+		t.Fatalf("failed to seal: %s", err)
+	}
+
+	// Entropy sealed for another wallet can't be opened for this one
+	mismatched := models.Device{WalletAddress: "sent1another"}
+	if err := old.SealDeviceEntropy(&mismatched, entropy); err != nil {
+		t.Fatalf("failed to seal: %s", err)
+	}
+
+	columns := []string{"id", "wallet_address", "wallet_entropy", "wallet_data_key", "wallet_key_version"}
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows(columns).
+			AddRow(1, sealed.WalletAddress, sealed.WalletEntropy, sealed.WalletDataKey, sealed.WalletKeyVersion).
+			AddRow(2, "sent1plain", entropy, nil, KeyVersionPlaintext).
+			AddRow(3, "sent1mismatched", mismatched.WalletEntropy, mismatched.WalletDataKey, mismatched.WalletKeyVersion)
+	}
+
+	tests := []struct {
+		name           string
+		allowPlaintext bool
+		rotated        []int
+		commitErr      error
+		want           RotationResult
+	}{
+		{"plaintext is rejected", false, []int{1}, nil, RotationResult{Rotated: 1, Failed: 2}},
+		{"plaintext is migrated", true, []int{1, 2}, nil, RotationResult{Rotated: 2, Failed: 1}},
+		{"batch is not committed", true, []int{1, 2}, errors.New("connection reset"), RotationResult{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create mock DB: %s", err)
+			}
+			defer conn.Close()
+
+			db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{Logger: logger.Discard, SkipDefaultTransaction: true})
+			if err != nil {
+				t.Fatalf("failed to open mock DB: %s", err)
+			}
+
+			rotated, err := New(map[int][]byte{1: testKey(1), 2: testKey(2)}, 2)
+			if err != nil {
+				t.Fatalf("failed to create vault: %s", err)
+			}
+			rotated.AllowPlaintext = tt.allowPlaintext
+
+			mock.ExpectQuery(`SELECT \* FROM "devices" WHERE id > \$1 AND wallet_key_version <> \$2`).WithArgs(0, 2).WillReturnRows(rows())
+			mock.ExpectBegin()
+			for _, id := range tt.rotated {
+				mock.ExpectExec(`UPDATE "devices" SET .* WHERE id = \$\d+ AND wallet_key_version = \$\d+`).
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 2, sqlmock.AnyArg(), id, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			if tt.commitErr != nil {
+				mock.ExpectCommit().WillReturnError(tt.commitErr)
+			} else {
+				mock.ExpectCommit()
+				mock.ExpectQuery(`SELECT \* FROM "devices" WHERE id > \$1 AND wallet_key_version <> \$2`).WithArgs(3, 2).WillReturnRows(sqlmock.NewRows(columns))
+			}
+
+			result, err := rotated.RotateDevices(db, 10, zap.NewNop().Sugar())
+			if !errors.Is(err, tt.commitErr) {
+				t.Fatalf("error = %v, want %v", err, tt.commitErr)
+			}
+
+			if *result != tt.want {
+				t.Errorf("result = %+v, want %+v", *result, tt.want)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
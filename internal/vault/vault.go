@@ -0,0 +1,273 @@
+package vault
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KeyVersionPlaintext marks rows written before wallet entropy was encrypted
+const KeyVersionPlaintext = 0
+
+const keySize = 32
+
+var ErrUnknownKeyVersion = errors.New("master key of this version is not configured")
+
+var ErrPlaintext = errors.New("secret is not encrypted and plaintext secrets are not allowed")
+
+// Vault encrypts secrets with envelope encryption: every secret gets its own random data key,
+// which is wrapped by a versioned master key. Only the wrapped data key and the master key version
+// are stored next to the secret, so rotating master keys re-wraps rows without touching the rest of the schema.
+type Vault struct {
+	// AllowPlaintext lets Open return secrets of KeyVersionPlaintext rows, only while they are migrated
+	AllowPlaintext bool
+
+	keys    map[int][]byte
+	current int
+}
+
+// Envelope is a sealed secret as it is stored in the DB
+type Envelope struct {
+	Ciphertext []byte
+	DataKey    []byte
+	KeyVersion int
+}
+
+// New creates a Vault which seals secrets with the master key of `current` version
+// and opens secrets sealed with any of `keys`.
+func New(keys map[int][]byte, current int) (*Vault, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no master keys configured")
+	}
+
+	for version, key := range keys {
+		if version <= KeyVersionPlaintext {
+			return nil, fmt.Errorf("master key version %d must be positive", version)
+		}
+
+		if len(key) != keySize {
+			return nil, fmt.Errorf("master key %d must be %d bytes long, got %d", version, keySize, len(key))
+		}
+	}
+
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current master key %d: %w", current, ErrUnknownKeyVersion)
+	}
+
+	return &Vault{keys: keys, current: current}, nil
+}
+
+// NewFromEnv loads master keys from WALLET_MASTER_KEYS or, when it is empty, from the file at WALLET_MASTER_KEYS_FILE.
+// Keys are `<version>:<base64 key>` pairs separated by commas or new lines. WALLET_MASTER_KEY_VERSION selects
+// the key new secrets are sealed with and defaults to the highest configured version.
+// WALLET_ALLOW_PLAINTEXT=true allows reading rows which are not encrypted yet.
+func NewFromEnv() (*Vault, error) {
+	raw := os.Getenv("WALLET_MASTER_KEYS")
+	if raw == "" {
+		path := os.Getenv("WALLET_MASTER_KEYS_FILE")
+		if path == "" {
+			return nil, errors.New("neither WALLET_MASTER_KEYS nor WALLET_MASTER_KEYS_FILE is set")
+		}
+
+		file, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		raw = string(file)
+	}
+
+	keys, err := ParseKeys(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	current := 0
+	if version := os.Getenv("WALLET_MASTER_KEY_VERSION"); version != "" {
+		current, err = strconv.Atoi(version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WALLET_MASTER_KEY_VERSION: %w", err)
+		}
+	} else {
+		for version := range keys {
+			if version > current {
+				current = version
+			}
+		}
+	}
+
+	vault, err := New(keys, current)
+	if err != nil {
+		return nil, err
+	}
+
+	if allow := os.Getenv("WALLET_ALLOW_PLAINTEXT"); allow != "" {
+		vault.AllowPlaintext, err = strconv.ParseBool(allow)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WALLET_ALLOW_PLAINTEXT: %w", err)
+		}
+	}
+
+	return vault, nil
+}
+
+// ParseKeys parses `<version>:<base64 key>` pairs separated by commas or new lines, skipping empty lines and `#` comments
+func ParseKeys(raw string) (map[int][]byte, error) {
+	keys := make(map[int][]byte)
+
+	scanner := bufio.NewScanner(strings.NewReader(strings.ReplaceAll(raw, ",", "\n")))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		version, encoded, found := strings.Cut(line, ":")
+		if !found {
+			return nil, errors.New("master key must be in `<version>:<base64 key>` format")
+		}
+
+		v, err := strconv.Atoi(strings.TrimSpace(version))
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key version %q: %w", version, err)
+		}
+
+		if _, ok := keys[v]; ok {
+			return nil, fmt.Errorf("master key %d is configured twice", v)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key %d: %w", v, err)
+		}
+
+		keys[v] = key
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// CurrentVersion is the version of the master key new secrets are sealed with
+func (v *Vault) CurrentVersion() int {
+	return v.current
+}
+
+// Versions lists configured master key versions in ascending order
+func (v *Vault) Versions() []int {
+	versions := make([]int, 0, len(v.keys))
+	for version := range v.keys {
+		versions = append(versions, version)
+	}
+
+	sort.Ints(versions)
+	return versions
+}
+
+// Seal encrypts plaintext with a fresh data key wrapped by the current master key.
+// Additional data binds the ciphertext to its owner, so it can't be opened in place of another row.
+func (v *Vault) Seal(plaintext []byte, additionalData []byte) (*Envelope, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := seal(dataKey, plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := seal(v.keys[v.current], dataKey, versionData(v.current))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		Ciphertext: ciphertext,
+		DataKey:    wrappedKey,
+		KeyVersion: v.current,
+	}, nil
+}
+
+// Open decrypts an envelope sealed with any configured master key. Envelopes of KeyVersionPlaintext
+// are returned as they are only when AllowPlaintext is set, so rows written before encryption can be
+// migrated, and are rejected otherwise.
+func (v *Vault) Open(envelope Envelope, additionalData []byte) ([]byte, error) {
+	if envelope.KeyVersion == KeyVersionPlaintext {
+		if !v.AllowPlaintext {
+			return nil, ErrPlaintext
+		}
+
+		return envelope.Ciphertext, nil
+	}
+
+	masterKey, ok := v.keys[envelope.KeyVersion]
+	if !ok {
+		return nil, fmt.Errorf("master key %d: %w", envelope.KeyVersion, ErrUnknownKeyVersion)
+	}
+
+	dataKey, err := open(masterKey, envelope.DataKey, versionData(envelope.KeyVersion))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	plaintext, err := open(dataKey, envelope.Ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+func versionData(version int) []byte {
+	return []byte("v" + strconv.Itoa(version))
+}
+
+// seal encrypts with AES-256-GCM and prepends the random nonce to the ciphertext
+func seal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, additionalData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
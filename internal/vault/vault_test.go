@@ -0,0 +1,195 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, keySize)
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    map[int][]byte
+		current int
+		wantErr bool
+	}{
+		{"single key", map[int][]byte{1: testKey(1)}, 1, false},
+		{"several keys", map[int][]byte{1: testKey(1), 2: testKey(2)}, 1, false},
+		{"no keys", nil, 1, true},
+		{"plaintext version", map[int][]byte{0: testKey(1)}, 0, true},
+		{"short key", map[int][]byte{1: testKey(1)[:16]}, 1, true},
+		{"unknown current key", map[int][]byte{1: testKey(1)}, 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.keys, tt.current)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(testKey(1))
+
+	tests := []struct {
+		name     string
+		raw      string
+		versions []int
+		wantErr  bool
+	}{
+		{"commas", "1:" + key + ",2:" + key, []int{1, 2}, false},
+		{"lines with comments", "# rotated in May\n1: " + key + "\n\n2:" + key + "\n", []int{1, 2}, false},
+		{"missing version", key, nil, true},
+		{"invalid version", "one:" + key, nil, true},
+		{"duplicate version", "1:" + key + ",1:" + key, nil, true},
+		{"invalid base64", "1:not base64!", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := ParseKeys(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+
+			if len(keys) != len(tt.versions) {
+				t.Errorf("parsed %d keys, want %d", len(keys), len(tt.versions))
+			}
+
+			for _, version := range tt.versions {
+				if !bytes.Equal(keys[version], testKey(1)) {
+					t.Errorf("key %d = %x, want %x", version, keys[version], testKey(1))
+				}
+			}
+		})
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	old, err := New(map[int][]byte{1: testKey(1)}, 1)
+	if err != nil {
+		t.Fatalf("failed to create vault: %s", err)
+	}
+
+	rotated, err := New(map[int][]byte{1: testKey(1), 2: testKey(2)}, 2)
+	if err != nil {
+		t.Fatalf("failed to create vault: %s", err)
+	}
+
+	secret := []byte("wallet entropy")
+	owner := []byte("sent1owner")
+
+	sealedByOld, err := old.Seal(secret, owner)
+	if err != nil {
+		t.Fatalf("failed to seal: %s", err)
+	}
+
+	sealedByRotated, err := rotated.Seal(secret, owner)
+	if err != nil {
+		t.Fatalf("failed to seal: %s", err)
+	}
+
+	if sealedByOld.KeyVersion != 1 || sealedByRotated.KeyVersion != 2 {
+		t.Fatalf("sealed with keys %d and %d, want 1 and 2", sealedByOld.KeyVersion, sealedByRotated.KeyVersion)
+	}
+
+	if bytes.Contains(sealedByOld.Ciphertext, secret) {
+		t.Fatalf("ciphertext contains the secret")
+	}
+
+	tampered := *sealedByRotated
+	tampered.Ciphertext = append([]byte{}, sealedByRotated.Ciphertext...)
+	tampered.Ciphertext[len(tampered.Ciphertext)-1] ^= 1
+
+	relabelled := *sealedByRotated
+	relabelled.KeyVersion = 1
+
+	plaintext := Envelope{Ciphertext: secret, KeyVersion: KeyVersionPlaintext}
+
+	tests := []struct {
+		name           string
+		vault          *Vault
+		envelope       Envelope
+		additionalData []byte
+		allowPlaintext bool
+		wantErr        bool
+		is             error
+	}{
+		{"same key", old, *sealedByOld, owner, false, false, nil},
+		{"previous key after rotation", rotated, *sealedByOld, owner, false, false, nil},
+		{"current key after rotation", rotated, *sealedByRotated, owner, false, false, nil},
+		{"key which is not configured", old, *sealedByRotated, owner, false, true, ErrUnknownKeyVersion},
+		{"another owner", rotated, *sealedByRotated, []byte("sent1another"), false, true, nil},
+		{"tampered ciphertext", rotated, tampered, owner, false, true, nil},
+		{"data key of another version", rotated, relabelled, owner, false, true, nil},
+		{"plaintext is rejected", rotated, plaintext, owner, false, true, ErrPlaintext},
+		{"plaintext is allowed for migration", rotated, plaintext, owner, true, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.vault.AllowPlaintext = tt.allowPlaintext
+			defer func() { tt.vault.AllowPlaintext = false }()
+
+			opened, err := tt.vault.Open(tt.envelope, tt.additionalData)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Fatalf("err = %v, want %v", err, tt.is)
+			}
+
+			if !tt.wantErr && !bytes.Equal(opened, secret) {
+				t.Errorf("opened %q, want %q", opened, secret)
+			}
+		})
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	keys := "1:" + base64.StdEncoding.EncodeToString(testKey(1)) + ",3:" + base64.StdEncoding.EncodeToString(testKey(3))
+
+	tests := []struct {
+		name           string
+		env            map[string]string
+		current        int
+		allowPlaintext bool
+		wantErr        bool
+	}{
+		{"highest version by default", map[string]string{"WALLET_MASTER_KEYS": keys}, 3, false, false},
+		{"selected version", map[string]string{"WALLET_MASTER_KEYS": keys, "WALLET_MASTER_KEY_VERSION": "1"}, 1, false, false},
+		{"plaintext allowed", map[string]string{"WALLET_MASTER_KEYS": keys, "WALLET_ALLOW_PLAINTEXT": "true"}, 3, true, false},
+		{"invalid plaintext flag", map[string]string{"WALLET_MASTER_KEYS": keys, "WALLET_ALLOW_PLAINTEXT": "sure"}, 0, false, true},
+		{"no keys", map[string]string{}, 0, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"WALLET_MASTER_KEYS", "WALLET_MASTER_KEYS_FILE", "WALLET_MASTER_KEY_VERSION", "WALLET_ALLOW_PLAINTEXT"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			vault, err := NewFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if vault.CurrentVersion() != tt.current || vault.AllowPlaintext != tt.allowPlaintext {
+				t.Errorf("current key %d and plaintext allowed %t, want %d and %t", vault.CurrentVersion(), vault.AllowPlaintext, tt.current, tt.allowPlaintext)
+			}
+		})
+	}
+}
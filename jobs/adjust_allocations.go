@@ -0,0 +1,70 @@
+package jobs
+
+import (
+	"context"
+	"dvpn/internal/sentinel"
+	"dvpn/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// AdjustAllocationsJob tops up or reduces allocations of enrolled device wallets once their quota policy changes.
+// It broadcasts from the same wallet as EnrollWalletsJob, so both should run on the same scheduler.
+type AdjustAllocationsJob struct {
+	DB       *gorm.DB
+	Logger   *zap.SugaredLogger
+	Sentinel sentinel.SentinelClient
+	Quotas   QuotaPolicies
+}
+
+func (job AdjustAllocationsJob) Run() {
+	ctx := context.Background()
+
+	quotas, err := job.Quotas.findChanged(job.DB, 15)
+	if err != nil {
+		job.Logger.Error("failed to get devices with changed quotas from the DB: " + err.Error())
+		return
+	}
+
+	subscriptions := make(map[int64][]deviceQuota)
+	for _, quota := range quotas {
+		subscriptions[*quota.SubscriptionId] = append(subscriptions[*quota.SubscriptionId], quota)
+	}
+
+	for subscriptionID, quotas := range subscriptions {
+		var allocations []sentinel.SentinelWalletAllocation = make([]sentinel.SentinelWalletAllocation, 0)
+		for _, quota := range quotas {
+			job.Logger.Infof("Sentinel wallet %s quota changed, %d bytes will be allocated to it.", quota.WalletAddress, quota.Bytes)
+			allocations = append(allocations, sentinel.SentinelWalletAllocation{
+				Address: quota.WalletAddress,
+				Bytes:   quota.Bytes,
+			})
+		}
+
+		receipt, err := job.Sentinel.EnrollWalletToSubscription(ctx, allocations, subscriptionID)
+		if err != nil {
+			job.Logger.Errorf("failed to adjust allocations of sentinel wallets in subscription %d: %s", subscriptionID, err)
+			continue
+		}
+
+		err = job.DB.Transaction(func(db *gorm.DB) error {
+			id := subscriptionID
+			err := createPendingTransaction(db, receipt, models.SentinelTransactionKindAllocation, &id)
+			if err != nil {
+				return err
+			}
+
+			for _, quota := range quotas {
+				err := markPendingQuota(db, quota.ID, "allocation_tx_hash", receipt.TxHash, quota.Bytes)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			job.Logger.Errorf("failed to save allocation transaction %s to the DB: %s", receipt.TxHash, err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package jobs
+
+import (
+	"context"
+	"dvpn/internal/sentinel"
+	"dvpn/models"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+)
+
+func TestAdjustAllocations(t *testing.T) {
+	fake := sentinel.NewFakeSentinel(sentinel.FakeSentinelConfig{ProviderPlanID: "1", ProviderWalletAddress: "sentprov1provider", Seed: 1})
+	subscription, err := fake.CreatePlanSubscription(context.Background())
+	if err != nil {
+		t.Fatalf("failed to create subscription: %s", err)
+	}
+
+	// The first wallet got a bigger policy, the second one a smaller policy, the third one is in an unknown subscription
+	rows := sqlmock.NewRows([]string{"id", "wallet_address", "subscription_id", "quota_bytes", "bytes"}).
+		AddRow(1, "sent1first", subscription.Base.ID, 1000, 4000).
+		AddRow(2, "sent1second", subscription.Base.ID, 4000, 2000).
+		AddRow(3, "sent1third", subscription.Base.ID+1, 1000, 4000)
+
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT \* FROM \(SELECT d.id, d.wallet_address, d.subscription_id, d.quota_bytes, GREATEST\(COALESCE\(.+\) AS bytes FROM devices AS d .+\) AS q WHERE q.bytes <> q.quota_bytes ORDER BY q.id LIMIT \$2`).
+		WithArgs(int64(1000), 15).
+		WillReturnRows(rows)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "sentinel_transactions"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), models.SentinelTransactionKindAllocation, models.SentinelTransactionStatusPending, subscription.Base.ID, sqlmock.AnyArg(), sqlmock.AnyArg(), 0, "", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`UPDATE "devices" SET "allocation_tx_hash"=\$1,"pending_quota_bytes"=\$2,"updated_at"=\$3 WHERE id = \$4`).
+		WithArgs(sqlmock.AnyArg(), 4000, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "devices" SET "allocation_tx_hash"=\$1,"pending_quota_bytes"=\$2,"updated_at"=\$3 WHERE id = \$4`).
+		WithArgs(sqlmock.AnyArg(), 2000, sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	AdjustAllocationsJob{DB: db, Logger: zap.NewNop().Sugar(), Sentinel: fake, Quotas: QuotaPolicies{DefaultBytes: 1000}}.Run()
+
+	for wallet, want := range map[string]string{"sent1first": "4000", "sent1second": "2000"} {
+		allocation, err := fake.FetchAllocationForWallet(context.Background(), subscription.Base.ID, wallet)
+		if err != nil || allocation == nil {
+			t.Fatalf("failed to get allocation of %s: %v", wallet, err)
+		}
+		if allocation.GrantedBytes != want {
+			t.Errorf("granted bytes of %s = %s, want %s", wallet, allocation.GrantedBytes, want)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
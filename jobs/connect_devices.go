@@ -0,0 +1,482 @@
+package jobs
+
+import (
+	"context"
+	"dvpn/internal/events"
+	"dvpn/internal/selection"
+	"dvpn/internal/sentinel"
+	"dvpn/internal/vault"
+	"dvpn/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// nodeSubscriptionMutex serializes creation of node subscriptions, which are all signed by the node subscriber wallet
+var nodeSubscriptionMutex sync.Mutex
+
+// ConnectDevicesJob runs the slow part of connecting a device to a server: subscribing to the node when there is
+// no active subscription to it yet, and the key exchange. Synchronous connects call Connect right away,
+// asynchronous ones are queued as Connections and processed by Run.
+type ConnectDevicesJob struct {
+	DB       *gorm.DB
+	Logger   *zap.SugaredLogger
+	Sentinel sentinel.SentinelClient
+	Vault    *vault.Vault
+	Events   *events.Bus
+	// Failures of the key exchange are recorded per server, so server selection avoids failing servers
+	Failures *selection.Failures
+	// Quarantine takes nodes which keep failing key exchanges out of the server lists
+	Quarantine *QuarantineNodesJob
+
+	// Concurrency is the number of connections processed at once
+	Concurrency int
+	// Connections which are not finished within Timeout fail, all of them are deleted after TTL
+	Timeout time.Duration
+	TTL     time.Duration
+
+	// Failed attempts fail over to the next candidate server until FailoverAttempts servers were tried
+	// or FailoverBudget has passed since the first attempt. Each attempt is cut at the end of the budget.
+	FailoverAttempts int
+	FailoverBudget   time.Duration
+}
+
+// ConnectResult is the server credentials were created on, after Attempts attempts
+type ConnectResult struct {
+	Server      *models.Server
+	Credentials *sentinel.SentinelCredentials
+	Attempts    int
+}
+
+// Queue saves a connection of the device to the first of servers, the rest are its fallbacks.
+// The connection is picked up by the next run.
+func (job ConnectDevicesJob) Queue(device *models.Device, servers []models.Server) (*models.Connection, error) {
+	if job.FailoverAttempts > 0 && len(servers) > job.FailoverAttempts {
+		servers = servers[:job.FailoverAttempts]
+	}
+
+	fallbackServerIDs := make([]uint, 0, len(servers))
+	for _, server := range servers[1:] {
+		fallbackServerIDs = append(fallbackServerIDs, server.ID)
+	}
+
+	connection := &models.Connection{
+		DeviceID:          device.ID,
+		ServerID:          servers[0].ID,
+		FallbackServerIDs: datatypes.NewJSONType(fallbackServerIDs),
+		Status:            models.ConnectionStatusQueued,
+		ExpiresAt:         time.Now().Add(job.TTL),
+	}
+
+	tx := job.DB.Create(connection)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return connection, nil
+}
+
+func (job ConnectDevicesJob) Run() {
+	job.failStale()
+	job.deleteExpired()
+
+	var connections []models.Connection
+	tx := job.DB.Model(&models.Connection{}).
+		Where("status = ?", models.ConnectionStatusQueued).
+		Order("id").
+		Limit(job.Concurrency).
+		Find(&connections)
+	if tx.Error != nil {
+		job.Logger.Error("failed to get queued connections from the DB: " + tx.Error.Error())
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := range connections {
+		if !job.claim(&connections[i]) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(connection *models.Connection) {
+			defer wg.Done()
+			job.process(connection)
+		}(&connections[i])
+	}
+
+	wg.Wait()
+}
+
+// claim moves a queued connection to the first stage, so it is processed once
+func (job ConnectDevicesJob) claim(connection *models.Connection) bool {
+	tx := job.DB.Model(&models.Connection{}).
+		Where("id = ? AND status = ?", connection.ID, models.ConnectionStatusQueued).
+		Update("status", models.ConnectionStatusSubscribing)
+	if tx.Error != nil {
+		job.Logger.Errorf("failed to claim connection %d: %s", connection.ID, tx.Error)
+		return false
+	}
+
+	if tx.RowsAffected != 1 {
+		return false
+	}
+
+	connection.Status = models.ConnectionStatusSubscribing
+	job.Events.Publish(events.TypeConnection, connection.DeviceID, *connection)
+
+	return true
+}
+
+func (job ConnectDevicesJob) process(connection *models.Connection) {
+	ctx, cancel := context.WithTimeout(context.Background(), job.Timeout)
+	defer cancel()
+
+	var device models.Device
+	tx := job.DB.First(&device, "id = ?", connection.DeviceID)
+	if tx.Error != nil {
+		job.fail(connection, fmt.Errorf("failed to get device: %w", tx.Error))
+		return
+	}
+
+	serverIDs := append([]uint{connection.ServerID}, connection.FallbackServerIDs.Data()...)
+
+	var found []models.Server
+	tx = job.DB.Find(&found, "id IN ?", serverIDs)
+	if tx.Error != nil {
+		job.fail(connection, fmt.Errorf("failed to get servers: %w", tx.Error))
+		return
+	}
+
+	// Servers are tried in the order they were ranked in, fallbacks removed since then are skipped
+	servers := make([]models.Server, 0, len(found))
+	for _, id := range serverIDs {
+		for _, server := range found {
+			if server.ID == id {
+				servers = append(servers, server)
+			}
+		}
+	}
+
+	if len(servers) == 0 {
+		job.fail(connection, errors.New("servers of connection not found"))
+		return
+	}
+
+	result, err := job.Connect(ctx, &device, servers, connection)
+	if err != nil {
+		job.fail(connection, err)
+		return
+	}
+
+	sealed, err := json.Marshal(result.Credentials)
+	if err != nil {
+		job.fail(connection, err)
+		return
+	}
+
+	err = job.Vault.SealConnectionCredentials(connection, sealed)
+	if err != nil {
+		job.fail(connection, fmt.Errorf("failed to seal credentials: %w", err))
+		return
+	}
+
+	now := time.Now()
+	connection.Status = models.ConnectionStatusReady
+	connection.ReadyAt = &now
+	err = job.save(connection, "status", "ready_at", "credentials_ciphertext", "credentials_data_key", "credentials_key_version")
+	if err != nil {
+		job.fail(connection, err)
+		return
+	}
+
+	job.Logger.Infof("connection %d of device %d to server %d is ready in %s after %d attempts", connection.ID, device.ID, connection.ServerID, time.Since(connection.CreatedAt), connection.Attempts)
+}
+
+// Connect creates credentials of the device on the first of servers it succeeds on. When the node subscription
+// or the node itself fails the key exchange, the next server is tried while the failover limits allow. Other
+// key exchange failures, e.g. of the chain starting the session, would repeat on every server and are returned
+// right away. Every attempt is recorded as a ConnectionAttempt. Stages of an asynchronous connection are saved and published as they are reached,
+// connection is nil for synchronous connects.
+func (job ConnectDevicesJob) Connect(ctx context.Context, device *models.Device, servers []models.Server, connection *models.Connection) (*ConnectResult, error) {
+	if device.SubscriptionId == nil {
+		return nil, errors.New("wallet " + device.WalletAddress + " is not yet enrolled")
+	}
+
+	signer, err := job.deviceSigner(device)
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	var lastErr error
+	for i := range servers {
+		server := &servers[i]
+
+		remaining := job.FailoverBudget - time.Since(started)
+		if i > 0 && (i >= job.FailoverAttempts || remaining <= 0) {
+			break
+		}
+
+		if i > 0 {
+			job.Logger.Warnf("failing over connection of device %d to server %d after: %s", device.ID, server.ID, lastErr)
+		}
+
+		credentials, stage, err := job.attemptWithin(ctx, remaining, device, server, signer, connection)
+		if err == nil {
+			return &ConnectResult{Server: server, Credentials: credentials, Attempts: i + 1}, nil
+		}
+
+		lastErr = err
+		if stage == models.ConnectionAttemptStageKeyExchange && !sentinel.IsNodeError(err) {
+			break
+		}
+	}
+
+	return nil, lastErr
+}
+
+// attemptWithin is attempt cut after timeout, if there is one
+func (job ConnectDevicesJob) attemptWithin(ctx context.Context, timeout time.Duration, device *models.Device, server *models.Server, signer *sentinel.Signer, connection *models.Connection) (*sentinel.SentinelCredentials, models.ConnectionAttemptStage, error) {
+	if job.FailoverBudget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return job.attempt(ctx, device, server, signer, connection)
+}
+
+// attempt subscribes to the node of the server if needed and exchanges keys with it, recording the attempt.
+// It returns the stage the attempt reached.
+func (job ConnectDevicesJob) attempt(ctx context.Context, device *models.Device, server *models.Server, signer *sentinel.Signer, connection *models.Connection) (*sentinel.SentinelCredentials, models.ConnectionAttemptStage, error) {
+	started := time.Now()
+	record := models.ConnectionAttempt{
+		DeviceID: device.ID,
+		ServerID: server.ID,
+		Stage:    models.ConnectionAttemptStageSubscription,
+	}
+
+	if connection != nil {
+		record.ConnectionID = &connection.ID
+		connection.ServerID = server.ID
+		connection.Attempts++
+		connection.Status = models.ConnectionStatusSubscribing
+		job.saveStage(connection, "server_id", "attempts", "status")
+	}
+
+	finish := func(err error) {
+		record.Duration = time.Since(started).Milliseconds()
+		if err != nil {
+			reason := err.Error()
+			record.Error = &reason
+		}
+
+		tx := job.DB.Create(&record)
+		if tx.Error != nil {
+			job.Logger.Errorf("failed to record connection attempt of device %d to server %d: %s", device.ID, server.ID, tx.Error)
+		}
+	}
+
+	err := job.ensureNodeSubscription(ctx, server.Configuration.Data().Address)
+	if err != nil {
+		finish(err)
+		return nil, record.Stage, err
+	}
+
+	record.Stage = models.ConnectionAttemptStageKeyExchange
+	if connection != nil {
+		now := time.Now()
+		connection.Status = models.ConnectionStatusExchangingKeys
+		connection.SubscribedAt = &now
+		job.saveStage(connection, "status", "subscribed_at")
+	}
+
+	credentials, err := job.Sentinel.CreateCredentials(ctx, sessionRequest(device, server, signer))
+	job.RecordKeyExchange(server, err)
+	if err != nil {
+		err = fmt.Errorf("failed to create sentinel credentials: %w", err)
+	} else {
+		job.Reserve(server)
+	}
+
+	finish(err)
+	return credentials, record.Stage, err
+}
+
+// RecordKeyExchange counts the outcome of a key exchange with the node of the server into its failure rate
+// and quarantine, err is nil when it succeeded. Only failures of the node itself are counted, errors of
+// the chain, of the circuit breaker or of the caller giving up say nothing about the node.
+func (job ConnectDevicesJob) RecordKeyExchange(server *models.Server, err error) {
+	if err != nil && !sentinel.IsNodeError(err) {
+		return
+	}
+
+	job.Failures.Record(server.ID, err)
+	job.Quarantine.Record(server.Configuration.Data().Address, err)
+}
+
+// Reserve counts a peer issued credentials on the server into its load until the next node status
+func (job ConnectDevicesJob) Reserve(server *models.Server) {
+	tx := job.DB.Model(&models.Server{}).Where("id = ?", server.ID).Updates(models.ReserveColumns(time.Now()))
+	if tx.Error != nil {
+		job.Logger.Warnf("failed to reserve capacity of server %d: %s", server.ID, tx.Error)
+	}
+}
+
+// Prepare makes sure there is an active subscription to the node of the server
+// and builds the request which starts a session of the device on it.
+func (job ConnectDevicesJob) Prepare(ctx context.Context, device *models.Device, server *models.Server) (*sentinel.SentinelCredentialsRequest, error) {
+	if device.SubscriptionId == nil {
+		return nil, errors.New("wallet " + device.WalletAddress + " is not yet enrolled")
+	}
+
+	err := job.ensureNodeSubscription(ctx, server.Configuration.Data().Address)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := job.deviceSigner(device)
+	if err != nil {
+		return nil, err
+	}
+
+	request := sessionRequest(device, server, signer)
+	return &request, nil
+}
+
+func (job ConnectDevicesJob) deviceSigner(device *models.Device) (*sentinel.Signer, error) {
+	entropy, err := job.Vault.OpenDeviceEntropy(device)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt wallet entropy: %w", err)
+	}
+
+	signer, err := sentinel.SignerForEntropy(entropy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive wallet key of device: %w", err)
+	}
+
+	return signer, nil
+}
+
+// NodeType returns the Sentinel node type of the server protocol
+func NodeType(server *models.Server) int64 {
+	if server.Protocols.Data()[0] == models.ServerProtocolWireGuard {
+		return sentinel.SentinelNodeTypeWireGuard
+	}
+
+	return sentinel.SentinelNodeTypeV2Ray
+}
+
+func sessionRequest(device *models.Device, server *models.Server, signer *sentinel.Signer) sentinel.SentinelCredentialsRequest {
+	return sentinel.SentinelCredentialsRequest{
+		NodeAddress:    server.Configuration.Data().Address,
+		RemoteURL:      server.Configuration.Data().RemoteURL,
+		NodeType:       NodeType(server),
+		SubscriptionID: *device.SubscriptionId,
+		Signer:         signer,
+	}
+}
+
+func (job ConnectDevicesJob) ensureNodeSubscription(ctx context.Context, nodeAddress string) error {
+	nodeSubscriptionMutex.Lock()
+	defer nodeSubscriptionMutex.Unlock()
+
+	var sentinelNodeSubscription models.SentinelNodeSubscription
+	tx := job.DB.Model(&models.SentinelNodeSubscription{}).Order("id desc").First(&sentinelNodeSubscription, "node_address = ? AND inactive_at > ?", nodeAddress, time.Now())
+	if tx.Error == nil {
+		return nil
+	}
+
+	if !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to get sentinel node subscription from DB: %w", tx.Error)
+	}
+
+	hours, err := strconv.ParseInt(os.Getenv("SENTINEL_NODE_HOURS"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("failed to parse sentinel node hours: %w", err)
+	}
+
+	s, err := job.Sentinel.CreateNodeSubscription(ctx, nodeAddress, 0, hours)
+	if err != nil {
+		return fmt.Errorf("failed to create sentinel node subscription: %w", err)
+	}
+
+	tx = job.DB.Create(&models.SentinelNodeSubscription{
+		ID:          s.Base.ID,
+		NodeAddress: s.NodeAddress,
+		InactiveAt:  s.Base.InactiveAt,
+	})
+	if tx.Error != nil {
+		return fmt.Errorf("failed to save create sentinel node subscription to the DB: %w", tx.Error)
+	}
+
+	return nil
+}
+
+// saveStage saves a stage the connection has reached, failing to save it doesn't stop the connection
+func (job ConnectDevicesJob) saveStage(connection *models.Connection, columns ...string) {
+	err := job.save(connection, columns...)
+	if err != nil {
+		job.Logger.Warnf("failed to save stage of connection %d: %s", connection.ID, err)
+	}
+}
+
+// save updates columns of the connection and publishes its new stage
+func (job ConnectDevicesJob) save(connection *models.Connection, columns ...string) error {
+	tx := job.DB.Model(connection).Select(columns).Updates(connection)
+	if tx.Error != nil {
+		return fmt.Errorf("failed to update connection: %w", tx.Error)
+	}
+
+	job.Events.Publish(events.TypeConnection, connection.DeviceID, *connection)
+	return nil
+}
+
+func (job ConnectDevicesJob) fail(connection *models.Connection, err error) {
+	job.Logger.Errorf("connection %d of device %d to server %d failed: %s", connection.ID, connection.DeviceID, connection.ServerID, err)
+
+	reason := err.Error()
+	now := time.Now()
+	connection.Status = models.ConnectionStatusFailed
+	connection.Error = &reason
+	connection.FailedAt = &now
+
+	err = job.save(connection, "status", "error", "failed_at")
+	if err != nil {
+		job.Logger.Errorf("failed to mark connection %d as failed: %s", connection.ID, err)
+	}
+}
+
+// failStale fails connections which were left unfinished, e.g. when the API was restarted while processing them
+func (job ConnectDevicesJob) failStale() {
+	tx := job.DB.Model(&models.Connection{}).
+		Where("status IN ? AND created_at < ?", []models.ConnectionStatus{models.ConnectionStatusQueued, models.ConnectionStatusSubscribing, models.ConnectionStatusExchangingKeys}, time.Now().Add(-2*job.Timeout)).
+		Updates(map[string]interface{}{
+			"status":    models.ConnectionStatusFailed,
+			"error":     "connection timed out",
+			"failed_at": time.Now(),
+		})
+	if tx.Error != nil {
+		job.Logger.Error("failed to fail stale connections: " + tx.Error.Error())
+		return
+	}
+
+	if tx.RowsAffected > 0 {
+		job.Logger.Warnf("failed %d stale connections", tx.RowsAffected)
+	}
+}
+
+func (job ConnectDevicesJob) deleteExpired() {
+	tx := job.DB.Where("expires_at < ?", time.Now()).Delete(&models.Connection{})
+	if tx.Error != nil {
+		job.Logger.Error("failed to delete expired connections: " + tx.Error.Error())
+	}
+}
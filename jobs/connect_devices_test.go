@@ -0,0 +1,428 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"dvpn/internal/events"
+	"dvpn/internal/selection"
+	"dvpn/internal/sentinel"
+	"dvpn/internal/vault"
+	"dvpn/models"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+)
+
+func TestNodeType(t *testing.T) {
+	tests := []struct {
+		protocol models.ServerProtocol
+		want     int64
+	}{
+		{models.ServerProtocolWireGuard, sentinel.SentinelNodeTypeWireGuard},
+		{models.ServerProtocolV2Ray, sentinel.SentinelNodeTypeV2Ray},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.protocol), func(t *testing.T) {
+			server := &models.Server{Protocols: datatypes.NewJSONType([]models.ServerProtocol{tt.protocol})}
+			if got := NodeType(server); got != tt.want {
+				t.Errorf("NodeType = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordKeyExchange(t *testing.T) {
+	server := &models.Server{Configuration: datatypes.NewJSONType(models.ServerConfiguration{Address: testNodeAddress})}
+	server.ID = 1
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	nodeErr := &sentinel.NodeError{Err: errors.New("connection refused")}
+
+	tests := []struct {
+		name     string
+		err      error
+		expect   func(mock sqlmock.Sqlmock)
+		recorded bool
+	}{
+		{"success", nil, expectSuccess, true},
+		{"node failure", fmt.Errorf("failed to create sentinel credentials: %w", nodeErr), expectFailure, true},
+		{"chain failure", &sentinel.APIError{Source: "Sentinel API", Code: 11, Message: "out of gas"}, func(mock sqlmock.Sqlmock) {}, false},
+		{"sequence mismatch", &sentinel.APIError{Source: "Sentinel API", Code: 32, Message: "account sequence mismatch"}, func(mock sqlmock.Sqlmock) {}, false},
+		{"open circuit", sentinel.ErrCircuitOpen, func(mock sqlmock.Sqlmock) {}, false},
+		{"cancelled", cancelled.Err(), func(mock sqlmock.Sqlmock) {}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			tt.expect(mock)
+
+			failures := selection.NewFailures(time.Hour)
+			job := ConnectDevicesJob{
+				Failures:   failures,
+				Quarantine: &QuarantineNodesJob{DB: db, Logger: zap.NewNop().Sugar(), Threshold: 3, Cooldown: time.Minute},
+			}
+
+			// Failures tell a rate only after a few attempts
+			for i := 0; i < 3; i++ {
+				job.Failures.Record(server.ID, nil)
+			}
+
+			job.RecordKeyExchange(server, tt.err)
+
+			wantRate := 0.0
+			if tt.recorded && tt.err != nil {
+				wantRate = 0.25
+			}
+
+			if rate := failures.Rate(server.ID); rate != wantRate {
+				t.Errorf("failure rate = %v, want %v", rate, wantRate)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// connectFixture is a device with a wallet sealed in the vault and servers of the first nodes of the fake Sentinel
+type connectFixture struct {
+	fake    *sentinel.FakeSentinel
+	vault   *vault.Vault
+	device  models.Device
+	servers []models.Server
+}
+
+func newConnectFixture(t *testing.T, nodes int, enroll bool) connectFixture {
+	ctx := context.Background()
+
+	v, err := vault.New(map[int][]byte{1: bytes.Repeat([]byte{1}, 32)}, 1)
+	if err != nil {
+		t.Fatalf("failed to create vault: %s", err)
+	}
+
+	entropy := bytes.Repeat([]byte{7}, 32)
+	signer, err := sentinel.NewSignerFromEntropy(entropy)
+	if err != nil {
+		t.Fatalf("failed to create signer: %s", err)
+	}
+
+	device := models.Device{WalletAddress: signer.Address}
+	device.ID = 1
+	if err := v.SealDeviceEntropy(&device, entropy); err != nil {
+		t.Fatalf("failed to seal entropy: %s", err)
+	}
+
+	fake := sentinel.NewFakeSentinel(sentinel.FakeSentinelConfig{ProviderPlanID: "1", ProviderWalletAddress: "sentprov1provider", Nodes: nodes, Seed: 1})
+
+	subscription, err := fake.CreatePlanSubscription(ctx)
+	if err != nil {
+		t.Fatalf("failed to subscribe to plan: %s", err)
+	}
+	device.SubscriptionId = &subscription.Base.ID
+
+	if enroll {
+		if _, err := fake.EnrollWalletToSubscription(ctx, []sentinel.SentinelWalletAllocation{{Address: signer.Address, Bytes: 1 << 30}}, subscription.Base.ID); err != nil {
+			t.Fatalf("failed to enroll wallet: %s", err)
+		}
+
+		if _, err := fake.GrantFeeToWallet(ctx, []string{signer.Address}); err != nil {
+			t.Fatalf("failed to grant fee: %s", err)
+		}
+	}
+
+	page, err := fake.FetchNodes(ctx, sentinel.PageRequest{Limit: nodes})
+	if err != nil {
+		t.Fatalf("failed to fetch nodes: %s", err)
+	}
+
+	var servers []models.Server
+	for i, node := range page.Items {
+		server := models.Server{
+			Protocols:     datatypes.NewJSONType([]models.ServerProtocol{models.ServerProtocolWireGuard}),
+			Configuration: datatypes.NewJSONType(models.ServerConfiguration{Address: node.Address, RemoteURL: node.RemoteURL}),
+		}
+		server.ID = uint(i + 1)
+		servers = append(servers, server)
+	}
+
+	return connectFixture{fake: fake, vault: v, device: device, servers: servers}
+}
+
+// expectAttempt expects an attempt on a server which is already subscribed to, reaching the key exchange
+func expectAttempt(mock sqlmock.Sqlmock, serverID uint, succeeds bool) {
+	mock.ExpectQuery(`SELECT \* FROM "sentinel_node_subscriptions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "node_address", "inactive_at"}).AddRow(1, "sentnode1node", time.Now().Add(time.Hour)))
+
+	if succeeds {
+		mock.ExpectExec(`UPDATE "servers" SET "reserved_at"=\$1,"reserved_peers"=CASE .* WHERE id = \$\d+`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	mock.ExpectQuery(`INSERT INTO "connection_attempts"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1, serverID, nil, models.ConnectionAttemptStageKeyExchange, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
+
+func TestConnectFailover(t *testing.T) {
+	tests := []struct {
+		name          string
+		enroll        bool
+		unhealthy     []int
+		attempts      int
+		budget        time.Duration
+		expect        func(mock sqlmock.Sqlmock)
+		want          uint
+		wantNodeError bool
+	}{
+		{"first server", true, nil, 3, time.Minute, func(mock sqlmock.Sqlmock) {
+			expectAttempt(mock, 1, true)
+		}, 1, false},
+		{"node failure fails over to the next server", true, []int{0}, 3, time.Minute, func(mock sqlmock.Sqlmock) {
+			expectAttempt(mock, 1, false)
+			expectAttempt(mock, 2, true)
+		}, 2, false},
+		{"chain failure is not failed over", false, nil, 3, time.Minute, func(mock sqlmock.Sqlmock) {
+			expectAttempt(mock, 1, false)
+		}, 0, false},
+		{"attempts exhausted", true, []int{0, 1}, 2, time.Minute, func(mock sqlmock.Sqlmock) {
+			expectAttempt(mock, 1, false)
+			expectAttempt(mock, 2, false)
+		}, 0, true},
+		{"budget exhausted", true, []int{0}, 3, time.Nanosecond, func(mock sqlmock.Sqlmock) {
+			expectAttempt(mock, 1, false)
+		}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixture := newConnectFixture(t, 3, tt.enroll)
+			for _, server := range fixture.servers {
+				fixture.fake.SetNodeHealthy(server.Configuration.Data().Address, true)
+			}
+			for _, i := range tt.unhealthy {
+				fixture.fake.SetNodeHealthy(fixture.servers[i].Configuration.Data().Address, false)
+			}
+
+			db, mock := newMockDB(t)
+			tt.expect(mock)
+
+			job := ConnectDevicesJob{
+				DB:               db,
+				Logger:           zap.NewNop().Sugar(),
+				Sentinel:         fixture.fake,
+				Vault:            fixture.vault,
+				Failures:         selection.NewFailures(time.Hour),
+				FailoverAttempts: tt.attempts,
+				FailoverBudget:   tt.budget,
+			}
+
+			result, err := job.Connect(context.Background(), &fixture.device, fixture.servers, nil)
+
+			if tt.want != 0 {
+				if err != nil {
+					t.Fatalf("failed to connect: %s", err)
+				}
+
+				if result.Server.ID != tt.want || result.Attempts != int(tt.want) || result.Credentials.Result == "" {
+					t.Errorf("connected to server %d after %d attempts, want server %d", result.Server.ID, result.Attempts, tt.want)
+				}
+			} else {
+				if err == nil {
+					t.Fatalf("connected to server %d, want error", result.Server.ID)
+				}
+
+				if sentinel.IsNodeError(err) != tt.wantNodeError {
+					t.Errorf("IsNodeError(%v) = %t, want %t", err, sentinel.IsNodeError(err), tt.wantNodeError)
+				}
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestQueue(t *testing.T) {
+	fixture := newConnectFixture(t, 3, true)
+
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`INSERT INTO "connections"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), fixture.device.ID, 1, `[2]`, 0, models.ConnectionStatusQueued, nil, nil, nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), 0, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+
+	job := ConnectDevicesJob{DB: db, Logger: zap.NewNop().Sugar(), FailoverAttempts: 2, TTL: time.Minute}
+
+	connection, err := job.Queue(&fixture.device, fixture.servers)
+	if err != nil {
+		t.Fatalf("failed to queue connection: %s", err)
+	}
+
+	if connection.ID != 7 || connection.ServerID != 1 || len(connection.FallbackServerIDs.Data()) != 1 {
+		t.Errorf("queued connection %d to server %d with fallbacks %v, want 7 to 1 with [2]", connection.ID, connection.ServerID, connection.FallbackServerIDs.Data())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	tests := []struct {
+		name    string
+		claimed int64
+		want    bool
+	}{
+		{"queued", 1, true},
+		{"claimed by another run", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectExec(`UPDATE "connections" SET "status"=\$1,"updated_at"=\$2 WHERE id = \$3 AND status = \$4`).
+				WithArgs(models.ConnectionStatusSubscribing, sqlmock.AnyArg(), 7, models.ConnectionStatusQueued).
+				WillReturnResult(sqlmock.NewResult(0, tt.claimed))
+
+			connection := &models.Connection{Status: models.ConnectionStatusQueued}
+			connection.ID = 7
+
+			job := ConnectDevicesJob{DB: db, Logger: zap.NewNop().Sugar()}
+			if got := job.claim(connection); got != tt.want {
+				t.Errorf("claim = %t, want %t", got, tt.want)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name   string
+		enroll bool
+		expect func(mock sqlmock.Sqlmock)
+		want   []models.ConnectionStatus
+	}{
+		{"ready", true, func(mock sqlmock.Sqlmock) {
+			expectConnectionAttempt(mock, true)
+		}, []models.ConnectionStatus{models.ConnectionStatusSubscribing, models.ConnectionStatusExchangingKeys, models.ConnectionStatusReady}},
+		{"failed", false, func(mock sqlmock.Sqlmock) {
+			expectConnectionAttempt(mock, false)
+		}, []models.ConnectionStatus{models.ConnectionStatusSubscribing, models.ConnectionStatusExchangingKeys, models.ConnectionStatusFailed}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixture := newConnectFixture(t, 1, tt.enroll)
+			server := fixture.servers[0]
+			fixture.fake.SetNodeHealthy(server.Configuration.Data().Address, true)
+
+			db, mock := newMockDB(t)
+			device := fixture.device
+			mock.ExpectQuery(`SELECT \* FROM "devices" WHERE id = \$1`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "wallet_address", "wallet_entropy", "wallet_data_key", "wallet_key_version", "subscription_id"}).
+					AddRow(device.ID, device.WalletAddress, device.WalletEntropy, device.WalletDataKey, device.WalletKeyVersion, *device.SubscriptionId))
+
+			protocols, _ := server.Protocols.MarshalJSON()
+			configuration, _ := server.Configuration.MarshalJSON()
+			mock.ExpectQuery(`SELECT \* FROM "servers" WHERE id IN \(\$1\)`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "protocols", "configuration"}).AddRow(server.ID, protocols, configuration))
+
+			tt.expect(mock)
+
+			bus := events.NewBus()
+			stream, unsubscribe := bus.Subscribe(device.ID)
+			defer unsubscribe()
+
+			job := ConnectDevicesJob{
+				DB:               db,
+				Logger:           zap.NewNop().Sugar(),
+				Sentinel:         fixture.fake,
+				Vault:            fixture.vault,
+				Events:           bus,
+				Timeout:          time.Minute,
+				FailoverAttempts: 1,
+			}
+
+			connection := &models.Connection{DeviceID: device.ID, ServerID: server.ID, Status: models.ConnectionStatusSubscribing}
+			connection.ID = 7
+			job.process(connection)
+
+			var published []models.ConnectionStatus
+			for len(stream) > 0 {
+				event := <-stream
+				published = append(published, event.Data.(models.Connection).Status)
+			}
+
+			if !reflect.DeepEqual(published, tt.want) {
+				t.Errorf("published stages %v, want %v", published, tt.want)
+			}
+
+			if connection.Status == models.ConnectionStatusReady && len(connection.CredentialsCiphertext) == 0 {
+				t.Errorf("credentials of ready connection are not sealed")
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// expectConnectionAttempt expects the stages of an attempt of a connection to a subscribed server to be saved,
+// and then the connection to be saved as ready when it succeeds or as failed
+func expectConnectionAttempt(mock sqlmock.Sqlmock, succeeds bool) {
+	mock.ExpectExec(`UPDATE "connections" SET "updated_at"=\$1,"server_id"=\$2,"attempts"=\$3,"status"=\$4 WHERE "id" = \$5`).
+		WithArgs(sqlmock.AnyArg(), 1, 1, models.ConnectionStatusSubscribing, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT \* FROM "sentinel_node_subscriptions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "node_address", "inactive_at"}).AddRow(1, "sentnode1node", time.Now().Add(time.Hour)))
+	mock.ExpectExec(`UPDATE "connections" SET "updated_at"=\$1,"status"=\$2,"subscribed_at"=\$3 WHERE "id" = \$4`).
+		WithArgs(sqlmock.AnyArg(), models.ConnectionStatusExchangingKeys, sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if succeeds {
+		mock.ExpectExec(`UPDATE "servers" SET "reserved_at"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	mock.ExpectQuery(`INSERT INTO "connection_attempts"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	if succeeds {
+		mock.ExpectExec(`UPDATE "connections" SET "updated_at"=\$1,"status"=\$2,"ready_at"=\$3,"credentials_ciphertext"=\$4,"credentials_data_key"=\$5,"credentials_key_version"=\$6 WHERE "id" = \$7`).
+			WithArgs(sqlmock.AnyArg(), models.ConnectionStatusReady, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1, 7).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	} else {
+		mock.ExpectExec(`UPDATE "connections" SET "updated_at"=\$1,"status"=\$2,"error"=\$3,"failed_at"=\$4 WHERE "id" = \$5`).
+			WithArgs(sqlmock.AnyArg(), models.ConnectionStatusFailed, sqlmock.AnyArg(), sqlmock.AnyArg(), 7).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+}
+
+func TestFailStale(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectExec(`UPDATE "connections" SET "error"=\$1,"failed_at"=\$2,"status"=\$3,"updated_at"=\$4 WHERE status IN \(\$5,\$6,\$7\) AND created_at < \$8`).
+		WithArgs("connection timed out", sqlmock.AnyArg(), models.ConnectionStatusFailed, sqlmock.AnyArg(),
+			models.ConnectionStatusQueued, models.ConnectionStatusSubscribing, models.ConnectionStatusExchangingKeys, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	job := ConnectDevicesJob{DB: db, Logger: zap.NewNop().Sugar(), Timeout: time.Minute}
+	job.failStale()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
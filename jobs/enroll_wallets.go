@@ -1,6 +1,8 @@
 package jobs
 
 import (
+	"context"
+	"dvpn/internal/events"
 	"dvpn/internal/sentinel"
 	"dvpn/models"
 	"errors"
@@ -12,10 +14,13 @@ import (
 type EnrollWalletsJob struct {
 	DB       *gorm.DB
 	Logger   *zap.SugaredLogger
-	Sentinel *sentinel.Sentinel
+	Sentinel sentinel.SentinelClient
+	Quotas   QuotaPolicies
+	Events   *events.Bus
 }
 
 func (job EnrollWalletsJob) Run() {
+	ctx := context.Background()
 
 	now := time.Now()
 	inactivityThreshold := now.Add(time.Duration(24) * time.Hour)
@@ -24,7 +29,7 @@ func (job EnrollWalletsJob) Run() {
 	tx := job.DB.Model(&models.SentinelPlanSubscription{}).Order("id desc").First(&sentinelPlanSubscription, "inactive_at > ?", inactivityThreshold)
 	if tx.Error != nil {
 		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
-			s, err := job.Sentinel.CreatePlanSubscription()
+			s, err := job.Sentinel.CreatePlanSubscription(ctx)
 			if err != nil {
 				job.Logger.Error("failed to create sentinel plan subscription: " + err.Error())
 				return
@@ -47,34 +52,63 @@ func (job EnrollWalletsJob) Run() {
 	}
 
 	var devices []models.Device
-	tx = job.DB.Model(&models.Device{}).Order("id desc").Limit(15).Where("subscription_id IS DISTINCT FROM ?", sentinelPlanSubscription.ID).Find(&devices)
+	tx = job.DB.Model(&models.Device{}).Order("id desc").Limit(15).Where("subscription_id IS DISTINCT FROM ? AND enrollment_tx_hash IS NULL AND allocation_tx_hash IS NULL", sentinelPlanSubscription.ID).Find(&devices)
 	if tx.Error != nil {
 		job.Logger.Error("failed to get sentinel wallets from the DB: " + tx.Error.Error())
 		return
 	}
 
-	var walletAddresses []string = make([]string, 0)
+	if len(devices) == 0 {
+		return
+	}
 
+	var deviceIDs []uint = make([]uint, 0)
 	for _, device := range devices {
-		walletAddresses = append(walletAddresses, device.WalletAddress)
+		deviceIDs = append(deviceIDs, device.ID)
 	}
 
-	if len(walletAddresses) == 0 {
+	quotas, err := job.Quotas.Resolve(job.DB, deviceIDs)
+	if err != nil {
+		job.Logger.Error("failed to resolve quotas of sentinel wallets: " + err.Error())
 		return
 	}
 
-	err := job.Sentinel.EnrollWalletToSubscription(walletAddresses, sentinelPlanSubscription.ID)
+	var allocations []sentinel.SentinelWalletAllocation = make([]sentinel.SentinelWalletAllocation, 0)
+	for _, device := range devices {
+		allocations = append(allocations, sentinel.SentinelWalletAllocation{
+			Address: device.WalletAddress,
+			Bytes:   quotas[device.ID],
+		})
+	}
+
+	receipt, err := job.Sentinel.EnrollWalletToSubscription(ctx, allocations, sentinelPlanSubscription.ID)
 	if err != nil {
 		job.Logger.Error("failed to enroll sentinel wallets to subscription: " + err.Error())
 		return
 	}
 
-	for _, device := range devices {
-		device.SubscriptionId = &sentinelPlanSubscription.ID
-		tx := job.DB.Save(&device)
-		if tx.Error != nil {
-			job.Logger.Error("failed to update device subscription ID: " + tx.Error.Error())
-			continue
+	err = job.DB.Transaction(func(db *gorm.DB) error {
+		err := createPendingTransaction(db, receipt, models.SentinelTransactionKindEnrollment, &sentinelPlanSubscription.ID)
+		if err != nil {
+			return err
 		}
+
+		for _, device := range devices {
+			err := markPendingQuota(db, device.ID, "enrollment_tx_hash", receipt.TxHash, quotas[device.ID])
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		job.Logger.Errorf("failed to save enrollment transaction %s to the DB: %s", receipt.TxHash, err)
+		return
+	}
+
+	err = publishEnrollments(job.Events, job.DB, deviceIDs)
+	if err != nil {
+		job.Logger.Warnf("failed to publish enrollment of devices %v: %s", deviceIDs, err)
 	}
 }
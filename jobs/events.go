@@ -0,0 +1,26 @@
+package jobs
+
+import (
+	"dvpn/internal/events"
+	"dvpn/models"
+	"gorm.io/gorm"
+)
+
+// publishEnrollments publishes the current enrollment of devices, it should be called once changes to them are committed
+func publishEnrollments(bus *events.Bus, db *gorm.DB, deviceIDs []uint) error {
+	if bus == nil || len(deviceIDs) == 0 {
+		return nil
+	}
+
+	var devices []models.Device
+	tx := db.Find(&devices, "id IN ?", deviceIDs)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	for _, device := range devices {
+		bus.Publish(events.TypeEnrollment, device.ID, device.Enrollment())
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package jobs
+
+import (
+	"dvpn/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ExpireBansJob lifts bans of devices and servers once their expiry has passed.
+// Unbanned servers are linked back to the plan by LinkNodesWithPlanJob.
+type ExpireBansJob struct {
+	DB     *gorm.DB
+	Logger *zap.SugaredLogger
+}
+
+func (job ExpireBansJob) Run() {
+	now := time.Now()
+
+	for _, model := range []interface{}{&models.Device{}, &models.Server{}} {
+		tx := job.DB.Model(model).
+			Where("is_banned = ? AND ban_expires_at IS NOT NULL AND ban_expires_at <= ?", true, now).
+			Updates(models.BanColumns(models.Ban{}))
+		if tx.Error != nil {
+			job.Logger.Errorf("failed to lift expired bans of %T: %s", model, tx.Error)
+			continue
+		}
+
+		if tx.RowsAffected > 0 {
+			job.Logger.Infof("lifted %d expired bans of %T", tx.RowsAffected, model)
+		}
+	}
+}
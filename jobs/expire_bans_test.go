@@ -0,0 +1,51 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+)
+
+func TestExpireBans(t *testing.T) {
+	tests := []struct {
+		name   string
+		expect func(mock sqlmock.Sqlmock)
+	}{
+		{"devices and servers", func(mock sqlmock.Sqlmock) {
+			expectBansLifted(mock, "devices", nil)
+			expectBansLifted(mock, "servers", nil)
+		}},
+		{"servers after devices failed", func(mock sqlmock.Sqlmock) {
+			expectBansLifted(mock, "devices", errors.New("connection reset"))
+			expectBansLifted(mock, "servers", nil)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			tt.expect(mock)
+
+			ExpireBansJob{DB: db, Logger: zap.NewNop().Sugar()}.Run()
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// expectBansLifted expects expired bans of the table to be lifted, failing with err when it is set
+func expectBansLifted(mock sqlmock.Sqlmock, table string, err error) {
+	expectation := mock.ExpectExec(`UPDATE "`+table+`" SET "ban_expires_at"=\$1,"ban_reason"=\$2,"banned_at"=\$3,"banned_by"=\$4,"is_banned"=\$5,"updated_at"=\$6 WHERE is_banned = \$7 AND ban_expires_at IS NOT NULL AND ban_expires_at <= \$8`).
+		WithArgs(nil, nil, nil, nil, false, sqlmock.AnyArg(), true, sqlmock.AnyArg())
+
+	if err != nil {
+		expectation.WillReturnError(err)
+		return
+	}
+
+	expectation.WillReturnResult(sqlmock.NewResult(0, 2))
+}
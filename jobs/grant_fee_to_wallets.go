@@ -1,6 +1,8 @@
 package jobs
 
 import (
+	"context"
+	"dvpn/internal/events"
 	"dvpn/internal/sentinel"
 	"dvpn/models"
 	"go.uber.org/zap"
@@ -10,89 +12,101 @@ import (
 type GrantFeeToWalletsJob struct {
 	DB       *gorm.DB
 	Logger   *zap.SugaredLogger
-	Sentinel *sentinel.Sentinel
+	Sentinel sentinel.SentinelClient
+	Events   *events.Bus
 }
 
 func (job GrantFeeToWalletsJob) Run() {
+	ctx := context.Background()
+
 	var devices []models.Device
-	tx := job.DB.Model(&models.Device{}).Order("created_at").Limit(5).Find(&devices, "is_fee_granted = ?", false)
+	tx := job.DB.Model(&models.Device{}).Order("created_at").Limit(5).Find(&devices, "is_fee_granted = ? AND fee_grant_tx_hash IS NULL", false)
 	if tx.Error != nil {
 		job.Logger.Error("failed to get sentinel wallets from the DB: " + tx.Error.Error())
 		return
 	}
 
+	if len(devices) == 0 {
+		return
+	}
+
 	job.Logger.Infof("fetching grant fee allowances from Sentinel")
-	existingAllowances, err := job.fetchAllowances()
+	grantedWallets, err := job.findGrantedWallets(ctx, devices)
 	if err != nil {
 		job.Logger.Errorw("failed to fetch grant fee allowances from Sentinel", "error", err)
 		return
 	}
 
 	var walletAddresses []string = make([]string, 0)
+	var deviceIDs []uint = make([]uint, 0)
 
 	for _, device := range devices {
-		for _, allowance := range *existingAllowances {
-			if allowance.Grantee == device.WalletAddress {
-				device.IsFeeGranted = true
-				tx = job.DB.Save(&device)
-				if tx.Error != nil {
-					job.Logger.Error("failed to update device Sentinel existing `is_fee_grant` status: " + tx.Error.Error())
-					continue
-				}
+		if grantedWallets[device.WalletAddress] {
+			device.IsFeeGranted = true
+			tx = job.DB.Save(&device)
+			if tx.Error != nil {
+				job.Logger.Error("failed to update device Sentinel existing `is_fee_grant` status: " + tx.Error.Error())
+			} else {
+				job.Events.Publish(events.TypeEnrollment, device.ID, device.Enrollment())
 			}
+			continue
 		}
 
 		job.Logger.Infof("Sentinel wallet %s will be granted fee.", device.WalletAddress)
 		walletAddresses = append(walletAddresses, device.WalletAddress)
+		deviceIDs = append(deviceIDs, device.ID)
 	}
 
 	if len(walletAddresses) == 0 {
 		return
 	}
 
-	err = job.Sentinel.GrantFeeToWallet(walletAddresses)
+	receipt, err := job.Sentinel.GrantFeeToWallet(ctx, walletAddresses)
 	if err != nil {
 		job.Logger.Error("failed to grant fee to sentinel wallets: " + err.Error())
 		return
 	}
 
-	for _, device := range devices {
-		device.IsFeeGranted = true
-		tx = job.DB.Save(&device)
-		if tx.Error != nil {
-			job.Logger.Error("failed to update device `is_fee_grant` status: " + tx.Error.Error())
-			continue
+	err = job.DB.Transaction(func(db *gorm.DB) error {
+		err := createPendingTransaction(db, receipt, models.SentinelTransactionKindFeeGrant, nil)
+		if err != nil {
+			return err
 		}
 
-		job.Logger.Infof("Sentinel wallet %s was granted fee.", device.WalletAddress)
+		return db.Model(&models.Device{}).Where("id IN ?", deviceIDs).Update("fee_grant_tx_hash", receipt.TxHash).Error
+	})
+	if err != nil {
+		job.Logger.Errorf("failed to save fee grant transaction %s to the DB: %s", receipt.TxHash, err)
+		return
 	}
-}
-
-func (job GrantFeeToWalletsJob) fetchAllowances() (*[]sentinel.SentinelAllowance, error) {
-	var syncInProgress bool
-	var limit int
-	var offset int
 
-	syncInProgress = true
-	limit = 1000000
-	offset = 0
+	err = publishEnrollments(job.Events, job.DB, deviceIDs)
+	if err != nil {
+		job.Logger.Warnf("failed to publish enrollment of devices %v: %s", deviceIDs, err)
+	}
 
-	var allowances []sentinel.SentinelAllowance
+	job.Logger.Infof("Sentinel wallets %v will be granted fee once transaction %s is confirmed.", walletAddresses, receipt.TxHash)
+}
 
-	for syncInProgress {
-		n, err := job.Sentinel.FetchFeeGrantAllowances(limit, offset)
-		if err != nil {
-			return nil, err
-		}
+// findGrantedWallets streams allowances of the provider until every wallet of devices is found
+func (job GrantFeeToWalletsJob) findGrantedWallets(ctx context.Context, devices []models.Device) (map[string]bool, error) {
+	pendingWallets := make(map[string]bool, len(devices))
+	for _, device := range devices {
+		pendingWallets[device.WalletAddress] = true
+	}
 
-		if n == nil {
-			syncInProgress = false
-		} else {
-			allowances = append(allowances, *n...)
+	grantedWallets := make(map[string]bool)
+	err := sentinel.FeeGrantAllowancesPager(job.Sentinel, sentinel.DefaultPageLimit).Each(ctx, func(allowance sentinel.SentinelAllowance) bool {
+		if pendingWallets[allowance.Grantee] {
+			grantedWallets[allowance.Grantee] = true
+			delete(pendingWallets, allowance.Grantee)
 		}
 
-		offset += limit
+		return len(pendingWallets) > 0
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return &allowances, nil
+	return grantedWallets, nil
 }
@@ -1,6 +1,7 @@
 package jobs
 
 import (
+	"context"
 	"dvpn/internal/sentinel"
 	"dvpn/models"
 	"go.uber.org/zap"
@@ -12,12 +13,17 @@ import (
 type LinkNodesWithPlanJob struct {
 	DB       *gorm.DB
 	Logger   *zap.SugaredLogger
-	Sentinel *sentinel.Sentinel
+	Sentinel sentinel.SentinelClient
 }
 
 func (job LinkNodesWithPlanJob) Run() {
+	ctx := context.Background()
+
+	planMutex.Lock()
+	defer planMutex.Unlock()
+
 	var servers []models.Server
-	tx := job.DB.Model(&models.Server{}).Order("created_at").Limit(5).Find(&servers, "is_included_in_plan = ? AND is_banned = ? AND is_active = ?", false, false, true)
+	tx := job.DB.Model(&models.Server{}).Order("created_at").Limit(5).Find(&servers, "is_included_in_plan = ? AND is_banned = ? AND is_active = ? AND plan_tx_hash IS NULL", false, false, true)
 	if tx.Error != nil {
 		job.Logger.Error("failed to get sentinel servers from the DB: " + tx.Error.Error())
 		return
@@ -30,11 +36,13 @@ func (job LinkNodesWithPlanJob) Run() {
 	}
 
 	var nodeAddresses []string = make([]string, 0)
+	var serverIDs []uint = make([]uint, 0)
 
 	for _, server := range servers {
 		if server.Configuration.Data().PricePerHour <= maxPricePerHour {
 			job.Logger.Infof("Sentinel node %s is now satisfy plan listing criteria. It will be added to the plan.", server.Configuration.Data().Address)
 			nodeAddresses = append(nodeAddresses, server.Configuration.Data().Address)
+			serverIDs = append(serverIDs, server.ID)
 		}
 	}
 
@@ -42,23 +50,27 @@ func (job LinkNodesWithPlanJob) Run() {
 		return
 	}
 
-	err = job.Sentinel.AddNodeToPlan(nodeAddresses)
+	receipt, err := job.Sentinel.AddNodeToPlan(ctx, nodeAddresses)
 	if err != nil {
 		job.Logger.Error("failed to add nodes to plan: " + err.Error())
 		return
 	}
 
-	for _, server := range servers {
-		server.IsIncludedInPlan = true
-		tx = job.DB.Save(&server)
-		if tx.Error != nil {
-			job.Logger.Error("failed to update server in the DB: " + tx.Error.Error())
-			continue
+	err = job.DB.Transaction(func(db *gorm.DB) error {
+		err := createPendingTransaction(db, receipt, models.SentinelTransactionKindLinkNodes, nil)
+		if err != nil {
+			return err
 		}
 
-		job.Logger.Infof("Sentinel node %s was added to the plan.", server.Configuration.Data().Address)
+		return db.Model(&models.Server{}).Where("id IN ?", serverIDs).Update("plan_tx_hash", receipt.TxHash).Error
+	})
+	if err != nil {
+		job.Logger.Errorf("failed to save plan transaction %s to the DB: %s", receipt.TxHash, err)
+		return
 	}
 
+	job.Logger.Infof("Sentinel nodes %v will be added to the plan once transaction %s is confirmed.", nodeAddresses, receipt.TxHash)
+
 	tx = job.DB.Exec("UPDATE cities AS c SET servers_available = (SELECT COUNT(s.id) FROM servers AS s WHERE s.city_id = c.id AND s.is_active = ? AND s.is_included_in_plan = ? AND s.is_banned = ?)", true, true, false)
 	if tx.Error != nil {
 		job.Logger.Errorf("Error updating cities: %v", tx.Error)
@@ -0,0 +1,35 @@
+package jobs
+
+import "dvpn/internal/sentinel"
+
+// nodeLoad is how busy a node is according to its status. It is parsed the same way by the full
+// SyncNodesWithSentinelJob and by RefreshServerLoadsJob in between.
+type nodeLoad struct {
+	CurrentLoad float64
+	MaxPeers    int64
+}
+
+func parseNodeLoad(status *sentinel.SentinelNodeStatus) nodeLoad {
+	load := nodeLoad{MaxPeers: status.QoS.MaxPeers}
+	if status.QoS.MaxPeers > 0 {
+		load.CurrentLoad = float64(status.Peers) / float64(status.QoS.MaxPeers)
+	}
+
+	if load.CurrentLoad > 1 {
+		load.CurrentLoad = 1
+	}
+
+	return load
+}
+
+// columns lists the load columns of the server. Capacity reserved since the previous status is dropped,
+// as peers it was reserved for are either counted by the node now or never connected.
+func (l nodeLoad) columns() map[string]interface{} {
+	return map[string]interface{}{
+		"current_load":    l.CurrentLoad,
+		"max_peers":       l.MaxPeers,
+		"reserved_peers":  0,
+		"reserved_at":     nil,
+		"status_failures": 0,
+	}
+}
@@ -0,0 +1,34 @@
+package jobs
+
+import (
+	"dvpn/internal/sentinel"
+	"testing"
+)
+
+func TestParseNodeLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		peers    int64
+		maxPeers int64
+		want     nodeLoad
+	}{
+		{"empty", 0, 100, nodeLoad{CurrentLoad: 0, MaxPeers: 100}},
+		{"partially used", 25, 100, nodeLoad{CurrentLoad: 0.25, MaxPeers: 100}},
+		{"over capacity is full", 150, 100, nodeLoad{CurrentLoad: 1, MaxPeers: 100}},
+		{"unknown capacity", 10, 0, nodeLoad{CurrentLoad: 0, MaxPeers: 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := &sentinel.SentinelNodeStatus{Peers: tt.peers, QoS: sentinel.SentinelNodeQoS{MaxPeers: tt.maxPeers}}
+			if got := parseNodeLoad(status); got != tt.want {
+				t.Errorf("parseNodeLoad = %+v, want %+v", got, tt.want)
+			}
+
+			columns := tt.want.columns()
+			if columns["reserved_peers"] != 0 || columns["reserved_at"] != nil || columns["status_failures"] != 0 {
+				t.Errorf("columns %v don't reset reservations and status failures", columns)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package jobs
+
+import (
+	"context"
+	"dvpn/internal/sentinel"
+	"dvpn/models"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// QuarantineNodesJob is a circuit breaker per node: servers whose node fails Threshold key exchanges in a row
+// are quarantined, so they are not listed or selected until the next sync would notice. Once Cooldown has passed,
+// Run probes the node status and releases the server when it responds. A released server is quarantined again
+// on its first failure, the same way a half-open breaker lets a single request through.
+type QuarantineNodesJob struct {
+	DB       *gorm.DB
+	Logger   *zap.SugaredLogger
+	Sentinel sentinel.SentinelClient
+
+	Threshold int
+	Cooldown  time.Duration
+}
+
+// Record counts the outcome of a key exchange with the node, err is nil when it succeeded.
+// Key exchanges cancelled by the caller say nothing about the node and are not counted.
+// Record of a nil QuarantineNodesJob does nothing, so the quarantine is optional.
+func (job *QuarantineNodesJob) Record(nodeAddress string, err error) {
+	if job == nil || job.Threshold <= 0 || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	if err == nil {
+		tx := job.DB.Model(&models.Server{}).
+			Where("\"configuration\"->>'address' = ? AND key_exchange_failures > 0", nodeAddress).
+			Update("key_exchange_failures", 0)
+		if tx.Error != nil {
+			job.Logger.Errorf("failed to reset key exchange failures of node %s: %s", nodeAddress, tx.Error)
+		}
+		return
+	}
+
+	tx := job.DB.Model(&models.Server{}).
+		Where("\"configuration\"->>'address' = ?", nodeAddress).
+		Update("key_exchange_failures", gorm.Expr("key_exchange_failures + 1"))
+	if tx.Error != nil {
+		job.Logger.Errorf("failed to count key exchange failure of node %s: %s", nodeAddress, tx.Error)
+		return
+	}
+
+	tx = job.DB.Model(&models.Server{}).
+		Where("\"configuration\"->>'address' = ? AND key_exchange_failures >= ? AND quarantined_until IS NULL", nodeAddress, job.Threshold).
+		Update("quarantined_until", time.Now().Add(job.Cooldown))
+	if tx.Error != nil {
+		job.Logger.Errorf("failed to quarantine node %s: %s", nodeAddress, tx.Error)
+		return
+	}
+
+	if tx.RowsAffected > 0 {
+		job.Logger.Warnf("quarantined node %s for %s after %d failed key exchanges, last one: %s", nodeAddress, job.Cooldown, job.Threshold, err)
+	}
+}
+
+// Run probes nodes whose quarantine has cooled down, releasing those which respond and extending it for the rest
+func (job QuarantineNodesJob) Run() {
+	ctx := context.Background()
+
+	var servers []models.Server
+	tx := job.DB.Find(&servers, "quarantined_until IS NOT NULL AND quarantined_until <= ?", time.Now())
+	if tx.Error != nil {
+		job.Logger.Error("failed to get quarantined servers from the DB: " + tx.Error.Error())
+		return
+	}
+
+	for _, server := range servers {
+		node := sentinel.SentinelNode{
+			Address:   server.Configuration.Data().Address,
+			RemoteURL: server.Configuration.Data().RemoteURL,
+		}
+
+		_, err := job.Sentinel.FetchNodeStatus(ctx, node)
+		if err != nil {
+			tx = job.DB.Model(&server).Update("quarantined_until", time.Now().Add(job.Cooldown))
+			if tx.Error != nil {
+				job.Logger.Errorf("failed to extend quarantine of node %s: %s", node.Address, tx.Error)
+				continue
+			}
+
+			job.Logger.Warnf("node %s failed the probe and stays quarantined for %s: %s", node.Address, job.Cooldown, err)
+			continue
+		}
+
+		tx = job.DB.Model(&server).Updates(map[string]interface{}{
+			"quarantined_until":     nil,
+			"key_exchange_failures": job.Threshold - 1,
+		})
+		if tx.Error != nil {
+			job.Logger.Errorf("failed to release node %s from quarantine: %s", node.Address, tx.Error)
+			continue
+		}
+
+		job.Logger.Infof("released node %s from quarantine after a successful probe", node.Address)
+	}
+}
@@ -0,0 +1,83 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testNodeAddress = "sentnode1node"
+
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{
+		Logger:                 logger.Discard,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to open mock DB: %s", err)
+	}
+
+	return db, mock
+}
+
+// expectSuccess expects the failures of the node to be reset
+func expectSuccess(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`UPDATE "servers" SET "key_exchange_failures"=\$1,.* AND key_exchange_failures > 0`).
+		WithArgs(0, sqlmock.AnyArg(), testNodeAddress).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// expectFailure expects a failure of the node to be counted and the node to be quarantined
+func expectFailure(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`UPDATE "servers" SET "key_exchange_failures"=key_exchange_failures \+ 1`).
+		WithArgs(sqlmock.AnyArg(), testNodeAddress).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "servers" SET "quarantined_until"=\$1,.* AND key_exchange_failures >= \$\d+ AND quarantined_until IS NULL`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), testNodeAddress, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestQuarantineRecord(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int
+		err       error
+		expect    func(mock sqlmock.Sqlmock)
+	}{
+		{"success resets failures", 3, nil, expectSuccess},
+		{"failure is counted", 3, errors.New("connection refused"), expectFailure},
+		{"cancelled key exchange is not counted", 3, fmt.Errorf("failed: %w", context.Canceled), func(mock sqlmock.Sqlmock) {}},
+		{"disabled quarantine", 0, errors.New("connection refused"), func(mock sqlmock.Sqlmock) {}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			tt.expect(mock)
+
+			job := &QuarantineNodesJob{DB: db, Logger: zap.NewNop().Sugar(), Threshold: tt.threshold, Cooldown: time.Minute}
+			job.Record(testNodeAddress, tt.err)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	var job *QuarantineNodesJob
+	job.Record(testNodeAddress, errors.New("connection refused"))
+}
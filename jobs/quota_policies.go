@@ -0,0 +1,72 @@
+package jobs
+
+import (
+	"database/sql"
+	"dvpn/models"
+	"gorm.io/gorm"
+)
+
+// quotaBytesSQL resolves bytes which should be allocated to the wallet of device `d` from quota policies.
+// Allocations are never reduced below bytes the wallet has already utilised, the chain would reject it.
+const quotaBytesSQL = `GREATEST(COALESCE(
+	(SELECT p.bytes FROM quota_policies AS p WHERE p.device_id = d.id),
+	(SELECT p.bytes FROM quota_policies AS p WHERE p.tier = d.tier),
+	(SELECT p.bytes FROM quota_policies AS p WHERE p.platform = d.platform),
+	@default_bytes
+), d.usage_utilised_bytes)`
+
+// QuotaPolicies decides how many bytes are allocated to device wallets, see models.QuotaPolicy
+type QuotaPolicies struct {
+	// Bytes allocated to devices no policy applies to
+	DefaultBytes int64
+}
+
+type deviceQuota struct {
+	ID             uint
+	WalletAddress  string
+	SubscriptionId *int64
+	Bytes          int64
+}
+
+// Resolve returns bytes which should be allocated to wallets of devices by their IDs
+func (qp QuotaPolicies) Resolve(db *gorm.DB, deviceIDs []uint) (map[uint]int64, error) {
+	var quotas []deviceQuota
+	tx := db.Raw("SELECT d.id, d.wallet_address, d.subscription_id, "+quotaBytesSQL+" AS bytes FROM devices AS d WHERE d.id IN @ids",
+		sql.Named("default_bytes", qp.DefaultBytes),
+		sql.Named("ids", deviceIDs),
+	).Scan(&quotas)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	result := make(map[uint]int64, len(quotas))
+	for _, quota := range quotas {
+		result[quota.ID] = quota.Bytes
+	}
+
+	return result, nil
+}
+
+// findChanged returns enrolled devices without pending transactions whose allocation differs from their policy
+func (qp QuotaPolicies) findChanged(db *gorm.DB, limit int) ([]deviceQuota, error) {
+	var quotas []deviceQuota
+	tx := db.Raw("SELECT * FROM (SELECT d.id, d.wallet_address, d.subscription_id, d.quota_bytes, "+quotaBytesSQL+" AS bytes FROM devices AS d "+
+		"WHERE d.subscription_id IS NOT NULL AND d.enrollment_tx_hash IS NULL AND d.allocation_tx_hash IS NULL) AS q "+
+		"WHERE q.bytes <> q.quota_bytes ORDER BY q.id LIMIT @limit",
+		sql.Named("default_bytes", qp.DefaultBytes),
+		sql.Named("limit", limit),
+	).Scan(&quotas)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return quotas, nil
+}
+
+// markPendingQuota remembers bytes allocated by a broadcast transaction until it is confirmed
+func markPendingQuota(db *gorm.DB, deviceID uint, column string, txHash string, bytes int64) error {
+	return db.Model(&models.Device{}).Where("id = ?", deviceID).Updates(map[string]any{
+		column:                txHash,
+		"pending_quota_bytes": bytes,
+	}).Error
+}
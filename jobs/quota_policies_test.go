@@ -0,0 +1,51 @@
+package jobs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestQuotaBytesPrecedence(t *testing.T) {
+	// A policy of a device wins over a policy of its tier, which wins over a policy of its platform
+	order := []string{"p.device_id = d.id", "p.tier = d.tier", "p.platform = d.platform", "@default_bytes"}
+
+	last := -1
+	for _, clause := range order {
+		index := strings.Index(quotaBytesSQL, clause)
+		if index < 0 {
+			t.Fatalf("quota bytes are resolved without `%s`", clause)
+		}
+		if index < last {
+			t.Errorf("`%s` is resolved before a policy it should lose to", clause)
+		}
+		last = index
+	}
+
+	if !strings.HasPrefix(quotaBytesSQL, "GREATEST(") || !strings.HasSuffix(quotaBytesSQL, ", d.usage_utilised_bytes)") {
+		t.Errorf("quota bytes may be reduced below bytes already utilised: %s", quotaBytesSQL)
+	}
+}
+
+func TestResolveQuotas(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT d.id, d.wallet_address, d.subscription_id, GREATEST\(COALESCE\(.+\) AS bytes FROM devices AS d WHERE d.id IN \(\$2,\$3\)`).
+		WithArgs(int64(1000), uint(1), uint(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "wallet_address", "subscription_id", "bytes"}).
+			AddRow(1, "sent1first", 7, 1000).
+			AddRow(2, "sent1second", 7, 5000))
+
+	quotas, err := QuotaPolicies{DefaultBytes: 1000}.Resolve(db, []uint{1, 2})
+	if err != nil {
+		t.Fatalf("failed to resolve quotas: %s", err)
+	}
+
+	if quotas[1] != 1000 || quotas[2] != 5000 {
+		t.Errorf("quotas = %v, want 1000 and 5000 bytes", quotas)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,93 @@
+package jobs
+
+import (
+	"context"
+	"dvpn/internal/events"
+	"dvpn/internal/sentinel"
+	"dvpn/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"time"
+)
+
+// RecordDeviceUsageJob samples data usage of enrolled devices from allocations of their subscriptions.
+// Every device is sampled at most once per Interval, each sample is kept as a DeviceUsage for the usage history.
+type RecordDeviceUsageJob struct {
+	DB       *gorm.DB
+	Logger   *zap.SugaredLogger
+	Sentinel sentinel.SentinelClient
+	Events   *events.Bus
+
+	Interval time.Duration
+}
+
+func (job RecordDeviceUsageJob) Run() {
+	ctx := context.Background()
+
+	var devices []models.Device
+	tx := job.DB.Model(&models.Device{}).
+		Where("subscription_id IS NOT NULL AND is_fee_granted = ?", true).
+		Where("usage_updated_at IS NULL OR usage_updated_at < ?", time.Now().Add(-job.Interval)).
+		Order("usage_updated_at NULLS FIRST").
+		Limit(20).
+		Find(&devices)
+	if tx.Error != nil {
+		job.Logger.Error("failed to get devices to record usage of from the DB: " + tx.Error.Error())
+		return
+	}
+
+	for _, device := range devices {
+		usage, err := job.Refresh(ctx, &device)
+		if err != nil {
+			job.Logger.Errorf("failed to refresh usage of device %d: %s", device.ID, err)
+			continue
+		}
+
+		tx = job.DB.Create(&models.DeviceUsage{
+			DeviceID:       device.ID,
+			SubscriptionID: *device.SubscriptionId,
+			GrantedBytes:   usage.GrantedBytes,
+			UtilisedBytes:  usage.UtilisedBytes,
+		})
+		if tx.Error != nil {
+			job.Logger.Errorf("failed to record usage of device %d: %s", device.ID, tx.Error)
+		}
+	}
+}
+
+// Refresh fetches current usage of an enrolled device from Sentinel and saves it as the latest usage of the device
+func (job RecordDeviceUsageJob) Refresh(ctx context.Context, device *models.Device) (*models.Usage, error) {
+	allocation, err := job.Sentinel.FetchAllocationForWallet(ctx, *device.SubscriptionId, device.WalletAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	var dto sentinel.SentinelAllocationDTO
+	if allocation != nil {
+		dto = allocation.DTO()
+	}
+
+	// Updates assigns the new usage to the device, whether its quota was used up is told beforehand
+	wasQuotaExceeded := device.IsQuotaExceeded()
+
+	now := time.Now()
+	tx := job.DB.Model(device).Updates(map[string]interface{}{
+		"usage_granted_bytes":  dto.GrantedBytes,
+		"usage_utilised_bytes": dto.UtilisedBytes,
+		"usage_updated_at":     now,
+	})
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	// The device is told to disconnect once, when its quota is found used up
+	device.UsageGrantedBytes = dto.GrantedBytes
+	device.UsageUtilisedBytes = dto.UtilisedBytes
+	device.UsageUpdatedAt = &now
+	if device.IsQuotaExceeded() && !wasQuotaExceeded {
+		job.Events.Publish(events.TypeDisconnect, device.ID, events.Disconnect{Reason: events.DisconnectReasonQuotaExceeded})
+	}
+
+	usage := models.NewUsage(dto.GrantedBytes, dto.UtilisedBytes, &now)
+	return &usage, nil
+}
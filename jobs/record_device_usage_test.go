@@ -0,0 +1,77 @@
+package jobs
+
+import (
+	"context"
+	"database/sql/driver"
+	"dvpn/internal/events"
+	"dvpn/internal/sentinel"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+)
+
+// allocationsClient is FakeSentinel with a fixed allocation of every wallet
+type allocationsClient struct {
+	*sentinel.FakeSentinel
+	allocation sentinel.SentinelAllocation
+}
+
+func (c allocationsClient) FetchAllocationForWallet(ctx context.Context, subscriptionID int64, walletAddress string) (*sentinel.SentinelAllocation, error) {
+	allocation := c.allocation
+	allocation.Address = walletAddress
+	return &allocation, nil
+}
+
+func TestRecordDeviceUsage(t *testing.T) {
+	updatedAt := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name       string
+		utilised   int64
+		previous   []driver.Value
+		disconnect bool
+	}{
+		{"within quota", 400, []driver.Value{1000, 300, updatedAt}, false},
+		{"quota used up", 1000, []driver.Value{1000, 900, updatedAt}, true},
+		{"quota already used up", 1200, []driver.Value{1000, 1000, updatedAt}, false},
+		{"first sample", 1000, []driver.Value{0, 0, nil}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := allocationsClient{
+				FakeSentinel: sentinel.NewFakeSentinel(sentinel.FakeSentinelConfig{Seed: 1}),
+				allocation:   sentinel.SentinelAllocation{GrantedBytes: "1000", UtilisedBytes: strconv.FormatInt(tt.utilised, 10)},
+			}
+
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(`SELECT \* FROM "devices" WHERE \(subscription_id IS NOT NULL AND is_fee_granted = \$1\) AND \(usage_updated_at IS NULL OR usage_updated_at < \$2\) ORDER BY usage_updated_at NULLS FIRST LIMIT 20`).
+				WithArgs(true, sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "wallet_address", "subscription_id", "usage_granted_bytes", "usage_utilised_bytes", "usage_updated_at"}).
+					AddRow(append([]driver.Value{9, "sent1wallet", 7}, tt.previous...)...))
+			mock.ExpectExec(`UPDATE "devices" SET "usage_granted_bytes"=\$1,"usage_updated_at"=\$2,"usage_utilised_bytes"=\$3,"updated_at"=\$4 WHERE "id" = \$5`).
+				WithArgs(1000, sqlmock.AnyArg(), tt.utilised, sqlmock.AnyArg(), 9).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(`INSERT INTO "device_usages"`).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 9, 7, 1000, tt.utilised).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+			bus := events.NewBus()
+			stream, unsubscribe := bus.Subscribe(9)
+			defer unsubscribe()
+
+			RecordDeviceUsageJob{DB: db, Logger: zap.NewNop().Sugar(), Sentinel: client, Events: bus, Interval: time.Minute}.Run()
+
+			if disconnected := len(stream) > 0; disconnected != tt.disconnect {
+				t.Errorf("disconnected = %t, want %t", disconnected, tt.disconnect)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package jobs
+
+import (
+	"context"
+	"dvpn/internal/sentinel"
+	"dvpn/models"
+	"sync"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// RefreshServerLoadsJob refreshes load of active servers on the plan from statuses of their nodes in between
+// runs of SyncNodesWithSentinelJob, which still owns the catalog. Servers whose node doesn't respond
+// FailureThreshold times in a row are deactivated, and activated again once it responds. Servers deactivated
+// by the full sync are left to it.
+type RefreshServerLoadsJob struct {
+	DB       *gorm.DB
+	Logger   *zap.SugaredLogger
+	Sentinel sentinel.SentinelClient
+
+	// Concurrency is the number of node statuses fetched at once
+	Concurrency int
+	// FailureThreshold is the number of failed status fetches in a row which deactivate a server
+	FailureThreshold int
+}
+
+func (job RefreshServerLoadsJob) Run() {
+	ctx := context.Background()
+
+	var servers []models.Server
+	tx := job.DB.Find(&servers, "(is_active = ? OR status_failures >= ?) AND is_included_in_plan = ? AND is_banned = ?", true, job.threshold(), true, false)
+	if tx.Error != nil {
+		job.Logger.Error("failed to get active servers from the DB: " + tx.Error.Error())
+		return
+	}
+
+	workers := job.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	queue := make(chan *models.Server)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for server := range queue {
+				job.refresh(ctx, server)
+			}
+		}()
+	}
+
+	for i := range servers {
+		queue <- &servers[i]
+	}
+	close(queue)
+
+	wg.Wait()
+	job.Logger.Debugf("refreshed load of %d servers", len(servers))
+}
+
+func (job RefreshServerLoadsJob) refresh(ctx context.Context, server *models.Server) {
+	node := sentinel.SentinelNode{
+		Address:   server.Configuration.Data().Address,
+		RemoteURL: server.Configuration.Data().RemoteURL,
+	}
+
+	status, err := job.Sentinel.FetchNodeStatus(ctx, node)
+	if err != nil {
+		job.Logger.Warnw("failed to fetch Sentinel node status for "+node.Address+": "+err.Error(), "url", node.RemoteURL, "failures", server.StatusFailures+1)
+
+		tx := job.DB.Model(server).Update("status_failures", gorm.Expr("status_failures + 1"))
+		if tx.Error != nil {
+			job.Logger.Errorf("failed to count status failure of server %s: %s", node.Address, tx.Error)
+			return
+		}
+
+		tx = job.DB.Model(&models.Server{}).
+			Where("id = ? AND is_active = ? AND status_failures >= ?", server.ID, true, job.threshold()).
+			Update("is_active", false)
+		if tx.Error != nil {
+			job.Logger.Errorf("failed to deactivate server %s: %s", node.Address, tx.Error)
+		} else if tx.RowsAffected > 0 {
+			job.Logger.Warnf("deactivated server %s after %d failed status fetches", node.Address, job.threshold())
+		}
+		return
+	}
+
+	columns := parseNodeLoad(status).columns()
+	columns["is_active"] = true
+
+	tx := job.DB.Model(server).Updates(columns)
+	if tx.Error != nil {
+		job.Logger.Errorf("failed to update load of server %s: %s", node.Address, tx.Error)
+		return
+	}
+
+	if !server.IsActive {
+		job.Logger.Infof("activated server %s again as its node responds", node.Address)
+	}
+}
+
+func (job RefreshServerLoadsJob) threshold() int {
+	if job.FailureThreshold < 1 {
+		return 1
+	}
+
+	return job.FailureThreshold
+}
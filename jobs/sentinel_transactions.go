@@ -0,0 +1,22 @@
+package jobs
+
+import (
+	"dvpn/internal/sentinel"
+	"dvpn/models"
+	"gorm.io/gorm"
+	"sync"
+)
+
+// planMutex serializes jobs which broadcast plan transactions, so a server is never linked and unlinked at once
+var planMutex sync.Mutex
+
+func createPendingTransaction(db *gorm.DB, receipt *sentinel.SentinelReceipt, kind models.SentinelTransactionKind, subscriptionID *int64) error {
+	return db.Create(&models.SentinelTransaction{
+		Hash:           receipt.TxHash,
+		Kind:           kind,
+		Status:         models.SentinelTransactionStatusPending,
+		SubscriptionID: subscriptionID,
+		MessageType:    receipt.MessageType,
+		Height:         receipt.Height,
+	}).Error
+}
@@ -1,6 +1,7 @@
 package jobs
 
 import (
+	"context"
 	"dvpn/internal/sentinel"
 	"dvpn/models"
 	"errors"
@@ -14,40 +15,58 @@ import (
 type SyncNodesWithSentinelJob struct {
 	DB       *gorm.DB
 	Logger   *zap.SugaredLogger
-	Sentinel *sentinel.Sentinel
+	Sentinel sentinel.SentinelClient
 
 	planNodes *[]sentinel.SentinelNode
 }
 
 func (job SyncNodesWithSentinelJob) Run() {
-	job.Logger.Infof("fetching nodes from Sentinel")
-	nodes, err := job.fetchActiveNodes()
-	if err != nil {
-		job.Logger.Errorw("failed to fetch nodes from Sentinel", "error", err)
-		return
-	}
+	ctx := context.Background()
 
-	healthChecks, err := job.Sentinel.FetchHealthChecks()
+	healthChecks, err := job.Sentinel.FetchHealthChecks(ctx)
 	if err != nil {
 		job.Logger.Errorw("failed to fetch health checks from Health API", "error", err)
 		return
 	}
 
 	job.Logger.Infof("fetching nodes listed on plan from Sentinel")
-	job.planNodes, err = job.fetchNodesOnPlan()
+	job.planNodes, err = job.fetchNodesOnPlan(ctx)
 	if err != nil {
 		job.Logger.Errorw("failed to fetch nodes listed on plan from Sentinel", "error", err)
 		return
 	}
 
-	job.Logger.Infof("processing %d nodes", len(*nodes))
-	job.processNodes(nodes, healthChecks)
-}
-
-func (job SyncNodesWithSentinelJob) processNodes(nodes *[]sentinel.SentinelNode, healthChecks *[]sentinel.SentinelHealthCheck) {
 	revision := time.Now().Unix()
 
-	for _, node := range *nodes {
+	job.Logger.Infof("fetching nodes from Sentinel")
+	pager := sentinel.NodesPager(job.Sentinel, sentinel.DefaultPageLimit)
+	for {
+		nodes, err := pager.Next(ctx)
+		if err != nil {
+			// Servers of the pages not fetched would be deactivated, so the sync is aborted
+			job.Logger.Errorw("failed to fetch nodes from Sentinel", "error", err)
+			return
+		}
+
+		if nodes == nil {
+			break
+		}
+
+		job.Logger.Infof("processing %d nodes", len(nodes))
+		job.processNodes(ctx, nodes, healthChecks, revision)
+	}
+
+	// Failures of the load refresh are reset, so it doesn't activate servers the sync deactivated
+	tx := job.DB.Model(&models.Server{}).Where("revision != ?", revision).Updates(map[string]interface{}{"is_active": false, "status_failures": 0})
+	if tx.Error != nil {
+		job.Logger.Errorf("failed to deactivate inactive servers: %s", tx.Error)
+	} else {
+		job.Logger.Infof("deactivated %d inactive servers", tx.RowsAffected)
+	}
+}
+
+func (job SyncNodesWithSentinelJob) processNodes(ctx context.Context, nodes []sentinel.SentinelNode, healthChecks *[]sentinel.SentinelHealthCheck, revision int64) {
+	for _, node := range nodes {
 		job.Logger.Infof("requesting status for node %s", node.Address)
 
 		isNodeHealthy := false
@@ -66,11 +85,11 @@ func (job SyncNodesWithSentinelJob) processNodes(nodes *[]sentinel.SentinelNode,
 			continue
 		}
 
-		status, err := job.Sentinel.FetchNodeStatus(node)
+		status, err := job.Sentinel.FetchNodeStatus(ctx, node)
 		if err == nil {
 			protocols := datatypes.NewJSONType(job.parseNodeProtocols(status))
 			configuration := datatypes.NewJSONType(job.parseNodeConfiguration(&node, status))
-			currentLoad := job.parseCurrentLoad(status)
+			load := parseNodeLoad(status)
 			countryId, err := job.parseCountryId(status)
 			if err != nil {
 				job.Logger.Errorf("failed to determine country id for %s: %s", status.Address, err)
@@ -86,17 +105,19 @@ func (job SyncNodesWithSentinelJob) processNodes(nodes *[]sentinel.SentinelNode,
 			var server models.Server
 			tx := job.DB.First(&server, "\"configuration\"->>'address' = ?", status.Address)
 			if tx.Error == nil {
-				server.Name = status.Moniker
-				server.CountryID = countryId
-				server.CityID = cityId
-				server.Protocols = protocols
-				server.Configuration = configuration
-				server.CurrentLoad = currentLoad
-				server.IsActive = true
-				server.IsIncludedInPlan = job.checkIfIncludedInPlan(&node)
-				server.Revision = revision
-
-				tx = job.DB.Save(&server)
+				// Only columns the sync owns are updated, quarantine and bans are changed concurrently by
+				// connects and admins, so a stale copy of them must not be written back
+				columns := load.columns()
+				columns["name"] = status.Moniker
+				columns["country_id"] = countryId
+				columns["city_id"] = cityId
+				columns["protocols"] = protocols
+				columns["configuration"] = configuration
+				columns["is_active"] = true
+				columns["is_included_in_plan"] = job.checkIfIncludedInPlan(&node)
+				columns["revision"] = revision
+
+				tx = job.DB.Model(&server).Updates(columns)
 				if tx.Error != nil {
 					job.Logger.Errorf("failed to update server %s in the DB: %s", status.Address, tx.Error)
 				} else {
@@ -109,9 +130,9 @@ func (job SyncNodesWithSentinelJob) processNodes(nodes *[]sentinel.SentinelNode,
 						CityID:           cityId,
 						Name:             status.Moniker,
 						IsActive:         true,
-						IsBanned:         false,
 						IsIncludedInPlan: job.checkIfIncludedInPlan(&node),
-						CurrentLoad:      currentLoad,
+						CurrentLoad:      load.CurrentLoad,
+						MaxPeers:         load.MaxPeers,
 						Protocols:        protocols,
 						Configuration:    configuration,
 						Revision:         revision,
@@ -131,68 +152,12 @@ func (job SyncNodesWithSentinelJob) processNodes(nodes *[]sentinel.SentinelNode,
 			job.Logger.Warnw("failed to fetch Sentinel node status for "+node.Address+": "+err.Error(), "url", node.RemoteURL)
 		}
 	}
-
-	tx := job.DB.Model(&models.Server{}).Where("revision != ?", revision).Update("is_active", false)
-	if tx.Error != nil {
-		job.Logger.Errorf("failed to deactivate inactive servers: %s", tx.Error)
-	} else {
-		job.Logger.Infof("deactivated %d inactive servers", tx.RowsAffected)
-	}
 }
 
-func (job SyncNodesWithSentinelJob) fetchActiveNodes() (*[]sentinel.SentinelNode, error) {
-	var syncInProgress bool
-	var limit int
-	var offset int
-
-	syncInProgress = true
-	limit = 1000000
-	offset = 0
-
-	var nodes []sentinel.SentinelNode
-
-	for syncInProgress {
-		n, err := job.Sentinel.FetchNodes(limit, offset)
-		if err != nil {
-			return nil, err
-		}
-
-		if n == nil {
-			syncInProgress = false
-		} else {
-			nodes = append(nodes, *n...)
-		}
-
-		offset += limit
-	}
-
-	return &nodes, nil
-}
-
-func (job SyncNodesWithSentinelJob) fetchNodesOnPlan() (*[]sentinel.SentinelNode, error) {
-	var syncInProgress bool
-	var limit int
-	var offset int
-
-	syncInProgress = true
-	limit = 1000000
-	offset = 0
-
-	var nodes []sentinel.SentinelNode
-
-	for syncInProgress {
-		n, err := job.Sentinel.FetchPlanNodes(limit, offset)
-		if err != nil {
-			return nil, err
-		}
-
-		if n == nil {
-			syncInProgress = false
-		} else {
-			nodes = append(nodes, *n...)
-		}
-
-		offset += limit
+func (job SyncNodesWithSentinelJob) fetchNodesOnPlan(ctx context.Context) (*[]sentinel.SentinelNode, error) {
+	nodes, err := sentinel.PlanNodesPager(job.Sentinel, sentinel.DefaultPageLimit).All(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	return &nodes, nil
@@ -201,14 +166,14 @@ func (job SyncNodesWithSentinelJob) fetchNodesOnPlan() (*[]sentinel.SentinelNode
 func (job SyncNodesWithSentinelJob) parseNodePrices(node *sentinel.SentinelNode) (int64, int64) {
 	var pricePerGB int64
 	for _, gigabytePrice := range node.GigabytePrices {
-		if gigabytePrice.Denom == job.Sentinel.DefaultDenom {
+		if gigabytePrice.Denom == job.Sentinel.Denom() {
 			pricePerGB, _ = strconv.ParseInt(gigabytePrice.Amount, 10, 64)
 		}
 	}
 
 	var pricePerHour int64
 	for _, hourlyPrice := range node.HourlyPrices {
-		if hourlyPrice.Denom == job.Sentinel.DefaultDenom {
+		if hourlyPrice.Denom == job.Sentinel.Denom() {
 			pricePerHour, _ = strconv.ParseInt(hourlyPrice.Amount, 10, 64)
 		}
 	}
@@ -248,15 +213,6 @@ func (job SyncNodesWithSentinelJob) parseNodeConfiguration(node *sentinel.Sentin
 	}
 }
 
-func (job SyncNodesWithSentinelJob) parseCurrentLoad(status *sentinel.SentinelNodeStatus) float64 {
-	currentLoad := float64(status.Peers) / float64(status.QoS.MaxPeers)
-	if currentLoad > 1 {
-		currentLoad = 1
-	}
-
-	return currentLoad
-}
-
 func (job SyncNodesWithSentinelJob) parseCountryId(status *sentinel.SentinelNodeStatus) (uint, error) {
 	countryName := status.Location.Country
 
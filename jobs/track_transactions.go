@@ -0,0 +1,177 @@
+package jobs
+
+import (
+	"context"
+	"dvpn/internal/events"
+	"dvpn/internal/sentinel"
+	"dvpn/models"
+	"errors"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"time"
+)
+
+// TrackTransactionsJob polls Sentinel for pending transactions and applies their effects to
+// devices and servers once they are confirmed. Failed and dropped transactions return the
+// related devices and servers to the queues of the jobs which broadcast them.
+type TrackTransactionsJob struct {
+	DB       *gorm.DB
+	Logger   *zap.SugaredLogger
+	Sentinel sentinel.SentinelClient
+	Events   *events.Bus
+	// Gas estimates are dropped when a confirmed transaction turns out to have run out of gas
+	Gas *sentinel.GasEstimator
+
+	// Timeout after which a transaction not found on chain is considered dropped. Only answers that it is not found
+	// count, failures to fetch it don't, so a committed transaction is not dropped while Sentinel API is down.
+	Timeout time.Duration
+}
+
+func (job TrackTransactionsJob) Run() {
+	ctx := context.Background()
+
+	var transactions []models.SentinelTransaction
+	tx := job.DB.Model(&models.SentinelTransaction{}).Order("created_at").Limit(20).Find(&transactions, "status = ?", models.SentinelTransactionStatusPending)
+	if tx.Error != nil {
+		job.Logger.Error("failed to get pending sentinel transactions from the DB: " + tx.Error.Error())
+		return
+	}
+
+	for _, transaction := range transactions {
+		result, err := job.Sentinel.FetchTransaction(ctx, transaction.Hash)
+		if err != nil {
+			if !sentinel.IsNotFound(err) {
+				job.Logger.Warnf("failed to fetch sentinel transaction %s: %s", transaction.Hash, err)
+				job.resetNotFound(&transaction)
+				continue
+			}
+
+			if transaction.NotFoundSince == nil {
+				now := time.Now()
+				transaction.NotFoundSince = &now
+
+				tx := job.DB.Model(&transaction).Update("not_found_since", transaction.NotFoundSince)
+				if tx.Error != nil {
+					job.Logger.Errorf("failed to save sentinel transaction %s is not found: %s", transaction.Hash, tx.Error)
+				}
+			}
+
+			if time.Since(*transaction.NotFoundSince) < job.Timeout {
+				continue
+			}
+
+			job.Logger.Warnf("sentinel transaction %s was not included in %s, considering it dropped", transaction.Hash, job.Timeout)
+			transaction.Status = models.SentinelTransactionStatusDropped
+		} else {
+			transaction.Height = result.Height
+			transaction.Code = result.TxResult.Code
+			transaction.Log = result.TxResult.Log
+
+			if result.TxResult.Code == 0 {
+				transaction.Status = models.SentinelTransactionStatusConfirmed
+			} else {
+				job.Logger.Warnf("sentinel transaction %s failed with code %d: %s", transaction.Hash, result.TxResult.Code, result.TxResult.Log)
+				transaction.Status = models.SentinelTransactionStatusFailed
+
+				// The transaction passed the check on broadcast and ran out of gas on execution, so the cached estimate is too low
+				if result.TxResult.Code == sentinel.CodeOutOfGas && transaction.MessageType != "" {
+					job.Gas.Forget(transaction.MessageType)
+				}
+			}
+		}
+
+		deviceIDs, err := job.findEnrollingDevices(&transaction)
+		if err != nil {
+			job.Logger.Errorf("failed to get devices of sentinel transaction %s: %s", transaction.Hash, err)
+			continue
+		}
+
+		err = job.DB.Transaction(func(db *gorm.DB) error {
+			err := job.apply(db, &transaction)
+			if err != nil {
+				return err
+			}
+
+			return db.Save(&transaction).Error
+		})
+		if err != nil {
+			job.Logger.Errorf("failed to apply sentinel transaction %s to the DB: %s", transaction.Hash, err)
+			continue
+		}
+
+		job.Logger.Infof("sentinel transaction %s (%s) is %s", transaction.Hash, transaction.Kind, transaction.Status)
+
+		err = publishEnrollments(job.Events, job.DB, deviceIDs)
+		if err != nil {
+			job.Logger.Warnf("failed to publish enrollment of devices %v: %s", deviceIDs, err)
+		}
+	}
+}
+
+// resetNotFound restarts the not found timeout of a transaction after Sentinel API failed to answer whether it exists
+func (job TrackTransactionsJob) resetNotFound(transaction *models.SentinelTransaction) {
+	if transaction.NotFoundSince == nil {
+		return
+	}
+
+	transaction.NotFoundSince = nil
+	tx := job.DB.Model(transaction).Update("not_found_since", nil)
+	if tx.Error != nil {
+		job.Logger.Errorf("failed to reset not found timeout of sentinel transaction %s: %s", transaction.Hash, tx.Error)
+	}
+}
+
+// findEnrollingDevices returns devices whose enrollment progresses with the transaction, so it can be published once applied
+func (job TrackTransactionsJob) findEnrollingDevices(transaction *models.SentinelTransaction) ([]uint, error) {
+	var column string
+	switch transaction.Kind {
+	case models.SentinelTransactionKindFeeGrant:
+		column = "fee_grant_tx_hash"
+	case models.SentinelTransactionKindEnrollment:
+		column = "enrollment_tx_hash"
+	default:
+		return nil, nil
+	}
+
+	var deviceIDs []uint
+	tx := job.DB.Model(&models.Device{}).Where(column+" = ?", transaction.Hash).Pluck("id", &deviceIDs)
+	return deviceIDs, tx.Error
+}
+
+func (job TrackTransactionsJob) apply(db *gorm.DB, transaction *models.SentinelTransaction) error {
+	isConfirmed := transaction.Status == models.SentinelTransactionStatusConfirmed
+
+	switch transaction.Kind {
+	case models.SentinelTransactionKindFeeGrant:
+		updates := map[string]any{"fee_grant_tx_hash": nil}
+		if isConfirmed {
+			updates["is_fee_granted"] = true
+		}
+
+		return db.Model(&models.Device{}).Where("fee_grant_tx_hash = ?", transaction.Hash).Updates(updates).Error
+	case models.SentinelTransactionKindEnrollment:
+		updates := map[string]any{"enrollment_tx_hash": nil, "pending_quota_bytes": nil}
+		if isConfirmed {
+			updates["subscription_id"] = transaction.SubscriptionID
+			updates["quota_bytes"] = gorm.Expr("pending_quota_bytes")
+		}
+
+		return db.Model(&models.Device{}).Where("enrollment_tx_hash = ?", transaction.Hash).Updates(updates).Error
+	case models.SentinelTransactionKindAllocation:
+		updates := map[string]any{"allocation_tx_hash": nil, "pending_quota_bytes": nil}
+		if isConfirmed {
+			updates["quota_bytes"] = gorm.Expr("pending_quota_bytes")
+		}
+
+		return db.Model(&models.Device{}).Where("allocation_tx_hash = ?", transaction.Hash).Updates(updates).Error
+	case models.SentinelTransactionKindLinkNodes, models.SentinelTransactionKindUnlinkNode:
+		updates := map[string]any{"plan_tx_hash": nil}
+		if isConfirmed {
+			updates["is_included_in_plan"] = transaction.Kind == models.SentinelTransactionKindLinkNodes
+		}
+
+		return db.Model(&models.Server{}).Where("plan_tx_hash = ?", transaction.Hash).Updates(updates).Error
+	}
+
+	return errors.New("unknown sentinel transaction kind " + string(transaction.Kind))
+}
@@ -0,0 +1,221 @@
+package jobs
+
+import (
+	"context"
+	"database/sql/driver"
+	"dvpn/internal/sentinel"
+	"dvpn/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+)
+
+const testTxHash = "A1B2C3"
+
+// transactionsClient is FakeSentinel answering for a single transaction with result, or with err when it is set
+type transactionsClient struct {
+	*sentinel.FakeSentinel
+	result *sentinel.SentinelTransaction
+	err    error
+}
+
+func (c transactionsClient) FetchTransaction(ctx context.Context, txHash string) (*sentinel.SentinelTransaction, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return c.result, nil
+}
+
+func included(code int64) *sentinel.SentinelTransaction {
+	return &sentinel.SentinelTransaction{Height: 100, TxHash: testTxHash, TxResult: sentinel.SentinelTransactionResult{Code: code}}
+}
+
+// expectPending expects the pending transaction of the kind to be fetched from the DB
+func expectPending(mock sqlmock.Sqlmock, kind models.SentinelTransactionKind, notFoundSince *time.Time) {
+	mock.ExpectQuery(`SELECT \* FROM "sentinel_transactions" WHERE status = \$1 ORDER BY created_at LIMIT 20`).
+		WithArgs(models.SentinelTransactionStatusPending).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "hash", "kind", "status", "subscription_id", "message_type", "not_found_since"}).
+			AddRow(1, testTxHash, kind, models.SentinelTransactionStatusPending, 42, "/sentinel.plan.v2.MsgLinkNodeRequest", notFoundSince))
+}
+
+// expectApplied expects effects of the transaction to be applied with the update and the transaction to be saved with the status
+func expectApplied(mock sqlmock.Sqlmock, update string, args []driver.Value, status models.SentinelTransactionStatus) {
+	mock.ExpectBegin()
+	mock.ExpectExec(update).WithArgs(args...).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "sentinel_transactions" SET .*"status"=\$\d+`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), testTxHash, sqlmock.AnyArg(), status, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+// expectEnrollingDevices expects devices enrolling with the transaction to be looked up by the column, none are found
+func expectEnrollingDevices(mock sqlmock.Sqlmock, column string) {
+	mock.ExpectQuery(`SELECT "id" FROM "devices" WHERE ` + column + ` = \$1`).WithArgs(testTxHash).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+}
+
+func TestTrackTransactionsApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		kind   models.SentinelTransactionKind
+		result *sentinel.SentinelTransaction
+		expect func(mock sqlmock.Sqlmock)
+	}{
+		{"fee grant confirmed", models.SentinelTransactionKindFeeGrant, included(0), func(mock sqlmock.Sqlmock) {
+			expectEnrollingDevices(mock, "fee_grant_tx_hash")
+			expectApplied(mock, `UPDATE "devices" SET "fee_grant_tx_hash"=\$1,"is_fee_granted"=\$2,"updated_at"=\$3 WHERE fee_grant_tx_hash = \$4`,
+				[]driver.Value{nil, true, sqlmock.AnyArg(), testTxHash}, models.SentinelTransactionStatusConfirmed)
+		}},
+		{"fee grant failed", models.SentinelTransactionKindFeeGrant, included(5), func(mock sqlmock.Sqlmock) {
+			expectEnrollingDevices(mock, "fee_grant_tx_hash")
+			expectApplied(mock, `UPDATE "devices" SET "fee_grant_tx_hash"=\$1,"updated_at"=\$2 WHERE fee_grant_tx_hash = \$3`,
+				[]driver.Value{nil, sqlmock.AnyArg(), testTxHash}, models.SentinelTransactionStatusFailed)
+		}},
+		{"enrollment confirmed", models.SentinelTransactionKindEnrollment, included(0), func(mock sqlmock.Sqlmock) {
+			expectEnrollingDevices(mock, "enrollment_tx_hash")
+			expectApplied(mock, `UPDATE "devices" SET "enrollment_tx_hash"=\$1,"pending_quota_bytes"=\$2,"quota_bytes"=pending_quota_bytes,"subscription_id"=\$3,"updated_at"=\$4 WHERE enrollment_tx_hash = \$5`,
+				[]driver.Value{nil, nil, 42, sqlmock.AnyArg(), testTxHash}, models.SentinelTransactionStatusConfirmed)
+		}},
+		{"allocation confirmed", models.SentinelTransactionKindAllocation, included(0), func(mock sqlmock.Sqlmock) {
+			expectApplied(mock, `UPDATE "devices" SET "allocation_tx_hash"=\$1,"pending_quota_bytes"=\$2,"quota_bytes"=pending_quota_bytes,"updated_at"=\$3 WHERE allocation_tx_hash = \$4`,
+				[]driver.Value{nil, nil, sqlmock.AnyArg(), testTxHash}, models.SentinelTransactionStatusConfirmed)
+		}},
+		{"allocation failed keeps quota", models.SentinelTransactionKindAllocation, included(5), func(mock sqlmock.Sqlmock) {
+			expectApplied(mock, `UPDATE "devices" SET "allocation_tx_hash"=\$1,"pending_quota_bytes"=\$2,"updated_at"=\$3 WHERE allocation_tx_hash = \$4`,
+				[]driver.Value{nil, nil, sqlmock.AnyArg(), testTxHash}, models.SentinelTransactionStatusFailed)
+		}},
+		{"nodes linked", models.SentinelTransactionKindLinkNodes, included(0), func(mock sqlmock.Sqlmock) {
+			expectApplied(mock, `UPDATE "servers" SET "is_included_in_plan"=\$1,"plan_tx_hash"=\$2,"updated_at"=\$3 WHERE plan_tx_hash = \$4`,
+				[]driver.Value{true, nil, sqlmock.AnyArg(), testTxHash}, models.SentinelTransactionStatusConfirmed)
+		}},
+		{"node unlinked", models.SentinelTransactionKindUnlinkNode, included(0), func(mock sqlmock.Sqlmock) {
+			expectApplied(mock, `UPDATE "servers" SET "is_included_in_plan"=\$1,"plan_tx_hash"=\$2,"updated_at"=\$3 WHERE plan_tx_hash = \$4`,
+				[]driver.Value{false, nil, sqlmock.AnyArg(), testTxHash}, models.SentinelTransactionStatusConfirmed)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			expectPending(mock, tt.kind, nil)
+			tt.expect(mock)
+
+			job := TrackTransactionsJob{
+				DB:       db,
+				Logger:   zap.NewNop().Sugar(),
+				Sentinel: transactionsClient{FakeSentinel: sentinel.NewFakeSentinel(sentinel.FakeSentinelConfig{Nodes: 1, Seed: 1}), result: tt.result},
+				Timeout:  time.Minute,
+			}
+			job.Run()
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestTrackTransactionsNotFound(t *testing.T) {
+	recently := time.Now().Add(-time.Second)
+	longAgo := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name          string
+		notFoundSince *time.Time
+		err           error
+		expect        func(mock sqlmock.Sqlmock)
+	}{
+		{"first answer that it is not found starts the timeout", nil, &sentinel.HTTPError{StatusCode: 404}, func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(`UPDATE "sentinel_transactions" SET "not_found_since"=\$1,"updated_at"=\$2 WHERE "id" = \$3`).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}},
+		{"not found within the timeout is kept", &recently, &sentinel.HTTPError{StatusCode: 404}, func(mock sqlmock.Sqlmock) {}},
+		{"not found after the timeout is dropped", &longAgo, &sentinel.HTTPError{StatusCode: 404}, func(mock sqlmock.Sqlmock) {
+			expectApplied(mock, `UPDATE "servers" SET "plan_tx_hash"=\$1,"updated_at"=\$2 WHERE plan_tx_hash = \$3`,
+				[]driver.Value{nil, sqlmock.AnyArg(), testTxHash}, models.SentinelTransactionStatusDropped)
+		}},
+		{"failure to fetch restarts the timeout", &longAgo, &sentinel.HTTPError{StatusCode: 503}, func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(`UPDATE "sentinel_transactions" SET "not_found_since"=\$1,"updated_at"=\$2 WHERE "id" = \$3`).
+				WithArgs(nil, sqlmock.AnyArg(), 1).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}},
+		{"failure to fetch without timeout", nil, sentinel.ErrCircuitOpen, func(mock sqlmock.Sqlmock) {}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			expectPending(mock, models.SentinelTransactionKindLinkNodes, tt.notFoundSince)
+			tt.expect(mock)
+
+			job := TrackTransactionsJob{
+				DB:       db,
+				Logger:   zap.NewNop().Sugar(),
+				Sentinel: transactionsClient{FakeSentinel: sentinel.NewFakeSentinel(sentinel.FakeSentinelConfig{Nodes: 1, Seed: 1}), err: tt.err},
+				Timeout:  time.Minute,
+			}
+			job.Run()
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestTrackTransactionsForgetsGas(t *testing.T) {
+	const messageType = "/sentinel.plan.v2.MsgLinkNodeRequest"
+
+	tests := []struct {
+		name      string
+		code      int64
+		forgotten bool
+	}{
+		{"out of gas", sentinel.CodeOutOfGas, true},
+		{"other failure", 5, false},
+		{"confirmed", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gas := sentinel.NewGasEstimator(1.2, 0, time.Hour)
+			if _, err := gas.Estimate(messageType, 1, func() (int64, error) { return 100000, nil }); err != nil {
+				t.Fatalf("failed to estimate gas: %s", err)
+			}
+
+			status := models.SentinelTransactionStatusFailed
+			if tt.code == 0 {
+				status = models.SentinelTransactionStatusConfirmed
+			}
+
+			db, mock := newMockDB(t)
+			expectPending(mock, models.SentinelTransactionKindLinkNodes, nil)
+			expectApplied(mock, `UPDATE "servers" SET .* WHERE plan_tx_hash = \$\d+`, nil, status)
+
+			job := TrackTransactionsJob{
+				DB:       db,
+				Logger:   zap.NewNop().Sugar(),
+				Sentinel: transactionsClient{FakeSentinel: sentinel.NewFakeSentinel(sentinel.FakeSentinelConfig{Nodes: 1, Seed: 1}), result: included(tt.code)},
+				Gas:      gas,
+				Timeout:  time.Minute,
+			}
+			job.Run()
+
+			simulated := false
+			if _, err := gas.Estimate(messageType, 1, func() (int64, error) { simulated = true; return 100000, nil }); err != nil {
+				t.Fatalf("failed to estimate gas: %s", err)
+			}
+
+			if simulated != tt.forgotten {
+				t.Errorf("estimate forgotten = %t, want %t", simulated, tt.forgotten)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
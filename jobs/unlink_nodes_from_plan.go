@@ -1,8 +1,10 @@
 package jobs
 
 import (
+	"context"
 	"dvpn/internal/sentinel"
 	"dvpn/models"
+	"fmt"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 	"os"
@@ -12,12 +14,17 @@ import (
 type UnlinkNodesFromPlanJob struct {
 	DB       *gorm.DB
 	Logger   *zap.SugaredLogger
-	Sentinel *sentinel.Sentinel
+	Sentinel sentinel.SentinelClient
 }
 
 func (job UnlinkNodesFromPlanJob) Run() {
+	ctx := context.Background()
+
+	planMutex.Lock()
+	defer planMutex.Unlock()
+
 	var servers []models.Server
-	tx := job.DB.Model(&models.Server{}).Order("created_at").Limit(5).Find(&servers, "is_included_in_plan = ?", true)
+	tx := job.DB.Model(&models.Server{}).Order("created_at").Limit(5).Find(&servers, "is_included_in_plan = ? AND plan_tx_hash IS NULL", true)
 	if tx.Error != nil {
 		job.Logger.Error("failed to get sentinel servers from the DB: " + tx.Error.Error())
 		return
@@ -31,23 +38,12 @@ func (job UnlinkNodesFromPlanJob) Run() {
 
 	for _, server := range servers {
 		if server.Configuration.Data().PricePerHour > maxPricePerHour || server.IsActive == false || server.IsBanned {
-
 			job.Logger.Infof("Sentinel node %s is no longer satisfy plan listing criteria. It will be removed from the plan.", server.Configuration.Data().Address)
 
-			err := job.Sentinel.RemoveNodeFromPlan(server.Configuration.Data().Address)
+			err := job.unlinkServer(ctx, &server)
 			if err != nil {
-				job.Logger.Error("failed to remove node from plan: " + err.Error())
-				continue
+				job.Logger.Error(err.Error())
 			}
-
-			server.IsIncludedInPlan = false
-			tx = job.DB.Save(&server)
-			if tx.Error != nil {
-				job.Logger.Error("failed to update server in the DB: " + tx.Error.Error())
-				continue
-			}
-
-			job.Logger.Infof("Sentinel node %s was removed from the plan.", server.Configuration.Data().Address)
 		}
 	}
 
@@ -63,3 +59,43 @@ func (job UnlinkNodesFromPlanJob) Run() {
 		return
 	}
 }
+
+// UnlinkServer broadcasts removal of a server from the plan right away, it is used when an admin bans a server.
+// Servers which are not in the plan or have a pending plan transaction are skipped, Run picks them up later.
+func (job UnlinkNodesFromPlanJob) UnlinkServer(ctx context.Context, server *models.Server) error {
+	planMutex.Lock()
+	defer planMutex.Unlock()
+
+	tx := job.DB.First(server, server.ID)
+	if tx.Error != nil {
+		return fmt.Errorf("failed to reload server %d from the DB: %w", server.ID, tx.Error)
+	}
+
+	return job.unlinkServer(ctx, server)
+}
+
+func (job UnlinkNodesFromPlanJob) unlinkServer(ctx context.Context, server *models.Server) error {
+	if server.IsIncludedInPlan == false || server.PlanTxHash != nil {
+		return nil
+	}
+
+	receipt, err := job.Sentinel.RemoveNodeFromPlan(ctx, server.Configuration.Data().Address)
+	if err != nil {
+		return fmt.Errorf("failed to remove node from plan: %w", err)
+	}
+
+	err = job.DB.Transaction(func(db *gorm.DB) error {
+		err := createPendingTransaction(db, receipt, models.SentinelTransactionKindUnlinkNode, nil)
+		if err != nil {
+			return err
+		}
+
+		return db.Model(server).Update("plan_tx_hash", receipt.TxHash).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save plan transaction %s to the DB: %w", receipt.TxHash, err)
+	}
+
+	job.Logger.Infof("Sentinel node %s will be removed from the plan once transaction %s is confirmed.", server.Configuration.Data().Address, receipt.TxHash)
+	return nil
+}
@@ -0,0 +1,70 @@
+package jobs
+
+import (
+	"context"
+	"dvpn/internal/sentinel"
+	"dvpn/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+)
+
+func TestUnlinkServerWaitsForPlanJobs(t *testing.T) {
+	fake := sentinel.NewFakeSentinel(sentinel.FakeSentinelConfig{ProviderPlanID: "1", Nodes: 1, Seed: 1})
+	page, err := fake.FetchNodes(context.Background(), sentinel.PageRequest{Limit: 1})
+	if err != nil {
+		t.Fatalf("failed to fetch nodes: %s", err)
+	}
+	address := page.Items[0].Address
+
+	if _, err := fake.AddNodeToPlan(context.Background(), []string{address}); err != nil {
+		t.Fatalf("failed to link node: %s", err)
+	}
+
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "servers" WHERE "servers"."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "is_included_in_plan", "is_banned", "configuration"}).AddRow(5, true, true, `{"address":"`+address+`"}`))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "sentinel_transactions"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), models.SentinelTransactionKindUnlinkNode, models.SentinelTransactionStatusPending, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), 0, "", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`UPDATE "servers" SET "plan_tx_hash"=\$1,"updated_at"=\$2 WHERE "id" = \$3`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	job := UnlinkNodesFromPlanJob{DB: db, Logger: zap.NewNop().Sugar(), Sentinel: fake}
+
+	// A plan job is broadcasting, the ban waits for it so the server isn't linked and unlinked at once
+	planMutex.Lock()
+
+	server := &models.Server{}
+	server.ID = 5
+
+	done := make(chan error)
+	go func() {
+		done <- job.UnlinkServer(context.Background(), server)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("unlinked while a plan job was running: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	planMutex.Unlock()
+
+	if err := <-done; err != nil {
+		t.Fatalf("failed to unlink server: %s", err)
+	}
+
+	if server.PlanTxHash == nil {
+		t.Errorf("plan transaction of unlinked server is not set")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"dvpn/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AdminPermission string
+
+const (
+	AdminPermissionReadDevices AdminPermission = "devices:read"
+	AdminPermissionBanDevices  AdminPermission = "devices:ban"
+	AdminPermissionReadServers AdminPermission = "servers:read"
+	AdminPermissionBanServers  AdminPermission = "servers:ban"
+	AdminPermissionReadBilling AdminPermission = "billing:read"
+	AdminPermissionReadQuotas  AdminPermission = "quotas:read"
+	AdminPermissionWriteQuotas AdminPermission = "quotas:write"
+)
+
+var AdminPermissions = []AdminPermission{
+	AdminPermissionReadDevices,
+	AdminPermissionBanDevices,
+	AdminPermissionReadServers,
+	AdminPermissionBanServers,
+	AdminPermissionReadBilling,
+	AdminPermissionReadQuotas,
+	AdminPermissionWriteQuotas,
+}
+
+var adminRolePermissions = map[models.AdminRole][]AdminPermission{
+	models.AdminRoleViewer: {
+		AdminPermissionReadDevices,
+		AdminPermissionReadServers,
+		AdminPermissionReadQuotas,
+	},
+	models.AdminRoleOperator: {
+		AdminPermissionReadDevices,
+		AdminPermissionBanDevices,
+		AdminPermissionReadServers,
+		AdminPermissionBanServers,
+		AdminPermissionReadQuotas,
+		AdminPermissionWriteQuotas,
+	},
+	models.AdminRoleFinance: {
+		AdminPermissionReadDevices,
+		AdminPermissionReadServers,
+		AdminPermissionReadBilling,
+		AdminPermissionReadQuotas,
+		AdminPermissionWriteQuotas,
+	},
+}
+
+// adminKeyPrefixLength is the number of leading characters of an admin key which are stored as they are to tell keys apart
+const adminKeyPrefixLength = 8
+
+// HasAdminPermission tells whether admins of the role are granted the permission
+func HasAdminPermission(role models.AdminRole, permission AdminPermission) bool {
+	for _, p := range adminRolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IssueAdminKey creates a new API key for an admin. The plain key is returned once and can't be recovered later.
+func IssueAdminKey(db *gorm.DB, admin *models.Admin, expiresAt *time.Time) (string, *models.AdminKey, error) {
+	key, err := generateToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	adminKey := &models.AdminKey{
+		AdminID:   admin.ID,
+		Prefix:    key[:adminKeyPrefixLength],
+		KeyHash:   HashToken(key),
+		ExpiresAt: expiresAt,
+	}
+
+	tx := db.Create(adminKey)
+	if tx.Error != nil {
+		return "", nil, tx.Error
+	}
+
+	return key, adminKey, nil
+}
+
+// RequireAdmin authenticates an admin by the API key in `x-admin-key` header.
+// Routes should also be guarded with RequirePermission.
+func (am AuthMiddleware) RequireAdmin(c *gin.Context) {
+	key := c.GetHeader("x-admin-key")
+	if len(key) == 0 {
+		RespondErr(c, APIErrorUnauthorizedAdmin, "admin key is required")
+		return
+	}
+
+	var adminKey models.AdminKey
+	tx := am.DB.Preload("Admin").First(&adminKey, "key_hash = ?", HashToken(key))
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			RespondErr(c, APIErrorUnauthorizedAdmin, "invalid admin key")
+			return
+		}
+
+		reason := "failed to find admin by key: " + tx.Error.Error()
+		RespondErr(c, APIErrorUnknown, reason)
+		am.Logger.Error(reason)
+		return
+	}
+
+	if !adminKey.IsValid() {
+		RespondErr(c, APIErrorUnauthorizedAdmin, "admin key is expired or revoked")
+		return
+	}
+
+	if adminKey.Admin.IsDisabled {
+		RespondErr(c, APIErrorUnauthorizedAdmin, "admin is disabled")
+		return
+	}
+
+	now := time.Now()
+	if adminKey.LastUsedAt == nil || now.Sub(*adminKey.LastUsedAt) > time.Minute {
+		tx = am.DB.Model(&adminKey).Update("last_used_at", now)
+		if tx.Error != nil {
+			am.Logger.Warnf("failed to update last use of admin key %s: %s", adminKey.Prefix, tx.Error)
+		}
+	}
+
+	c.Set("currentAdmin", adminKey.Admin)
+	c.Next()
+}
+
+// RequirePermission allows the request only to admins whose role is granted the permission
+func (am AuthMiddleware) RequirePermission(permission AdminPermission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		admin, err := am.CurrentAdmin(c)
+		if err != nil {
+			reason := "failed to retrieve admin: " + err.Error()
+			RespondErr(c, APIErrorUnknown, reason)
+			am.Logger.Error(reason)
+			return
+		}
+
+		if !HasAdminPermission(admin.Role, permission) {
+			RespondErr(c, APIErrorForbiddenAdmin, fmt.Sprintf("role %s is not granted %s permission", admin.Role, permission))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func (am AuthMiddleware) CurrentAdmin(c *gin.Context) (*models.Admin, error) {
+	value, exist := c.Get("currentAdmin")
+	if exist == false {
+		return nil, errors.New("admin not found in context")
+	}
+
+	admin := value.(models.Admin)
+
+	return &admin, nil
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"dvpn/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestHasAdminPermission(t *testing.T) {
+	// Permissions each role is granted, everything else is denied
+	granted := map[models.AdminRole][]AdminPermission{
+		models.AdminRoleViewer:   {AdminPermissionReadDevices, AdminPermissionReadServers, AdminPermissionReadQuotas},
+		models.AdminRoleOperator: {AdminPermissionReadDevices, AdminPermissionBanDevices, AdminPermissionReadServers, AdminPermissionBanServers, AdminPermissionReadQuotas, AdminPermissionWriteQuotas},
+		models.AdminRoleFinance:  {AdminPermissionReadDevices, AdminPermissionReadServers, AdminPermissionReadBilling, AdminPermissionReadQuotas, AdminPermissionWriteQuotas},
+		models.AdminRole("ROOT"): nil,
+	}
+
+	for role, permissions := range granted {
+		for _, permission := range AdminPermissions {
+			want := false
+			for _, p := range permissions {
+				want = want || p == permission
+			}
+
+			t.Run(string(role)+" "+string(permission), func(t *testing.T) {
+				if got := HasAdminPermission(role, permission); got != want {
+					t.Errorf("HasAdminPermission(%s, %s) = %t, want %t", role, permission, got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	am := AuthMiddleware{Logger: zap.NewNop().Sugar()}
+
+	tests := []struct {
+		name   string
+		admin  *models.Admin
+		want   int
+		called bool
+	}{
+		{"granted", &models.Admin{Role: models.AdminRoleOperator}, http.StatusOK, true},
+		{"not granted", &models.Admin{Role: models.AdminRoleViewer}, http.StatusForbidden, false},
+		{"not authenticated", nil, http.StatusInternalServerError, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+
+			router := gin.New()
+			router.GET("/", func(c *gin.Context) {
+				if tt.admin != nil {
+					c.Set("currentAdmin", *tt.admin)
+				}
+			}, am.RequirePermission(AdminPermissionBanServers), func(c *gin.Context) {
+				called = true
+				c.Status(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.Code != tt.want {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.want)
+			}
+
+			if called != tt.called {
+				t.Errorf("handler called = %t, want %t", called, tt.called)
+			}
+		})
+	}
+}
@@ -7,11 +7,17 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"time"
 )
 
 type AuthMiddleware struct {
 	DB     *gorm.DB
 	Logger *zap.SugaredLogger
+
+	TokenTTL        time.Duration
+	RefreshTokenTTL time.Duration
+	// LegacyTokenTTL is how long plain tokens issued before hashing keep working after their first use
+	LegacyTokenTTL time.Duration
 }
 
 func (am AuthMiddleware) RequireAuth(c *gin.Context) {
@@ -29,21 +35,25 @@ func (am AuthMiddleware) RequireAuth(c *gin.Context) {
 		return
 	}
 
-	var device models.Device
-	tx := db.First(&device, "token = ?", token)
-	if tx.Error != nil {
-		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+	device, err := am.findDeviceByToken(db, token)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
 			RespondErr(c, APIErrorUnauthorizedDevice, "invalid device token")
 			return
 		}
 
-		reason := "failed to find device by token: " + tx.Error.Error()
+		reason := "failed to find device by token: " + err.Error()
 		RespondErr(c, APIErrorUnknown, reason)
 		am.Logger.Error(reason)
 		return
 	}
 
-	if device.IsBanned {
+	if device.TokenExpiresAt != nil && device.TokenExpiresAt.Before(time.Now()) {
+		RespondErr(c, APIErrorExpiredDeviceToken, "device token is expired")
+		return
+	}
+
+	if device.IsBanActive() {
 		RespondErr(c, APIErrorBannedDevice, "device is banned")
 		return
 	}
@@ -52,6 +62,64 @@ func (am AuthMiddleware) RequireAuth(c *gin.Context) {
 	c.Next()
 }
 
+// findDeviceByToken looks a device up by hash of its token. Plain tokens issued before hashing are
+// looked up as they are and replaced with their hash. They were generated with a predictable source,
+// so they expire after LegacyTokenTTL from their migration and the device has to rotate its token before that.
+func (am AuthMiddleware) findDeviceByToken(db *gorm.DB, token string) (*models.Device, error) {
+	tokenHash := HashToken(token)
+
+	var device models.Device
+	tx := db.First(&device, "token_hash = ?", tokenHash)
+	if tx.Error == nil {
+		// Tokens migrated before they were given an expiry
+		if device.TokenExpiresAt == nil {
+			return am.expireMigratedToken(db, &device)
+		}
+
+		return &device, nil
+	}
+
+	if !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil, tx.Error
+	}
+
+	tx = db.First(&device, "token = ?", token)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	tokenExpiresAt := time.Now().Add(am.LegacyTokenTTL)
+	tx = db.Model(&models.Device{}).Where("id = ? AND token = ?", device.ID, token).Updates(map[string]interface{}{
+		"token_hash":       tokenHash,
+		"token":            nil,
+		"token_expires_at": tokenExpiresAt,
+	})
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	am.Logger.Infof("migrated plain token of device %d to its hash, it expires at %s", device.ID, tokenExpiresAt)
+
+	device.TokenHash = &tokenHash
+	device.LegacyToken = nil
+	device.TokenExpiresAt = &tokenExpiresAt
+
+	return &device, nil
+}
+
+func (am AuthMiddleware) expireMigratedToken(db *gorm.DB, device *models.Device) (*models.Device, error) {
+	tokenExpiresAt := time.Now().Add(am.LegacyTokenTTL)
+	tx := db.Model(&models.Device{}).Where("id = ? AND token_expires_at IS NULL", device.ID).Update("token_expires_at", tokenExpiresAt)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	am.Logger.Infof("migrated token of device %d expires at %s", device.ID, tokenExpiresAt)
+
+	device.TokenExpiresAt = &tokenExpiresAt
+	return device, nil
+}
+
 func (am AuthMiddleware) CurrentDeviceID(c *gin.Context) (*uint, error) {
 	value, exist := c.Get("currentDeviceID")
 	if exist == false {
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock DB: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{
+		Logger:                 logger.Discard,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to open mock DB: %s", err)
+	}
+
+	return db, mock
+}
+
+func TestFindDeviceByToken(t *testing.T) {
+	const token = "device-token"
+	tokenHash := HashToken(token)
+	expiresAt := time.Now().Add(time.Hour)
+
+	columns := []string{"id", "token_hash", "token_expires_at", "token"}
+
+	tests := []struct {
+		name       string
+		expect     func(mock sqlmock.Sqlmock)
+		wantErr    error
+		wantExpiry bool
+	}{
+		{"hashed token", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "devices" WHERE token_hash = \$1`).WithArgs(tokenHash).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow(1, tokenHash, expiresAt, nil))
+		}, nil, true},
+		{"hashed token without expiry is given one", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "devices" WHERE token_hash = \$1`).WithArgs(tokenHash).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow(1, tokenHash, nil, nil))
+			mock.ExpectExec(`UPDATE "devices" SET "token_expires_at"=\$1,"updated_at"=\$2 WHERE id = \$3 AND token_expires_at IS NULL`).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, nil, true},
+		{"plain token is migrated to its hash with an expiry", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "devices" WHERE token_hash = \$1`).WithArgs(tokenHash).
+				WillReturnRows(sqlmock.NewRows(columns))
+			mock.ExpectQuery(`SELECT \* FROM "devices" WHERE token = \$1`).WithArgs(token).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow(1, nil, nil, token))
+			mock.ExpectExec(`UPDATE "devices" SET "token"=\$1,"token_expires_at"=\$2,"token_hash"=\$3,"updated_at"=\$4 WHERE id = \$5 AND token = \$6`).
+				WithArgs(nil, sqlmock.AnyArg(), tokenHash, sqlmock.AnyArg(), 1, token).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}, nil, true},
+		{"unknown token", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "devices" WHERE token_hash = \$1`).WithArgs(tokenHash).
+				WillReturnRows(sqlmock.NewRows(columns))
+			mock.ExpectQuery(`SELECT \* FROM "devices" WHERE token = \$1`).WithArgs(token).
+				WillReturnRows(sqlmock.NewRows(columns))
+		}, gorm.ErrRecordNotFound, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			tt.expect(mock)
+
+			am := AuthMiddleware{Logger: zap.NewNop().Sugar(), TokenTTL: 30 * 24 * time.Hour, LegacyTokenTTL: time.Hour}
+			device, err := am.findDeviceByToken(db, token)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantExpiry {
+				if device.TokenHash == nil || *device.TokenHash != tokenHash {
+					t.Errorf("device token hash = %v, want %s", device.TokenHash, tokenHash)
+				}
+
+				if device.TokenExpiresAt == nil || time.Until(*device.TokenExpiresAt) > time.Hour {
+					t.Errorf("device token expires at %v, want within LegacyTokenTTL", device.TokenExpiresAt)
+				}
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -13,9 +13,13 @@ var (
 	// Auth
 	APIErrorUnauthorizedDevice APIError = errors.New("unauthorizedDevice")
 	APIErrorBannedDevice       APIError = errors.New("bannedDevice")
+	APIErrorExpiredDeviceToken APIError = errors.New("expiredDeviceToken")
+	APIErrorUnauthorizedAdmin  APIError = errors.New("unauthorizedAdmin")
+	APIErrorForbiddenAdmin     APIError = errors.New("forbiddenAdmin")
 
 	// Other
 	APIErrorDeviceNotEnrolled APIError = errors.New("deviceNotEnrolled")
 	APIErrorServerInactive    APIError = errors.New("serverInactive")
 	APIErrorServerNotCovered  APIError = errors.New("serverNotCovered")
+	APIErrorQuotaExceeded     APIError = errors.New("quotaExceeded")
 )
@@ -33,9 +33,9 @@ func RespondErr(c *gin.Context, error APIError, reason string) {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, r)
 	} else if error == APIErrorNotFound {
 		c.AbortWithStatusJSON(http.StatusNotFound, r)
-	} else if error == APIErrorUnauthorizedDevice {
+	} else if error == APIErrorUnauthorizedDevice || error == APIErrorExpiredDeviceToken || error == APIErrorUnauthorizedAdmin {
 		c.AbortWithStatusJSON(http.StatusUnauthorized, r)
-	} else if error == APIErrorBannedDevice {
+	} else if error == APIErrorBannedDevice || error == APIErrorForbiddenAdmin || error == APIErrorQuotaExceeded {
 		c.AbortWithStatusJSON(http.StatusForbidden, r)
 	} else if error == APIErrorDeviceNotEnrolled {
 		c.AbortWithStatusJSON(http.StatusTooEarly, r)
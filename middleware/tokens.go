@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"dvpn/models"
+	"encoding/hex"
+	"math/big"
+	"time"
+)
+
+const deviceTokenLength = 128
+
+const deviceTokenCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// HashToken returns the hash a device token or an admin key is stored and looked up by.
+// Tokens are long random strings, so a plain SHA-256 is enough and keeps lookups indexable.
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// IssueToken generates a new access and refresh token pair for a device, replacing previous tokens.
+// Plain tokens are only kept in the device until it is returned, the caller is responsible for saving it.
+func (am AuthMiddleware) IssueToken(device *models.Device) error {
+	token, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	refreshToken, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tokenHash := HashToken(token)
+	refreshTokenHash := HashToken(refreshToken)
+	tokenExpiresAt := now.Add(am.TokenTTL)
+	refreshTokenExpiresAt := now.Add(am.RefreshTokenTTL)
+
+	device.Token = token
+	device.TokenHash = &tokenHash
+	device.TokenExpiresAt = &tokenExpiresAt
+	device.RefreshToken = refreshToken
+	device.RefreshTokenHash = &refreshTokenHash
+	device.RefreshTokenExpiresAt = &refreshTokenExpiresAt
+	device.LegacyToken = nil
+
+	return nil
+}
+
+// RevokeToken clears all tokens of a device, the caller is responsible for saving it
+func (am AuthMiddleware) RevokeToken(device *models.Device) {
+	device.Token = ""
+	device.TokenHash = nil
+	device.TokenExpiresAt = nil
+	device.RefreshToken = ""
+	device.RefreshTokenHash = nil
+	device.RefreshTokenExpiresAt = nil
+	device.LegacyToken = nil
+}
+
+func generateToken() (string, error) {
+	max := big.NewInt(int64(len(deviceTokenCharset)))
+
+	b := make([]byte, deviceTokenLength)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+
+		b[i] = deviceTokenCharset[n.Int64()]
+	}
+
+	return string(b), nil
+}
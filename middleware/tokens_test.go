@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"dvpn/models"
+	"testing"
+	"time"
+)
+
+func TestHashToken(t *testing.T) {
+	tests := []struct {
+		token string
+		want  string
+	}{
+		{"", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			if got := HashToken(tt.token); got != tt.want {
+				t.Errorf("HashToken(%q) = %s, want %s", tt.token, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIssueToken(t *testing.T) {
+	am := AuthMiddleware{TokenTTL: time.Hour, RefreshTokenTTL: 24 * time.Hour}
+
+	legacyToken := "legacy"
+	device := models.Device{LegacyToken: &legacyToken}
+
+	if err := am.IssueToken(&device); err != nil {
+		t.Fatalf("failed to issue token: %s", err)
+	}
+
+	if len(device.Token) != deviceTokenLength || len(device.RefreshToken) != deviceTokenLength || device.Token == device.RefreshToken {
+		t.Fatalf("issued tokens %q and %q, want two distinct tokens of %d characters", device.Token, device.RefreshToken, deviceTokenLength)
+	}
+
+	if *device.TokenHash != HashToken(device.Token) || *device.RefreshTokenHash != HashToken(device.RefreshToken) {
+		t.Errorf("stored hashes don't match issued tokens")
+	}
+
+	if until := time.Until(*device.TokenExpiresAt); until <= 59*time.Minute || until > time.Hour {
+		t.Errorf("token expires in %s, want TokenTTL", until)
+	}
+
+	if until := time.Until(*device.RefreshTokenExpiresAt); until <= 23*time.Hour || until > 24*time.Hour {
+		t.Errorf("refresh token expires in %s, want RefreshTokenTTL", until)
+	}
+
+	if device.LegacyToken != nil {
+		t.Errorf("legacy token is kept")
+	}
+
+	am.RevokeToken(&device)
+	if device.Token != "" || device.TokenHash != nil || device.RefreshTokenHash != nil || device.TokenExpiresAt != nil {
+		t.Errorf("tokens are kept after revocation: %+v", device)
+	}
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+)
+
+type AdminRole string
+
+const (
+	// AdminRoleViewer can only read devices, servers and quotas
+	AdminRoleViewer AdminRole = "VIEWER"
+	// AdminRoleOperator can also ban and unban devices and servers and manage quotas
+	AdminRoleOperator AdminRole = "OPERATOR"
+	// AdminRoleFinance can read devices, servers and billing and manage quotas
+	AdminRoleFinance AdminRole = "FINANCE"
+)
+
+var AdminRoles = []AdminRole{AdminRoleViewer, AdminRoleOperator, AdminRoleFinance}
+
+// Admin is a member of the operations team. Admins authenticate with API keys and are managed with `cmd/admin`.
+type Admin struct {
+	Generic
+
+	Name       string    `gorm:"not null; unique" json:"name"`
+	Role       AdminRole `gorm:"not null" json:"role"`
+	IsDisabled bool      `gorm:"not null; default:false" json:"is_disabled"`
+}
+
+// AdminKey is an API key of an admin. Only a hash of the key is stored, Prefix identifies the key in listings.
+type AdminKey struct {
+	Generic
+
+	AdminID uint  `gorm:"index; not null"`
+	Admin   Admin `json:"-"`
+
+	Prefix  string `gorm:"not null; index"`
+	KeyHash string `gorm:"not null; unique"`
+
+	ExpiresAt  *time.Time
+	RevokedAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+// IsValid tells whether the key is neither revoked nor expired
+func (k AdminKey) IsValid() bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || k.ExpiresAt.After(time.Now()))
+}
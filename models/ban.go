@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+)
+
+// Ban is embedded into devices and servers which can be banned by admins.
+// Bans with BanExpiresAt are lifted by ExpireBansJob once they expire.
+type Ban struct {
+	IsBanned     bool       `gorm:"not null; default:false" json:"is_banned"`
+	BanReason    *string    `json:"reason,omitempty"`
+	BannedBy     *string    `json:"banned_by,omitempty"`
+	BannedAt     *time.Time `json:"banned_at,omitempty"`
+	BanExpiresAt *time.Time `gorm:"index" json:"expires_at,omitempty"`
+}
+
+// IsBanActive tells whether the ban is in effect, including expired bans which are not lifted yet
+func (b Ban) IsBanActive() bool {
+	return b.IsBanned && (b.BanExpiresAt == nil || b.BanExpiresAt.After(time.Now()))
+}
+
+// NewBan creates a ban by `actor`, `expiresAt` is nil for permanent bans
+func NewBan(reason string, actor string, expiresAt *time.Time) Ban {
+	now := time.Now()
+
+	return Ban{
+		IsBanned:     true,
+		BanReason:    &reason,
+		BannedBy:     &actor,
+		BannedAt:     &now,
+		BanExpiresAt: expiresAt,
+	}
+}
+
+// BanColumns lists columns of a ban to update them without saving the whole row
+func BanColumns(ban Ban) map[string]interface{} {
+	return map[string]interface{}{
+		"is_banned":      ban.IsBanned,
+		"ban_reason":     ban.BanReason,
+		"banned_by":      ban.BannedBy,
+		"banned_at":      ban.BannedAt,
+		"ban_expires_at": ban.BanExpiresAt,
+	}
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// ConnectionStatus is the stage an asynchronous connection has reached
+type ConnectionStatus string
+
+const (
+	ConnectionStatusQueued         ConnectionStatus = "QUEUED"
+	ConnectionStatusSubscribing    ConnectionStatus = "SUBSCRIBING"
+	ConnectionStatusExchangingKeys ConnectionStatus = "EXCHANGING_KEYS"
+	ConnectionStatusReady          ConnectionStatus = "READY"
+	ConnectionStatusFailed         ConnectionStatus = "FAILED"
+)
+
+// Connection is a connect request of a device which is processed by ConnectDevicesJob,
+// so the device polls it instead of waiting for the node subscription and the key exchange.
+type Connection struct {
+	Generic
+
+	DeviceID uint   `gorm:"not null; index" json:"-"`
+	Device   Device `json:"-"`
+	ServerID uint   `gorm:"not null" json:"server_id"`
+	Server   Server `json:"-"`
+
+	// Servers tried in order when connecting to ServerID fails. ServerID is updated to the server finally used.
+	FallbackServerIDs datatypes.JSONType[[]uint] `gorm:"type:json" json:"-"`
+	Attempts          int                        `gorm:"not null; default:0" json:"attempts"`
+
+	Status ConnectionStatus `gorm:"not null; index" json:"status"`
+	Error  *string          `json:"error,omitempty"`
+
+	SubscribedAt *time.Time `json:"subscribed_at,omitempty"`
+	ReadyAt      *time.Time `json:"ready_at,omitempty"`
+	FailedAt     *time.Time `json:"failed_at,omitempty"`
+
+	// Credentials are sealed the same way wallet entropy of devices is, they include the WireGuard private key
+	CredentialsCiphertext []byte `json:"-"`
+	CredentialsDataKey    []byte `json:"-"`
+	CredentialsKeyVersion int    `gorm:"not null; default:0" json:"-"`
+
+	ExpiresAt time.Time `gorm:"not null; index" json:"expires_at"`
+}
+
+func (c Connection) IsFinished() bool {
+	return c.Status == ConnectionStatusReady || c.Status == ConnectionStatusFailed
+}
@@ -0,0 +1,25 @@
+package models
+
+// ConnectionAttemptStage is the step of connecting to a server an attempt reached
+type ConnectionAttemptStage string
+
+const (
+	ConnectionAttemptStageSubscription ConnectionAttemptStage = "SUBSCRIPTION"
+	ConnectionAttemptStageKeyExchange  ConnectionAttemptStage = "KEY_EXCHANGE"
+)
+
+// ConnectionAttempt records every attempt of a device to get credentials of a server, including the ones
+// failed over to the next candidate. Attempts of asynchronous connects reference their Connection.
+type ConnectionAttempt struct {
+	Generic
+
+	DeviceID     uint  `gorm:"not null; index"`
+	ServerID     uint  `gorm:"not null; index"`
+	ConnectionID *uint `gorm:"index"`
+
+	Stage ConnectionAttemptStage `gorm:"not null"`
+	Error *string
+
+	// Duration of the attempt in milliseconds
+	Duration int64 `gorm:"not null"`
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"time"
 )
 
 type DevicePlatform string
@@ -19,31 +20,106 @@ type Device struct {
 	Generic
 
 	Platform DevicePlatform
-	Token    string `gorm:"not null; unique"`
+	Tier     *string `gorm:"index"`
 
-	IsBanned bool `gorm:"not null; default:false"`
+	// Only hashes of device tokens are stored. Token and RefreshToken are set when a token pair is issued
+	// and are returned to the device once. LegacyToken holds plain tokens issued before hashing,
+	// they are moved to TokenHash on first use.
+	Token                 string  `gorm:"-"`
+	TokenHash             *string `gorm:"unique"`
+	TokenExpiresAt        *time.Time
+	RefreshToken          string  `gorm:"-"`
+	RefreshTokenHash      *string `gorm:"unique"`
+	RefreshTokenExpiresAt *time.Time
+	LegacyToken           *string `gorm:"column:token; unique"`
+
+	Ban
 
 	WalletAddress string `gorm:"not null; unique"`
-	WalletEntropy []byte `gorm:"not null; unique"`
+
+	// Wallet entropy is sealed with a data key, which is wrapped by the master key of WalletKeyVersion.
+	// Rows of version 0 were written before encryption and hold plain entropy until they are rotated.
+	WalletEntropy    []byte `gorm:"not null; unique"`
+	WalletDataKey    []byte
+	WalletKeyVersion int `gorm:"not null; default:0; index"`
 
 	SubscriptionId *int64
 	IsFeeGranted   bool `gorm:"not null; default:false"`
+
+	// Bytes allocated to the wallet on chain, and bytes a not yet confirmed enrollment or allocation transaction allocates
+	QuotaBytes        int64 `gorm:"not null; default:0"`
+	PendingQuotaBytes *int64
+
+	// Latest data usage of the wallet in the subscription, updated by RecordDeviceUsageJob and `GET /device/usage`
+	UsageGrantedBytes  int64      `gorm:"not null; default:0"`
+	UsageUtilisedBytes int64      `gorm:"not null; default:0"`
+	UsageUpdatedAt     *time.Time `gorm:"index"`
+
+	// Hashes of broadcast transactions which are not confirmed yet
+	FeeGrantTxHash   *string `gorm:"index"`
+	EnrollmentTxHash *string `gorm:"index"`
+	AllocationTxHash *string `gorm:"index"`
 }
 
 func (d Device) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
-		ID            uint   `json:"id"`
-		Platform      string `json:"platform"`
-		Token         string `json:"token"`
-		IsBanned      bool   `json:"is_banned"`
-		IsEnrolled    bool   `json:"is_enrolled"`
-		WalletAddress string `json:"wallet_address"`
+		ID                    uint       `json:"id"`
+		Platform              string     `json:"platform"`
+		Tier                  *string    `json:"tier,omitempty"`
+		Token                 string     `json:"token,omitempty"`
+		TokenExpiresAt        *time.Time `json:"token_expires_at,omitempty"`
+		RefreshToken          string     `json:"refresh_token,omitempty"`
+		RefreshTokenExpiresAt *time.Time `json:"refresh_token_expires_at,omitempty"`
+		IsBanned              bool       `json:"is_banned"`
+		IsEnrolled            bool       `json:"is_enrolled"`
+		WalletAddress         string     `json:"wallet_address"`
+		Usage                 *Usage     `json:"usage"`
 	}{
-		ID:            d.ID,
-		Platform:      string(d.Platform),
-		Token:         d.Token,
-		IsBanned:      d.IsBanned,
-		IsEnrolled:    d.SubscriptionId != nil && d.IsFeeGranted,
-		WalletAddress: d.WalletAddress,
+		ID:                    d.ID,
+		Platform:              string(d.Platform),
+		Tier:                  d.Tier,
+		Token:                 d.Token,
+		TokenExpiresAt:        d.TokenExpiresAt,
+		RefreshToken:          d.RefreshToken,
+		RefreshTokenExpiresAt: d.RefreshTokenExpiresAt,
+		IsBanned:              d.IsBanned,
+		IsEnrolled:            d.SubscriptionId != nil && d.IsFeeGranted,
+		WalletAddress:         d.WalletAddress,
+		Usage:                 d.Usage(),
 	})
 }
+
+// Usage is nil until usage of the device was recorded at least once
+func (d Device) Usage() *Usage {
+	if d.UsageUpdatedAt == nil {
+		return nil
+	}
+
+	usage := NewUsage(d.UsageGrantedBytes, d.UsageUtilisedBytes, d.UsageUpdatedAt)
+	return &usage
+}
+
+// Enrollment is the progress of a device towards connecting: its wallet has to be granted fee
+// and enrolled to the plan subscription, both are broadcast and confirmed independently.
+type Enrollment struct {
+	IsFeeGranted        bool `json:"is_fee_granted"`
+	IsFeeGrantPending   bool `json:"is_fee_grant_pending"`
+	IsEnrolled          bool `json:"is_enrolled"`
+	IsEnrollmentPending bool `json:"is_enrollment_pending"`
+	IsReady             bool `json:"is_ready"`
+}
+
+func (d Device) Enrollment() Enrollment {
+	return Enrollment{
+		IsFeeGranted:        d.IsFeeGranted,
+		IsFeeGrantPending:   d.FeeGrantTxHash != nil,
+		IsEnrolled:          d.SubscriptionId != nil,
+		IsEnrollmentPending: d.EnrollmentTxHash != nil,
+		IsReady:             d.SubscriptionId != nil && d.IsFeeGranted,
+	}
+}
+
+// IsQuotaExceeded tells whether the latest recorded usage of the device has reached bytes allocated to it
+func (d Device) IsQuotaExceeded() bool {
+	return d.UsageUpdatedAt != nil && d.UsageGrantedBytes > 0 && d.UsageUtilisedBytes >= d.UsageGrantedBytes
+}
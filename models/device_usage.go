@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+)
+
+// DeviceUsage is a sample of data usage of a device wallet in its subscription, recorded by RecordDeviceUsageJob
+type DeviceUsage struct {
+	Generic
+
+	DeviceID       uint  `gorm:"not null; index"`
+	SubscriptionID int64 `gorm:"not null"`
+
+	GrantedBytes  int64 `gorm:"not null"`
+	UtilisedBytes int64 `gorm:"not null"`
+}
+
+// Usage is the latest known data usage of a device, as it is exposed in the API
+type Usage struct {
+	GrantedBytes   int64      `json:"granted_bytes"`
+	UtilisedBytes  int64      `json:"utilised_bytes"`
+	RemainingBytes int64      `json:"remaining_bytes"`
+	UpdatedAt      *time.Time `json:"updated_at"`
+}
+
+func NewUsage(grantedBytes int64, utilisedBytes int64, updatedAt *time.Time) Usage {
+	remainingBytes := grantedBytes - utilisedBytes
+	if remainingBytes < 0 {
+		remainingBytes = 0
+	}
+
+	return Usage{
+		GrantedBytes:   grantedBytes,
+		UtilisedBytes:  utilisedBytes,
+		RemainingBytes: remainingBytes,
+		UpdatedAt:      updatedAt,
+	}
+}
@@ -0,0 +1,14 @@
+package models
+
+// QuotaPolicy sets the number of bytes allocated to wallets of devices of a platform, of a tier or of a single device.
+// Exactly one of Platform, Tier and DeviceID is set. A policy of a device wins over a policy of its tier,
+// which wins over a policy of its platform.
+type QuotaPolicy struct {
+	Generic
+
+	Platform *DevicePlatform `gorm:"unique" json:"platform,omitempty"`
+	Tier     *string         `gorm:"unique" json:"tier,omitempty"`
+	DeviceID *uint           `gorm:"unique" json:"device_id,omitempty"`
+
+	Bytes int64 `gorm:"not null" json:"bytes"`
+}
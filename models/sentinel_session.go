@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// SentinelSession is an on-chain session of a device, keyed by its ID on chain.
+// It is recorded when the device ends the session with `POST /disconnect`.
+type SentinelSession struct {
+	ID             int64  `gorm:"primary_key; not null; unique"`
+	DeviceID       uint   `gorm:"not null; index"`
+	SubscriptionID int64  `gorm:"not null"`
+	NodeAddress    string `gorm:"not null"`
+
+	// Duration in seconds and bandwidth of the session when it was ended
+	Duration      int64 `gorm:"not null"`
+	UploadBytes   int64 `gorm:"not null"`
+	DownloadBytes int64 `gorm:"not null"`
+
+	EndedAt   *time.Time
+	EndTxHash *string
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+type SentinelTransactionKind string
+
+const (
+	SentinelTransactionKindFeeGrant   SentinelTransactionKind = "FEE_GRANT"
+	SentinelTransactionKindEnrollment SentinelTransactionKind = "ENROLLMENT"
+	SentinelTransactionKindAllocation SentinelTransactionKind = "ALLOCATION"
+	SentinelTransactionKindLinkNodes  SentinelTransactionKind = "LINK_NODES"
+	SentinelTransactionKindUnlinkNode SentinelTransactionKind = "UNLINK_NODE"
+)
+
+type SentinelTransactionStatus string
+
+const (
+	SentinelTransactionStatusPending   SentinelTransactionStatus = "PENDING"
+	SentinelTransactionStatusConfirmed SentinelTransactionStatus = "CONFIRMED"
+	SentinelTransactionStatusFailed    SentinelTransactionStatus = "FAILED"
+	SentinelTransactionStatusDropped   SentinelTransactionStatus = "DROPPED"
+)
+
+// SentinelTransaction is a broadcast transaction whose effects are applied to devices and
+// servers only once it is confirmed on chain. Devices and servers reference it by hash.
+type SentinelTransaction struct {
+	Generic
+
+	Hash   string                    `gorm:"not null; unique"`
+	Kind   SentinelTransactionKind   `gorm:"not null"`
+	Status SentinelTransactionStatus `gorm:"not null; index"`
+
+	SubscriptionID *int64
+
+	// MessageType is the type gas of the transaction was estimated for, its estimate is dropped when the transaction runs out of gas
+	MessageType string
+
+	Height int64
+	Code   int64
+	Log    string
+
+	// NotFoundSince is when Sentinel API started answering the transaction is not found, it is dropped after a timeout of such answers
+	NotFoundSince *time.Time
+}
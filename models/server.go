@@ -3,8 +3,15 @@ package models
 import (
 	"encoding/json"
 	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"math"
+	"time"
 )
 
+// ReservationHalfLife is how fast capacity reserved by issued credentials decays,
+// as those peers either show up in the next node status or never connect
+var ReservationHalfLife = 10 * time.Minute
+
 type ServerProtocol string
 
 const (
@@ -44,13 +51,49 @@ type Server struct {
 	City   City `json:"-"`
 
 	Name             string                                  `gorm:"not null"`
-	IsBanned         bool                                    `gorm:"not null; default:false"`
 	IsActive         bool                                    `gorm:"not null"`
 	IsIncludedInPlan bool                                    `gorm:"not null; default:false"`
 	CurrentLoad      float64                                 `gorm:"not null"`
+	MaxPeers         int64                                   `gorm:"not null; default:0"`
 	Protocols        datatypes.JSONType[[]ServerProtocol]    `gorm:"type:json;not null"`
 	Configuration    datatypes.JSONType[ServerConfiguration] `gorm:"type:json;not null"`
 	Revision         int64                                   `gorm:"not null"`
+
+	Ban
+
+	// Consecutive failed key exchanges with the node, it is quarantined by QuarantineNodesJob after a threshold
+	KeyExchangeFailures int        `gorm:"not null; default:0"`
+	QuarantinedUntil    *time.Time `gorm:"index"`
+
+	// Peers issued credentials since the last node status, decaying from ReservedAt. They are reset by the next sync.
+	ReservedPeers float64 `gorm:"not null; default:0"`
+	ReservedAt    *time.Time
+
+	// Consecutive failures of RefreshServerLoadsJob to fetch the node status, it deactivates the server after a threshold
+	StatusFailures int `gorm:"not null; default:0"`
+
+	// Hash of a not yet confirmed transaction which links server to the plan or unlinks it
+	PlanTxHash *string `gorm:"index"`
+}
+
+// Load is CurrentLoad of the last node status with the capacity reserved since then, so devices don't herd
+// onto a server which looked empty at the last sync
+func (s Server) Load() float64 {
+	load := s.CurrentLoad
+	if s.ReservedAt != nil && s.MaxPeers > 0 {
+		halfLives := time.Since(*s.ReservedAt).Seconds() / ReservationHalfLife.Seconds()
+		load += s.ReservedPeers * math.Pow(0.5, halfLives) / float64(s.MaxPeers)
+	}
+
+	return math.Min(load, 1)
+}
+
+// ReserveColumns adds a peer to the decayed reservation of a server, to update it atomically
+func ReserveColumns(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"reserved_peers": gorm.Expr("CASE WHEN reserved_at IS NULL THEN 1 ELSE reserved_peers * power(0.5, extract(epoch FROM ?::timestamptz - reserved_at)::float8 / ?::float8) + 1 END", now, ReservationHalfLife.Seconds()),
+		"reserved_at":    now,
+	}
 }
 
 func (s Server) MarshalJSON() ([]byte, error) {
@@ -78,7 +121,7 @@ func (s Server) MarshalJSON() ([]byte, error) {
 		Name:        s.Name,
 		Address:     s.Configuration.Data().Address,
 		IsAvailable: s.IsActive,
-		Load:        s.CurrentLoad,
+		Load:        s.Load(),
 		Version:     s.Configuration.Data().Version,
 		Latitude:    s.Configuration.Data().LocationLat,
 		Longitude:   s.Configuration.Data().LocationLon,
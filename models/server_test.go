@@ -0,0 +1,35 @@
+package models
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestServerLoad(t *testing.T) {
+	at := func(ago time.Duration) *time.Time {
+		reservedAt := time.Now().Add(-ago)
+		return &reservedAt
+	}
+
+	tests := []struct {
+		name   string
+		server Server
+		want   float64
+	}{
+		{"no reservation", Server{CurrentLoad: 0.4, MaxPeers: 10}, 0.4},
+		{"fresh reservation", Server{CurrentLoad: 0.4, MaxPeers: 10, ReservedPeers: 2, ReservedAt: at(0)}, 0.6},
+		{"reservation decayed by half", Server{CurrentLoad: 0.4, MaxPeers: 10, ReservedPeers: 2, ReservedAt: at(ReservationHalfLife)}, 0.5},
+		{"reservation decayed by two half lives", Server{CurrentLoad: 0.4, MaxPeers: 10, ReservedPeers: 2, ReservedAt: at(2 * ReservationHalfLife)}, 0.45},
+		{"unknown capacity ignores reservation", Server{CurrentLoad: 0.4, ReservedPeers: 2, ReservedAt: at(0)}, 0.4},
+		{"capped at full", Server{CurrentLoad: 0.9, MaxPeers: 10, ReservedPeers: 5, ReservedAt: at(0)}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.server.Load(); math.Abs(got-tt.want) > 1e-3 {
+				t.Errorf("Load = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -9,9 +9,12 @@ import (
 type Router struct {
 	Auth *middleware.AuthMiddleware
 
-	HealthController  *controllers.HealthController
-	DevicesController *controllers.DevicesController
-	VPNController     *controllers.VPNController
+	HealthController   *controllers.HealthController
+	DevicesController  *controllers.DevicesController
+	VPNController      *controllers.VPNController
+	SessionsController *controllers.SessionsController
+	AdminController    *controllers.AdminController
+	EventsController   *controllers.EventsController
 }
 
 func (r Router) RegisterRoutes(router gin.IRouter) {
@@ -27,6 +30,7 @@ func (r Router) RegisterRoutes(router gin.IRouter) {
 	router.GET("/health", r.HealthController.Status)
 	router.GET("/versions", r.HealthController.GetSupportedAppVersions)
 	router.POST("/device", r.DevicesController.CreateDevice)
+	router.POST("/device/token/refresh", r.DevicesController.RefreshToken)
 
 	//
 	// Authorized Requests
@@ -34,6 +38,11 @@ func (r Router) RegisterRoutes(router gin.IRouter) {
 	authorized := router.Group("/", r.Auth.RequireAuth)
 	authorized.GET("/ip", r.VPNController.GetIPAddress)
 	authorized.GET("/device", r.DevicesController.GetDevice)
+	authorized.GET("/device/usage", r.DevicesController.GetUsage)
+	authorized.POST("/device/token", r.DevicesController.RotateToken)
+	authorized.DELETE("/device/token", r.DevicesController.RevokeToken)
+	authorized.GET("/sessions", r.SessionsController.GetSessions)
+	authorized.GET("/events", r.EventsController.StreamEvents)
 	authorized.GET("/countries", r.VPNController.GetCountries)
 	authorized.GET("/countries/:country_id/cities", r.VPNController.GetCities)
 	authorized.GET("/countries/:country_id/cities/:city_id/servers", r.VPNController.GetServers)
@@ -41,4 +50,23 @@ func (r Router) RegisterRoutes(router gin.IRouter) {
 	authorized.POST("/countries/:country_id/cities/:city_id/credentials", r.VPNController.ConnectToCity)
 	authorized.POST("/countries/:country_id/cities/:city_id/credentials/:protocol", r.VPNController.ConnectToCity)
 	authorized.POST("/countries/:country_id/cities/:city_id/servers/:server_id/credentials", r.VPNController.ConnectToServer)
+	authorized.POST("/countries/:country_id/cities/:city_id/servers/:server_id/credentials/manual", r.VPNController.ConnectToServerManually)
+	authorized.POST("/disconnect", r.VPNController.Disconnect)
+	authorized.GET("/connections/:connection_id", r.VPNController.GetConnection)
+
+	//
+	// Admin Requests
+	//
+	admin := router.Group("/admin", r.Auth.RequireAdmin)
+	admin.GET("/me", r.AdminController.GetAdmin)
+	admin.GET("/devices", r.Auth.RequirePermission(middleware.AdminPermissionReadDevices), r.AdminController.ListDevices)
+	admin.PUT("/devices/:device_id/ban", r.Auth.RequirePermission(middleware.AdminPermissionBanDevices), r.AdminController.BanDevice)
+	admin.DELETE("/devices/:device_id/ban", r.Auth.RequirePermission(middleware.AdminPermissionBanDevices), r.AdminController.UnbanDevice)
+	admin.PUT("/devices/:device_id/tier", r.Auth.RequirePermission(middleware.AdminPermissionWriteQuotas), r.AdminController.SetDeviceTier)
+	admin.GET("/servers", r.Auth.RequirePermission(middleware.AdminPermissionReadServers), r.AdminController.ListServers)
+	admin.PUT("/servers/:server_id/ban", r.Auth.RequirePermission(middleware.AdminPermissionBanServers), r.AdminController.BanServer)
+	admin.DELETE("/servers/:server_id/ban", r.Auth.RequirePermission(middleware.AdminPermissionBanServers), r.AdminController.UnbanServer)
+	admin.GET("/quotas", r.Auth.RequirePermission(middleware.AdminPermissionReadQuotas), r.AdminController.ListQuotaPolicies)
+	admin.PUT("/quotas", r.Auth.RequirePermission(middleware.AdminPermissionWriteQuotas), r.AdminController.SetQuotaPolicy)
+	admin.DELETE("/quotas/:policy_id", r.Auth.RequirePermission(middleware.AdminPermissionWriteQuotas), r.AdminController.DeleteQuotaPolicy)
 }